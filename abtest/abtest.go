@@ -0,0 +1,186 @@
+// Package abtest runs authboss in an httptest.Server so that the flows an
+// app depends on (registering, confirming, logging in with two factor auth
+// etc.) can be tested end to end. The server is configured for a json api
+// with a memstore storer, and captures e-mails and sms messages instead of
+// sending them so that tests can follow the links and codes in them.
+//
+//	func TestLogin(t *testing.T) {
+//		server := abtest.NewServer(t, abtest.Options{})
+//		client := server.NewClient()
+//
+//		client.Register("test@test.com", "Passw0rd!")
+//		client.FollowConfirmEmail("test@test.com")
+//		if resp := client.Login("test@test.com", "Passw0rd!"); resp.Status() != "success" {
+//			t.Error("login failed:", resp.Data)
+//		}
+//	}
+package abtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/defaults"
+	"github.com/volatiletech/authboss/v3/mocks"
+	"github.com/volatiletech/authboss/v3/otp/twofactor/sms2fa"
+	"github.com/volatiletech/authboss/v3/otp/twofactor/totp2fa"
+	"github.com/volatiletech/authboss/v3/storers/memstore"
+	"golang.org/x/crypto/bcrypt"
+
+	// Register the DefaultModules
+	_ "github.com/volatiletech/authboss/v3/auth"
+	_ "github.com/volatiletech/authboss/v3/confirm"
+	_ "github.com/volatiletech/authboss/v3/lock"
+	_ "github.com/volatiletech/authboss/v3/logout"
+	_ "github.com/volatiletech/authboss/v3/recover"
+	_ "github.com/volatiletech/authboss/v3/register"
+)
+
+// DefaultModules are loaded when Options.Modules is empty, totp2fa and
+// sms2fa are always set up.
+var DefaultModules = []string{"auth", "confirm", "lock", "logout", "recover", "register"}
+
+// Options for NewServer, the zero value is a usable server
+type Options struct {
+	// Modules to load, the app must import any that aren't in
+	// DefaultModules so that they are registered.
+	Modules []string
+
+	// Configure is called before Init to change the configuration
+	Configure func(config *authboss.Config)
+
+	// Handler serves every path outside of Paths.Mount, the client
+	// state is loaded before it's called so it can use CurrentUser.
+	// By default it responds with the pid of the logged in user
+	// (see Client.CurrentPID).
+	Handler http.Handler
+}
+
+// Server is an httptest.Server running authboss
+type Server struct {
+	*httptest.Server
+
+	AB     *authboss.Authboss
+	Storer *memstore.Store
+	Mailer *Mailer
+	SMS    *SMSSender
+	// Clock is the server's Config.Core.Clock, it starts at the current
+	// time and only moves when it's told to
+	Clock *mocks.Clock
+
+	t testing.TB
+}
+
+// NewServer starts a server, it's closed when the test finishes
+func NewServer(t testing.TB, opts Options) *Server {
+	t.Helper()
+
+	s := &Server{
+		AB:     authboss.New(),
+		Storer: memstore.New(),
+		Mailer: &Mailer{},
+		SMS:    &SMSSender{},
+		Clock:  mocks.NewClock(time.Now().UTC()),
+		t:      t,
+	}
+
+	s.Server = httptest.NewUnstartedServer(nil)
+	t.Cleanup(s.Close)
+
+	config := &s.AB.Config
+	config.Paths.RootURL = "http://" + s.Listener.Addr().String()
+
+	s.Storer.Clock = s.Clock
+	config.Storage.Server = s.Storer
+	config.Core.ViewRenderer = defaults.JSONRenderer{}
+	config.Core.MailRenderer = defaults.JSONRenderer{}
+	config.Core.Clock = s.Clock
+	defaults.SetCore(config, true, false)
+
+	logger := testLogger{t}
+	config.Core.Logger = logger
+	config.Core.ErrorHandler = defaults.NewErrorHandler(logger)
+	config.Core.Mailer = s.Mailer
+	config.Core.Hasher = authboss.NewBCryptHasher(bcrypt.MinCost)
+
+	// The json api posts the tokens from e-mails, and e-mails are sent
+	// before the request finishes so they're captured by the time the
+	// client gets the response
+	config.Modules.MailRouteMethod = http.MethodPost
+	config.Modules.MailNoGoroutine = true
+	config.Modules.TOTP2FAIssuer = "abtest"
+
+	if opts.Configure != nil {
+		opts.Configure(config)
+	}
+
+	modules := opts.Modules
+	if len(modules) == 0 {
+		modules = DefaultModules
+	}
+	if err := s.AB.Init(modules...); err != nil {
+		t.Fatal("failed to initialize authboss:", err)
+	}
+
+	totp := &totp2fa.TOTP{Authboss: s.AB}
+	if err := totp.Setup(); err != nil {
+		t.Fatal("failed to set up totp2fa:", err)
+	}
+	sms := &sms2fa.SMS{Authboss: s.AB, Sender: s.SMS}
+	if err := sms.Setup(); err != nil {
+		t.Fatal("failed to set up sms2fa:", err)
+	}
+
+	handler := opts.Handler
+	if handler == nil {
+		handler = http.HandlerFunc(s.currentPID)
+	}
+
+	mount := config.Paths.Mount
+	mux := http.NewServeMux()
+	mux.Handle(mount+"/", http.StripPrefix(mount, config.Core.Router))
+	mux.Handle("/", handler)
+
+	s.Config.Handler = s.AB.LoadClientStateMiddleware(mux)
+	s.Start()
+
+	return s
+}
+
+// pidResponse is what the default handler responds with
+type pidResponse struct {
+	PID string `json:"pid"`
+}
+
+// currentPID is the default handler, it responds with the pid of the
+// logged in user or an empty pid
+func (s *Server) currentPID(w http.ResponseWriter, r *http.Request) {
+	pid, err := s.AB.CurrentUserID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(pidResponse{PID: pid})
+}
+
+// testLogger writes authboss' logs to the test log
+type testLogger struct {
+	t testing.TB
+}
+
+// Info logs go to the test log
+func (l testLogger) Info(s string) {
+	l.t.Log(fmt.Sprintf("[INFO]: %s", s))
+}
+
+// Error logs go to the test log
+func (l testLogger) Error(s string) {
+	l.t.Log(fmt.Sprintf("[EROR]: %s", s))
+}
@@ -0,0 +1,295 @@
+package abtest
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+	_ "github.com/volatiletech/authboss/v3/ratelimit"
+	_ "github.com/volatiletech/authboss/v3/sessions"
+)
+
+const (
+	testEmail    = "test@test.com"
+	testPassword = "Passw0rd!"
+)
+
+func TestRegisterConfirmLogin(t *testing.T) {
+	t.Parallel()
+
+	server := NewServer(t, Options{})
+	client := server.NewClient()
+
+	if resp := client.Register(testEmail, testPassword); resp.Status() != "success" {
+		t.Fatalf("register failed: %d %s", resp.StatusCode, resp.Body)
+	}
+	if pid := client.CurrentPID(); pid != "" {
+		t.Error("an unconfirmed user should not be logged in:", pid)
+	}
+
+	if resp := client.Login(testEmail, testPassword); client.CurrentPID() != "" {
+		t.Errorf("an unconfirmed user should not be able to log in: %s", resp.Body)
+	}
+
+	if resp := client.FollowConfirmEmail(testEmail); resp.Status() != "success" {
+		t.Fatalf("confirm failed: %d %s", resp.StatusCode, resp.Body)
+	}
+
+	resp := client.Login(testEmail, testPassword)
+	if resp.Status() != "success" || resp.Location() != server.AB.Config.Paths.AuthLoginOK {
+		t.Fatalf("login failed: %d %s", resp.StatusCode, resp.Body)
+	}
+	if pid := client.CurrentPID(); pid != testEmail {
+		t.Error("pid wrong:", pid)
+	}
+
+	// Cookies are kept per client
+	if pid := server.NewClient().CurrentPID(); pid != "" {
+		t.Error("a new client should not be logged in:", pid)
+	}
+
+	client.Logout()
+	if pid := client.CurrentPID(); pid != "" {
+		t.Error("the user should be logged out:", pid)
+	}
+}
+
+func TestLoginFailure(t *testing.T) {
+	t.Parallel()
+
+	server := NewServer(t, Options{
+		Modules: []string{"auth", "register", "logout"},
+	})
+	client := server.NewClient()
+
+	// Without confirm registering logs the user in
+	client.Register(testEmail, testPassword)
+	if pid := client.CurrentPID(); pid != testEmail {
+		t.Error("pid wrong:", pid)
+	}
+	client.Logout()
+
+	resp := client.Login(testEmail, "wrong")
+	if resp.StatusCode != http.StatusOK || resp.Data[authboss.DataErr] == nil {
+		t.Errorf("login should have failed: %d %s", resp.StatusCode, resp.Body)
+	}
+	if pid := client.CurrentPID(); pid != "" {
+		t.Error("the user should not be logged in:", pid)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	t.Parallel()
+
+	// ratelimit is loaded last, routes registered before it are limited too
+	server := NewServer(t, Options{
+		Modules: []string{"auth", "register", "logout", "ratelimit"},
+		Configure: func(config *authboss.Config) {
+			config.Modules.RateLimits = map[string]authboss.RateLimitPolicy{
+				"/login": {IP: authboss.RateLimit{Limit: 2, Window: time.Minute}},
+			}
+		},
+	})
+	client := server.NewClient()
+
+	for i := 0; i < 2; i++ {
+		if resp := client.Login(testEmail, "wrong"); resp.StatusCode != http.StatusOK {
+			t.Fatalf("%d) login should not be limited yet: %d %s", i, resp.StatusCode, resp.Body)
+		}
+	}
+
+	resp := client.Login(testEmail, "wrong")
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("login should be limited: %d %s", resp.StatusCode, resp.Body)
+	}
+	if len(resp.Header.Get("Retry-After")) == 0 {
+		t.Error("retry-after should be set")
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	t.Parallel()
+
+	server := NewServer(t, Options{
+		Configure: func(config *authboss.Config) {
+			config.Paths.AuthLoginOK = "/dashboard"
+		},
+	})
+	client := server.NewClient()
+
+	client.Register(testEmail, testPassword)
+	client.FollowConfirmEmail(testEmail)
+	client.Login(testEmail, testPassword)
+
+	secret, recoveryCodes := client.SetupTOTP()
+	if len(secret) == 0 || len(recoveryCodes) == 0 {
+		t.Fatal("totp setup should return a secret and recovery codes")
+	}
+	client.Logout()
+
+	resp := client.Login(testEmail, testPassword)
+	if !strings.HasSuffix(resp.Location(), "/2fa/totp/validate") {
+		t.Fatalf("login should redirect to totp validation: %s", resp.Body)
+	}
+	if pid := client.CurrentPID(); pid != "" {
+		t.Error("the user should not be logged in before totp:", pid)
+	}
+
+	// The code used to set up totp can't be used again
+	if resp := client.CompleteTOTP(secret); resp.Data[authboss.DataValidation] == nil {
+		t.Errorf("a repeated code should fail: %s", resp.Body)
+	}
+
+	server.Clock.Advance(30 * time.Second)
+	resp = client.CompleteTOTP(secret)
+	if resp.Status() != "success" || resp.Location() != "/dashboard" {
+		t.Fatalf("totp failed: %d %s", resp.StatusCode, resp.Body)
+	}
+	if pid := client.CurrentPID(); pid != testEmail {
+		t.Error("pid wrong:", pid)
+	}
+}
+
+func TestRecoverEmail(t *testing.T) {
+	t.Parallel()
+
+	server := NewServer(t, Options{})
+	client := server.NewClient()
+
+	client.Register(testEmail, testPassword)
+	client.FollowConfirmEmail(testEmail)
+
+	client.Post("/auth/recover", map[string]string{"email": testEmail})
+
+	email, ok := server.Mailer.Last(testEmail)
+	if !ok {
+		t.Fatal("no e-mail was sent")
+	}
+	u, err := EmailURL(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Path != "/auth/recover/end" || len(u.Query().Get("token")) == 0 {
+		t.Error("url wrong:", u)
+	}
+
+	if len(server.Mailer.Emails()) != 2 {
+		t.Error("both the confirm and recover e-mails should be captured")
+	}
+}
+
+func TestSMS(t *testing.T) {
+	t.Parallel()
+
+	server := NewServer(t, Options{})
+	client := server.NewClient()
+
+	client.Register(testEmail, testPassword)
+	client.FollowConfirmEmail(testEmail)
+	client.Login(testEmail, testPassword)
+
+	client.Post("/auth/2fa/sms/setup", map[string]string{"phone_number": "+15555555555"})
+	// Posting the confirm page without a code sends one
+	client.Post("/auth/2fa/sms/confirm", map[string]string{})
+
+	sms, ok := server.SMS.Last("+15555555555")
+	if !ok {
+		t.Fatal("no sms was sent")
+	}
+
+	resp := client.Post("/auth/2fa/sms/confirm", map[string]string{"code": sms.Text})
+	if resp.Data["recovery_codes"] == nil {
+		t.Errorf("sms confirm failed: %d %s", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestClock(t *testing.T) {
+	t.Parallel()
+
+	server := NewServer(t, Options{})
+	client := server.NewClient()
+
+	client.Register(testEmail, testPassword)
+	client.FollowConfirmEmail(testEmail)
+	client.Post("/auth/recover", map[string]string{"email": testEmail})
+
+	email, ok := server.Mailer.Last(testEmail)
+	if !ok {
+		t.Fatal("no e-mail was sent")
+	}
+
+	u, err := EmailURL(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The recover token expires after a day
+	server.Clock.Advance(25 * time.Hour)
+	resp := client.Post(u.Path, map[string]string{
+		"token":                             u.Query().Get("token"),
+		"password":                          testPassword,
+		authboss.ConfirmPrefix + "password": testPassword,
+	})
+	if resp.Status() != "failure" || resp.Data[authboss.DataValidation] == nil {
+		t.Errorf("an expired recover link should fail: %d %s", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestRegisterSession(t *testing.T) {
+	t.Parallel()
+
+	server := NewServer(t, Options{
+		Modules: []string{"auth", "register", "logout", "sessions"},
+	})
+	client := server.NewClient()
+
+	client.Register(testEmail, testPassword)
+	if pid := client.CurrentPID(); pid != testEmail {
+		t.Fatal("pid wrong:", pid)
+	}
+
+	records, err := server.Storer.ListSessions(context.Background(), testEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatal("the login after registering should be recorded:", records)
+	}
+
+	other := server.NewClient()
+	other.Login(testEmail, testPassword)
+	if resp := other.Post("/auth/sessions/revoke/all", map[string]string{}); resp.Status() != "success" {
+		t.Fatalf("revoke all failed: %d %s", resp.StatusCode, resp.Body)
+	}
+
+	if pid := client.CurrentPID(); pid != "" {
+		t.Error("the registered session should be revoked:", pid)
+	}
+	if pid := other.CurrentPID(); pid != testEmail {
+		t.Error("the revoking session should stay logged in:", pid)
+	}
+}
+
+func TestRegisterSessionConfirm(t *testing.T) {
+	t.Parallel()
+
+	// sessions is loaded first so its register handler runs before
+	// confirm has handled the request
+	server := NewServer(t, Options{
+		Modules: []string{"sessions", "auth", "confirm", "register", "logout"},
+	})
+	client := server.NewClient()
+
+	client.Register(testEmail, testPassword)
+
+	records, err := server.Storer.ListSessions(context.Background(), testEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Error("an unconfirmed user is not logged in and should have no session:", records)
+	}
+}
@@ -0,0 +1,243 @@
+package abtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"path"
+	"testing"
+
+	"github.com/pquerna/otp/totp"
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/otp/twofactor"
+	"github.com/volatiletech/authboss/v3/otp/twofactor/totp2fa"
+)
+
+// Client makes json api requests to a Server keeping its cookies between
+// them like a browser would. Its methods fail the test when a request
+// can't be made so they must be called from the test's goroutine, what
+// the server responded with is left to the test to check.
+type Client struct {
+	HTTP *http.Client
+
+	server *Server
+	t      testing.TB
+}
+
+// Response is a response from the server, the json renderer and api
+// redirects both respond with a json object that's parsed into Data.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Data       map[string]any
+}
+
+// Status is "success" or "failure"
+func (r *Response) Status() string { return r.String("status") }
+
+// Location is where the client is being redirected to
+func (r *Response) Location() string { return r.String("location") }
+
+// String returns the string value for key in Data
+func (r *Response) String(key string) string {
+	s, _ := r.Data[key].(string)
+	return s
+}
+
+// NewClient creates a client with an empty cookie jar
+func (s *Server) NewClient() *Client {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		s.t.Fatal("failed to create cookie jar:", err)
+	}
+
+	return &Client{
+		HTTP: &http.Client{
+			Jar: jar,
+			// Api redirects are responses that the test should see
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		server: s,
+		t:      s.t,
+	}
+}
+
+// Do makes a request, values are sent as the json body when they're not
+// nil. Paths that don't start with the mount path are not in authboss.
+func (c *Client) Do(method, urlPath string, values map[string]string) *Response {
+	c.t.Helper()
+
+	var body io.Reader
+	if values != nil {
+		b, err := json.Marshal(values)
+		if err != nil {
+			c.t.Fatal("failed to encode request body:", err)
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, c.server.URL+urlPath, body)
+	if err != nil {
+		c.t.Fatal("failed to create request:", err)
+	}
+	// The redirector responds with json instead of redirecting when the
+	// request is json
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		c.t.Fatalf("%s %s failed: %v", method, urlPath, err)
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatalf("%s %s failed to read body: %v", method, urlPath, err)
+	}
+
+	r := &Response{StatusCode: resp.StatusCode, Header: resp.Header, Body: b}
+	// Not everything is json, like http.Error responses
+	_ = json.Unmarshal(b, &r.Data)
+
+	return r
+}
+
+// Get a path
+func (c *Client) Get(urlPath string) *Response {
+	c.t.Helper()
+	return c.Do(http.MethodGet, urlPath, nil)
+}
+
+// Post values to a path
+func (c *Client) Post(urlPath string, values map[string]string) *Response {
+	c.t.Helper()
+	return c.Do(http.MethodPost, urlPath, values)
+}
+
+// Register a user, when confirm is loaded they have to follow the
+// confirm e-mail before they can log in
+func (c *Client) Register(email, password string) *Response {
+	c.t.Helper()
+	return c.Post(c.mounted("/register"), map[string]string{
+		"email":                             email,
+		"password":                          password,
+		authboss.ConfirmPrefix + "password": password,
+	})
+}
+
+// Login with an e-mail address and password. If the user has two factor
+// auth enabled the response's Location is where to complete it, for
+// totp see CompleteTOTP.
+func (c *Client) Login(email, password string) *Response {
+	c.t.Helper()
+	return c.Post(c.mounted("/login"), map[string]string{
+		"email":    email,
+		"password": password,
+	})
+}
+
+// FollowConfirmEmail follows the link in the newest e-mail sent to the
+// address, which is expected to be the confirm e-mail
+func (c *Client) FollowConfirmEmail(email string) *Response {
+	c.t.Helper()
+
+	mail, ok := c.server.Mailer.Last(email)
+	if !ok {
+		c.t.Fatal("no e-mail was sent to", email)
+	}
+
+	return c.FollowEmail(mail)
+}
+
+// FollowEmail follows the link in an e-mail. The server uses POST for the
+// links so the link's query values are posted to its path.
+func (c *Client) FollowEmail(email authboss.Email) *Response {
+	c.t.Helper()
+
+	u, err := EmailURL(email)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	values := make(map[string]string)
+	for k, v := range u.Query() {
+		values[k] = v[0]
+	}
+
+	return c.Post(u.Path, values)
+}
+
+// SetupTOTP enables totp two factor auth for the logged in user and
+// returns the secret and recovery codes
+func (c *Client) SetupTOTP() (secret string, recoveryCodes []string) {
+	c.t.Helper()
+
+	if resp := c.Post(c.mounted("/2fa/totp/setup"), map[string]string{}); resp.Status() != "success" {
+		c.t.Fatalf("totp setup failed: %d %s", resp.StatusCode, resp.Body)
+	}
+
+	resp := c.Get(c.mounted("/2fa/totp/confirm"))
+	secret = resp.String(totp2fa.DataTOTPSecret)
+	if len(secret) == 0 {
+		c.t.Fatalf("totp confirm has no secret: %d %s", resp.StatusCode, resp.Body)
+	}
+
+	resp = c.Post(c.mounted("/2fa/totp/confirm"), map[string]string{"code": c.totpCode(secret)})
+	codes, ok := resp.Data[twofactor.DataRecoveryCodes].([]any)
+	if !ok {
+		c.t.Fatalf("totp confirm failed: %d %s", resp.StatusCode, resp.Body)
+	}
+	for _, code := range codes {
+		recoveryCodes = append(recoveryCodes, code.(string))
+	}
+
+	return secret, recoveryCodes
+}
+
+// CompleteTOTP finishes a login that was redirected to totp validation
+// with a code for the server's Clock. Codes can only be used once so the
+// clock must be advanced by a totp period (30 seconds) between codes.
+func (c *Client) CompleteTOTP(secret string) *Response {
+	c.t.Helper()
+	return c.Post(c.mounted("/2fa/totp/validate"), map[string]string{"code": c.totpCode(secret)})
+}
+
+// Logout using the configured method
+func (c *Client) Logout() *Response {
+	c.t.Helper()
+	return c.Do(c.server.AB.Config.Modules.LogoutMethod, c.mounted("/logout"), nil)
+}
+
+// CurrentPID asks the default handler who is logged in, it's empty when
+// no one is
+func (c *Client) CurrentPID() string {
+	c.t.Helper()
+
+	resp := c.Get("/")
+	if resp.StatusCode != http.StatusOK {
+		c.t.Fatalf("failed to get the current pid: %d %s", resp.StatusCode, resp.Body)
+	}
+
+	return resp.String("pid")
+}
+
+func (c *Client) mounted(p string) string {
+	return path.Join(c.server.AB.Config.Paths.Mount, p)
+}
+
+func (c *Client) totpCode(secret string) string {
+	c.t.Helper()
+
+	code, err := totp.GenerateCode(secret, c.server.Clock.Now())
+	if err != nil {
+		c.t.Fatal("failed to generate totp code:", err)
+	}
+
+	return code
+}
@@ -0,0 +1,117 @@
+package abtest
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"sync"
+
+	"github.com/friendsofgo/errors"
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/confirm"
+	"github.com/volatiletech/authboss/v3/recover"
+)
+
+// Mailer captures the e-mails authboss sends
+type Mailer struct {
+	mut    sync.Mutex
+	emails []authboss.Email
+}
+
+// Send captures the e-mail
+func (m *Mailer) Send(ctx context.Context, email authboss.Email) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	m.emails = append(m.emails, email)
+	return nil
+}
+
+// Emails that have been sent, the oldest first
+func (m *Mailer) Emails() []authboss.Email {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	return append([]authboss.Email(nil), m.emails...)
+}
+
+// Last returns the newest e-mail sent to the address
+func (m *Mailer) Last(to string) (authboss.Email, bool) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	for i := len(m.emails) - 1; i >= 0; i-- {
+		for _, addr := range m.emails[i].To {
+			if addr == to {
+				return m.emails[i], true
+			}
+		}
+	}
+
+	return authboss.Email{}, false
+}
+
+// emailURLKeys are the data keys the modules put the link in, most of
+// them use the same key as confirm
+var emailURLKeys = []string{confirm.DataConfirmURL, recover.DataRecoverURL}
+
+// EmailURL finds the link in an e-mail. The server renders e-mails with
+// defaults.JSONRenderer so the body is the json of the template data.
+func EmailURL(email authboss.Email) (*url.URL, error) {
+	var data map[string]any
+	if err := json.Unmarshal([]byte(email.TextBody), &data); err != nil {
+		return nil, errors.Wrap(err, "failed to parse e-mail body")
+	}
+
+	for _, key := range emailURLKeys {
+		if link, ok := data[key].(string); ok && len(link) != 0 {
+			return url.Parse(link)
+		}
+	}
+
+	return nil, errors.Errorf("e-mail %q has no url", email.Subject)
+}
+
+// SMS is a message captured by SMSSender
+type SMS struct {
+	Number string
+	Text   string
+}
+
+// SMSSender captures the sms messages sms2fa sends, the text of them is
+// the code
+type SMSSender struct {
+	mut      sync.Mutex
+	messages []SMS
+}
+
+// Send captures the message
+func (s *SMSSender) Send(ctx context.Context, number, text string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.messages = append(s.messages, SMS{Number: number, Text: text})
+	return nil
+}
+
+// Messages that have been sent, the oldest first
+func (s *SMSSender) Messages() []SMS {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return append([]SMS(nil), s.messages...)
+}
+
+// Last returns the newest message sent to the number
+func (s *SMSSender) Last(number string) (SMS, bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	for i := len(s.messages) - 1; i >= 0; i-- {
+		if s.messages[i].Number == number {
+			return s.messages[i], true
+		}
+	}
+
+	return SMS{}, false
+}
@@ -0,0 +1,447 @@
+// Package apikey lets logged in users create long lived api keys for
+// scripts and other non-interactive clients, and authenticates requests
+// that use them with an Authorization: Token header.
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/friendsofgo/errors"
+	"github.com/volatiletech/authboss/v3"
+)
+
+const (
+	nKeySize   = 32
+	nKeyIDSize = 16
+
+	// touchInterval is how often the LastUsed of an api key is updated,
+	// this avoids a write to the database on every request.
+	touchInterval = time.Minute
+)
+
+// Pages
+const (
+	PageAPIKeys = "apikeys"
+	// PageAPIKeysCreate is only really used for the BodyReader
+	PageAPIKeysCreate = "apikeys_create"
+	// PageAPIKeysRevoke is only really used for the BodyReader
+	PageAPIKeysRevoke = "apikeys_revoke"
+)
+
+// Data constants
+const (
+	// DataAPIKeys is the list of the user's APIKeys, their hashes
+	// are removed.
+	DataAPIKeys = "api_keys"
+	// DataAPIKey is a newly created key, this is the only time it
+	// can be shown to the user.
+	DataAPIKey = "api_key"
+	// DataAPIKeyScopes is the list of scopes a key can be given
+	DataAPIKeyScopes = "api_key_scopes"
+)
+
+type contextKey string
+
+const ctxKeyAPIKey contextKey = "apikey"
+
+var (
+	// ErrInvalidKey is returned when an api key can't be decoded
+	ErrInvalidKey = errors.New("api key is invalid")
+)
+
+func init() {
+	authboss.RegisterModule("apikey", &APIKeys{})
+}
+
+// APIKeys module
+type APIKeys struct {
+	*authboss.Authboss
+}
+
+// APIKeyValuer returns the name and scopes of a new api key
+type APIKeyValuer interface {
+	authboss.Validator
+
+	GetName() string
+	GetScopes() []string
+}
+
+// RevokeValuer returns the id of the api key to revoke
+type RevokeValuer interface {
+	authboss.Validator
+
+	GetAPIKeyID() string
+}
+
+// MustHaveAPIKeyValues upgrades a validatable set of values
+// to ones specific to creating an api key.
+func MustHaveAPIKeyValues(v authboss.Validator) APIKeyValuer {
+	if u, ok := v.(APIKeyValuer); ok {
+		return u
+	}
+
+	panic(fmt.Sprintf("bodyreader returned a type that could not be upgraded to APIKeyValuer: %T", v))
+}
+
+// MustHaveRevokeValues upgrades a validatable set of values
+// to ones specific to revoking an api key.
+func MustHaveRevokeValues(v authboss.Validator) RevokeValuer {
+	if u, ok := v.(RevokeValuer); ok {
+		return u
+	}
+
+	panic(fmt.Sprintf("bodyreader returned a type that could not be upgraded to RevokeValuer: %T", v))
+}
+
+// Init module
+func (a *APIKeys) Init(ab *authboss.Authboss) error {
+	a.Authboss = ab
+
+	// Ensure the storer is capable before anything is registered
+	_ = authboss.EnsureCanAPIKey(a.Config.Storage.Server)
+
+	if err := a.Core.ViewRenderer.Load(PageAPIKeys); err != nil {
+		return err
+	}
+
+	var unauthedResponse authboss.MWRespondOnFailure
+	if a.Config.Modules.ResponseOnUnauthed != 0 {
+		unauthedResponse = a.Config.Modules.ResponseOnUnauthed
+	} else if a.Config.Modules.RoutesRedirectOnUnauthed {
+		unauthedResponse = authboss.RespondRedirect
+	}
+	abmw := authboss.MountedMiddleware2(a.Authboss, true, authboss.RequireFullAuth, unauthedResponse)
+	middleware := func(handler func(http.ResponseWriter, *http.Request) error) http.Handler {
+		return abmw(a.requireSession(a.Core.ErrorHandler.Wrap(handler)))
+	}
+
+	a.Core.Router.Get("/apikeys", middleware(a.Get))
+	a.Core.Router.Post("/apikeys", middleware(a.Post))
+	a.Core.Router.Post("/apikeys/revoke", middleware(a.PostRevoke))
+
+	return nil
+}
+
+// requireSession responds with a 403 unless the user logged in with a
+// session. Requests authenticated with a header (an api key or a bearer
+// token) must not be able to create keys or revoke the user's other keys,
+// otherwise a scoped key could be used to make an unscoped one.
+func (a *APIKeys) requireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, usedKey := CurrentAPIKey(r)
+		sessionPID, hasSession := authboss.GetSession(r, authboss.SessionKey)
+		pid, err := a.CurrentUserID(r)
+
+		if usedKey || !hasSession || err != nil || sessionPID != pid {
+			logger := a.RequestLogger(r)
+			logger.Infof("refused to manage api keys for user %s without a session login", pid)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Get lists the api keys of the current user
+func (a *APIKeys) Get(w http.ResponseWriter, r *http.Request) error {
+	return a.respond(w, r, nil)
+}
+
+// Post creates a new api key for the current user. The key is only
+// ever shown in the response to this request.
+func (a *APIKeys) Post(w http.ResponseWriter, r *http.Request) error {
+	logger := a.RequestLogger(r)
+
+	validatable, err := a.Core.BodyReader.Read(PageAPIKeysCreate, r)
+	if err != nil {
+		return err
+	}
+
+	if errs := validatable.Validate(); errs != nil {
+		logger.Info("api key validation failed")
+		return a.respond(w, r, authboss.HTMLData{authboss.DataValidation: authboss.ErrorMap(errs)})
+	}
+
+	values := MustHaveAPIKeyValues(validatable)
+
+	scopes, ok := a.checkScopes(values.GetScopes())
+	if !ok {
+		logger.Infof("api key was given unknown scopes: %v", values.GetScopes())
+		return a.respond(w, r, authboss.HTMLData{authboss.DataErr: a.Localizef(r.Context(), authboss.TxtInvalidAPIKeyScope)})
+	}
+
+	id, hash, key, err := GenerateKey()
+	if err != nil {
+		return err
+	}
+
+	pid := a.CurrentUserIDP(r)
+	apiKey := authboss.APIKey{
+		ID:        id,
+		PID:       pid,
+		Name:      values.GetName(),
+		Hash:      hash,
+		Scopes:    scopes,
+		CreatedAt: a.Now(),
+	}
+
+	storer := authboss.EnsureCanAPIKey(a.Config.Storage.Server)
+	if err = storer.CreateAPIKey(r.Context(), apiKey); err != nil {
+		return err
+	}
+
+	logger.Infof("user %s created api key %s", pid, id)
+
+	return a.respond(w, r, authboss.HTMLData{DataAPIKey: key})
+}
+
+// PostRevoke deletes one of the current user's api keys
+func (a *APIKeys) PostRevoke(w http.ResponseWriter, r *http.Request) error {
+	logger := a.RequestLogger(r)
+
+	validatable, err := a.Core.BodyReader.Read(PageAPIKeysRevoke, r)
+	if err != nil {
+		return err
+	}
+	id := MustHaveRevokeValues(validatable).GetAPIKeyID()
+
+	pid := a.CurrentUserIDP(r)
+	storer := authboss.EnsureCanAPIKey(a.Config.Storage.Server)
+
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: path.Join(a.Paths.Mount, "/apikeys"),
+	}
+
+	apiKey, err := storer.LoadAPIKey(r.Context(), id)
+	if err == authboss.ErrAPIKeyNotFound || (err == nil && apiKey.PID != pid) {
+		logger.Infof("user %s tried to revoke an api key that does not exist or is not theirs", pid)
+		ro.Failure = a.Localizef(r.Context(), authboss.TxtAPIKeyNotFound)
+		return a.Core.Redirector.Redirect(w, r, ro)
+	} else if err != nil {
+		return err
+	}
+
+	if err = storer.DeleteAPIKey(r.Context(), id); err != nil {
+		return err
+	}
+
+	logger.Infof("user %s revoked api key %s", pid, id)
+
+	ro.Success = a.Localizef(r.Context(), authboss.TxtAPIKeyRevoked)
+	return a.Core.Redirector.Redirect(w, r, ro)
+}
+
+// respond with the api keys page, the user's keys and the allowed scopes
+// are added to data.
+func (a *APIKeys) respond(w http.ResponseWriter, r *http.Request, data authboss.HTMLData) error {
+	storer := authboss.EnsureCanAPIKey(a.Config.Storage.Server)
+
+	apiKeys, err := storer.ListAPIKeys(r.Context(), a.CurrentUserIDP(r))
+	if err != nil {
+		return err
+	}
+
+	// The hashes have no use in a view, don't hand them out
+	for i := range apiKeys {
+		apiKeys[i].Hash = ""
+	}
+
+	page := authboss.HTMLData{
+		DataAPIKeys:      apiKeys,
+		DataAPIKeyScopes: a.Config.Modules.APIKeyScopes,
+	}
+	return a.Core.Responder.Respond(w, r, http.StatusOK, PageAPIKeys, page.Merge(data))
+}
+
+// checkScopes removes duplicates from scopes and makes sure they're all
+// in Modules.APIKeyScopes
+func (a *APIKeys) checkScopes(scopes []string) ([]string, bool) {
+	var checked []string
+
+Scopes:
+	for _, scope := range scopes {
+		for _, seen := range checked {
+			if seen == scope {
+				continue Scopes
+			}
+		}
+
+		found := false
+		for _, allowed := range a.Config.Modules.APIKeyScopes {
+			if scope == allowed {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+
+		checked = append(checked, scope)
+	}
+
+	return checked, true
+}
+
+// Middleware authenticates requests that have an Authorization: Token
+// header by putting the pid, user and api key into the request context.
+// Requests without the header are passed through untouched and ones with
+// an unknown key get a 401.
+func Middleware(ab *authboss.Authboss) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, ok := keyFromHeader(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			err := Authenticate(ab, &r, key)
+			switch {
+			case err == ErrInvalidKey || err == authboss.ErrAPIKeyNotFound || err == authboss.ErrUserNotFound:
+				logger := ab.RequestLogger(r)
+				logger.Infof("rejected api key: %v", err)
+				w.Header().Set("WWW-Authenticate", "Token")
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			case err != nil:
+				logger := ab.RequestLogger(r)
+				logger.Errorf("failed to authenticate api key: %+v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Authenticate the request with the api key. The pid, user and api key
+// are put into the request's context and the key's LastUsed is updated
+// if it's more than a minute old.
+func Authenticate(ab *authboss.Authboss, r **http.Request, key string) error {
+	hash, err := HashKey(key)
+	if err != nil {
+		return err
+	}
+
+	ctx := (*r).Context()
+	storer := authboss.EnsureCanAPIKey(ab.Config.Storage.Server)
+
+	apiKey, err := storer.LoadAPIKeyByHash(ctx, hash)
+	if err != nil {
+		return err
+	}
+
+	user, err := ab.Config.Storage.Server.Load(ctx, apiKey.PID)
+	if err != nil {
+		return err
+	}
+
+	if now := ab.Now(); now.Sub(apiKey.LastUsed) > touchInterval {
+		apiKey.LastUsed = now
+		if err = storer.TouchAPIKey(ctx, apiKey.ID, apiKey.LastUsed); err != nil {
+			return err
+		}
+	}
+
+	apiKey.Hash = ""
+	ctx = context.WithValue(ctx, authboss.CTXKeyPID, apiKey.PID)
+	ctx = context.WithValue(ctx, authboss.CTXKeyUser, user)
+	ctx = context.WithValue(ctx, authboss.CTXKeyHeaderAuth, true)
+	ctx = context.WithValue(ctx, ctxKeyAPIKey, apiKey)
+	*r = (*r).WithContext(ctx)
+
+	return nil
+}
+
+// CurrentAPIKey returns the api key the request was authenticated with,
+// ok is false if the request did not use one.
+func CurrentAPIKey(r *http.Request) (apiKey authboss.APIKey, ok bool) {
+	apiKey, ok = r.Context().Value(ctxKeyAPIKey).(authboss.APIKey)
+	return apiKey, ok
+}
+
+// HasScope checks if the api key was given the scope
+func HasScope(apiKey authboss.APIKey, scope string) bool {
+	for _, s := range apiKey.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RequireScopes responds with a 403 to requests that were authenticated
+// with an api key that is missing any of the scopes. Requests that did
+// not use an api key are passed through, use authboss.Middleware2 to
+// make sure there is a user at all.
+func RequireScopes(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if apiKey, ok := CurrentAPIKey(r); ok {
+				for _, scope := range scopes {
+					if !HasScope(apiKey, scope) {
+						w.WriteHeader(http.StatusForbidden)
+						return
+					}
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// GenerateKey creates a new api key. The id and hash should be stored
+// and the key is given to the user.
+func GenerateKey() (id, hash, key string, err error) {
+	rawID := make([]byte, nKeyIDSize)
+	if _, err = io.ReadFull(rand.Reader, rawID); err != nil {
+		return "", "", "", errors.Wrap(err, "failed to create api key id")
+	}
+
+	rawKey := make([]byte, nKeySize)
+	if _, err = io.ReadFull(rand.Reader, rawKey); err != nil {
+		return "", "", "", errors.Wrap(err, "failed to create api key")
+	}
+
+	sum := sha512.Sum512(rawKey)
+	return base64.RawURLEncoding.EncodeToString(rawID),
+		base64.StdEncoding.EncodeToString(sum[:]),
+		base64.URLEncoding.EncodeToString(rawKey),
+		nil
+}
+
+// HashKey returns the hash of an api key that is stored
+func HashKey(key string) (string, error) {
+	rawKey, err := base64.URLEncoding.DecodeString(key)
+	if err != nil || len(rawKey) != nKeySize {
+		return "", ErrInvalidKey
+	}
+
+	sum := sha512.Sum512(rawKey)
+	return base64.StdEncoding.EncodeToString(sum[:]), nil
+}
+
+func keyFromHeader(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) < 6 || !strings.EqualFold(header[:6], "token ") {
+		return "", false
+	}
+
+	key := strings.TrimSpace(header[6:])
+	return key, len(key) != 0
+}
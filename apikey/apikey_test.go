@@ -0,0 +1,449 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+
+	router := &mocks.Router{}
+	renderer := &mocks.Renderer{}
+	errHandler := &mocks.ErrorHandler{}
+	ab.Config.Core.Router = router
+	ab.Config.Core.ViewRenderer = renderer
+	ab.Config.Core.ErrorHandler = errHandler
+	ab.Config.Storage.Server = mocks.NewServerStorer()
+
+	a := &APIKeys{}
+	if err := a.Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := renderer.HasLoadedViews(PageAPIKeys); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasGets("/apikeys"); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasPosts("/apikeys", "/apikeys/revoke"); err != nil {
+		t.Error(err)
+	}
+}
+
+type testHarness struct {
+	apikeys *APIKeys
+	ab      *authboss.Authboss
+
+	bodyReader *mocks.BodyReader
+	redirector *mocks.Redirector
+	responder  *mocks.Responder
+	storer     *mocks.ServerStorer
+}
+
+func testSetup() *testHarness {
+	harness := &testHarness{}
+
+	harness.ab = authboss.New()
+	harness.bodyReader = &mocks.BodyReader{}
+	harness.redirector = &mocks.Redirector{}
+	harness.responder = &mocks.Responder{}
+	harness.storer = mocks.NewServerStorer()
+
+	harness.ab.Config.Modules.APIKeyScopes = []string{"read", "write"}
+
+	harness.ab.Config.Core.BodyReader = harness.bodyReader
+	harness.ab.Config.Core.Logger = mocks.Logger{}
+	harness.ab.Config.Core.Redirector = harness.redirector
+	harness.ab.Config.Core.Responder = harness.responder
+	harness.ab.Config.Storage.Server = harness.storer
+
+	harness.storer.Users["test@test.com"] = &mocks.User{Email: "test@test.com"}
+
+	harness.apikeys = &APIKeys{harness.ab}
+
+	return harness
+}
+
+func userRequest(method string) *http.Request {
+	r := mocks.Request(method)
+	return r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyPID, "test@test.com"))
+}
+
+func (h *testHarness) putAPIKey(t *testing.T, id, pid string, scopes ...string) string {
+	t.Helper()
+
+	_, hash, key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.storer.APIKeys[id] = authboss.APIKey{ID: id, PID: pid, Name: id, Hash: hash, Scopes: scopes}
+	return key
+}
+
+func TestGet(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.putAPIKey(t, "1", "test@test.com")
+	h.putAPIKey(t, "2", "other@test.com")
+
+	if err := h.apikeys.Get(httptest.NewRecorder(), userRequest("GET")); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.responder.Page != PageAPIKeys {
+		t.Error("page wrong:", h.responder.Page)
+	}
+	keys := h.responder.Data[DataAPIKeys].([]authboss.APIKey)
+	if len(keys) != 1 || keys[0].ID != "1" {
+		t.Errorf("keys wrong: %#v", keys)
+	}
+	if len(keys[0].Hash) != 0 {
+		t.Error("hash should not be given to the view")
+	}
+	if len(h.storer.APIKeys["1"].Hash) == 0 {
+		t.Error("hash should not be removed from storage")
+	}
+}
+
+func TestPostSuccess(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.bodyReader.Return = mocks.Values{Name: "ci", Scopes: []string{"read", "read"}}
+
+	if err := h.apikeys.Post(httptest.NewRecorder(), userRequest("POST")); err != nil {
+		t.Fatal(err)
+	}
+
+	key, ok := h.responder.Data[DataAPIKey].(string)
+	if !ok || len(key) == 0 {
+		t.Fatal("the key should be shown")
+	}
+	hash, err := HashKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := h.storer.LoadAPIKeyByHash(context.Background(), hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.PID != "test@test.com" || stored.Name != "ci" {
+		t.Errorf("stored key wrong: %#v", stored)
+	}
+	if len(stored.Scopes) != 1 || stored.Scopes[0] != "read" {
+		t.Error("scopes wrong:", stored.Scopes)
+	}
+	if stored.CreatedAt.IsZero() {
+		t.Error("created at should be set")
+	}
+}
+
+func TestPostValidationFailure(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.bodyReader.Return = mocks.Values{Errors: []error{errors.New("name required")}}
+
+	if err := h.apikeys.Post(httptest.NewRecorder(), userRequest("POST")); err != nil {
+		t.Fatal(err)
+	}
+
+	errList := h.responder.Data[authboss.DataValidation].(map[string][]string)
+	if e := errList[""][0]; e != "name required" {
+		t.Error("validation error wrong:", e)
+	}
+	if len(h.storer.APIKeys) != 0 {
+		t.Error("no key should be created")
+	}
+}
+
+func TestPostUnknownScope(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.bodyReader.Return = mocks.Values{Name: "ci", Scopes: []string{"read", "admin"}}
+
+	if err := h.apikeys.Post(httptest.NewRecorder(), userRequest("POST")); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.responder.Data[authboss.DataErr] != authboss.TxtInvalidAPIKeyScope.Default {
+		t.Error("error wrong:", h.responder.Data[authboss.DataErr])
+	}
+	if _, ok := h.responder.Data[DataAPIKey]; ok {
+		t.Error("no key should be shown")
+	}
+	if len(h.storer.APIKeys) != 0 {
+		t.Error("no key should be created")
+	}
+}
+
+func TestPostRevoke(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.putAPIKey(t, "1", "test@test.com")
+	h.bodyReader.Return = mocks.Values{APIKeyID: "1"}
+
+	if err := h.apikeys.PostRevoke(httptest.NewRecorder(), userRequest("POST")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := h.storer.APIKeys["1"]; ok {
+		t.Error("key should be deleted")
+	}
+	opts := h.redirector.Options
+	if opts.RedirectPath != "/auth/apikeys" {
+		t.Error("redirect path wrong:", opts.RedirectPath)
+	}
+	if opts.Success != authboss.TxtAPIKeyRevoked.Default {
+		t.Error("success wrong:", opts.Success)
+	}
+}
+
+func TestPostRevokeNotOwned(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.putAPIKey(t, "1", "other@test.com")
+
+	for _, id := range []string{"1", "unknown"} {
+		h.bodyReader.Return = mocks.Values{APIKeyID: id}
+		if err := h.apikeys.PostRevoke(httptest.NewRecorder(), userRequest("POST")); err != nil {
+			t.Fatal(err)
+		}
+
+		if opts := h.redirector.Options; opts.Failure != authboss.TxtAPIKeyNotFound.Default {
+			t.Errorf("%s) failure wrong: %s", id, opts.Failure)
+		}
+	}
+
+	if _, ok := h.storer.APIKeys["1"]; !ok {
+		t.Error("another user's key should not be deleted")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	key := h.putAPIKey(t, "1", "test@test.com", "read")
+
+	var called bool
+	var pid string
+	var apiKey authboss.APIKey
+	var headerAuth bool
+	mw := Middleware(h.ab)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		pid, _ = h.ab.CurrentUserID(r)
+		apiKey, _ = CurrentAPIKey(r)
+		headerAuth, _ = r.Context().Value(authboss.CTXKeyHeaderAuth).(bool)
+	}))
+
+	// No header passes through without a user
+	mw.ServeHTTP(httptest.NewRecorder(), mocks.Request("GET"))
+	if !called || len(pid) != 0 || headerAuth {
+		t.Error("it should pass through without a user", called, pid, headerAuth)
+	}
+
+	called = false
+	r := mocks.Request("GET")
+	r.Header.Set("Authorization", "Token "+key)
+	mw.ServeHTTP(httptest.NewRecorder(), r)
+	if !called {
+		t.Fatal("it should call the next handler")
+	}
+	if pid != "test@test.com" {
+		t.Error("pid wrong:", pid)
+	}
+	if apiKey.ID != "1" || len(apiKey.Hash) != 0 {
+		t.Errorf("api key wrong: %#v", apiKey)
+	}
+	if !headerAuth {
+		t.Error("the request should be marked as authenticated by a header")
+	}
+	if h.storer.APIKeys["1"].LastUsed.IsZero() {
+		t.Error("last used should be updated")
+	}
+
+	for _, header := range []string{"Token abc", "Token " + key[:len(key)-4] + "AAA="} {
+		called = false
+		r = mocks.Request("GET")
+		r.Header.Set("Authorization", header)
+		w := httptest.NewRecorder()
+		mw.ServeHTTP(w, r)
+		if called {
+			t.Errorf("%q should not be allowed", header)
+		}
+		if w.Code != http.StatusUnauthorized {
+			t.Error("code wrong:", w.Code)
+		}
+	}
+}
+
+func TestAuthenticateTouchInterval(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	clock := mocks.NewClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	h.ab.Config.Core.Clock = clock
+	key := h.putAPIKey(t, "1", "test@test.com")
+
+	authenticate := func() time.Time {
+		t.Helper()
+
+		r := mocks.Request("GET")
+		if err := Authenticate(h.ab, &r, key); err != nil {
+			t.Fatal(err)
+		}
+		return h.storer.APIKeys["1"].LastUsed
+	}
+
+	first := authenticate()
+	if !first.Equal(clock.Now()) {
+		t.Error("last used should be set on first use:", first)
+	}
+
+	clock.Advance(30 * time.Second)
+	if lastUsed := authenticate(); !lastUsed.Equal(first) {
+		t.Error("last used should not be updated within the interval:", lastUsed)
+	}
+
+	clock.Advance(time.Minute)
+	if lastUsed := authenticate(); !lastUsed.Equal(clock.Now()) {
+		t.Error("last used should be updated after the interval:", lastUsed)
+	}
+}
+
+func TestRequireSession(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	session := mocks.NewClientRW()
+	errHandler := &mocks.ErrorHandler{}
+	h.ab.Config.Storage.SessionState = session
+	h.ab.Config.Core.ErrorHandler = errHandler
+	key := h.putAPIKey(t, "1", "test@test.com", "read")
+
+	h.bodyReader.Return = mocks.Values{Name: "escalated"}
+	handler := h.ab.LoadClientStateMiddleware(Middleware(h.ab)(
+		h.apikeys.requireSession(h.ab.Core.ErrorHandler.Wrap(h.apikeys.Post)),
+	))
+
+	// A scoped key can't be used to make another key, even when the
+	// browser also has a session
+	for _, loggedIn := range []bool{false, true} {
+		if loggedIn {
+			session.ClientValues[authboss.SessionKey] = "test@test.com"
+		}
+
+		r := mocks.Request("POST")
+		r.Header.Set("Authorization", "Token "+key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != http.StatusForbidden {
+			t.Error("code wrong:", w.Code)
+		}
+		if len(h.storer.APIKeys) != 1 {
+			t.Error("no api key should be created")
+		}
+	}
+
+	// Neither can anything else that puts a user into the context
+	// without a session, like a bearer token
+	delete(session.ClientValues, authboss.SessionKey)
+	w := httptest.NewRecorder()
+	h.apikeys.requireSession(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("it should not call the next handler")
+	})).ServeHTTP(w, userRequest("POST"))
+	if w.Code != http.StatusForbidden {
+		t.Error("code wrong:", w.Code)
+	}
+
+	session.ClientValues[authboss.SessionKey] = "test@test.com"
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, mocks.Request("POST"))
+	if errHandler.Error != nil {
+		t.Fatal(errHandler.Error)
+	}
+	if len(h.storer.APIKeys) != 2 {
+		t.Error("a session login should be able to create an api key")
+	}
+}
+
+func TestRequireScopes(t *testing.T) {
+	t.Parallel()
+
+	var called bool
+	mw := RequireScopes("read", "write")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	withKey := func(scopes ...string) *http.Request {
+		r := mocks.Request("GET")
+		apiKey := authboss.APIKey{ID: "1", Scopes: scopes}
+		return r.WithContext(context.WithValue(r.Context(), ctxKeyAPIKey, apiKey))
+	}
+
+	tests := []struct {
+		R      *http.Request
+		Called bool
+	}{
+		{mocks.Request("GET"), true},
+		{withKey("read", "write"), true},
+		{withKey("read"), false},
+		{withKey(), false},
+	}
+
+	for i, test := range tests {
+		called = false
+		w := httptest.NewRecorder()
+		mw.ServeHTTP(w, test.R)
+
+		if called != test.Called {
+			t.Errorf("%d) called wrong: %t", i, called)
+		}
+		if !test.Called && w.Code != http.StatusForbidden {
+			t.Errorf("%d) code wrong: %d", i, w.Code)
+		}
+	}
+}
+
+func TestGenerateKey(t *testing.T) {
+	t.Parallel()
+
+	id, hash, key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(id) == 0 || len(hash) == 0 || len(key) == 0 {
+		t.Fatal("values should be set", id, hash, key)
+	}
+
+	got, err := HashKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != hash {
+		t.Error("hash wrong:", got)
+	}
+
+	if _, err = HashKey("!"); err != ErrInvalidKey {
+		t.Error("it should fail to decode:", err)
+	}
+}
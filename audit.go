@@ -0,0 +1,45 @@
+package authboss
+
+import (
+	"context"
+	"time"
+)
+
+// Audit outcomes
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// AuditRecord is a record of a single event kept by the audit module
+type AuditRecord struct {
+	// PID is empty when the user isn't known, for example a failed
+	// oauth2 login
+	PID string `json:"pid"`
+	// Event is the name of the Event that was fired
+	Event string `json:"event"`
+	// Outcome is AuditSuccess or AuditFailure
+	Outcome string `json:"outcome"`
+
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+
+	Time time.Time `json:"time"`
+}
+
+// AuditStorer keeps the records written by the audit module, the audit
+// package has an implementation that writes json lines.
+type AuditStorer interface {
+	// PutAuditRecord stores a new record
+	PutAuditRecord(ctx context.Context, record AuditRecord) error
+}
+
+// ReadingAuditStorer can also read the records back so that users can
+// see their recent security activity.
+type ReadingAuditStorer interface {
+	AuditStorer
+
+	// RecentAuditRecords returns up to limit of the most recent records
+	// for the given pid, the newest first.
+	RecentAuditRecords(ctx context.Context, pid string, limit int) ([]AuditRecord, error)
+}
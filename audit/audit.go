@@ -0,0 +1,135 @@
+// Package audit keeps a record of every authentication event so that apps
+// can meet compliance requirements and show users their recent security
+// activity.
+package audit
+
+import (
+	"net/http"
+
+	"github.com/friendsofgo/errors"
+	"github.com/volatiletech/authboss/v3"
+)
+
+// Pages
+const (
+	PageAudit = "audit"
+)
+
+// Data constants
+const (
+	// DataAuditRecords is the list of the user's most recent AuditRecords
+	DataAuditRecords = "audit_records"
+)
+
+// AuditedEvents is each event that's recorded and its outcome
+var AuditedEvents = []struct {
+	Event   authboss.Event
+	Outcome string
+}{
+	{authboss.EventRegister, authboss.AuditSuccess},
+	{authboss.EventAuth, authboss.AuditSuccess},
+	{authboss.EventAuthFail, authboss.AuditFailure},
+	{authboss.EventOAuth2, authboss.AuditSuccess},
+	{authboss.EventOAuth2Fail, authboss.AuditFailure},
+	{authboss.EventRecoverStart, authboss.AuditSuccess},
+	{authboss.EventRecoverEnd, authboss.AuditSuccess},
+	{authboss.EventLogout, authboss.AuditSuccess},
+	{authboss.EventTwoFactorAdded, authboss.AuditSuccess},
+	{authboss.EventTwoFactorRemoved, authboss.AuditSuccess},
+	{authboss.EventRememberAuth, authboss.AuditSuccess},
+	{authboss.EventPasswordChange, authboss.AuditSuccess},
+	{authboss.EventEmailChange, authboss.AuditSuccess},
+	{authboss.EventAccountDelete, authboss.AuditSuccess},
+	{authboss.EventUnlock, authboss.AuditSuccess},
+	{authboss.EventBearerAuth, authboss.AuditSuccess},
+}
+
+func init() {
+	authboss.RegisterModule("audit", &Audit{})
+}
+
+// Audit module
+type Audit struct {
+	*authboss.Authboss
+}
+
+// Init module
+func (a *Audit) Init(ab *authboss.Authboss) error {
+	a.Authboss = ab
+
+	if a.Config.Storage.Audit == nil {
+		return errors.New("audit requires Storage.Audit to be set")
+	}
+
+	for _, e := range AuditedEvents {
+		a.Events.After(e.Event, a.recordEvent(e.Event, e.Outcome))
+	}
+
+	// The security activity page needs to read the records back
+	if _, ok := a.Config.Storage.Audit.(authboss.ReadingAuditStorer); !ok {
+		return nil
+	}
+
+	if err := a.Core.ViewRenderer.Load(PageAudit); err != nil {
+		return err
+	}
+
+	var unauthedResponse authboss.MWRespondOnFailure
+	if a.Config.Modules.ResponseOnUnauthed != 0 {
+		unauthedResponse = a.Config.Modules.ResponseOnUnauthed
+	} else if a.Config.Modules.RoutesRedirectOnUnauthed {
+		unauthedResponse = authboss.RespondRedirect
+	}
+	middleware := authboss.MountedMiddleware2(a.Authboss, true, authboss.RequireFullAuth, unauthedResponse)
+
+	a.Core.Router.Get("/audit", middleware(a.Core.ErrorHandler.Wrap(a.Get)))
+
+	return nil
+}
+
+// Get lists the current user's most recent records
+func (a *Audit) Get(w http.ResponseWriter, r *http.Request) error {
+	pid := a.CurrentUserIDP(r)
+	storer := a.Config.Storage.Audit.(authboss.ReadingAuditStorer)
+
+	records, err := storer.RecentAuditRecords(r.Context(), pid, a.Config.Modules.AuditRecentLimit)
+	if err != nil {
+		return err
+	}
+
+	data := authboss.HTMLData{DataAuditRecords: records}
+	return a.Core.Responder.Respond(w, r, http.StatusOK, PageAudit, data)
+}
+
+// recordEvent creates an event handler that writes a record of the event.
+// Failing to write the record is logged rather than failing the request
+// since the event has already happened by the time it's fired.
+func (a *Audit) recordEvent(event authboss.Event, outcome string) authboss.EventHandler {
+	return func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		record := authboss.AuditRecord{
+			PID:       a.eventPID(r),
+			Event:     event.String(),
+			Outcome:   outcome,
+			IP:        authboss.RemoteIP(r),
+			UserAgent: r.UserAgent(),
+			Time:      a.Now(),
+		}
+
+		if err := a.Config.Storage.Audit.PutAuditRecord(r.Context(), record); err != nil {
+			a.RequestLogger(r).Errorf("failed to write audit record for %s: %+v", event, err)
+		}
+
+		return false, nil
+	}
+}
+
+// eventPID finds the user the event is about, the modules put them in the
+// context when they're not the logged in user.
+func (a *Audit) eventPID(r *http.Request) string {
+	if user, ok := r.Context().Value(authboss.CTXKeyUser).(authboss.User); ok && user != nil {
+		return user.GetPID()
+	}
+
+	pid, _ := a.CurrentUserID(r)
+	return pid
+}
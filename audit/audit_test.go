@@ -0,0 +1,175 @@
+package audit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+type testStorer struct {
+	records []authboss.AuditRecord
+}
+
+func (t *testStorer) PutAuditRecord(ctx context.Context, record authboss.AuditRecord) error {
+	t.records = append(t.records, record)
+	return nil
+}
+
+type testReadingStorer struct {
+	testStorer
+}
+
+func (t *testReadingStorer) RecentAuditRecords(ctx context.Context, pid string, limit int) ([]authboss.AuditRecord, error) {
+	var records []authboss.AuditRecord
+	for i := len(t.records) - 1; i >= 0 && len(records) < limit; i-- {
+		if t.records[i].PID == pid {
+			records = append(records, t.records[i])
+		}
+	}
+	return records, nil
+}
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+
+	router := &mocks.Router{}
+	renderer := &mocks.Renderer{}
+	errHandler := &mocks.ErrorHandler{}
+	ab.Config.Core.Router = router
+	ab.Config.Core.ViewRenderer = renderer
+	ab.Config.Core.ErrorHandler = errHandler
+	ab.Config.Storage.Audit = &testReadingStorer{}
+
+	a := &Audit{}
+	if err := a.Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := renderer.HasLoadedViews(PageAudit); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasGets("/audit"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInitNoStorer(t *testing.T) {
+	t.Parallel()
+
+	a := &Audit{}
+	if err := a.Init(authboss.New()); err == nil {
+		t.Error("it should require an audit storer")
+	}
+}
+
+func TestRecordEvents(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+	ab.Config.Core.Logger = mocks.Logger{}
+	storer := &testStorer{}
+	ab.Config.Storage.Audit = storer
+
+	a := &Audit{}
+	if err := a.Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	user := &mocks.User{Email: "test@test.com"}
+
+	r := httptest.NewRequest("POST", "/login", nil)
+	r.RemoteAddr = "1.1.1.1:1234"
+	r.Header.Set("User-Agent", "test-agent")
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+
+	if _, err := ab.Events.FireAfter(authboss.EventAuthFail, httptest.NewRecorder(), r); err != nil {
+		t.Fatal(err)
+	}
+
+	r = httptest.NewRequest("POST", "/remember", nil)
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyPID, "other@test.com"))
+	if _, err := ab.Events.FireAfter(authboss.EventRememberAuth, httptest.NewRecorder(), r); err != nil {
+		t.Fatal(err)
+	}
+
+	// Events that aren't audited are ignored
+	if _, err := ab.Events.FireAfter(authboss.EventGetUser, httptest.NewRecorder(), r); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(storer.records) != 2 {
+		t.Fatal("wrong number of records:", len(storer.records))
+	}
+
+	record := storer.records[0]
+	if record.PID != "test@test.com" {
+		t.Error("pid wrong:", record.PID)
+	}
+	if record.Event != "EventAuthFail" {
+		t.Error("event wrong:", record.Event)
+	}
+	if record.Outcome != authboss.AuditFailure {
+		t.Error("outcome wrong:", record.Outcome)
+	}
+	if record.IP != "1.1.1.1" {
+		t.Error("ip wrong:", record.IP)
+	}
+	if record.UserAgent != "test-agent" {
+		t.Error("user agent wrong:", record.UserAgent)
+	}
+	if record.Time.IsZero() {
+		t.Error("time should be set")
+	}
+
+	record = storer.records[1]
+	if record.PID != "other@test.com" {
+		t.Error("pid wrong:", record.PID)
+	}
+	if record.Outcome != authboss.AuditSuccess {
+		t.Error("outcome wrong:", record.Outcome)
+	}
+}
+
+func TestGet(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+	responder := &mocks.Responder{}
+	ab.Config.Core.Responder = responder
+	ab.Config.Modules.AuditRecentLimit = 1
+
+	storer := &testReadingStorer{}
+	storer.records = []authboss.AuditRecord{
+		{PID: "test@test.com", Event: "EventAuth"},
+		{PID: "other@test.com", Event: "EventAuth"},
+		{PID: "test@test.com", Event: "EventLogout"},
+	}
+	ab.Config.Storage.Audit = storer
+
+	a := &Audit{ab}
+
+	r := mocks.Request("GET")
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyPID, "test@test.com"))
+
+	if err := a.Get(httptest.NewRecorder(), r); err != nil {
+		t.Fatal(err)
+	}
+
+	if responder.Status != http.StatusOK {
+		t.Error("status wrong:", responder.Status)
+	}
+	if responder.Page != PageAudit {
+		t.Error("page wrong:", responder.Page)
+	}
+
+	records := responder.Data[DataAuditRecords].([]authboss.AuditRecord)
+	if len(records) != 1 || records[0].Event != "EventLogout" {
+		t.Errorf("records wrong: %#v", records)
+	}
+}
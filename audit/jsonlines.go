@@ -0,0 +1,142 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/friendsofgo/errors"
+	"github.com/volatiletech/authboss/v3"
+)
+
+// maxLineSize is the longest line ReadJSONLines accepts
+const maxLineSize = 1024 * 1024
+
+// JSONLines is an authboss.AuditStorer that writes each record to w as a
+// line of json, for example to os.Stdout for a log collector to pick up.
+type JSONLines struct {
+	mut sync.Mutex
+	w   io.Writer
+}
+
+// NewJSONLines constructor
+func NewJSONLines(w io.Writer) *JSONLines {
+	return &JSONLines{w: w}
+}
+
+// PutAuditRecord writes the record as a line of json
+func (j *JSONLines) PutAuditRecord(ctx context.Context, record authboss.AuditRecord) error {
+	line, err := marshalLine(record)
+	if err != nil {
+		return err
+	}
+
+	j.mut.Lock()
+	defer j.mut.Unlock()
+
+	_, err = j.w.Write(line)
+	return errors.Wrap(err, "failed to write audit record")
+}
+
+// JSONLinesFile is an authboss.ReadingAuditStorer that appends each record
+// to a file as a line of json. Reading scans the whole file so it's best
+// suited to small apps, or to files that are rotated.
+type JSONLinesFile struct {
+	mut  sync.Mutex
+	path string
+}
+
+// NewJSONLinesFile constructor, the file is created when the first
+// record is written.
+func NewJSONLinesFile(path string) *JSONLinesFile {
+	return &JSONLinesFile{path: path}
+}
+
+// PutAuditRecord appends the record to the file as a line of json
+func (j *JSONLinesFile) PutAuditRecord(ctx context.Context, record authboss.AuditRecord) error {
+	line, err := marshalLine(record)
+	if err != nil {
+		return err
+	}
+
+	j.mut.Lock()
+	defer j.mut.Unlock()
+
+	f, err := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to open audit file")
+	}
+
+	if _, err = f.Write(line); err != nil {
+		_ = f.Close()
+		return errors.Wrap(err, "failed to write audit record")
+	}
+
+	return errors.Wrap(f.Close(), "failed to close audit file")
+}
+
+// RecentAuditRecords reads the file for the pid's most recent records
+func (j *JSONLinesFile) RecentAuditRecords(ctx context.Context, pid string, limit int) ([]authboss.AuditRecord, error) {
+	j.mut.Lock()
+	defer j.mut.Unlock()
+
+	f, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to open audit file")
+	}
+	defer f.Close()
+
+	return ReadJSONLines(f, pid, limit)
+}
+
+// ReadJSONLines reads records written by JSONLines and returns up to limit
+// of the most recent ones for the given pid, the newest first. This is
+// for apps that keep the lines somewhere other than a JSONLinesFile.
+func ReadJSONLines(r io.Reader, pid string, limit int) ([]authboss.AuditRecord, error) {
+	var records []authboss.AuditRecord
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLineSize)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var record authboss.AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, errors.Wrap(err, "failed to parse audit record")
+		}
+
+		if record.PID != pid {
+			continue
+		}
+
+		records = append(records, record)
+		if limit > 0 && len(records) > limit {
+			records = records[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read audit records")
+	}
+
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+
+	return records, nil
+}
+
+func marshalLine(record authboss.AuditRecord) ([]byte, error) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal audit record")
+	}
+
+	return append(line, '\n'), nil
+}
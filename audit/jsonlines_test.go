@@ -0,0 +1,86 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+func TestJSONLines(t *testing.T) {
+	t.Parallel()
+
+	buf := &bytes.Buffer{}
+	j := NewJSONLines(buf)
+
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	record := authboss.AuditRecord{PID: "test@test.com", Event: "EventAuth", Outcome: authboss.AuditSuccess, Time: now}
+	for i := 0; i < 2; i++ {
+		if err := j.PutAuditRecord(context.Background(), record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatal("wrong number of lines:", len(lines))
+	}
+
+	var got authboss.AuditRecord
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != record {
+		t.Errorf("record wrong: %#v", got)
+	}
+}
+
+func TestJSONLinesFile(t *testing.T) {
+	t.Parallel()
+
+	j := NewJSONLinesFile(filepath.Join(t.TempDir(), "audit.jsonl"))
+	ctx := context.Background()
+
+	records, err := j.RecentAuditRecords(ctx, "test@test.com", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Error("there should be no records before the file exists")
+	}
+
+	for _, r := range []authboss.AuditRecord{
+		{PID: "test@test.com", Event: "EventRegister"},
+		{PID: "test@test.com", Event: "EventAuth"},
+		{PID: "other@test.com", Event: "EventAuth"},
+		{PID: "test@test.com", Event: "EventLogout"},
+	} {
+		if err := j.PutAuditRecord(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err = j.RecentAuditRecords(ctx, "test@test.com", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatal("wrong number of records:", len(records))
+	}
+	if records[0].Event != "EventLogout" || records[1].Event != "EventAuth" {
+		t.Errorf("records should be newest first: %#v", records)
+	}
+}
+
+func TestReadJSONLinesInvalid(t *testing.T) {
+	t.Parallel()
+
+	if _, err := ReadJSONLines(strings.NewReader("{\n"), "test@test.com", 10); err == nil {
+		t.Error("it should fail on invalid json")
+	}
+}
@@ -98,6 +98,14 @@ func (a *Auth) LoginPost(w http.ResponseWriter, r *http.Request) error {
 		return nil
 	}
 
+	// Rehash before the hijack so users that go on to a second factor
+	// are migrated as well
+	if hasher, ok := a.Authboss.Core.Hasher.(authboss.RehashingHasher); ok && hasher.NeedsRehash(password) {
+		if err = a.rehash(r, authUser, creds.GetPassword()); err != nil {
+			logger.Errorf("failed to rehash password for user %s: %+v", pid, err)
+		}
+	}
+
 	handled, err = a.Events.FireBefore(authboss.EventAuthHijack, w, r)
 	if err != nil {
 		return err
@@ -123,3 +131,15 @@ func (a *Auth) LoginPost(w http.ResponseWriter, r *http.Request) error {
 	}
 	return a.Authboss.Core.Redirector.Redirect(w, r, ro)
 }
+
+// rehash replaces the user's password hash with one from the current hasher
+// settings, failing to do so should not prevent the user from logging in.
+func (a *Auth) rehash(r *http.Request, user authboss.AuthableUser, password string) error {
+	hash, err := a.Authboss.Core.Hasher.GenerateHash(password)
+	if err != nil {
+		return err
+	}
+
+	user.PutPassword(hash)
+	return a.Authboss.Storage.Server.Save(r.Context(), user)
+}
@@ -3,6 +3,7 @@ package auth
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/volatiletech/authboss/v3"
@@ -241,6 +242,127 @@ func TestAuthPostSuccess(t *testing.T) {
 	})
 }
 
+func TestAuthPostRehash(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Config.Core.Hasher = authboss.NewCompositeHasher(
+		authboss.NewScryptHasher(authboss.ScryptParams{LogN: 4, R: 8, P: 1, SaltLength: 16, KeyLength: 32}),
+		authboss.NewBCryptHasher(10),
+	)
+
+	h.bodyReader.Return = mocks.Values{
+		PID:      "test@test.com",
+		Password: "hello world",
+	}
+	user := &mocks.User{
+		Email:    "test@test.com",
+		Password: "$2a$10$IlfnqVyDZ6c1L.kaA/q3bu1nkAC6KukNUsizvlzay1pZPXnX2C9Ji", // hello world
+	}
+	h.storer.Users["test@test.com"] = user
+
+	r := mocks.Request("POST")
+	w := h.ab.NewResponse(httptest.NewRecorder())
+
+	if err := h.auth.LoginPost(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(user.Password, "$scrypt$") {
+		t.Error("password should have been rehashed:", user.Password)
+	}
+	if err := h.ab.Config.Core.Hasher.CompareHashAndPassword(user.Password, "hello world"); err != nil {
+		t.Error("rehashed password should still match:", err)
+	}
+	if _, ok := h.session.ClientValues[authboss.SessionKey]; !ok {
+		t.Error("user should be logged in")
+	}
+
+	// A current hash is left alone
+	hash := user.Password
+	w = h.ab.NewResponse(httptest.NewRecorder())
+	if err := h.auth.LoginPost(w, mocks.Request("POST")); err != nil {
+		t.Fatal(err)
+	}
+	if user.Password != hash {
+		t.Error("current hash should not be rehashed")
+	}
+}
+
+func TestAuthPostRehashAfterBeforeHooks(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Config.Core.Hasher = authboss.NewCompositeHasher(
+		authboss.NewScryptHasher(authboss.ScryptParams{LogN: 4, R: 8, P: 1, SaltLength: 16, KeyLength: 32}),
+		authboss.NewBCryptHasher(10),
+	)
+	h.ab.Events.Before(authboss.EventAuth, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		w.WriteHeader(http.StatusTeapot)
+		return true, nil
+	})
+
+	h.bodyReader.Return = mocks.Values{
+		PID:      "test@test.com",
+		Password: "hello world",
+	}
+	hash := "$2a$10$IlfnqVyDZ6c1L.kaA/q3bu1nkAC6KukNUsizvlzay1pZPXnX2C9Ji" // hello world
+	user := &mocks.User{
+		Email:    "test@test.com",
+		Password: hash,
+	}
+	h.storer.Users["test@test.com"] = user
+
+	r := mocks.Request("POST")
+	w := h.ab.NewResponse(httptest.NewRecorder())
+
+	if err := h.auth.LoginPost(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if user.Password != hash {
+		t.Error("password should not be rehashed when a before hook stops the login:", user.Password)
+	}
+}
+
+func TestAuthPostRehashBeforeHijack(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Config.Core.Hasher = authboss.NewCompositeHasher(
+		authboss.NewScryptHasher(authboss.ScryptParams{LogN: 4, R: 8, P: 1, SaltLength: 16, KeyLength: 32}),
+		authboss.NewBCryptHasher(10),
+	)
+	h.ab.Events.Before(authboss.EventAuthHijack, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		w.WriteHeader(http.StatusTeapot)
+		return true, nil
+	})
+
+	h.bodyReader.Return = mocks.Values{
+		PID:      "test@test.com",
+		Password: "hello world",
+	}
+	user := &mocks.User{
+		Email:    "test@test.com",
+		Password: "$2a$10$IlfnqVyDZ6c1L.kaA/q3bu1nkAC6KukNUsizvlzay1pZPXnX2C9Ji", // hello world
+	}
+	h.storer.Users["test@test.com"] = user
+
+	r := mocks.Request("POST")
+	w := h.ab.NewResponse(httptest.NewRecorder())
+
+	if err := h.auth.LoginPost(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(user.Password, "$scrypt$") {
+		t.Error("password should be rehashed when the login is hijacked:", user.Password)
+	}
+	if _, ok := h.session.ClientValues[authboss.SessionKey]; ok {
+		t.Error("user should not be logged in")
+	}
+}
+
 func TestAuthPostBadPassword(t *testing.T) {
 	t.Parallel()
 
@@ -52,6 +52,11 @@ func (a *Authboss) Init(modulesToLoad ...string) error {
 		modulesToLoad = RegisteredModules()
 	}
 
+	// Wrap the router before the modules register their routes
+	if _, ok := a.Config.Core.Router.(csrfRouter); a.Config.Modules.CSRFProtection && !ok {
+		a.Config.Core.Router = csrfRouter{Router: a.Config.Core.Router, middleware: CSRFMiddleware(a)}
+	}
+
 	for _, name := range modulesToLoad {
 		if err := a.loadModule(name); err != nil {
 			return errors.Errorf("module %s failed to load: %+v", name, err)
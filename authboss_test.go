@@ -237,3 +237,39 @@ func TestAuthbossMiddleware(t *testing.T) {
 		}
 	})
 }
+
+func TestAuthbossMiddlewareRevokedSession(t *testing.T) {
+	t.Parallel()
+
+	storer := newMockSessionServerStorer()
+	storer.Users["test@test.com"] = &mockUser{Email: "test@test.com"}
+
+	ab := New()
+	ab.Core.Logger = mockLogger{}
+	ab.Storage.Server = storer
+	ab.Storage.SessionState = mockClientStateReadWriter{
+		state: mockClientState{SessionKey: "test@test.com", SessionID: "revoked"},
+	}
+
+	r := httptest.NewRequest("GET", "/super/secret", nil)
+	rec := httptest.NewRecorder()
+	w := ab.NewResponse(rec)
+
+	r, err := ab.LoadClientState(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var called bool
+	server := Middleware2(ab, RequireNone, RespondNotFound)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	server.ServeHTTP(w, r)
+
+	if called {
+		t.Error("should not have been called with a revoked session")
+	}
+	if rec.Code != http.StatusNotFound {
+		t.Error("wrong code:", rec.Code)
+	}
+}
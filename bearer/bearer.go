@@ -0,0 +1,384 @@
+// Package bearer issues signed access tokens and rotating refresh tokens from
+// its own login route so that API clients that cannot keep cookie state can
+// authenticate with an Authorization: Bearer header instead.
+package bearer
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/friendsofgo/errors"
+	"github.com/volatiletech/authboss/v3"
+)
+
+// Pages and data keys
+const (
+	// PageBearerToken is the page the tokens are rendered with, it's used
+	// for the responses to /token and /token/refresh
+	PageBearerToken = "bearer_token"
+	// PageBearerLogin is only really used for the BodyReader, it reads the
+	// same values as the auth module's login page
+	PageBearerLogin = "bearer_login"
+	// PageBearerRefresh is only really used for the BodyReader
+	PageBearerRefresh = "bearer_refresh"
+	// PageBearerRevoke is the page for /token/revoke
+	PageBearerRevoke = "bearer_revoke"
+
+	// DataAccessToken is the signed access token
+	DataAccessToken = "access_token"
+	// DataRefreshToken is the refresh token to get a new access token with
+	DataRefreshToken = "refresh_token"
+	// DataTokenType is always "Bearer"
+	DataTokenType = "token_type"
+	// DataExpiresIn is the number of seconds the access token is valid for
+	DataExpiresIn = "expires_in"
+
+	// minKeySize is the smallest BearerTokenKey allowed
+	minKeySize = 32
+)
+
+func init() {
+	authboss.RegisterModule("bearer", &Bearer{})
+}
+
+// Bearer module
+type Bearer struct {
+	*authboss.Authboss
+}
+
+// Init module
+func (b *Bearer) Init(ab *authboss.Authboss) error {
+	b.Authboss = ab
+
+	if len(b.Config.Modules.BearerTokenKey) < minKeySize {
+		return errors.Errorf("bearer requires Modules.BearerTokenKey to be at least %d bytes", minKeySize)
+	}
+
+	if err := b.Config.Core.ViewRenderer.Load(PageBearerToken, PageBearerRevoke); err != nil {
+		return err
+	}
+
+	// API clients have no session to keep a csrf token in and nothing is
+	// stored in cookies so these are safe to leave unprotected
+	b.Config.Core.Router.Post("/token", authboss.CSRFExempt(b.Core.ErrorHandler.Wrap(b.Login)))
+	b.Config.Core.Router.Post("/token/refresh", authboss.CSRFExempt(b.Core.ErrorHandler.Wrap(b.Refresh)))
+	b.Config.Core.Router.Post("/token/revoke", authboss.CSRFExempt(b.Core.ErrorHandler.Wrap(b.Revoke)))
+
+	b.Events.After(authboss.EventRecoverEnd, b.DelRefreshTokens)
+	b.Events.After(authboss.EventPasswordChange, b.DelRefreshTokens)
+	b.Events.After(authboss.EventAccountDelete, b.DelRefreshTokens)
+
+	return nil
+}
+
+// Login checks the user's credentials the same way the auth module does and
+// responds with a new access token and refresh token. The user is not put
+// in the session, so EventBearerAuth is fired after the login instead of
+// EventAuth which would have other modules store client state.
+func (b *Bearer) Login(w http.ResponseWriter, r *http.Request) error {
+	logger := b.RequestLogger(r)
+
+	validatable, err := b.Core.BodyReader.Read(PageBearerLogin, r)
+	if err != nil {
+		return err
+	}
+
+	if errs := validatable.Validate(); errs != nil {
+		logger.Info("token login validation failed")
+		data := authboss.HTMLData{authboss.DataValidation: authboss.ErrorMap(errs)}
+		return b.Core.Responder.Respond(w, r, http.StatusBadRequest, PageBearerToken, data)
+	}
+
+	creds := authboss.MustHaveUserValues(validatable)
+
+	pid := creds.GetPID()
+	pidUser, err := b.Config.Storage.Server.Load(r.Context(), pid)
+	if err == authboss.ErrUserNotFound {
+		logger.Infof("failed to load user requested by pid: %s", pid)
+		return b.invalidCredentials(w, r)
+	} else if err != nil {
+		return err
+	}
+
+	authUser := authboss.MustBeAuthable(pidUser)
+
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, pidUser))
+
+	var handled bool
+	if err = b.VerifyPassword(authUser, creds.GetPassword()); err != nil {
+		handled, err = b.Events.FireAfter(authboss.EventAuthFail, w, r)
+		if err != nil {
+			return err
+		} else if handled {
+			return nil
+		}
+
+		logger.Infof("user %s failed to log in for a token", pid)
+		return b.invalidCredentials(w, r)
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyValues, validatable))
+
+	handled, err = b.Events.FireBefore(authboss.EventAuth, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	// Two factor authentication can't be done without a session, the 2fa
+	// modules take over the request here and the client won't get tokens
+	handled, err = b.Events.FireBefore(authboss.EventAuthHijack, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	logger.Infof("user %s logged in for a token", pid)
+
+	handled, err = b.Events.FireAfter(authboss.EventBearerAuth, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	return b.respondTokens(w, r, pid)
+}
+
+// Refresh exchanges a refresh token for a new access token and refresh
+// token, the old refresh token can no longer be used.
+func (b *Bearer) Refresh(w http.ResponseWriter, r *http.Request) error {
+	logger := b.RequestLogger(r)
+
+	validatable, err := b.Core.BodyReader.Read(PageBearerRefresh, r)
+	if err != nil {
+		return err
+	}
+
+	if errs := validatable.Validate(); errs != nil {
+		logger.Info("refresh token validation failed")
+		data := authboss.HTMLData{authboss.DataValidation: authboss.ErrorMap(errs)}
+		return b.Core.Responder.Respond(w, r, http.StatusBadRequest, PageBearerToken, data)
+	}
+
+	token := authboss.MustHaveRefreshTokenValues(validatable).GetRefreshToken()
+
+	pid, hash, err := ParseRefreshToken(token)
+	if err != nil {
+		logger.Info("failed to decode refresh token")
+		return b.invalidRefreshToken(w, r)
+	}
+
+	storer := authboss.EnsureCanBearerToken(b.Config.Storage.Server)
+	err = storer.UseRefreshToken(r.Context(), pid, hash)
+	if err == authboss.ErrTokenNotFound {
+		logger.Infof("refresh token for user %s was not in storage", pid)
+		return b.invalidRefreshToken(w, r)
+	} else if err != nil {
+		return err
+	}
+
+	user, err := b.Config.Storage.Server.Load(r.Context(), pid)
+	if err == authboss.ErrUserNotFound {
+		logger.Infof("refresh token for user %s who no longer exists", pid)
+		return b.invalidRefreshToken(w, r)
+	} else if err != nil {
+		return err
+	}
+
+	if lu, ok := user.(authboss.LockableUser); ok && lu.GetLocked().After(b.Now()) {
+		logger.Infof("refresh token for locked user %s", pid)
+		return b.invalidRefreshToken(w, r)
+	}
+
+	logger.Infof("user %s refreshed their bearer token", pid)
+	return b.respondTokens(w, r, pid)
+}
+
+// Revoke the refresh token given in the body as well as the access token in
+// the Authorization header if there is one. This is how API clients log out.
+func (b *Bearer) Revoke(w http.ResponseWriter, r *http.Request) error {
+	logger := b.RequestLogger(r)
+
+	validatable, err := b.Core.BodyReader.Read(PageBearerRevoke, r)
+	if err != nil {
+		return err
+	}
+
+	storer := authboss.EnsureCanBearerToken(b.Config.Storage.Server)
+
+	token := authboss.MustHaveRefreshTokenValues(validatable).GetRefreshToken()
+	if len(token) != 0 {
+		if pid, hash, err := ParseRefreshToken(token); err == nil {
+			if err = storer.UseRefreshToken(r.Context(), pid, hash); err != nil && err != authboss.ErrTokenNotFound {
+				return err
+			}
+			logger.Infof("user %s revoked a refresh token", pid)
+		}
+	}
+
+	if access, ok := tokenFromHeader(r); ok {
+		claims, err := ParseToken(b.Config.Modules.BearerTokenKey, access, b.Now())
+		if err == nil {
+			if err = storer.RevokeAccessToken(r.Context(), claims.ID, claims.Expires()); err != nil {
+				return err
+			}
+			logger.Infof("user %s revoked an access token", claims.Subject)
+		}
+	}
+
+	return b.Core.Responder.Respond(w, r, http.StatusOK, PageBearerRevoke, nil)
+}
+
+// DelRefreshTokens is called after the user's password was reset or
+// changed and after their account was deleted. A refresh token that was
+// stolen must not be able to keep minting access tokens after that.
+func (b *Bearer) DelRefreshTokens(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+	user, err := b.CurrentUser(r)
+	if err != nil {
+		return false, err
+	}
+
+	pid := user.GetPID()
+	b.RequestLogger(r).Infof("deleting refresh tokens for user %s", pid)
+
+	storer := authboss.EnsureCanBearerToken(b.Config.Storage.Server)
+	return false, storer.DelRefreshTokens(r.Context(), pid)
+}
+
+func (b *Bearer) respondTokens(w http.ResponseWriter, r *http.Request, pid string) error {
+	access, err := IssueAccessToken(b.Authboss, pid)
+	if err != nil {
+		return err
+	}
+
+	hash, refresh, err := GenerateRefreshToken(pid)
+	if err != nil {
+		return err
+	}
+
+	storer := authboss.EnsureCanBearerToken(b.Config.Storage.Server)
+	expires := b.Now().Add(b.Config.Modules.BearerRefreshTokenDuration)
+	if err = storer.AddRefreshToken(r.Context(), pid, hash, expires); err != nil {
+		return err
+	}
+
+	data := authboss.HTMLData{
+		DataAccessToken:  access,
+		DataRefreshToken: refresh,
+		DataTokenType:    "Bearer",
+		DataExpiresIn:    int(b.Config.Modules.BearerTokenDuration / time.Second),
+	}
+	return b.Core.Responder.Respond(w, r, http.StatusOK, PageBearerToken, data)
+}
+
+func (b *Bearer) invalidCredentials(w http.ResponseWriter, r *http.Request) error {
+	data := authboss.HTMLData{authboss.DataErr: b.Localizef(r.Context(), authboss.TxtInvalidCredentials)}
+	return b.Core.Responder.Respond(w, r, http.StatusUnauthorized, PageBearerToken, data)
+}
+
+func (b *Bearer) invalidRefreshToken(w http.ResponseWriter, r *http.Request) error {
+	data := authboss.HTMLData{authboss.DataErr: b.Localizef(r.Context(), authboss.TxtInvalidRefreshToken)}
+	return b.Core.Responder.Respond(w, r, http.StatusUnauthorized, PageBearerToken, data)
+}
+
+// IssueAccessToken creates a signed access token for the pid using the
+// bearer configuration.
+func IssueAccessToken(ab *authboss.Authboss, pid string) (string, error) {
+	id, err := GenerateTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	now := ab.Now()
+	claims := Claims{
+		ID:        id,
+		Subject:   pid,
+		Issuer:    ab.Config.Modules.BearerTokenIssuer,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ab.Config.Modules.BearerTokenDuration).Unix(),
+	}
+
+	return SignToken(ab.Config.Modules.BearerTokenKey, claims)
+}
+
+// Middleware authenticates requests that have an Authorization: Bearer
+// header by putting the pid and user into the request context, this allows
+// authboss.Middleware2 and other middleware that use CurrentUser to work
+// without any client state. Requests without the header are passed through
+// untouched and ones with an invalid, expired or revoked token get a 401.
+func Middleware(ab *authboss.Authboss) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := tokenFromHeader(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			err := Authenticate(ab, &r, token)
+			switch {
+			case err == ErrInvalidToken || err == ErrExpiredToken || err == authboss.ErrUserNotFound:
+				logger := ab.RequestLogger(r)
+				logger.Infof("rejected bearer token: %v", err)
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			case err != nil:
+				logger := ab.RequestLogger(r)
+				logger.Errorf("failed to authenticate bearer token: %+v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Authenticate the request with the access token. The pid and user are put
+// into the request's context.
+func Authenticate(ab *authboss.Authboss, r **http.Request, token string) error {
+	claims, err := ParseToken(ab.Config.Modules.BearerTokenKey, token, ab.Now())
+	if err != nil {
+		return err
+	}
+
+	if issuer := ab.Config.Modules.BearerTokenIssuer; len(issuer) != 0 && claims.Issuer != issuer {
+		return ErrInvalidToken
+	}
+
+	ctx := (*r).Context()
+	storer := authboss.EnsureCanBearerToken(ab.Config.Storage.Server)
+	if revoked, err := storer.IsAccessTokenRevoked(ctx, claims.ID); err != nil {
+		return err
+	} else if revoked {
+		return ErrInvalidToken
+	}
+
+	user, err := ab.Config.Storage.Server.Load(ctx, claims.Subject)
+	if err != nil {
+		return err
+	}
+
+	ctx = context.WithValue(ctx, authboss.CTXKeyPID, claims.Subject)
+	ctx = context.WithValue(ctx, authboss.CTXKeyUser, user)
+	ctx = context.WithValue(ctx, authboss.CTXKeyHeaderAuth, true)
+	*r = (*r).WithContext(ctx)
+
+	return nil
+}
+
+func tokenFromHeader(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "bearer ") {
+		return "", false
+	}
+
+	token := strings.TrimSpace(header[7:])
+	return token, len(token) != 0
+}
@@ -0,0 +1,511 @@
+package bearer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/defaults"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+
+	router := &mocks.Router{}
+	renderer := &mocks.Renderer{}
+	errHandler := &mocks.ErrorHandler{}
+	ab.Config.Core.Router = router
+	ab.Config.Core.ViewRenderer = renderer
+	ab.Config.Core.ErrorHandler = errHandler
+
+	b := &Bearer{}
+	if err := b.Init(ab); err == nil {
+		t.Error("it should require a key")
+	}
+
+	ab.Config.Modules.BearerTokenKey = testKey
+	if err := b.Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := renderer.HasLoadedViews(PageBearerToken, PageBearerRevoke); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasPosts("/token", "/token/refresh", "/token/revoke"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCSRFProtection(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.putPassword(t, "hello world")
+	h.ab.Config.Core.Router = defaults.NewRouter()
+	h.ab.Config.Core.ViewRenderer = &mocks.Renderer{}
+	h.ab.Config.Core.ErrorHandler = &mocks.ErrorHandler{}
+	h.ab.Config.Modules.CSRFProtection = true
+
+	if err := h.ab.Init("bearer"); err != nil {
+		t.Fatal(err)
+	}
+
+	h.bodyReader.Return = mocks.Values{PID: "test@test.com", Password: "hello world"}
+
+	for _, path := range []string{"/token", "/token/refresh", "/token/revoke"} {
+		rec := httptest.NewRecorder()
+		w := h.ab.NewResponse(rec)
+		r, err := h.ab.LoadClientState(w, httptest.NewRequest("POST", path, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h.ab.Config.Core.Router.ServeHTTP(w, r)
+		if rec.Code == http.StatusForbidden {
+			t.Errorf("%s) should not need a csrf token", path)
+		}
+	}
+
+	if h.responder.Page != PageBearerToken && h.responder.Page != PageBearerRevoke {
+		t.Error("the token routes should have been reached:", h.responder.Page)
+	}
+}
+
+type testHarness struct {
+	bearer *Bearer
+	ab     *authboss.Authboss
+
+	bodyReader *mocks.BodyReader
+	responder  *mocks.Responder
+	session    *mocks.ClientStateRW
+	storer     *mocks.ServerStorer
+}
+
+func testSetup() *testHarness {
+	harness := &testHarness{}
+
+	harness.ab = authboss.New()
+	harness.bodyReader = &mocks.BodyReader{}
+	harness.responder = &mocks.Responder{}
+	harness.session = mocks.NewClientRW()
+	harness.storer = mocks.NewServerStorer()
+
+	harness.ab.Config.Modules.BearerTokenKey = testKey
+	harness.ab.Config.Modules.BearerTokenIssuer = "authboss"
+
+	harness.ab.Config.Core.BodyReader = harness.bodyReader
+	harness.ab.Config.Core.Logger = mocks.Logger{}
+	harness.ab.Config.Core.Hasher = mocks.Hasher{}
+	harness.ab.Config.Core.Responder = harness.responder
+	harness.ab.Config.Storage.SessionState = harness.session
+	harness.ab.Config.Storage.Server = harness.storer
+
+	harness.storer.Users["test@test.com"] = &mocks.User{Email: "test@test.com"}
+
+	harness.bearer = &Bearer{harness.ab}
+
+	return harness
+}
+
+func (h *testHarness) putPassword(t *testing.T, password string) {
+	t.Helper()
+
+	hash, err := h.ab.Config.Core.Hasher.GenerateHash(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.storer.Users["test@test.com"].Password = hash
+}
+
+func TestLogin(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.putPassword(t, "hello world")
+
+	var bearerAuth, auth bool
+	h.ab.Events.After(authboss.EventBearerAuth, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		bearerAuth = r.Context().Value(authboss.CTXKeyUser) != nil
+		return false, nil
+	})
+	h.ab.Events.After(authboss.EventAuth, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		auth = true
+		return false, nil
+	})
+
+	h.bodyReader.Return = mocks.Values{PID: "test@test.com", Password: "hello world"}
+
+	r := mocks.Request("POST")
+	w := h.ab.NewResponse(httptest.NewRecorder())
+
+	if err := h.bearer.Login(w, r); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if !bearerAuth || auth {
+		t.Error("only the bearer auth event should fire:", bearerAuth, auth)
+	}
+	if len(h.session.ClientValues) != 0 {
+		t.Error("nothing should be put in the session:", h.session.ClientValues)
+	}
+
+	if h.responder.Status != http.StatusOK {
+		t.Error("status wrong:", h.responder.Status)
+	}
+	if h.responder.Page != PageBearerToken {
+		t.Error("page wrong:", h.responder.Page)
+	}
+
+	access := h.responder.Data[DataAccessToken].(string)
+	claims, err := ParseToken(testKey, access, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "test@test.com" || claims.Issuer != "authboss" {
+		t.Errorf("claims wrong: %#v", claims)
+	}
+	if exp := h.responder.Data[DataExpiresIn].(int); exp != 15*60 {
+		t.Error("expires in wrong:", exp)
+	}
+
+	_, hash, err := ParseRefreshToken(h.responder.Data[DataRefreshToken].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := h.storer.RefreshTokens["test@test.com"][hash]; !ok {
+		t.Error("refresh token should be stored")
+	}
+}
+
+func TestLoginBadCredentials(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.putPassword(t, "hello world")
+
+	var failed bool
+	h.ab.Events.After(authboss.EventAuthFail, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		failed = true
+		return false, nil
+	})
+
+	for _, pid := range []string{"test@test.com", "nobody@test.com"} {
+		h.bodyReader.Return = mocks.Values{PID: pid, Password: "world hello"}
+
+		r := mocks.Request("POST")
+		w := httptest.NewRecorder()
+
+		if err := h.bearer.Login(w, r); err != nil {
+			t.Fatal(err)
+		}
+
+		if h.responder.Status != http.StatusUnauthorized {
+			t.Error("status wrong:", h.responder.Status)
+		}
+		if h.responder.Data[authboss.DataErr] != authboss.TxtInvalidCredentials.Default {
+			t.Error("error wrong:", h.responder.Data[authboss.DataErr])
+		}
+		if _, ok := h.responder.Data[DataAccessToken]; ok {
+			t.Error("it should not issue tokens")
+		}
+	}
+
+	if !failed {
+		t.Error("the auth fail event should fire for a wrong password")
+	}
+}
+
+func TestLoginHijacked(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.putPassword(t, "hello world")
+
+	h.ab.Events.Before(authboss.EventAuthHijack, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		w.WriteHeader(http.StatusTeapot)
+		return true, nil
+	})
+
+	h.bodyReader.Return = mocks.Values{PID: "test@test.com", Password: "hello world"}
+
+	r := mocks.Request("POST")
+	w := httptest.NewRecorder()
+
+	if err := h.bearer.Login(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusTeapot {
+		t.Error("the hijacker's response should be left alone:", w.Code)
+	}
+	if len(h.responder.Page) != 0 {
+		t.Error("it should not issue tokens")
+	}
+	if len(h.storer.RefreshTokens["test@test.com"]) != 0 {
+		t.Error("no refresh token should be stored")
+	}
+}
+
+func (h *testHarness) putRefreshToken(t *testing.T) string {
+	t.Helper()
+
+	hash, token, err := GenerateRefreshToken("test@test.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = h.storer.AddRefreshToken(context.Background(), "test@test.com", hash, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func TestRefresh(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	token := h.putRefreshToken(t)
+
+	h.bodyReader.Return = mocks.Values{RefreshToken: token}
+
+	r := mocks.Request("POST")
+	w := httptest.NewRecorder()
+
+	if err := h.bearer.Refresh(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.responder.Status != http.StatusOK {
+		t.Error("status wrong:", h.responder.Status)
+	}
+	if _, ok := h.responder.Data[DataAccessToken]; !ok {
+		t.Error("should have a new access token")
+	}
+	newToken := h.responder.Data[DataRefreshToken].(string)
+	if newToken == token {
+		t.Error("refresh token should be rotated")
+	}
+	if n := len(h.storer.RefreshTokens["test@test.com"]); n != 1 {
+		t.Error("only the new refresh token should be stored:", n)
+	}
+
+	// The old one can't be used again
+	h.responder.Data = nil
+	if err := h.bearer.Refresh(httptest.NewRecorder(), mocks.Request("POST")); err != nil {
+		t.Fatal(err)
+	}
+	if h.responder.Status != http.StatusUnauthorized {
+		t.Error("status wrong:", h.responder.Status)
+	}
+	if h.responder.Data[authboss.DataErr] != authboss.TxtInvalidRefreshToken.Default {
+		t.Error("error wrong:", h.responder.Data[authboss.DataErr])
+	}
+}
+
+func TestRefreshLocked(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	token := h.putRefreshToken(t)
+	h.storer.Users["test@test.com"].Locked = time.Now().Add(time.Hour)
+
+	h.bodyReader.Return = mocks.Values{RefreshToken: token}
+
+	if err := h.bearer.Refresh(httptest.NewRecorder(), mocks.Request("POST")); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.responder.Status != http.StatusUnauthorized {
+		t.Error("status wrong:", h.responder.Status)
+	}
+}
+
+func TestRefreshInvalid(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.bodyReader.Return = mocks.Values{RefreshToken: "not a token"}
+
+	if err := h.bearer.Refresh(httptest.NewRecorder(), mocks.Request("POST")); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.responder.Status != http.StatusUnauthorized {
+		t.Error("status wrong:", h.responder.Status)
+	}
+}
+
+func TestRefreshAfterPasswordEvents(t *testing.T) {
+	t.Parallel()
+
+	events := []authboss.Event{
+		authboss.EventRecoverEnd,
+		authboss.EventPasswordChange,
+		authboss.EventAccountDelete,
+	}
+
+	for _, event := range events {
+		event := event
+		t.Run(event.String(), func(t *testing.T) {
+			t.Parallel()
+
+			h := testSetup()
+			h.ab.Config.Core.Router = &mocks.Router{}
+			h.ab.Config.Core.ViewRenderer = &mocks.Renderer{}
+			h.ab.Config.Core.ErrorHandler = &mocks.ErrorHandler{}
+			if err := h.bearer.Init(h.ab); err != nil {
+				t.Fatal(err)
+			}
+
+			token := h.putRefreshToken(t)
+
+			r := mocks.Request("POST")
+			r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, h.storer.Users["test@test.com"]))
+			if handled, err := h.ab.Events.FireAfter(event, httptest.NewRecorder(), r); err != nil {
+				t.Fatal(err)
+			} else if handled {
+				t.Error("it should not be handled")
+			}
+
+			h.bodyReader.Return = mocks.Values{RefreshToken: token}
+			if err := h.bearer.Refresh(httptest.NewRecorder(), mocks.Request("POST")); err != nil {
+				t.Fatal(err)
+			}
+
+			if h.responder.Status != http.StatusUnauthorized {
+				t.Error("status wrong:", h.responder.Status)
+			}
+			if _, ok := h.responder.Data[DataAccessToken]; ok {
+				t.Error("no access token should be issued")
+			}
+		})
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	refresh := h.putRefreshToken(t)
+
+	access, err := IssueAccessToken(h.ab, "test@test.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ParseToken(testKey, access, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.bodyReader.Return = mocks.Values{RefreshToken: refresh}
+
+	r := mocks.Request("POST")
+	r.Header.Set("Authorization", "Bearer "+access)
+	w := httptest.NewRecorder()
+
+	if err := h.bearer.Revoke(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.responder.Status != http.StatusOK {
+		t.Error("status wrong:", h.responder.Status)
+	}
+	if n := len(h.storer.RefreshTokens["test@test.com"]); n != 0 {
+		t.Error("refresh token should be removed:", n)
+	}
+	if _, ok := h.storer.RevokedTokens[claims.ID]; !ok {
+		t.Error("access token should be revoked")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	access, err := IssueAccessToken(h.ab, "test@test.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var called bool
+	var pid string
+	var user authboss.User
+	var headerAuth bool
+	mw := Middleware(h.ab)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		pid, _ = h.ab.CurrentUserID(r)
+		user, _ = h.ab.CurrentUser(r)
+		headerAuth, _ = r.Context().Value(authboss.CTXKeyHeaderAuth).(bool)
+	}))
+
+	// No header passes through without a user
+	w := httptest.NewRecorder()
+	mw.ServeHTTP(w, mocks.Request("GET"))
+	if !called || len(pid) != 0 || user != nil || headerAuth {
+		t.Error("it should pass through without a user", called, pid, user, headerAuth)
+	}
+
+	called = false
+	r := mocks.Request("GET")
+	r.Header.Set("Authorization", "Bearer "+access)
+	w = httptest.NewRecorder()
+	mw.ServeHTTP(w, r)
+	if !called {
+		t.Fatal("it should call the next handler")
+	}
+	if pid != "test@test.com" {
+		t.Error("pid wrong:", pid)
+	}
+	if user == nil || user.GetPID() != "test@test.com" {
+		t.Error("user wrong:", user)
+	}
+	if !headerAuth {
+		t.Error("the request should be marked as authenticated by a header")
+	}
+
+	claims, err := ParseToken(testKey, access, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.storer.RevokedTokens[claims.ID] = claims.Expires()
+
+	called = false
+	w = httptest.NewRecorder()
+	mw.ServeHTTP(w, r)
+	if called {
+		t.Error("it should not allow a revoked token")
+	}
+	if w.Code != http.StatusUnauthorized {
+		t.Error("code wrong:", w.Code)
+	}
+	if len(w.Header().Get("WWW-Authenticate")) == 0 {
+		t.Error("it should set the WWW-Authenticate header")
+	}
+}
+
+func TestMiddlewareIssuer(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	h.ab.Config.Modules.BearerTokenIssuer = "someone else"
+	access, err := IssueAccessToken(h.ab, "test@test.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.ab.Config.Modules.BearerTokenIssuer = "authboss"
+
+	r := mocks.Request("GET")
+	r.Header.Set("Authorization", "Bearer "+access)
+	if err := Authenticate(h.ab, &r, access); err != ErrInvalidToken {
+		t.Error("it should reject other issuers:", err)
+	}
+}
@@ -0,0 +1,149 @@
+package bearer
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/friendsofgo/errors"
+)
+
+const (
+	refreshNonceSize = 32
+	tokenIDSize      = 16
+)
+
+var (
+	// ErrInvalidToken is returned when an access token is malformed or its
+	// signature does not match.
+	ErrInvalidToken = errors.New("bearer token is invalid")
+	// ErrExpiredToken is returned when an access token has expired.
+	ErrExpiredToken = errors.New("bearer token has expired")
+
+	jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+)
+
+// Claims that are put into the access tokens. Access tokens are JWTs signed
+// with HS256.
+type Claims struct {
+	ID        string `json:"jti"`
+	Subject   string `json:"sub"`
+	Issuer    string `json:"iss,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Expires returns the expiry of the token as a time
+func (c Claims) Expires() time.Time {
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+// SignToken creates a signed JWT from the claims using key
+func SignToken(key []byte, claims Claims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode bearer token claims")
+	}
+
+	unsigned := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + sign(key, unsigned), nil
+}
+
+// ParseToken verifies the signature and expiry of a JWT created by SignToken
+// and returns its claims. Only the HS256 algorithm is accepted.
+func ParseToken(key []byte, token string, now time.Time) (Claims, error) {
+	var claims Claims
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, ErrInvalidToken
+	}
+
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return claims, ErrInvalidToken
+	}
+	var h struct {
+		Alg string `json:"alg"`
+	}
+	if err = json.Unmarshal(header, &h); err != nil || h.Alg != "HS256" {
+		return claims, ErrInvalidToken
+	}
+
+	signature := sign(key, parts[0]+"."+parts[1])
+	if !hmac.Equal([]byte(signature), []byte(parts[2])) {
+		return claims, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, ErrInvalidToken
+	}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return claims, ErrInvalidToken
+	}
+
+	if len(claims.Subject) == 0 || len(claims.ID) == 0 {
+		return claims, ErrInvalidToken
+	}
+	if !now.Before(claims.Expires()) {
+		return claims, ErrExpiredToken
+	}
+
+	return claims, nil
+}
+
+func sign(key []byte, unsigned string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// GenerateTokenID creates a random id for the jti claim
+func GenerateTokenID() (string, error) {
+	id := make([]byte, tokenIDSize)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return "", errors.Wrap(err, "failed to create bearer token id")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(id), nil
+}
+
+// GenerateRefreshToken creates a refresh token for the pid, the hash is
+// what should be stored and the token is given to the client. It has the
+// same format as remember me tokens.
+func GenerateRefreshToken(pid string) (hash string, token string, err error) {
+	rawToken := make([]byte, refreshNonceSize+len(pid)+1)
+	copy(rawToken, pid)
+	rawToken[len(pid)] = ';'
+
+	if _, err := io.ReadFull(rand.Reader, rawToken[len(pid)+1:]); err != nil {
+		return "", "", errors.Wrap(err, "failed to create refresh token nonce")
+	}
+
+	sum := sha512.Sum512(rawToken)
+	return base64.StdEncoding.EncodeToString(sum[:]), base64.URLEncoding.EncodeToString(rawToken), nil
+}
+
+// ParseRefreshToken returns the pid and the hash of a refresh token
+func ParseRefreshToken(token string) (pid string, hash string, err error) {
+	rawToken, err := base64.URLEncoding.DecodeString(token)
+	if err != nil {
+		return "", "", ErrInvalidToken
+	}
+
+	// The nonce is random so the separator is found by its size
+	index := len(rawToken) - refreshNonceSize - 1
+	if index <= 0 || rawToken[index] != ';' {
+		return "", "", ErrInvalidToken
+	}
+
+	sum := sha512.Sum512(rawToken)
+	return string(rawToken[:index]), base64.StdEncoding.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,102 @@
+package bearer
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testKey = []byte("01234567890123456789012345678901")
+
+func TestSignParseToken(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	claims := Claims{
+		ID:        "id",
+		Subject:   "test@test.com",
+		Issuer:    "authboss",
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(time.Minute).Unix(),
+	}
+
+	token, err := SignToken(testKey, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parts := strings.Split(token, "."); len(parts) != 3 {
+		t.Fatal("token should have 3 parts:", token)
+	}
+
+	got, err := ParseToken(testKey, token, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != claims {
+		t.Errorf("claims wrong: %#v", got)
+	}
+
+	if _, err = ParseToken(testKey, token, now.Add(time.Minute)); err != ErrExpiredToken {
+		t.Error("token should be expired:", err)
+	}
+	if _, err = ParseToken([]byte("another key another key another k"), token, now); err != ErrInvalidToken {
+		t.Error("token should not be valid with a different key:", err)
+	}
+}
+
+func TestParseTokenInvalid(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	token, err := SignToken(testKey, Claims{ID: "id", Subject: "pid", ExpiresAt: now.Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+
+	noneHeader := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+	otherClaims := base64.RawURLEncoding.EncodeToString([]byte(`{"jti":"id","sub":"admin","exp":9999999999}`))
+
+	tests := []string{
+		"",
+		"a.b",
+		"a.b.c",
+		noneHeader + "." + parts[1] + ".",
+		noneHeader + "." + parts[1] + "." + parts[2],
+		parts[0] + "." + otherClaims + "." + parts[2],
+	}
+
+	for i, test := range tests {
+		if _, err := ParseToken(testKey, test, now); err != ErrInvalidToken {
+			t.Errorf("%d) token should be invalid: %v", i, err)
+		}
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	t.Parallel()
+
+	hash, token, err := GenerateRefreshToken("test;user@test.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pid, gotHash, err := ParseRefreshToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid != "test;user@test.com" {
+		t.Error("pid wrong:", pid)
+	}
+	if gotHash != hash {
+		t.Error("hash wrong:", gotHash)
+	}
+
+	for _, test := range []string{"!", base64.URLEncoding.EncodeToString([]byte("pid;short"))} {
+		if _, _, err := ParseRefreshToken(test); err != ErrInvalidToken {
+			t.Errorf("%q should be invalid: %v", test, err)
+		}
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -34,6 +36,24 @@ const (
 	// SessionOAuth2Params is the additional settings for oauth
 	// like redirection/remember.
 	SessionOAuth2Params = "oauth2_params"
+	// SessionOAuth2Nonce is the nonce that must be in the id token
+	// returned by an OpenID Connect provider.
+	SessionOAuth2Nonce = "oauth2_nonce"
+	// SessionOAuth2PKCEVerifier is the PKCE code verifier that is sent
+	// when exchanging the oauth2 code for a token.
+	SessionOAuth2PKCEVerifier = "oauth2_pkce_verifier"
+	// SessionOAuth2Link is set to the provider when the oauth2 flow was
+	// started to link an identity to the logged in user.
+	SessionOAuth2Link = "oauth2_link"
+	// SessionID is the id of the SessionRecord for the current login,
+	// it's only present when the sessions module is in use.
+	SessionID = "session_id"
+	// SessionCSRFToken is the csrf token for the session, see
+	// CSRFMiddleware.
+	SessionCSRFToken = "csrf_token"
+	// SessionLastLogin holds the unix time of the user's last full login,
+	// see RecordLastLogin and LoggedInWithin.
+	SessionLastLogin = "last_login"
 
 	// CookieRemember is used for cookies and form input names.
 	CookieRemember = "rm"
@@ -176,9 +196,78 @@ func (a *Authboss) LoadClientState(w http.ResponseWriter, r *http.Request) (*htt
 		}
 	}
 
+	if storer, ok := a.Storage.Server.(SessionServerStorer); ok {
+		var err error
+		if r, err = a.checkSessionRecord(w, r, storer); err != nil {
+			return nil, err
+		}
+	}
+
 	return r, nil
 }
 
+// sessionTouchInterval is how often the LastSeen of a SessionRecord
+// is updated, this avoids a write to the database on every request.
+const sessionTouchInterval = time.Minute
+
+// checkSessionRecord ensures that a session carrying a SessionID has not
+// been revoked. A revoked session is cleared from the response and hidden
+// from the rest of the request so that the user is logged out, and
+// CTXKeySessionRevoked is set so its remember me token isn't used.
+func (a *Authboss) checkSessionRecord(w http.ResponseWriter, r *http.Request, storer SessionServerStorer) (*http.Request, error) {
+	id, ok := GetSession(r, SessionID)
+	if !ok || len(id) == 0 {
+		return r, nil
+	}
+	pid, _ := GetSession(r, SessionKey)
+
+	record, err := storer.LoadSession(r.Context(), id)
+	switch {
+	case err == ErrSessionNotFound:
+	case err != nil:
+		return nil, err
+	case record.PID == pid:
+		if now := a.Now(); now.Sub(record.LastSeen) > sessionTouchInterval {
+			if err = storer.TouchSession(r.Context(), id, now); err != nil {
+				return nil, err
+			}
+		}
+		return r, nil
+	}
+
+	logger := a.RequestLogger(r)
+	logger.Infof("session for user %s was revoked, logging out", pid)
+
+	whitelist := a.Config.Storage.SessionStateWhitelistKeys
+	DelAllSession(w, whitelist)
+
+	state := whitelistedState{
+		ClientState: r.Context().Value(CTXKeySessionState).(ClientState),
+		whitelist:   whitelist,
+	}
+	ctx := context.WithValue(r.Context(), CTXKeySessionState, state)
+	ctx = context.WithValue(ctx, CTXKeySessionRevoked, true)
+	return r.WithContext(ctx), nil
+}
+
+// whitelistedState hides all keys that are not in the whitelist
+type whitelistedState struct {
+	ClientState
+
+	whitelist []string
+}
+
+// Get a key if it's whitelisted
+func (w whitelistedState) Get(key string) (string, bool) {
+	for _, k := range w.whitelist {
+		if k == key {
+			return w.ClientState.Get(key)
+		}
+	}
+
+	return "", false
+}
+
 // MustClientStateResponseWriter tries to find a csrw inside the response
 // writer by using the UnderlyingResponseWriter interface.
 func MustClientStateResponseWriter(w http.ResponseWriter) *ClientStateResponseWriter {
@@ -288,6 +377,30 @@ func IsTwoFactored(r *http.Request) bool {
 	return has2fa
 }
 
+// RecordLastLogin is an EventHandler that stores the time of the login in
+// the session. Modules that let users do something sensitive without
+// re-entering their password (deleting their account, changing their
+// e-mail address) hook it after EventAuth and EventOAuth2.
+func (a *Authboss) RecordLastLogin(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+	PutSession(w, SessionLastLogin, strconv.FormatInt(a.Now().Unix(), 10))
+	return false, nil
+}
+
+// LoggedInWithin checks that the last full login recorded in the session
+// by RecordLastLogin was no longer than d ago.
+func (a *Authboss) LoggedInWithin(r *http.Request, d time.Duration) bool {
+	last, ok := GetSession(r, SessionLastLogin)
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(last, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	return a.Now().Sub(time.Unix(unix, 0)) <= d
+}
+
 // DelAllSession deletes all variables in the session except for those on
 // the whitelist.
 //
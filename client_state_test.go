@@ -2,9 +2,11 @@ package authboss
 
 import (
 	"io"
+	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestStateGet(t *testing.T) {
@@ -31,6 +33,57 @@ func TestStateGet(t *testing.T) {
 	}
 }
 
+func TestStateSessionRecord(t *testing.T) {
+	t.Parallel()
+
+	storer := newMockSessionServerStorer()
+	storer.Sessions["current"] = SessionRecord{ID: "current", PID: "test@test.com", LastSeen: time.Now().UTC().Add(-time.Hour)}
+	storer.Sessions["other"] = SessionRecord{ID: "other", PID: "other@test.com"}
+
+	load := func(id string) (*http.Request, *ClientStateResponseWriter) {
+		t.Helper()
+
+		ab := New()
+		ab.Config.Core.Logger = mockLogger{}
+		ab.Storage.Server = storer
+		ab.Storage.SessionState = newMockClientStateRW(SessionKey, "test@test.com", SessionID, id, FlashSuccessKey, "flash")
+		ab.Storage.SessionStateWhitelistKeys = []string{FlashSuccessKey}
+
+		r := httptest.NewRequest("GET", "/", nil)
+		w := ab.NewResponse(httptest.NewRecorder())
+
+		r, err := ab.LoadClientState(w, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r, w
+	}
+
+	r, w := load("current")
+	if pid, _ := GetSession(r, SessionKey); pid != "test@test.com" {
+		t.Error("valid session should remain logged in:", pid)
+	}
+	if len(w.sessionStateEvents) != 0 {
+		t.Error("valid session should not be changed:", w.sessionStateEvents)
+	}
+	if time.Since(storer.Sessions["current"].LastSeen) > time.Minute {
+		t.Error("last seen should be updated")
+	}
+
+	for _, id := range []string{"revoked", "other"} {
+		r, w = load(id)
+		if pid, _ := GetSession(r, SessionKey); len(pid) != 0 {
+			t.Error("revoked session should be logged out:", pid)
+		}
+		if flash, _ := GetSession(r, FlashSuccessKey); flash != "flash" {
+			t.Error("whitelisted keys should remain:", flash)
+		}
+		if len(w.sessionStateEvents) != 1 || w.sessionStateEvents[0].Kind != ClientStateEventDelAll {
+			t.Error("revoked session should be cleared:", w.sessionStateEvents)
+		}
+	}
+}
+
 func TestStateResponseWriterDoubleWritePanic(t *testing.T) {
 	t.Parallel()
 
@@ -160,6 +213,52 @@ func TestFlashClearer(t *testing.T) {
 	}
 }
 
+func TestLastLogin(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	ab := New()
+	ab.Config.Core.Clock = fixedClock(now)
+
+	csrw := &ClientStateResponseWriter{}
+	if handled, err := ab.RecordLastLogin(csrw, nil, false); err != nil {
+		t.Fatal(err)
+	} else if handled {
+		t.Error("it should not handle the request")
+	}
+	if len(csrw.sessionStateEvents) != 1 || csrw.sessionStateEvents[0].Key != SessionLastLogin {
+		t.Fatal("the login time should be put in the session:", csrw.sessionStateEvents)
+	}
+
+	load := func(keyValue ...string) *http.Request {
+		t.Helper()
+
+		ab.Storage.SessionState = newMockClientStateRW(keyValue...)
+		r, err := ab.LoadClientState(ab.NewResponse(httptest.NewRecorder()), httptest.NewRequest("GET", "/", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	r := load(SessionLastLogin, csrw.sessionStateEvents[0].Value)
+	if !ab.LoggedInWithin(r, time.Minute) {
+		t.Error("the login was just recorded")
+	}
+
+	ab.Config.Core.Clock = fixedClock(now.Add(2 * time.Minute))
+	if ab.LoggedInWithin(r, time.Minute) {
+		t.Error("the login is too old")
+	}
+
+	if ab.LoggedInWithin(load(), time.Minute) {
+		t.Error("without a recorded login it should fail")
+	}
+	if ab.LoggedInWithin(load(SessionLastLogin, "garbage"), time.Minute) {
+		t.Error("a bad login time should fail")
+	}
+}
+
 func TestDelAllSession(t *testing.T) {
 	t.Parallel()
 
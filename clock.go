@@ -0,0 +1,28 @@
+package authboss
+
+import "time"
+
+// Clock tells the time, the modules read it from Config.Core.Clock
+// wherever expiries, lockouts, totp windows and rate limits are computed
+// so that a fake clock can be used to test time dependent behavior.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is a Clock that reads the system time
+type SystemClock struct{}
+
+// Now returns time.Now()
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Now returns the current time in UTC according to Config.Core.Clock, if
+// no clock has been configured the system time is used.
+func (a *Authboss) Now() time.Time {
+	if a.Config.Core.Clock == nil {
+		return time.Now().UTC()
+	}
+
+	return a.Config.Core.Clock.Now().UTC()
+}
@@ -0,0 +1,35 @@
+package authboss
+
+import (
+	"testing"
+	"time"
+)
+
+type fixedClock time.Time
+
+func (f fixedClock) Now() time.Time { return time.Time(f) }
+
+func TestNow(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	if _, ok := ab.Config.Core.Clock.(SystemClock); !ok {
+		t.Errorf("the default clock should be the system clock: %T", ab.Config.Core.Clock)
+	}
+
+	est := time.FixedZone("EST", -5*60*60)
+	ab.Config.Core.Clock = fixedClock(time.Date(2020, 1, 1, 0, 0, 0, 0, est))
+
+	now := ab.Now()
+	if now.Location() != time.UTC {
+		t.Error("the time should be in utc:", now)
+	}
+	if want := time.Date(2020, 1, 1, 5, 0, 0, 0, time.UTC); !now.Equal(want) {
+		t.Error("time wrong:", now)
+	}
+
+	ab.Config.Core.Clock = nil
+	if now := ab.Now(); time.Since(now) > time.Minute {
+		t.Error("without a clock the system time should be used:", now)
+	}
+}
@@ -28,18 +28,38 @@ type Config struct {
 		// to confirm their account, this is where they should be redirected to.
 		ConfirmNotOK string
 
+		// DeleteAccountOK is the redirect path after a user has deleted
+		// their account.
+		DeleteAccountOK string
+
+		// EmailChangeOK is the redirect path after a user has requested
+		// an e-mail change and after they have confirmed it.
+		EmailChangeOK string
+
 		// LockNotOK is a path to go to when the user fails
 		LockNotOK string
 
 		// LogoutOK is the redirect path after a log out.
 		LogoutOK string
 
+		// MagicLinkOK is the redirect path after a user has requested
+		// a magic link.
+		MagicLinkOK string
+
+		// OAuth2LinkOK is the redirect path after an oauth2 identity has
+		// been linked or unlinked.
+		OAuth2LinkOK string
+
 		// OAuth2LoginOK is the redirect path after a successful oauth2 login
 		OAuth2LoginOK string
 		// OAuth2LoginNotOK is the redirect path after
 		// an unsuccessful oauth2 login
 		OAuth2LoginNotOK string
 
+		// PasswordChangeOK is the redirect path after a user has changed
+		// their password.
+		PasswordChangeOK string
+
 		// RecoverOK is the redirect path after a successful recovery of a
 		// password.
 		RecoverOK string
@@ -57,13 +77,37 @@ type Config struct {
 		// their e-mail OR when they've completed the first step towards
 		// verification and need to check their e-mail to proceed.
 		TwoFactorEmailAuthNotOK string
+
+		// UnlockOK is the redirect path after a user has asked for an
+		// unlock e-mail, or has unlocked their account with the link in it.
+		UnlockOK string
 	}
 
 	Modules struct {
+		// APIKeyScopes are the scopes users may give the api keys they
+		// create. When it's empty api keys cannot have scopes.
+		APIKeyScopes []string
+
+		// AuditRecentLimit is how many records the audit module shows on
+		// the security activity page.
+		AuditRecentLimit int
+
 		// BCryptCost is the cost of the bcrypt password hashing function.
 		// Deprecated: Use Hasher instead.
 		BCryptCost int
 
+		// BearerTokenKey is the secret used to sign access tokens issued by
+		// the bearer module with HMAC-SHA256. It must be at least 32 bytes.
+		BearerTokenKey []byte
+		// BearerTokenIssuer is put in the iss claim of access tokens and
+		// checked when they're used if it's not empty.
+		BearerTokenIssuer string
+		// BearerTokenDuration controls how long an access token is valid for.
+		BearerTokenDuration time.Duration
+		// BearerRefreshTokenDuration controls how long a refresh token is
+		// valid for, refresh tokens are replaced each time they're used.
+		BearerRefreshTokenDuration time.Duration
+
 		// ConfirmMethod IS DEPRECATED! See MailRouteMethod instead.
 		//
 		// ConfirmMethod controls which http method confirm expects.
@@ -72,6 +116,19 @@ type Config struct {
 		// post since there's data that must be sent to it.
 		ConfirmMethod string
 
+		// CSRFProtection wraps all of Authboss's routes with CSRFMiddleware
+		// when Init is called. Forms must then send the csrf token that
+		// defaults.Responder puts in the data under DataCSRFToken.
+		CSRFProtection bool
+
+		// EmailChangeTokenDuration controls how long a token sent via
+		// email to confirm a new e-mail address is valid for.
+		EmailChangeTokenDuration time.Duration
+		// EmailChangeRecentLogin is how long after logging in a user that
+		// has no password may change their e-mail address. Users with a
+		// password must enter it instead.
+		EmailChangeRecentLogin time.Duration
+
 		// ExpireAfter controls the time an account is idle before being
 		// logged out by the ExpireMiddleware.
 		ExpireAfter time.Duration
@@ -82,6 +139,12 @@ type Config struct {
 		LockWindow time.Duration
 		// LockDuration is how long an account is locked for.
 		LockDuration time.Duration
+		// LockPolicy decides how long an account is locked for each time
+		// it's locked, if it's nil LockDuration is always used.
+		LockPolicy LockPolicy
+		// UnlockTokenDuration controls how long the unlock link sent to
+		// a user when they're locked is valid for.
+		UnlockTokenDuration time.Duration
 
 		// LogoutMethod is the method the logout route should use
 		// (default should be DELETE)
@@ -130,6 +193,34 @@ type Config struct {
 		// configuration variable.
 		RegisterPreserveFields []string
 
+		// DeleteRecentLogin is how long after logging in a user may delete
+		// their account without being re-prompted, it applies to users
+		// that have no password or totp code to check and users with sms
+		// or webauthn two factor authentication.
+		DeleteRecentLogin time.Duration
+
+		// PasswordChangeRevokeRemember if true deletes all of a user's
+		// remember me tokens when they change their password, logging out
+		// any other browsers that were remembered.
+		PasswordChangeRevokeRemember bool
+
+		// RateLimits are the limits the ratelimit module puts on POSTs to
+		// Authboss's routes, keyed by the path of the route without
+		// Paths.Mount (eg. /login). The defaults only limit ip addresses,
+		// a Global limit slows down attacks spread over many addresses but
+		// an attacker can also use it up to refuse everyone.
+		RateLimits map[string]RateLimitPolicy
+		// RateLimitAuthFailures limits the failed logins from an ip address,
+		// once it's exceeded the ip address is refused on all the
+		// RateLimits routes until the bucket refills.
+		RateLimitAuthFailures RateLimit
+		// RateLimitRecoverEmails limits the recover e-mails that are sent
+		// to a single user.
+		RateLimitRecoverEmails RateLimit
+		// RateLimitMagicLinkEmails limits the magic link e-mails that are
+		// sent to a single user.
+		RateLimitMagicLinkEmails RateLimit
+
 		// RecoverTokenDuration controls how long a token sent via
 		// email for password recovery is valid for.
 		RecoverTokenDuration time.Duration
@@ -138,6 +229,10 @@ type Config struct {
 		// again manually.
 		RecoverLoginAfterRecovery bool
 
+		// MagicLinkTokenDuration controls how long a login link sent via
+		// email by the magiclink module is valid for.
+		MagicLinkTokenDuration time.Duration
+
 		// OAuth2Providers lists all providers that can be used. See
 		// OAuthProvider documentation for more details.
 		OAuth2Providers map[string]OAuth2Provider
@@ -151,6 +246,28 @@ type Config struct {
 		// a qr code for google authenticator.
 		TOTP2FAIssuer string
 
+		// WebAuthnRPID is the relying party id used for webauthn credentials.
+		// This must be the domain (or a registrable suffix of it) the site
+		// is served from. If empty the host of Paths.RootURL is used.
+		WebAuthnRPID string
+		// WebAuthnRPName is the human readable name of the site that
+		// is displayed by the browser when creating a credential.
+		WebAuthnRPName string
+		// WebAuthnOrigins is the list of origins (scheme://host[:port]) that
+		// webauthn ceremonies may be performed from. If empty the origin of
+		// Paths.RootURL is used.
+		WebAuthnOrigins []string
+		// WebAuthnPasswordless enables the /webauthn/login routes which allow
+		// users to log in with a passkey alone. Credentials used this way
+		// must perform user verification.
+		WebAuthnPasswordless bool
+		// WebAuthnFakeCredentialKey is the secret that the fake credentials
+		// given to unknown users at /webauthn/login are derived from, so
+		// that the response doesn't reveal whether an account exists. If
+		// empty a random key is used which differs between instances of
+		// the app, set it when running more than one.
+		WebAuthnFakeCredentialKey []byte
+
 		// DEPRECATED: See ResponseOnUnauthed
 		// RoutesRedirectOnUnauthed controls whether or not a user is redirected
 		// or given a 404 when they are unauthenticated and attempting to access
@@ -203,6 +320,14 @@ type Config struct {
 		// from the request.
 		SessionState ClientStateReadWriter
 
+		// RateLimit keeps the token buckets for the ratelimit module, if
+		// it's nil when the module is loaded an in-memory one is used.
+		RateLimit RateLimitStorer
+
+		// Audit is where the audit module writes a record of each event,
+		// it must be set when the module is loaded.
+		Audit AuditStorer
+
 		// SessionStateWhitelistKeys are set to preserve keys in the session
 		// when authboss.DelAllSession is called. A correct implementation
 		// of ClientStateReadWriter will delete ALL session key-value pairs
@@ -253,6 +378,11 @@ type Config struct {
 
 		// Localizer is used to translate strings into different languages.
 		Localizer Localizer
+
+		// Clock is where the modules get the current time from, it can
+		// be replaced with a fake clock (see mocks.Clock) to test time
+		// dependent behavior like expiries and lockouts.
+		Clock Clock
 	}
 }
 
@@ -263,25 +393,57 @@ func (c *Config) Defaults() {
 	c.Paths.AuthLoginOK = "/"
 	c.Paths.ConfirmOK = "/"
 	c.Paths.ConfirmNotOK = "/"
+	c.Paths.DeleteAccountOK = "/"
+	c.Paths.EmailChangeOK = "/"
 	c.Paths.LockNotOK = "/"
 	c.Paths.LogoutOK = "/"
+	c.Paths.MagicLinkOK = "/"
+	c.Paths.OAuth2LinkOK = "/"
 	c.Paths.OAuth2LoginOK = "/"
 	c.Paths.OAuth2LoginNotOK = "/"
+	c.Paths.PasswordChangeOK = "/"
 	c.Paths.RecoverOK = "/"
 	c.Paths.RegisterOK = "/"
 	c.Paths.RootURL = "http://localhost:8080"
 	c.Paths.TwoFactorEmailAuthNotOK = "/"
+	c.Paths.UnlockOK = "/"
 
+	c.Modules.AuditRecentLimit = 20
 	c.Modules.BCryptCost = bcrypt.DefaultCost
+	c.Modules.BearerTokenDuration = 15 * time.Minute
+	c.Modules.BearerRefreshTokenDuration = 30 * 24 * time.Hour
 	c.Modules.ConfirmMethod = http.MethodGet
+	c.Modules.DeleteRecentLogin = 10 * time.Minute
+	c.Modules.EmailChangeRecentLogin = 10 * time.Minute
+	c.Modules.EmailChangeTokenDuration = 24 * time.Hour
 	c.Modules.ExpireAfter = time.Hour
 	c.Modules.LockAfter = 3
 	c.Modules.LockWindow = 5 * time.Minute
 	c.Modules.LockDuration = 12 * time.Hour
+	c.Modules.UnlockTokenDuration = 24 * time.Hour
 	c.Modules.LogoutMethod = "DELETE"
+	c.Modules.MagicLinkTokenDuration = 15 * time.Minute
 	c.Modules.MailRouteMethod = http.MethodGet
+	// Only per ip address limits are set by default, a global limit can be
+	// used up by one client with many addresses locking everyone out
+	c.Modules.RateLimits = map[string]RateLimitPolicy{
+		"/login":            {IP: RateLimit{10, time.Minute}},
+		"/token":            {IP: RateLimit{10, time.Minute}},
+		"/otp/login":        {IP: RateLimit{10, time.Minute}},
+		"/recover":          {IP: RateLimit{5, 10 * time.Minute}},
+		"/magiclink":        {IP: RateLimit{5, 10 * time.Minute}},
+		"/lock/unlock":      {IP: RateLimit{5, 10 * time.Minute}},
+		"/register":         {IP: RateLimit{5, time.Hour}},
+		"/2fa/sms/setup":    {IP: RateLimit{5, 10 * time.Minute}},
+		"/2fa/sms/confirm":  {IP: RateLimit{10, 10 * time.Minute}},
+		"/2fa/sms/validate": {IP: RateLimit{10, 10 * time.Minute}},
+	}
+	c.Modules.RateLimitAuthFailures = RateLimit{20, time.Hour}
+	c.Modules.RateLimitRecoverEmails = RateLimit{3, time.Hour}
+	c.Modules.RateLimitMagicLinkEmails = RateLimit{3, time.Hour}
 	c.Modules.RecoverLoginAfterRecovery = false
 	c.Modules.RecoverTokenDuration = 24 * time.Hour
 
 	c.Core.OneTimeTokenGenerator = NewSha512TokenGenerator()
+	c.Core.Clock = SystemClock{}
 }
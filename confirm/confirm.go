@@ -188,22 +188,12 @@ func (c *Confirm) Get(w http.ResponseWriter, r *http.Request) error {
 
 	values := authboss.MustHaveConfirmValues(validator)
 
-	rawToken, err := base64.URLEncoding.DecodeString(values.GetToken())
+	selector, verifier, err := ParseToken(c.Authboss.Core.OneTimeTokenGenerator, values.GetToken())
 	if err != nil {
-		logger.Infof("error decoding token in Confirm.Get, this typically means a bad token: %s %+v", values.GetToken(), err)
+		logger.Infof("invalid confirm token submitted: %s %+v", values.GetToken(), err)
 		return c.invalidToken(w, r)
 	}
 
-	credsGenerator := c.Authboss.Core.OneTimeTokenGenerator
-
-	if len(rawToken) != credsGenerator.TokenSize() {
-		logger.Infof("invalid confirm token submitted, size was wrong: %d", len(rawToken))
-		return c.invalidToken(w, r)
-	}
-
-	selectorBytes, verifierBytes := credsGenerator.ParseToken(string(rawToken))
-	selector := base64.StdEncoding.EncodeToString(selectorBytes[:])
-
 	storer := authboss.EnsureCanConfirm(c.Authboss.Config.Storage.Server)
 	user, err := storer.LoadByConfirmSelector(r.Context(), selector)
 	if err == authboss.ErrUserNotFound {
@@ -213,14 +203,7 @@ func (c *Confirm) Get(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	dbVerifierBytes, err := base64.StdEncoding.DecodeString(user.GetConfirmVerifier())
-	if err != nil {
-		logger.Infof("invalid confirm verifier stored in database: %s", user.GetConfirmVerifier())
-		return c.invalidToken(w, r)
-	}
-
-	if subtle.ConstantTimeEq(int32(len(verifierBytes)), int32(len(dbVerifierBytes))) != 1 ||
-		subtle.ConstantTimeCompare(verifierBytes[:], dbVerifierBytes) != 1 {
+	if !VerifierMatches(user.GetConfirmVerifier(), verifier) {
 		logger.Info("stored confirm verifier does not match provided one")
 		return c.invalidToken(w, r)
 	}
@@ -242,6 +225,35 @@ func (c *Confirm) Get(w http.ResponseWriter, r *http.Request) error {
 	return c.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
 }
 
+// ParseToken decodes a token that was sent out by e-mail into the selector
+// that is used to look up the user and the verifier that must match the one
+// stored on the user (see VerifierMatches).
+func ParseToken(generator authboss.OneTimeTokenGenerator, token string) (selector string, verifier []byte, err error) {
+	rawToken, err := base64.URLEncoding.DecodeString(token)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to decode token")
+	}
+
+	if len(rawToken) != generator.TokenSize() {
+		return "", nil, errors.Errorf("token size was wrong: %d", len(rawToken))
+	}
+
+	selectorBytes, verifierBytes := generator.ParseToken(string(rawToken))
+	return base64.StdEncoding.EncodeToString(selectorBytes[:]), verifierBytes[:], nil
+}
+
+// VerifierMatches does a constant time comparison of a verifier from
+// ParseToken with the base64 encoded verifier stored on the user.
+func VerifierMatches(stored string, verifier []byte) bool {
+	dbVerifierBytes, err := base64.StdEncoding.DecodeString(stored)
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeEq(int32(len(verifier)), int32(len(dbVerifierBytes))) == 1 &&
+		subtle.ConstantTimeCompare(verifier, dbVerifierBytes) == 1
+}
+
 func (c *Confirm) mailURL(token string) string {
 	query := url.Values{FormValueConfirm: []string{token}}
 
@@ -2,6 +2,7 @@ package authboss
 
 import (
 	"context"
+	"net"
 	"net/http"
 )
 
@@ -25,6 +26,21 @@ const (
 	// user information currently is remember so only auth/oauth2 are currently
 	// going to use this.
 	CTXKeyValues contextKey = "values"
+
+	// CTXKeyCSRFToken is the csrf token for the request, it's set by
+	// CSRFMiddleware.
+	CTXKeyCSRFToken contextKey = "csrf_token"
+
+	// CTXKeySessionRevoked is set to true by LoadClientState when the
+	// session making the request has been revoked. The remember module
+	// uses it to throw away the session's remember me token instead of
+	// logging it back in.
+	CTXKeySessionRevoked contextKey = "session_revoked"
+
+	// CTXKeyHeaderAuth is set to true by the bearer and apikey modules once
+	// they've authenticated the request with its Authorization header.
+	// CSRFMiddleware doesn't check the csrf token of these requests.
+	CTXKeyHeaderAuth contextKey = "header_auth"
 )
 
 func (c contextKey) String() string {
@@ -160,3 +176,15 @@ func (a *Authboss) LoadCurrentUserP(r **http.Request) User {
 
 	return user
 }
+
+// RemoteIP strips the port from the request's remote address. If the app is
+// behind a proxy it should set RemoteAddr from the proxy headers before
+// Authboss sees the request.
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -248,3 +248,22 @@ func TestCTXKeyString(t *testing.T) {
 		t.Error(got)
 	}
 }
+
+func TestRemoteIP(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"1.2.3.4:5678":     "1.2.3.4",
+		"[::1]:5678":       "::1",
+		"1.2.3.4":          "1.2.3.4",
+		"unix-socket-addr": "unix-socket-addr",
+	}
+
+	for addr, want := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = addr
+		if got := RemoteIP(r); got != want {
+			t.Errorf("%s) want: %s, got: %s", addr, want, got)
+		}
+	}
+}
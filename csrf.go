@@ -0,0 +1,151 @@
+package authboss
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"io"
+	"net/http"
+
+	"github.com/friendsofgo/errors"
+)
+
+const (
+	// FormValueCSRFToken is the form value the csrf token is read from
+	FormValueCSRFToken = "csrf_token"
+	// HeaderCSRFToken is the header the csrf token is read from, it's
+	// checked before the form value so javascript clients can use it.
+	HeaderCSRFToken = "X-CSRF-Token"
+
+	csrfTokenSize = 32
+)
+
+// CSRFMiddleware protects against cross site request forgery. It makes
+// sure the session has a csrf token and puts it in the request context,
+// where GetCSRFToken (and so defaults.Responder) can find it. Requests that
+// are not GET, HEAD, OPTIONS or TRACE must send the token back in the
+// HeaderCSRFToken header or FormValueCSRFToken form value or they get a
+// 403. Requests that were authenticated by bearer.Middleware or
+// apikey.Middleware are exempt since browsers never send those
+// Authorization headers on their own, only having the header is not
+// enough.
+//
+// When Config.Modules.CSRFProtection is set Authboss's own routes are
+// wrapped with this, it can also be used to protect the rest of the app.
+// It must be used after LoadClientStateMiddleware and after the bearer and
+// apikey middlewares.
+func CSRFMiddleware(ab *Authboss) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logger := ab.RequestLogger(r)
+
+			token, ok := GetSession(r, SessionCSRFToken)
+			if !ok || len(token) == 0 {
+				var err error
+				if token, err = generateCSRFToken(); err != nil {
+					logger.Errorf("failed to generate csrf token: %+v", err)
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				PutSession(w, SessionCSRFToken, token)
+			}
+
+			r = r.WithContext(context.WithValue(r.Context(), CTXKeyCSRFToken, token))
+
+			if !isCSRFSafe(r) && !verifyCSRFToken(r, token) {
+				logger.Infof("csrf token missing or invalid for: %s", r.URL.Path)
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = io.WriteString(w, "invalid csrf token")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// GetCSRFToken returns the csrf token for the request, or the empty string
+// if there is none because CSRFMiddleware has not been used.
+func GetCSRFToken(r *http.Request) string {
+	if token, ok := r.Context().Value(CTXKeyCSRFToken).(string); ok {
+		return token
+	}
+
+	token, _ := GetSession(r, SessionCSRFToken)
+	return token
+}
+
+// isCSRFSafe checks if the request does not need a csrf token, either
+// because of its method or because it was authenticated by a header. The
+// header check is for the app's own API routes, requests to log in for
+// a token don't have one yet so those routes use CSRFExempt.
+func isCSRFSafe(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+
+	headerAuth, _ := r.Context().Value(CTXKeyHeaderAuth).(bool)
+	return headerAuth
+}
+
+func verifyCSRFToken(r *http.Request, token string) bool {
+	sent := r.Header.Get(HeaderCSRFToken)
+	if len(sent) == 0 {
+		sent = r.PostFormValue(FormValueCSRFToken)
+	}
+
+	return len(sent) != 0 && subtle.ConstantTimeCompare([]byte(sent), []byte(token)) == 1
+}
+
+func generateCSRFToken() (string, error) {
+	token := make([]byte, csrfTokenSize)
+	if _, err := io.ReadFull(rand.Reader, token); err != nil {
+		return "", errors.Wrap(err, "failed to read random bytes")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// CSRFExempt marks a handler so that it's not wrapped with CSRFMiddleware
+// when Config.Modules.CSRFProtection is set. It's meant for routes that
+// other sites post to and that are protected some other way, like the
+// oauth2 callback which checks the oauth2 state, and for routes that are
+// only used by API clients and never read or write client state, like the
+// bearer module's token routes. Any module with routes like that must
+// mark them since they can't be told apart from a browser's requests.
+func CSRFExempt(handler http.Handler) http.Handler {
+	return csrfExemptHandler{handler}
+}
+
+type csrfExemptHandler struct {
+	http.Handler
+}
+
+// csrfRouter wraps every route that's registered with CSRFMiddleware
+type csrfRouter struct {
+	Router
+
+	middleware func(http.Handler) http.Handler
+}
+
+func (c csrfRouter) Get(path string, handler http.Handler) {
+	c.Router.Get(path, c.wrap(handler))
+}
+
+func (c csrfRouter) Post(path string, handler http.Handler) {
+	c.Router.Post(path, c.wrap(handler))
+}
+
+func (c csrfRouter) Delete(path string, handler http.Handler) {
+	c.Router.Delete(path, c.wrap(handler))
+}
+
+func (c csrfRouter) wrap(handler http.Handler) http.Handler {
+	if _, ok := handler.(csrfExemptHandler); ok {
+		return handler
+	}
+
+	return c.middleware(handler)
+}
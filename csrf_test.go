@@ -0,0 +1,169 @@
+package authboss
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func testCSRFRequest(t *testing.T, ab *Authboss, r *http.Request) (*httptest.ResponseRecorder, *http.Request, bool) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	w := ab.NewResponse(rec)
+
+	r, err := ab.LoadClientState(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got *http.Request
+	called := false
+	CSRFMiddleware(ab)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		got = r
+		w.WriteHeader(http.StatusOK)
+	})).ServeHTTP(w, r)
+
+	return rec, got, called
+}
+
+func TestCSRFMiddlewareGenerates(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	ab.Config.Core.Logger = mockLogger{}
+	ab.Storage.SessionState = newMockClientStateRW()
+
+	rec, r, called := testCSRFRequest(t, ab, httptest.NewRequest("GET", "/", nil))
+	if !called {
+		t.Fatal("the handler should be called")
+	}
+
+	token := GetCSRFToken(r)
+	if len(token) == 0 {
+		t.Fatal("there should be a token in the context")
+	}
+	if !strings.Contains(rec.Header().Get("test_session"), token) {
+		t.Error("the token should be stored in the session")
+	}
+}
+
+func TestCSRFMiddlewareVerifies(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name    string
+		Request func() *http.Request
+		Allowed bool
+	}{
+		{"Safe", func() *http.Request { return httptest.NewRequest("HEAD", "/", nil) }, true},
+		{"Missing", func() *http.Request { return httptest.NewRequest("POST", "/", nil) }, false},
+		{"Header", func() *http.Request {
+			r := httptest.NewRequest("DELETE", "/", nil)
+			r.Header.Set(HeaderCSRFToken, "token")
+			return r
+		}, true},
+		{"Form", func() *http.Request {
+			r := httptest.NewRequest("POST", "/", strings.NewReader(url.Values{FormValueCSRFToken: {"token"}}.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			return r
+		}, true},
+		{"Wrong", func() *http.Request {
+			r := httptest.NewRequest("POST", "/", nil)
+			r.Header.Set(HeaderCSRFToken, "other")
+			return r
+		}, false},
+		{"Query", func() *http.Request { return httptest.NewRequest("POST", "/?csrf_token=token", nil) }, false},
+		{"HeaderAuth", func() *http.Request {
+			r := httptest.NewRequest("POST", "/", nil)
+			r.Header.Set("Authorization", "Bearer abc")
+			return r.WithContext(context.WithValue(r.Context(), CTXKeyHeaderAuth, true))
+		}, true},
+		{"UnauthenticatedBearer", func() *http.Request {
+			r := httptest.NewRequest("POST", "/", nil)
+			r.Header.Set("Authorization", "Bearer abc")
+			return r
+		}, false},
+		{"UnauthenticatedAPIKey", func() *http.Request {
+			r := httptest.NewRequest("POST", "/", nil)
+			r.Header.Set("Authorization", "Token abc")
+			return r
+		}, false},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			ab := New()
+			ab.Config.Core.Logger = mockLogger{}
+			ab.Storage.SessionState = newMockClientStateRW(SessionCSRFToken, "token")
+
+			rec, _, called := testCSRFRequest(t, ab, test.Request())
+			if called != test.Allowed {
+				t.Errorf("allowed wrong: %t", called)
+			}
+			if !test.Allowed && rec.Code != http.StatusForbidden {
+				t.Error("code wrong:", rec.Code)
+			}
+		})
+	}
+}
+
+type csrfTestRouter struct {
+	handlers map[string]http.Handler
+}
+
+func (c csrfTestRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.handlers[r.URL.Path].ServeHTTP(w, r)
+}
+func (c csrfTestRouter) Get(path string, h http.Handler)    { c.handlers[path] = h }
+func (c csrfTestRouter) Post(path string, h http.Handler)   { c.handlers[path] = h }
+func (c csrfTestRouter) Delete(path string, h http.Handler) { c.handlers[path] = h }
+
+func TestCSRFProtection(t *testing.T) {
+	t.Parallel()
+
+	router := csrfTestRouter{handlers: make(map[string]http.Handler)}
+
+	ab := New()
+	ab.Config.Core.Logger = mockLogger{}
+	ab.Config.Core.Router = router
+	ab.Config.Modules.CSRFProtection = true
+	ab.Storage.SessionState = newMockClientStateRW()
+
+	if err := ab.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ab.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ab.Config.Core.Router.(csrfRouter).Router.(csrfTestRouter); !ok {
+		t.Fatal("the router should be wrapped once")
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	ab.Config.Core.Router.Post("/protected", ok)
+	ab.Config.Core.Router.Post("/exempt", CSRFExempt(ok))
+
+	for path, code := range map[string]int{"/protected": http.StatusForbidden, "/exempt": http.StatusOK} {
+		rec := httptest.NewRecorder()
+		w := ab.NewResponse(rec)
+		r, err := ab.LoadClientState(w, httptest.NewRequest("POST", path, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ab.Config.Core.Router.ServeHTTP(w, r)
+		if rec.Code != code {
+			t.Errorf("%s) code wrong: %d", path, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package defaults
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/friendsofgo/errors"
+	"github.com/volatiletech/authboss/v3"
+)
+
+const (
+	// DefaultSessionCookieName is the name of the cookie used by the
+	// session ClientStateReadWriters when none is given.
+	DefaultSessionCookieName = "ab_session"
+
+	// DefaultCookieMaxAge is the MaxAge given to cookies created by
+	// NewCookieStorer, it matches the lifetime of a remember me token.
+	DefaultCookieMaxAge = 30 * 24 * time.Hour
+)
+
+// clientState is a simple map backed authboss.ClientState
+type clientState map[string]string
+
+// Get a value from the state
+func (c clientState) Get(key string) (string, bool) {
+	val, ok := c[key]
+	return val, ok
+}
+
+// applyEvents returns a copy of state with all the events applied
+func applyEvents(state clientState, events []authboss.ClientStateEvent) clientState {
+	values := make(clientState, len(state))
+	for k, v := range state {
+		values[k] = v
+	}
+
+	for _, ev := range events {
+		switch ev.Kind {
+		case authboss.ClientStateEventPut:
+			values[ev.Key] = ev.Value
+		case authboss.ClientStateEventDel:
+			delete(values, ev.Key)
+		case authboss.ClientStateEventDelAll:
+			whitelist := strings.Split(ev.Key, ",")
+			for k := range values {
+				if !inWhitelist(k, whitelist) {
+					delete(values, k)
+				}
+			}
+		}
+	}
+
+	return values
+}
+
+func inWhitelist(key string, whitelist []string) bool {
+	for _, w := range whitelist {
+		if key == w {
+			return true
+		}
+	}
+	return false
+}
+
+// CookieStorer is a ClientStateReadWriter for authboss's cookie state
+// (Config.Storage.CookieState). Each key is stored in a separate signed and
+// encrypted cookie of the same name.
+type CookieStorer struct {
+	CookieOptions
+
+	codec cookieCodec
+}
+
+// NewCookieStorer creates a cookie storer that uses the first of keys to
+// encrypt cookies and all of them to decrypt. Keys must be 16, 24 or 32
+// bytes long (see GenerateCookieKey). Don't share keys with a session
+// storer, every cookie in the request is decoded so the session would be
+// readable as a cookie.
+func NewCookieStorer(keys ...[]byte) *CookieStorer {
+	opts := DefaultCookieOptions()
+	opts.MaxAge = DefaultCookieMaxAge
+
+	return &CookieStorer{
+		CookieOptions: opts,
+		codec:         newCookieCodec(keys),
+	}
+}
+
+// ReadState decodes all cookies that were written by this storer, any that
+// fail to decode are ignored.
+func (c *CookieStorer) ReadState(r *http.Request) (authboss.ClientState, error) {
+	state := make(clientState)
+	for _, cookie := range r.Cookies() {
+		value, err := c.codec.decode(cookie.Name, cookie.Value, c.MaxAge, c.now())
+		if err != nil {
+			continue
+		}
+		state[cookie.Name] = string(value)
+	}
+
+	return state, nil
+}
+
+// WriteState sets and deletes cookies according to the events
+func (c *CookieStorer) WriteState(w http.ResponseWriter, state authboss.ClientState, events []authboss.ClientStateEvent) error {
+	for _, ev := range events {
+		switch ev.Kind {
+		case authboss.ClientStateEventPut:
+			if err := setCookie(w, c.codec, c.CookieOptions, ev.Key, []byte(ev.Value)); err != nil {
+				return err
+			}
+		case authboss.ClientStateEventDel:
+			http.SetCookie(w, c.expired(ev.Key))
+		case authboss.ClientStateEventDelAll:
+			cookies, ok := state.(clientState)
+			if !ok {
+				continue
+			}
+
+			whitelist := strings.Split(ev.Key, ",")
+			for name := range cookies {
+				if !inWhitelist(name, whitelist) {
+					http.SetCookie(w, c.expired(name))
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// SessionStorer is a ClientStateReadWriter for authboss's session state
+// (Config.Storage.SessionState). The entire session is kept in a single
+// signed and encrypted cookie, see ServerSessionStorer to keep it on the
+// server instead.
+type SessionStorer struct {
+	CookieOptions
+
+	// Name of the session cookie
+	Name string
+
+	codec cookieCodec
+}
+
+// NewSessionStorer creates a session storer that uses the first of keys to
+// encrypt the session and all of them to decrypt. Keys must be 16, 24 or 32
+// bytes long (see GenerateCookieKey). If name is empty
+// DefaultSessionCookieName is used.
+func NewSessionStorer(name string, keys ...[]byte) *SessionStorer {
+	if len(name) == 0 {
+		name = DefaultSessionCookieName
+	}
+
+	return &SessionStorer{
+		CookieOptions: DefaultCookieOptions(),
+		Name:          name,
+		codec:         newCookieCodec(keys),
+	}
+}
+
+// ReadState decodes the session cookie, a missing or invalid cookie results
+// in an empty session.
+func (s *SessionStorer) ReadState(r *http.Request) (authboss.ClientState, error) {
+	state := make(clientState)
+
+	cookie, err := r.Cookie(s.Name)
+	if err != nil {
+		return state, nil
+	}
+
+	value, err := s.codec.decode(cookie.Name, cookie.Value, s.MaxAge, s.now())
+	if err != nil {
+		return state, nil
+	}
+
+	if err = json.Unmarshal(value, &state); err != nil {
+		return make(clientState), nil
+	}
+
+	return state, nil
+}
+
+// WriteState applies the events to the session and writes it back to the
+// cookie, or deletes the cookie if the session is empty.
+func (s *SessionStorer) WriteState(w http.ResponseWriter, state authboss.ClientState, events []authboss.ClientStateEvent) error {
+	current, _ := state.(clientState)
+	values := applyEvents(current, events)
+
+	if len(values) == 0 {
+		http.SetCookie(w, s.expired(s.Name))
+		return nil
+	}
+
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode session")
+	}
+
+	return setCookie(w, s.codec, s.CookieOptions, s.Name, encoded)
+}
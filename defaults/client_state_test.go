@@ -0,0 +1,203 @@
+package defaults
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+// roundTrip writes the events with rw and returns a request carrying the
+// cookies from the response along with any passed in cookies that were not
+// overwritten.
+func roundTrip(t *testing.T, rw authboss.ClientStateReadWriter, state authboss.ClientState, events []authboss.ClientStateEvent, cookies ...*http.Cookie) (*http.Request, []*http.Cookie) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	if err := rw.WriteState(w, state, events); err != nil {
+		t.Fatal(err)
+	}
+
+	set := w.Result().Cookies()
+	jar := make(map[string]*http.Cookie)
+	for _, c := range cookies {
+		jar[c.Name] = c
+	}
+	for _, c := range set {
+		if c.MaxAge < 0 {
+			delete(jar, c.Name)
+		} else {
+			jar[c.Name] = c
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	var all []*http.Cookie
+	for _, c := range jar {
+		r.AddCookie(&http.Cookie{Name: c.Name, Value: c.Value})
+		all = append(all, c)
+	}
+
+	return r, all
+}
+
+func readState(t *testing.T, rw authboss.ClientStateReadWriter, r *http.Request) authboss.ClientState {
+	t.Helper()
+
+	state, err := rw.ReadState(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func TestCookieStorer(t *testing.T) {
+	t.Parallel()
+
+	storer := NewCookieStorer(testKey(t))
+
+	state := readState(t, storer, httptest.NewRequest("GET", "/", nil))
+	r, cookies := roundTrip(t, storer, state, []authboss.ClientStateEvent{
+		{Kind: authboss.ClientStateEventPut, Key: authboss.CookieRemember, Value: "token"},
+		{Kind: authboss.ClientStateEventPut, Key: "other", Value: "value"},
+	})
+
+	if len(cookies) != 2 {
+		t.Fatal("expected two cookies:", cookies)
+	}
+	for _, c := range cookies {
+		if !c.HttpOnly || c.MaxAge != int(DefaultCookieMaxAge.Seconds()) {
+			t.Errorf("cookie attributes wrong: %#v", c)
+		}
+	}
+
+	// A cookie that wasn't written by us is ignored
+	r.AddCookie(&http.Cookie{Name: "foreign", Value: "value"})
+
+	state = readState(t, storer, r)
+	if val, ok := state.Get(authboss.CookieRemember); !ok || val != "token" {
+		t.Error("remember cookie wrong:", val)
+	}
+	if _, ok := state.Get("foreign"); ok {
+		t.Error("foreign cookie should be ignored")
+	}
+
+	r, cookies = roundTrip(t, storer, state, []authboss.ClientStateEvent{
+		{Kind: authboss.ClientStateEventDel, Key: authboss.CookieRemember},
+	}, cookies...)
+	if len(cookies) != 1 {
+		t.Error("expected one cookie:", cookies)
+	}
+
+	state = readState(t, storer, r)
+	if _, ok := state.Get(authboss.CookieRemember); ok {
+		t.Error("remember cookie should be deleted")
+	}
+
+	_, cookies = roundTrip(t, storer, state, []authboss.ClientStateEvent{
+		{Kind: authboss.ClientStateEventDelAll, Key: "other"},
+	}, cookies...)
+	if len(cookies) != 1 || cookies[0].Name != "other" {
+		t.Error("whitelisted cookie should survive:", cookies)
+	}
+
+	_, cookies = roundTrip(t, storer, state, []authboss.ClientStateEvent{
+		{Kind: authboss.ClientStateEventDelAll},
+	}, cookies...)
+	if len(cookies) != 0 {
+		t.Error("all cookies should be deleted:", cookies)
+	}
+}
+
+func TestCookieStorerClock(t *testing.T) {
+	t.Parallel()
+
+	clock := mocks.NewClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	storer := NewCookieStorer(testKey(t))
+	storer.Clock = clock
+
+	r, cookies := roundTrip(t, storer, nil, []authboss.ClientStateEvent{
+		{Kind: authboss.ClientStateEventPut, Key: authboss.CookieRemember, Value: "token"},
+	})
+	if !cookies[0].Expires.Equal(clock.Now().Add(DefaultCookieMaxAge)) {
+		t.Error("expiry should be read from the clock:", cookies[0].Expires)
+	}
+
+	clock.Advance(DefaultCookieMaxAge)
+	if _, ok := readState(t, storer, r).Get(authboss.CookieRemember); !ok {
+		t.Error("cookie should be accepted until max age")
+	}
+
+	clock.Advance(time.Second)
+	if _, ok := readState(t, storer, r).Get(authboss.CookieRemember); ok {
+		t.Error("cookie past max age should be ignored")
+	}
+}
+
+func TestSessionStorer(t *testing.T) {
+	t.Parallel()
+
+	storer := NewSessionStorer("", testKey(t))
+	storer.Secure = true
+
+	state := readState(t, storer, httptest.NewRequest("GET", "/", nil))
+	if _, ok := state.Get(authboss.SessionKey); ok {
+		t.Error("session should be empty")
+	}
+
+	r, cookies := roundTrip(t, storer, state, []authboss.ClientStateEvent{
+		{Kind: authboss.ClientStateEventPut, Key: authboss.SessionKey, Value: "test@test.com"},
+		{Kind: authboss.ClientStateEventPut, Key: authboss.FlashSuccessKey, Value: "yay"},
+		{Kind: authboss.ClientStateEventPut, Key: "temp", Value: "value"},
+		{Kind: authboss.ClientStateEventDel, Key: "temp"},
+	})
+	if len(cookies) != 1 || cookies[0].Name != DefaultSessionCookieName || !cookies[0].Secure {
+		t.Fatalf("session cookie wrong: %#v", cookies)
+	}
+
+	state = readState(t, storer, r)
+	if val, ok := state.Get(authboss.SessionKey); !ok || val != "test@test.com" {
+		t.Error("session key wrong:", val)
+	}
+	if _, ok := state.Get("temp"); ok {
+		t.Error("deleted key should not be present")
+	}
+
+	r, cookies = roundTrip(t, storer, state, []authboss.ClientStateEvent{
+		{Kind: authboss.ClientStateEventDelAll, Key: authboss.FlashSuccessKey},
+	}, cookies...)
+
+	state = readState(t, storer, r)
+	if _, ok := state.Get(authboss.SessionKey); ok {
+		t.Error("session key should be deleted")
+	}
+	if val, _ := state.Get(authboss.FlashSuccessKey); val != "yay" {
+		t.Error("whitelisted key should survive:", val)
+	}
+
+	_, cookies = roundTrip(t, storer, state, []authboss.ClientStateEvent{
+		{Kind: authboss.ClientStateEventDel, Key: authboss.FlashSuccessKey},
+	}, cookies...)
+	if len(cookies) != 0 {
+		t.Error("empty session should delete the cookie:", cookies)
+	}
+}
+
+func TestSessionStorerInvalidCookie(t *testing.T) {
+	t.Parallel()
+
+	storer := NewSessionStorer("", testKey(t))
+	other := NewSessionStorer("", testKey(t))
+
+	r, _ := roundTrip(t, other, nil, []authboss.ClientStateEvent{
+		{Kind: authboss.ClientStateEventPut, Key: authboss.SessionKey, Value: "test@test.com"},
+	})
+
+	state := readState(t, storer, r)
+	if _, ok := state.Get(authboss.SessionKey); ok {
+		t.Error("session from a different key should not be read")
+	}
+}
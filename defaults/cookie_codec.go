@@ -0,0 +1,200 @@
+package defaults
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/friendsofgo/errors"
+	"github.com/volatiletech/authboss/v3"
+)
+
+// maxCookieSize is the largest cookie (name, value and attributes) that
+// browsers are guaranteed to store.
+const maxCookieSize = 4096
+
+var errInvalidCookie = errors.New("cookie value could not be authenticated")
+
+// CookieOptions are the attributes given to every cookie written by the
+// cookie based ClientStateReadWriters in this package.
+type CookieOptions struct {
+	Path   string
+	Domain string
+	// MaxAge is both the lifetime given to the browser and the maximum age
+	// of a value that will be accepted when reading it back. Zero creates
+	// browser session cookies whose age is not checked.
+	MaxAge   time.Duration
+	Secure   bool
+	HTTPOnly bool
+	SameSite http.SameSite
+
+	// Clock is used to timestamp cookies and check their MaxAge, it should
+	// be the same as Config.Core.Clock. When it's nil the system time is
+	// used.
+	Clock authboss.Clock
+}
+
+// DefaultCookieOptions are HTTPOnly, SameSite=Lax cookies on the root path
+func DefaultCookieOptions() CookieOptions {
+	return CookieOptions{
+		Path:     "/",
+		HTTPOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func (c CookieOptions) now() time.Time {
+	if c.Clock == nil {
+		return time.Now().UTC()
+	}
+
+	return c.Clock.Now().UTC()
+}
+
+func (c CookieOptions) cookie(name, value string) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     c.Path,
+		Domain:   c.Domain,
+		Secure:   c.Secure,
+		HttpOnly: c.HTTPOnly,
+		SameSite: c.SameSite,
+	}
+
+	if c.MaxAge > 0 {
+		cookie.MaxAge = int(c.MaxAge / time.Second)
+		cookie.Expires = c.now().Add(c.MaxAge)
+	}
+
+	return cookie
+}
+
+func (c CookieOptions) expired(name string) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Path:     c.Path,
+		Domain:   c.Domain,
+		Secure:   c.Secure,
+		HttpOnly: c.HTTPOnly,
+		SameSite: c.SameSite,
+		MaxAge:   -1,
+		Expires:  time.Unix(1, 0).UTC(),
+	}
+}
+
+// GenerateCookieKey creates a new random key suitable for the cookie
+// ClientStateReadWriters.
+func GenerateCookieKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, errors.Wrap(err, "failed to generate cookie key")
+	}
+
+	return key, nil
+}
+
+// cookieCodec encrypts and authenticates cookie values with AES-GCM.
+//
+// The first key is used to encode values, every key is tried when decoding
+// which allows keys to be rotated by prepending a new key and removing the
+// old one once cookies encoded by it are no longer valid.
+//
+// The name of the cookie is authenticated along with the value so that a
+// value cannot be moved between cookies, and the time of encoding is
+// stored inside the ciphertext so a max age can be enforced server side.
+type cookieCodec struct {
+	aeads []cipher.AEAD
+}
+
+func newCookieCodec(keys [][]byte) cookieCodec {
+	if len(keys) == 0 {
+		panic("at least one cookie key must be provided")
+	}
+
+	codec := cookieCodec{aeads: make([]cipher.AEAD, len(keys))}
+	for i, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			panic(fmt.Sprintf("cookie key %d is invalid, it must be 16, 24 or 32 bytes: %v", i, err))
+		}
+
+		codec.aeads[i], err = cipher.NewGCM(block)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	return codec
+}
+
+func (c cookieCodec) encode(name string, value []byte, now time.Time) (string, error) {
+	aead := c.aeads[0]
+
+	plaintext := make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(plaintext, uint64(now.Unix()))
+	copy(plaintext[8:], value)
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", errors.Wrap(err, "failed to generate cookie nonce")
+	}
+
+	sealed := aead.Seal(nonce, nonce, plaintext, []byte(name))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (c cookieCodec) decode(name, value string, maxAge time.Duration, now time.Time) ([]byte, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errInvalidCookie
+	}
+
+	for _, aead := range c.aeads {
+		if len(sealed) < aead.NonceSize()+aead.Overhead() {
+			return nil, errInvalidCookie
+		}
+
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(name))
+		if err != nil {
+			continue
+		}
+		if len(plaintext) < 8 {
+			return nil, errInvalidCookie
+		}
+
+		if maxAge > 0 {
+			created := time.Unix(int64(binary.BigEndian.Uint64(plaintext)), 0)
+			if now.Sub(created) > maxAge {
+				return nil, errInvalidCookie
+			}
+		}
+
+		return plaintext[8:], nil
+	}
+
+	return nil, errInvalidCookie
+}
+
+// setCookie encodes value and sets it on the response
+func setCookie(w http.ResponseWriter, codec cookieCodec, opts CookieOptions, name string, value []byte) error {
+	encoded, err := codec.encode(name, value, opts.now())
+	if err != nil {
+		return err
+	}
+
+	cookie := opts.cookie(name, encoded)
+	if len(cookie.String()) > maxCookieSize {
+		return errors.Errorf("cookie %s is too large to be stored by browsers", name)
+	}
+
+	http.SetCookie(w, cookie)
+	return nil
+}
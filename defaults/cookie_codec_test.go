@@ -0,0 +1,150 @@
+package defaults
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testKey(t *testing.T) []byte {
+	t.Helper()
+
+	key, err := GenerateCookieKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestCookieCodec(t *testing.T) {
+	t.Parallel()
+
+	codec := newCookieCodec([][]byte{testKey(t)})
+	now := time.Now()
+
+	encoded, err := codec.encode("name", []byte("value"), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(encoded, "value") {
+		t.Error("value should be encrypted")
+	}
+
+	decoded, err := codec.decode("name", encoded, time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded, []byte("value")) {
+		t.Error("value wrong:", decoded)
+	}
+
+	if _, err := codec.decode("other", encoded, 0, now); err == nil {
+		t.Error("value should not be accepted for a different cookie")
+	}
+
+	raw, _ := base64.RawURLEncoding.DecodeString(encoded)
+	raw[len(raw)-1] ^= 1
+	if _, err := codec.decode("name", base64.RawURLEncoding.EncodeToString(raw), 0, now); err == nil {
+		t.Error("tampered value should not be accepted")
+	}
+
+	if _, err := codec.decode("name", "!!", 0, now); err == nil {
+		t.Error("garbage should not be accepted")
+	}
+	if _, err := codec.decode("name", "", 0, now); err == nil {
+		t.Error("empty value should not be accepted")
+	}
+}
+
+func TestCookieCodecMaxAge(t *testing.T) {
+	t.Parallel()
+
+	codec := newCookieCodec([][]byte{testKey(t)})
+	// Timestamps are stored in seconds
+	now := time.Now().Truncate(time.Second)
+
+	encoded, err := codec.encode("name", []byte("value"), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := codec.decode("name", encoded, time.Minute, now.Add(time.Minute)); err != nil {
+		t.Error(err)
+	}
+	if _, err := codec.decode("name", encoded, time.Minute, now.Add(time.Minute+time.Second)); err == nil {
+		t.Error("expired value should not be accepted")
+	}
+}
+
+func TestCookieCodecKeyRotation(t *testing.T) {
+	t.Parallel()
+
+	oldKey, newKey := testKey(t), testKey(t)
+
+	oldCodec := newCookieCodec([][]byte{oldKey})
+	rotated := newCookieCodec([][]byte{newKey, oldKey})
+	removed := newCookieCodec([][]byte{newKey})
+	now := time.Now()
+
+	encoded, err := oldCodec.encode("name", []byte("value"), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := rotated.decode("name", encoded, 0, now); err != nil {
+		t.Error("old key should still be accepted:", err)
+	}
+	if _, err := removed.decode("name", encoded, 0, now); err == nil {
+		t.Error("removed key should not be accepted")
+	}
+
+	encoded, err = rotated.encode("name", []byte("value"), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := removed.decode("name", encoded, 0, now); err != nil {
+		t.Error("new key should be used to encode:", err)
+	}
+}
+
+func TestCookieCodecBadKey(t *testing.T) {
+	t.Parallel()
+
+	defer func() {
+		if recover() == nil {
+			t.Error("it should panic")
+		}
+	}()
+
+	newCookieCodec([][]byte{[]byte("short")})
+}
+
+func TestSetCookieTooLarge(t *testing.T) {
+	t.Parallel()
+
+	codec := newCookieCodec([][]byte{testKey(t)})
+	w := httptest.NewRecorder()
+
+	err := setCookie(w, codec, DefaultCookieOptions(), "name", bytes.Repeat([]byte("a"), maxCookieSize))
+	if err == nil {
+		t.Error("it should fail")
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Error("no cookie should be set")
+	}
+
+	w = httptest.NewRecorder()
+	opts := CookieOptions{Path: "/", Domain: "example.com", MaxAge: time.Hour, Secure: true, HTTPOnly: true, SameSite: http.SameSiteStrictMode}
+	if err = setCookie(w, codec, opts, "name", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	cookie := w.Result().Cookies()[0]
+	if cookie.Domain != "example.com" || cookie.MaxAge != 3600 || !cookie.Secure || !cookie.HttpOnly || cookie.SameSite != http.SameSiteStrictMode {
+		t.Errorf("cookie attributes wrong: %#v", cookie)
+	}
+}
@@ -9,6 +9,7 @@ package defaults
 
 import (
 	"os"
+	"strings"
 
 	"github.com/volatiletech/authboss/v3"
 )
@@ -16,6 +17,14 @@ import (
 // SetCore creates instances of all the default pieces
 // with the exception of ViewRenderer which should be already set
 // before calling this method.
+//
+// If Storage.CookieState or Storage.SessionState are not already set they
+// are filled with a CookieStorer and SessionStorer using randomly
+// generated keys and a warning is logged, this means that sessions and
+// remember me cookies do not survive a restart and are not shared between
+// instances of the app. Random keys are only meant for tests and
+// development, set them with your own keys to avoid this. They read the
+// time from Config.Core.Clock so it should be set before calling this.
 func SetCore(config *authboss.Config, readJSON, useUsername bool) {
 	logger := NewLogger(os.Stdout)
 
@@ -26,4 +35,36 @@ func SetCore(config *authboss.Config, readJSON, useUsername bool) {
 	config.Core.BodyReader = NewHTTPBodyReader(readJSON, useUsername)
 	config.Core.Mailer = NewLogMailer(os.Stdout)
 	config.Core.Logger = logger
+
+	if config.Storage.CookieState != nil && config.Storage.SessionState != nil {
+		return
+	}
+
+	secure := strings.HasPrefix(config.Paths.RootURL, "https://")
+
+	// Each storer gets its own key, the cookie storer decodes every cookie
+	// in the request and would otherwise be able to read the session
+	if config.Storage.CookieState == nil {
+		cookieStorer := NewCookieStorer(mustGenerateCookieKey())
+		cookieStorer.Secure = secure
+		cookieStorer.Clock = config.Core.Clock
+		config.Storage.CookieState = cookieStorer
+		logger.Error("Storage.CookieState was not set, using a random key: remember me cookies will not survive a restart")
+	}
+	if config.Storage.SessionState == nil {
+		sessionStorer := NewSessionStorer(DefaultSessionCookieName, mustGenerateCookieKey())
+		sessionStorer.Secure = secure
+		sessionStorer.Clock = config.Core.Clock
+		config.Storage.SessionState = sessionStorer
+		logger.Error("Storage.SessionState was not set, using a random key: sessions will not survive a restart")
+	}
+}
+
+func mustGenerateCookieKey() []byte {
+	key, err := GenerateCookieKey()
+	if err != nil {
+		panic(err)
+	}
+
+	return key
 }
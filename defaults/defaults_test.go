@@ -36,4 +36,50 @@ func TestSetCore(t *testing.T) {
 	if config.Core.Logger == nil {
 		t.Error("logger should be set")
 	}
+	if config.Storage.CookieState == nil {
+		t.Error("cookie state should be set")
+	}
+	if config.Storage.SessionState == nil {
+		t.Error("session state should be set")
+	}
+}
+
+func TestSetCoreSeparateKeys(t *testing.T) {
+	t.Parallel()
+
+	config := &authboss.Config{}
+	SetCore(config, false, false)
+
+	events := []authboss.ClientStateEvent{{Kind: authboss.ClientStateEventPut, Key: authboss.SessionKey, Value: "pid"}}
+	r, _ := roundTrip(t, config.Storage.SessionState, nil, events)
+
+	state := readState(t, config.Storage.CookieState, r)
+	if _, ok := state.Get(DefaultSessionCookieName); ok {
+		t.Error("the cookie storer should not be able to read the session")
+	}
+	if pid, ok := readState(t, config.Storage.SessionState, r).Get(authboss.SessionKey); !ok || pid != "pid" {
+		t.Error("the session storer should read its own cookie:", pid)
+	}
+}
+
+func TestSetCoreKeepsClientState(t *testing.T) {
+	t.Parallel()
+
+	key, err := GenerateCookieKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &authboss.Config{}
+	sessionStorer := NewServerSessionStorer("", NewMemorySessionStore(), key)
+	config.Storage.SessionState = sessionStorer
+	config.Paths.RootURL = "https://example.com"
+	SetCore(config, false, false)
+
+	if config.Storage.SessionState != sessionStorer {
+		t.Error("session state should not be replaced")
+	}
+	if cookieStorer := config.Storage.CookieState.(*CookieStorer); !cookieStorer.Secure {
+		t.Error("cookies should be secure when served over https")
+	}
 }
@@ -19,7 +19,7 @@ func NewResponder(renderer authboss.Renderer) *Responder {
 
 // Respond to an HTTP request. It's main job is to merge data that comes in from
 // various middlewares via the context with the data sent by the controller and
-// render that.
+// render that. The csrf token is added to the data when there is one.
 func (r *Responder) Respond(w http.ResponseWriter, req *http.Request, code int, page string, data authboss.HTMLData) error {
 	ctxData := req.Context().Value(authboss.CTXKeyData)
 	if ctxData != nil {
@@ -29,6 +29,13 @@ func (r *Responder) Respond(w http.ResponseWriter, req *http.Request, code int,
 		data.Merge(ctxData.(authboss.HTMLData))
 	}
 
+	if token := authboss.GetCSRFToken(req); len(token) != 0 {
+		if data == nil {
+			data = authboss.HTMLData{}
+		}
+		data[authboss.DataCSRFToken] = token
+	}
+
 	rendered, mime, err := r.Renderer.Render(req.Context(), page, data)
 	if err != nil {
 		return err
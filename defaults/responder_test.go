@@ -81,6 +81,31 @@ func TestResponder(t *testing.T) {
 	}
 }
 
+func TestResponderCSRF(t *testing.T) {
+	t.Parallel()
+
+	responder := Responder{
+		Renderer: testRenderer{Callback: testJSONRender},
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyCSRFToken, "token"))
+	w := httptest.NewRecorder()
+
+	if err := responder.Respond(w, r, http.StatusOK, "some_template.tpl", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var gotData authboss.HTMLData
+	if err := json.Unmarshal(w.Body.Bytes(), &gotData); err != nil {
+		t.Fatal(err)
+	}
+
+	if token := gotData[authboss.DataCSRFToken]; token != "token" {
+		t.Error("csrf token wrong:", token)
+	}
+}
+
 func TestRedirector(t *testing.T) {
 	t.Parallel()
 
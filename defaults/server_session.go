@@ -0,0 +1,252 @@
+package defaults
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/friendsofgo/errors"
+	"github.com/volatiletech/authboss/v3"
+)
+
+// DefaultServerSessionExpiry is how long a ServerSessionStorer keeps
+// sessions when its cookies have no MaxAge.
+const DefaultServerSessionExpiry = 24 * time.Hour
+
+var (
+	// ErrSessionNotFound should be returned from SessionStore.Load when
+	// the session does not exist or has expired.
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// SessionStore keeps session values on the server for ServerSessionStorer
+type SessionStore interface {
+	// Load the values for the session id, returns ErrSessionNotFound if
+	// the session does not exist or has expired.
+	Load(ctx context.Context, id string) (map[string]string, error)
+	// Save the values for the session id, replacing any that are there,
+	// the session should be removed after expiry.
+	Save(ctx context.Context, id string, values map[string]string, expiry time.Time) error
+	// Delete the session id, it is not an error to delete a session that
+	// does not exist.
+	Delete(ctx context.Context, id string) error
+}
+
+// ServerSessionStorer is a ClientStateReadWriter for authboss's session
+// state (Config.Storage.SessionState) that keeps only a session id in a
+// signed and encrypted cookie, the values are kept in a SessionStore.
+//
+// A new session id is issued whenever a user logs in (authboss.SessionKey is
+// put) or the session is cleared to prevent session fixation.
+type ServerSessionStorer struct {
+	CookieOptions
+
+	// Name of the session cookie
+	Name string
+
+	Store SessionStore
+
+	codec cookieCodec
+}
+
+// serverSession is the ClientState of a ServerSessionStorer, the id is kept
+// so that the session can be saved again when it is written.
+type serverSession struct {
+	clientState
+
+	ctx context.Context
+	id  string
+}
+
+// NewServerSessionStorer creates a server side session storer that uses the
+// first of keys to encrypt session ids and all of them to decrypt. Keys must
+// be 16, 24 or 32 bytes long (see GenerateCookieKey). If name is empty
+// DefaultSessionCookieName is used.
+func NewServerSessionStorer(name string, store SessionStore, keys ...[]byte) *ServerSessionStorer {
+	if len(name) == 0 {
+		name = DefaultSessionCookieName
+	}
+
+	return &ServerSessionStorer{
+		CookieOptions: DefaultCookieOptions(),
+		Name:          name,
+		Store:         store,
+		codec:         newCookieCodec(keys),
+	}
+}
+
+// ReadState loads the session from the store, a missing or invalid cookie
+// or a session that is not found results in an empty session.
+func (s *ServerSessionStorer) ReadState(r *http.Request) (authboss.ClientState, error) {
+	session := &serverSession{clientState: make(clientState), ctx: r.Context()}
+
+	cookie, err := r.Cookie(s.Name)
+	if err != nil {
+		return session, nil
+	}
+
+	id, err := s.codec.decode(cookie.Name, cookie.Value, s.MaxAge, s.now())
+	if err != nil {
+		return session, nil
+	}
+
+	values, err := s.Store.Load(r.Context(), string(id))
+	if err == ErrSessionNotFound {
+		return session, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to load session")
+	}
+
+	session.id = string(id)
+	for k, v := range values {
+		session.clientState[k] = v
+	}
+
+	return session, nil
+}
+
+// WriteState applies the events to the session and saves it
+func (s *ServerSessionStorer) WriteState(w http.ResponseWriter, state authboss.ClientState, events []authboss.ClientStateEvent) error {
+	ctx := context.Background()
+	var current clientState
+	var id string
+	if session, ok := state.(*serverSession); ok {
+		ctx, current, id = session.ctx, session.clientState, session.id
+	}
+
+	values := applyEvents(current, events)
+
+	renew := len(id) == 0
+	for _, ev := range events {
+		if ev.Kind == authboss.ClientStateEventDelAll || (ev.Kind == authboss.ClientStateEventPut && ev.Key == authboss.SessionKey) {
+			renew = true
+		}
+	}
+
+	if len(id) != 0 && (renew || len(values) == 0) {
+		if err := s.Store.Delete(ctx, id); err != nil {
+			return errors.Wrap(err, "failed to delete session")
+		}
+	}
+
+	if len(values) == 0 {
+		http.SetCookie(w, s.expired(s.Name))
+		return nil
+	}
+
+	if renew {
+		var err error
+		if id, err = newSessionID(); err != nil {
+			return err
+		}
+	}
+
+	expiry := DefaultServerSessionExpiry
+	if s.MaxAge > 0 {
+		expiry = s.MaxAge
+	}
+
+	if err := s.Store.Save(ctx, id, values, s.now().Add(expiry)); err != nil {
+		return errors.Wrap(err, "failed to save session")
+	}
+
+	return setCookie(w, s.codec, s.CookieOptions, s.Name, []byte(id))
+}
+
+func newSessionID() (string, error) {
+	id := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return "", errors.Wrap(err, "failed to generate session id")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(id), nil
+}
+
+// MemorySessionStore is an in-memory SessionStore, sessions do not survive
+// a restart and are not shared between processes.
+type MemorySessionStore struct {
+	mut       sync.Mutex
+	sessions  map[string]memorySession
+	lastSweep time.Time
+
+	// Clock is used to check whether sessions have expired, it should be
+	// the same as Config.Core.Clock. When it's nil the system time is used.
+	Clock authboss.Clock
+}
+
+type memorySession struct {
+	values map[string]string
+	expiry time.Time
+}
+
+// NewMemorySessionStore constructor
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]memorySession)}
+}
+
+// Load a session
+func (m *MemorySessionStore) Load(_ context.Context, id string) (map[string]string, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	session, ok := m.sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	if m.now().After(session.expiry) {
+		delete(m.sessions, id)
+		return nil, ErrSessionNotFound
+	}
+
+	values := make(map[string]string, len(session.values))
+	for k, v := range session.values {
+		values[k] = v
+	}
+
+	return values, nil
+}
+
+// Save a session, expired sessions are periodically swept out here as well
+func (m *MemorySessionStore) Save(_ context.Context, id string, values map[string]string, expiry time.Time) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	now := m.now()
+	if now.Sub(m.lastSweep) > time.Minute {
+		m.lastSweep = now
+		for k, session := range m.sessions {
+			if now.After(session.expiry) {
+				delete(m.sessions, k)
+			}
+		}
+	}
+
+	copied := make(map[string]string, len(values))
+	for k, v := range values {
+		copied[k] = v
+	}
+
+	m.sessions[id] = memorySession{values: copied, expiry: expiry}
+	return nil
+}
+
+// Delete a session
+func (m *MemorySessionStore) Delete(_ context.Context, id string) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *MemorySessionStore) now() time.Time {
+	if m.Clock == nil {
+		return time.Now()
+	}
+
+	return m.Clock.Now()
+}
@@ -0,0 +1,171 @@
+package defaults
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+func TestServerSessionStorer(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemorySessionStore()
+	storer := NewServerSessionStorer("", store, testKey(t))
+
+	state := readState(t, storer, httptest.NewRequest("GET", "/", nil))
+	r, cookies := roundTrip(t, storer, state, []authboss.ClientStateEvent{
+		{Kind: authboss.ClientStateEventPut, Key: authboss.SessionOAuth2State, Value: "state"},
+	})
+	if len(store.sessions) != 1 {
+		t.Fatal("session should be stored:", store.sessions)
+	}
+	firstID := cookies[0].Value
+
+	state = readState(t, storer, r)
+	if val, _ := state.Get(authboss.SessionOAuth2State); val != "state" {
+		t.Error("value wrong:", val)
+	}
+
+	// Logging in issues a new session id
+	r, cookies = roundTrip(t, storer, state, []authboss.ClientStateEvent{
+		{Kind: authboss.ClientStateEventPut, Key: authboss.SessionKey, Value: "test@test.com"},
+	}, cookies...)
+	if cookies[0].Value == firstID {
+		t.Error("session id should be renewed on login")
+	}
+	if len(store.sessions) != 1 {
+		t.Error("old session should be deleted:", store.sessions)
+	}
+
+	state = readState(t, storer, r)
+	if val, _ := state.Get(authboss.SessionKey); val != "test@test.com" {
+		t.Error("value wrong:", val)
+	}
+	if val, _ := state.Get(authboss.SessionOAuth2State); val != "state" {
+		t.Error("value should be carried over:", val)
+	}
+
+	// Other writes keep the id
+	secondID := store.onlyID(t)
+	r, cookies = roundTrip(t, storer, state, []authboss.ClientStateEvent{
+		{Kind: authboss.ClientStateEventDel, Key: authboss.SessionOAuth2State},
+	}, cookies...)
+	if store.onlyID(t) != secondID {
+		t.Error("session id should not change")
+	}
+
+	// Logging out removes the session
+	state = readState(t, storer, r)
+	_, cookies = roundTrip(t, storer, state, []authboss.ClientStateEvent{
+		{Kind: authboss.ClientStateEventDelAll},
+	}, cookies...)
+	if len(cookies) != 0 {
+		t.Error("cookie should be deleted:", cookies)
+	}
+	if len(store.sessions) != 0 {
+		t.Error("session should be deleted:", store.sessions)
+	}
+
+	// Replaying the old cookie gives an empty session
+	replay := httptest.NewRequest("GET", "/", nil)
+	replay.AddCookie(&http.Cookie{Name: DefaultSessionCookieName, Value: firstID})
+	state = readState(t, storer, replay)
+	if _, ok := state.Get(authboss.SessionKey); ok {
+		t.Error("replayed session should be empty")
+	}
+}
+
+func TestServerSessionStorerClock(t *testing.T) {
+	t.Parallel()
+
+	clock := mocks.NewClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	store := NewMemorySessionStore()
+	store.Clock = clock
+	storer := NewServerSessionStorer("", store, testKey(t))
+	storer.Clock = clock
+
+	r, _ := roundTrip(t, storer, nil, []authboss.ClientStateEvent{
+		{Kind: authboss.ClientStateEventPut, Key: authboss.SessionKey, Value: "test@test.com"},
+	})
+	if expiry := store.sessions[store.onlyID(t)].expiry; !expiry.Equal(clock.Now().Add(DefaultServerSessionExpiry)) {
+		t.Error("expiry should be read from the clock:", expiry)
+	}
+
+	clock.Advance(DefaultServerSessionExpiry)
+	if _, ok := readState(t, storer, r).Get(authboss.SessionKey); !ok {
+		t.Error("session should be found until it expires")
+	}
+
+	clock.Advance(time.Second)
+	if _, ok := readState(t, storer, r).Get(authboss.SessionKey); ok {
+		t.Error("expired session should be empty")
+	}
+
+	// MaxAge is the lifetime of both the cookie and the session
+	storer.MaxAge = time.Hour
+	r, _ = roundTrip(t, storer, nil, []authboss.ClientStateEvent{
+		{Kind: authboss.ClientStateEventPut, Key: authboss.SessionKey, Value: "test@test.com"},
+	})
+	clock.Advance(time.Hour + time.Second)
+	if _, ok := readState(t, storer, r).Get(authboss.SessionKey); ok {
+		t.Error("cookie past max age should be ignored")
+	}
+}
+
+func (m *MemorySessionStore) onlyID(t *testing.T) string {
+	t.Helper()
+
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if len(m.sessions) != 1 {
+		t.Fatal("expected exactly one session:", m.sessions)
+	}
+	for id := range m.sessions {
+		return id
+	}
+	return ""
+}
+
+func TestMemorySessionStore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewMemorySessionStore()
+
+	values := map[string]string{"a": "b"}
+	if err := store.Save(ctx, "id", values, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	values["a"] = "changed"
+
+	got, err := store.Load(ctx, "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["a"] != "b" {
+		t.Error("stored values should be copied:", got)
+	}
+
+	if err = store.Save(ctx, "expired", values, time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Load(ctx, "expired"); err != ErrSessionNotFound {
+		t.Error("expired session should not be found:", err)
+	}
+
+	if err = store.Delete(ctx, "id"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Load(ctx, "id"); err != ErrSessionNotFound {
+		t.Error("deleted session should not be found:", err)
+	}
+	if err = store.Delete(ctx, "id"); err != nil {
+		t.Error("deleting a missing session should not fail:", err)
+	}
+}
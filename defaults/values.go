@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/url"
 	"regexp"
+	"strings"
 
 	"github.com/friendsofgo/errors"
 	"github.com/volatiletech/authboss/v3"
@@ -22,6 +23,16 @@ const (
 	FormValueCode         = "code"
 	FormValueRecoveryCode = "recovery_code"
 	FormValuePhoneNumber  = "phone_number"
+	FormValueCredential   = "credential"
+	FormValueSessionID    = "session_id"
+	FormValueRefreshToken = "refresh_token"
+	FormValueAPIKeyID     = "api_key_id"
+	FormValueName         = "name"
+	FormValueScopes       = "scopes"
+	FormValueProvider     = "provider"
+	FormValueUID          = "uid"
+
+	FormValueCurrentPassword = "current_password"
 )
 
 // UserValues from the login form
@@ -133,6 +144,157 @@ func (s SMSTwoFA) GetRecoveryCode() string { return s.RecoveryCode }
 // GetPhoneNumber from authenticator
 func (s SMSTwoFA) GetPhoneNumber() string { return s.PhoneNumber }
 
+// WebAuthnTwoFA for webauthn2fa pages
+type WebAuthnTwoFA struct {
+	HTTPFormValidator
+
+	PID          string
+	Credential   string
+	RecoveryCode string
+}
+
+// GetPID for passwordless webauthn login
+func (wa WebAuthnTwoFA) GetPID() string { return wa.PID }
+
+// GetCredential from the browser's webauthn api
+func (wa WebAuthnTwoFA) GetCredential() string { return wa.Credential }
+
+// GetRecoveryCode for webauthn
+func (wa WebAuthnTwoFA) GetRecoveryCode() string { return wa.RecoveryCode }
+
+// EmailChangeValues for the email_change page
+type EmailChangeValues struct {
+	HTTPFormValidator
+
+	CurrentPassword string
+	Email           string
+}
+
+// GetCurrentPassword to check against the user's password
+func (e EmailChangeValues) GetCurrentPassword() string { return e.CurrentPassword }
+
+// GetEmail to change to
+func (e EmailChangeValues) GetEmail() string { return e.Email }
+
+// DeleteAccountValues for the account_delete page
+type DeleteAccountValues struct {
+	HTTPFormValidator
+
+	CurrentPassword string
+	Code            string
+}
+
+// GetCurrentPassword to check against the user's password
+func (d DeleteAccountValues) GetCurrentPassword() string { return d.CurrentPassword }
+
+// GetCode to check against the user's two factor secret
+func (d DeleteAccountValues) GetCode() string { return d.Code }
+
+// MagicLinkStartValues for the magiclink page
+type MagicLinkStartValues struct {
+	HTTPFormValidator
+
+	PID string
+}
+
+// GetPID for magic link
+func (m MagicLinkStartValues) GetPID() string { return m.PID }
+
+// GetShouldRemember checks the form values for the remember checkbox
+func (m MagicLinkStartValues) GetShouldRemember() bool {
+	rm, ok := m.Values[authboss.CookieRemember]
+	return ok && rm == "true"
+}
+
+// MagicLinkValues for the magiclink_login page
+type MagicLinkValues struct {
+	HTTPFormValidator
+
+	Token string
+}
+
+// GetToken from the magic link
+func (m MagicLinkValues) GetToken() string { return m.Token }
+
+// GetShouldRemember checks the link for the remember value that was
+// carried over from the magiclink page
+func (m MagicLinkValues) GetShouldRemember() bool {
+	rm, ok := m.Values[authboss.CookieRemember]
+	return ok && rm == "true"
+}
+
+// PasswordChangeValues for the password_change page
+type PasswordChangeValues struct {
+	HTTPFormValidator
+
+	CurrentPassword string
+	NewPassword     string
+}
+
+// GetCurrentPassword to check against the user's password
+func (p PasswordChangeValues) GetCurrentPassword() string { return p.CurrentPassword }
+
+// GetPassword to change to
+func (p PasswordChangeValues) GetPassword() string { return p.NewPassword }
+
+// RefreshToken for the bearer_refresh and bearer_revoke pages
+type RefreshToken struct {
+	HTTPFormValidator
+
+	Token string
+}
+
+// GetRefreshToken sent by the client
+func (r RefreshToken) GetRefreshToken() string { return r.Token }
+
+// APIKeyValues for the apikeys_create page
+type APIKeyValues struct {
+	HTTPFormValidator
+
+	Name   string
+	Scopes []string
+}
+
+// GetName of the new api key
+func (a APIKeyValues) GetName() string { return a.Name }
+
+// GetScopes of the new api key
+func (a APIKeyValues) GetScopes() []string { return a.Scopes }
+
+// APIKey for the apikeys_revoke page
+type APIKey struct {
+	HTTPFormValidator
+
+	APIKeyID string
+}
+
+// GetAPIKeyID of the api key to revoke
+func (a APIKey) GetAPIKeyID() string { return a.APIKeyID }
+
+// OAuth2IdentityValues for the oauth2_unlink page
+type OAuth2IdentityValues struct {
+	HTTPFormValidator
+
+	Provider string
+	UID      string
+}
+
+// GetProvider of the identity to unlink
+func (o OAuth2IdentityValues) GetProvider() string { return o.Provider }
+
+// GetUID of the identity to unlink
+func (o OAuth2IdentityValues) GetUID() string { return o.UID }
+
+// Session for the sessions_revoke page
+type Session struct {
+	HTTPFormValidator
+
+	SessionID string
+}
+
+// GetSessionID of the session to revoke
+func (s Session) GetSessionID() string { return s.SessionID }
+
 // HTTPBodyReader reads forms from various pages and decodes
 // them.
 type HTTPBodyReader struct {
@@ -178,6 +340,12 @@ func NewHTTPBodyReader(readJSON, useUsernameNotEmail bool) *HTTPBodyReader {
 		}
 	}
 
+	emailRule := Rules{
+		FieldName: FormValueEmail, Required: true,
+		MatchError: "Must be a valid e-mail address",
+		MustMatch:  regexp.MustCompile(`.*@.*\.[a-z]+`),
+	}
+
 	passwordRule := Rules{
 		FieldName:  "password",
 		MinLength:  8,
@@ -192,16 +360,30 @@ func NewHTTPBodyReader(readJSON, useUsernameNotEmail bool) *HTTPBodyReader {
 		ReadJSON:    readJSON,
 		Rulesets: map[string][]Rules{
 			"login":         {pidRules},
+			"bearer_login":  {pidRules},
 			"register":      {pidRules, passwordRule},
 			"confirm":       {Rules{FieldName: FormValueConfirm, Required: true}},
 			"recover_start": {pidRules},
+			"unlock":        {pidRules},
+			"magiclink":     {pidRules},
 			"recover_end":   {passwordRule},
 
+			"password_change": {Rules{FieldName: FormValueCurrentPassword, Required: true}, passwordRule},
+			"email_change":    {emailRule},
+
+			"email_change_confirm": {Rules{FieldName: FormValueConfirm, Required: true}},
+
 			"twofactor_verify_end": {Rules{FieldName: FormValueToken, Required: true}},
+			"magiclink_login":      {Rules{FieldName: FormValueToken, Required: true}},
+			"unlock_confirm":       {Rules{FieldName: FormValueToken, Required: true}},
+			"bearer_refresh":       {Rules{FieldName: FormValueRefreshToken, Required: true}},
+			"apikeys_create":       {Rules{FieldName: FormValueName, Required: true}},
 		},
 		Confirms: map[string][]string{
 			"register":    {FormValuePassword, authboss.ConfirmPrefix + FormValuePassword},
 			"recover_end": {FormValuePassword, authboss.ConfirmPrefix + FormValuePassword},
+
+			"password_change": {FormValuePassword, authboss.ConfirmPrefix + FormValuePassword},
 		},
 		Whitelist: map[string][]string{
 			"register": {FormValueEmail, FormValuePassword},
@@ -240,7 +422,7 @@ func (h HTTPBodyReader) Read(page string, r *http.Request) (authboss.Validator,
 			HTTPFormValidator: HTTPFormValidator{Values: values, Ruleset: rules},
 			Token:             values[FormValueConfirm],
 		}, nil
-	case "login":
+	case "login", "bearer_login":
 		var pid string
 		if h.UseUsername {
 			pid = values[FormValueUsername]
@@ -253,7 +435,8 @@ func (h HTTPBodyReader) Read(page string, r *http.Request) (authboss.Validator,
 			PID:               pid,
 			Password:          values[FormValuePassword],
 		}, nil
-	case "recover_start":
+	case "recover_start", "unlock":
+		// Reuse RecoverStartValues for unlock, it's the same values we need
 		var pid string
 		if h.UseUsername {
 			pid = values[FormValueUsername]
@@ -295,6 +478,94 @@ func (h HTTPBodyReader) Read(page string, r *http.Request) (authboss.Validator,
 			PhoneNumber:       values[FormValuePhoneNumber],
 			RecoveryCode:      values[FormValueRecoveryCode],
 		}, nil
+	case "webauthn2fa_confirm", "webauthn2fa_remove", "webauthn2fa_validate", "webauthn2fa_login":
+		var pid string
+		if h.UseUsername {
+			pid = values[FormValueUsername]
+		} else {
+			pid = values[FormValueEmail]
+		}
+
+		return WebAuthnTwoFA{
+			HTTPFormValidator: HTTPFormValidator{Values: values, Ruleset: rules, ConfirmFields: confirms},
+			PID:               pid,
+			Credential:        values[FormValueCredential],
+			RecoveryCode:      values[FormValueRecoveryCode],
+		}, nil
+	case "apikeys_create":
+		return APIKeyValues{
+			HTTPFormValidator: HTTPFormValidator{Values: values, Ruleset: rules, ConfirmFields: confirms},
+			Name:              values[FormValueName],
+			Scopes:            strings.Fields(values[FormValueScopes]),
+		}, nil
+	case "apikeys_revoke":
+		return APIKey{
+			HTTPFormValidator: HTTPFormValidator{Values: values, Ruleset: rules, ConfirmFields: confirms},
+			APIKeyID:          values[FormValueAPIKeyID],
+		}, nil
+	case "account_delete":
+		return DeleteAccountValues{
+			HTTPFormValidator: HTTPFormValidator{Values: values, Ruleset: rules, ConfirmFields: confirms},
+			CurrentPassword:   values[FormValueCurrentPassword],
+			Code:              values[FormValueCode],
+		}, nil
+	case "email_change":
+		return EmailChangeValues{
+			HTTPFormValidator: HTTPFormValidator{Values: values, Ruleset: rules, ConfirmFields: confirms},
+			CurrentPassword:   values[FormValueCurrentPassword],
+			Email:             values[FormValueEmail],
+		}, nil
+	case "email_change_confirm":
+		// Reuse ConfirmValues here, it's the same values we need
+		return ConfirmValues{
+			HTTPFormValidator: HTTPFormValidator{Values: values, Ruleset: rules, ConfirmFields: confirms},
+			Token:             values[FormValueConfirm],
+		}, nil
+	case "magiclink":
+		var pid string
+		if h.UseUsername {
+			pid = values[FormValueUsername]
+		} else {
+			pid = values[FormValueEmail]
+		}
+
+		return MagicLinkStartValues{
+			HTTPFormValidator: HTTPFormValidator{Values: values, Ruleset: rules, ConfirmFields: confirms},
+			PID:               pid,
+		}, nil
+	case "magiclink_login":
+		return MagicLinkValues{
+			HTTPFormValidator: HTTPFormValidator{Values: values, Ruleset: rules, ConfirmFields: confirms},
+			Token:             values[FormValueToken],
+		}, nil
+	case "oauth2_unlink":
+		return OAuth2IdentityValues{
+			HTTPFormValidator: HTTPFormValidator{Values: values, Ruleset: rules, ConfirmFields: confirms},
+			Provider:          values[FormValueProvider],
+			UID:               values[FormValueUID],
+		}, nil
+	case "password_change":
+		return PasswordChangeValues{
+			HTTPFormValidator: HTTPFormValidator{Values: values, Ruleset: rules, ConfirmFields: confirms},
+			CurrentPassword:   values[FormValueCurrentPassword],
+			NewPassword:       values[FormValuePassword],
+		}, nil
+	case "bearer_refresh", "bearer_revoke":
+		return RefreshToken{
+			HTTPFormValidator: HTTPFormValidator{Values: values, Ruleset: rules, ConfirmFields: confirms},
+			Token:             values[FormValueRefreshToken],
+		}, nil
+	case "sessions_revoke":
+		return Session{
+			HTTPFormValidator: HTTPFormValidator{Values: values, Ruleset: rules, ConfirmFields: confirms},
+			SessionID:         values[FormValueSessionID],
+		}, nil
+	case "unlock_confirm":
+		// Reuse ConfirmValues here, it's the same values we need
+		return ConfirmValues{
+			HTTPFormValidator: HTTPFormValidator{Values: values, Ruleset: rules, ConfirmFields: confirms},
+			Token:             values[FormValueToken],
+		}, nil
 	case "register":
 		arbitrary := make(map[string]string)
 
@@ -146,3 +146,30 @@ func TestHTTPBodyReaderRegister(t *testing.T) {
 		t.Error("address was wrong:", address)
 	}
 }
+
+func TestHTTPBodyReaderMagicLink(t *testing.T) {
+	t.Parallel()
+
+	h := NewHTTPBodyReader(false, false)
+
+	validator, err := h.Read("magiclink", mocks.Request("POST", FormValueEmail, "a@a.com"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	mv := validator.(authboss.MagicLinkStartValuer)
+	if pid := mv.GetPID(); pid != "a@a.com" {
+		t.Error("pid was wrong:", pid)
+	}
+	if errs := validator.Validate(); errs != nil {
+		t.Error("should be valid:", errs)
+	}
+
+	validator, err = h.Read("magiclink", mocks.Request("POST", FormValueEmail, ""))
+	if err != nil {
+		t.Error(err)
+	}
+	if errs := validator.Validate(); len(errs) == 0 {
+		t.Error("the pid should be required")
+	}
+}
@@ -0,0 +1,220 @@
+// Package deleteaccount allows logged in users to delete their own account
+package deleteaccount
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/otp/twofactor/totp2fa"
+)
+
+// Pages
+const (
+	PageDeleteAccount = "account_delete"
+)
+
+// FormValueCode is the form field that holds the totp code when a user
+// with totp two factor authentication deletes their account.
+const FormValueCode = "code"
+
+func init() {
+	authboss.RegisterModule("deleteaccount", &Delete{})
+}
+
+// SMSUser is implemented by users of the sms2fa module, when the user has
+// a phone number they must have recently logged in with their second factor
+// to delete their account.
+type SMSUser interface {
+	GetSMSPhoneNumber() string
+}
+
+// WebAuthnUser is implemented by users of the webauthn2fa module, when the
+// user has credentials they must have recently logged in with their second
+// factor to delete their account.
+type WebAuthnUser interface {
+	GetWebAuthnCredentials() string
+}
+
+// Delete module
+type Delete struct {
+	*authboss.Authboss
+}
+
+// Init module
+func (d *Delete) Init(ab *authboss.Authboss) error {
+	d.Authboss = ab
+
+	if err := d.Core.ViewRenderer.Load(PageDeleteAccount); err != nil {
+		return err
+	}
+
+	var unauthedResponse authboss.MWRespondOnFailure
+	if d.Config.Modules.ResponseOnUnauthed != 0 {
+		unauthedResponse = d.Config.Modules.ResponseOnUnauthed
+	} else if d.Config.Modules.RoutesRedirectOnUnauthed {
+		unauthedResponse = authboss.RespondRedirect
+	}
+	middleware := authboss.MountedMiddleware2(d.Authboss, true, authboss.RequireFullAuth, unauthedResponse)
+
+	d.Core.Router.Get("/account/delete", middleware(d.Core.ErrorHandler.Wrap(d.Get)))
+	d.Core.Router.Post("/account/delete", middleware(d.Core.ErrorHandler.Wrap(d.Post)))
+
+	// Users that can't be re-prompted for a password or totp code must
+	// have logged in within Config.Modules.DeleteRecentLogin
+	d.Events.After(authboss.EventAuth, d.RecordLastLogin)
+	d.Events.After(authboss.EventOAuth2, d.RecordLastLogin)
+
+	return nil
+}
+
+// Get the account deletion page
+func (d *Delete) Get(w http.ResponseWriter, r *http.Request) error {
+	return d.Core.Responder.Respond(w, r, http.StatusOK, PageDeleteAccount, nil)
+}
+
+// Post deletes the user's account after checking their password and their
+// totp code if they have them, and then logs them out. Users with sms or
+// webauthn two factor authentication, or with nothing to check at all, must
+// have logged in recently instead.
+func (d *Delete) Post(w http.ResponseWriter, r *http.Request) error {
+	logger := d.RequestLogger(r)
+
+	validatable, err := d.Core.BodyReader.Read(PageDeleteAccount, r)
+	if err != nil {
+		return err
+	}
+
+	user, err := d.CurrentUser(r)
+	if err != nil {
+		return err
+	}
+
+	if errs := validatable.Validate(); errs != nil {
+		logger.Infof("user %s account deletion validation failed", user.GetPID())
+		data := authboss.HTMLData{authboss.DataValidation: authboss.ErrorMap(errs)}
+		return d.Core.Responder.Respond(w, r, http.StatusOK, PageDeleteAccount, data)
+	}
+
+	values := authboss.MustHaveDeleteAccountValues(validatable)
+
+	prompted := false
+	if authUser, ok := user.(authboss.AuthableUser); ok && len(authUser.GetPassword()) != 0 {
+		prompted = true
+		if err = d.VerifyPassword(authUser, values.GetCurrentPassword()); err != nil {
+			if handled, err := d.authFail(w, r, user); err != nil || handled {
+				return err
+			}
+
+			logger.Infof("user %s failed to delete their account, current password was wrong", user.GetPID())
+			data := authboss.HTMLData{authboss.DataErr: d.Localizef(r.Context(), authboss.TxtInvalidCurrentPassword)}
+			return d.Core.Responder.Respond(w, r, http.StatusOK, PageDeleteAccount, data)
+		}
+	}
+
+	// A user with a totp secret must give a code, it's checked the same way
+	// as totp2fa so that a code that was used to log in can't be replayed
+	if totpUser, ok := user.(totp2fa.User); ok && len(totpUser.GetTOTPSecretKey()) != 0 {
+		prompted = true
+		failure := totp2fa.CheckCode(totpUser, values.GetCode(), d.Now())
+		if _, ok := user.(totp2fa.UserOneTime); ok {
+			if err = d.Config.Storage.Server.Save(r.Context(), user); err != nil {
+				return err
+			}
+		}
+
+		if failure != nil {
+			if handled, err := d.authFail(w, r, user); err != nil || handled {
+				return err
+			}
+
+			logger.Infof("user %s failed to delete their account, totp code was wrong", user.GetPID())
+			data := authboss.HTMLData{
+				authboss.DataValidation: map[string][]string{FormValueCode: {
+					d.Localizef(r.Context(), *failure),
+				}},
+			}
+			return d.Core.Responder.Respond(w, r, http.StatusOK, PageDeleteAccount, data)
+		}
+	}
+
+	// Second factors that can't be checked here (sms codes have to be sent
+	// and webauthn needs a ceremony) are covered by a recent login, as is a
+	// user that has no credential that could be checked at all.
+	needs2FA := needsRecent2FA(user)
+	if (needs2FA || !prompted) && !d.recentLogin(r, needs2FA) {
+		logger.Infof("user %s failed to delete their account, they have not logged in recently", user.GetPID())
+		data := authboss.HTMLData{authboss.DataErr: d.Localizef(r.Context(), authboss.TxtDeleteLoginRequired)}
+		return d.Core.Responder.Respond(w, r, http.StatusOK, PageDeleteAccount, data)
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	handled, err := d.Events.FireBefore(authboss.EventAccountDelete, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	pid := user.GetPID()
+	if rmStorer, ok := d.Config.Storage.Server.(authboss.RememberingServerStorer); ok {
+		if err = rmStorer.DelRememberTokens(r.Context(), pid); err != nil {
+			return err
+		}
+	}
+
+	storer := authboss.EnsureCanDelete(d.Config.Storage.Server)
+	if err = storer.Delete(r.Context(), pid); err != nil {
+		return err
+	}
+
+	authboss.DelAllSession(w, d.Config.Storage.SessionStateWhitelistKeys)
+	authboss.DelKnownSession(w)
+	authboss.DelKnownCookie(w)
+
+	logger.Infof("user %s deleted their account", pid)
+
+	handled, err = d.Events.FireAfter(authboss.EventAccountDelete, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: d.Config.Paths.DeleteAccountOK,
+		Success:      d.Localizef(r.Context(), authboss.TxtAccountDeleted),
+	}
+	return d.Core.Redirector.Redirect(w, r, ro)
+}
+
+// authFail fires EventAuthFail for a wrong password or totp code so that
+// lock and ratelimit count it the same as a failed login.
+func (d *Delete) authFail(w http.ResponseWriter, r *http.Request, user authboss.User) (bool, error) {
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	return d.Events.FireAfter(authboss.EventAuthFail, w, r)
+}
+
+// recentLogin checks that the session's last full login was within
+// Config.Modules.DeleteRecentLogin, and that it passed a second factor
+// when twoFactored is true.
+func (d *Delete) recentLogin(r *http.Request, twoFactored bool) bool {
+	if twoFactored && !authboss.IsTwoFactored(r) {
+		return false
+	}
+
+	return d.LoggedInWithin(r, d.Config.Modules.DeleteRecentLogin)
+}
+
+// needsRecent2FA is true when the user has a second factor that the delete
+// page can't prompt for.
+func needsRecent2FA(user authboss.User) bool {
+	if smsUser, ok := user.(SMSUser); ok && len(smsUser.GetSMSPhoneNumber()) != 0 {
+		return true
+	}
+	if waUser, ok := user.(WebAuthnUser); ok && len(waUser.GetWebAuthnCredentials()) != 0 {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,467 @@
+package deleteaccount
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+
+	router := &mocks.Router{}
+	renderer := &mocks.Renderer{}
+	errHandler := &mocks.ErrorHandler{}
+	ab.Config.Core.Router = router
+	ab.Config.Core.ViewRenderer = renderer
+	ab.Config.Core.ErrorHandler = errHandler
+
+	d := &Delete{}
+	if err := d.Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := renderer.HasLoadedViews(PageDeleteAccount); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasGets("/account/delete"); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasPosts("/account/delete"); err != nil {
+		t.Error(err)
+	}
+}
+
+type testHarness struct {
+	delete *Delete
+	ab     *authboss.Authboss
+
+	bodyReader *mocks.BodyReader
+	responder  *mocks.Responder
+	redirector *mocks.Redirector
+	session    *mocks.ClientStateRW
+	cookies    *mocks.ClientStateRW
+	storer     *mocks.ServerStorer
+	clock      *mocks.Clock
+}
+
+func testSetup() *testHarness {
+	harness := &testHarness{}
+
+	harness.ab = authboss.New()
+	harness.bodyReader = &mocks.BodyReader{}
+	harness.responder = &mocks.Responder{}
+	harness.redirector = &mocks.Redirector{}
+	harness.session = mocks.NewClientRW()
+	harness.cookies = mocks.NewClientRW()
+	harness.storer = mocks.NewServerStorer()
+	harness.clock = mocks.NewClock(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+
+	harness.ab.Paths.DeleteAccountOK = "/deleted"
+
+	harness.ab.Config.Core.BodyReader = harness.bodyReader
+	harness.ab.Config.Core.Logger = mocks.Logger{}
+	harness.ab.Config.Core.Hasher = mocks.Hasher{}
+	harness.ab.Config.Core.Responder = harness.responder
+	harness.ab.Config.Core.Redirector = harness.redirector
+	harness.ab.Config.Storage.SessionState = harness.session
+	harness.ab.Config.Storage.CookieState = harness.cookies
+	harness.ab.Config.Storage.Server = harness.storer
+	harness.ab.Config.Core.Clock = harness.clock
+
+	harness.delete = &Delete{harness.ab}
+
+	return harness
+}
+
+func (h *testHarness) putUser(t *testing.T, password string) *mocks.User {
+	t.Helper()
+
+	user := &mocks.User{Email: "test@test.com"}
+	if len(password) != 0 {
+		hash, err := h.ab.Config.Core.Hasher.GenerateHash(password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		user.Password = hash
+	}
+
+	h.storer.Users["test@test.com"] = user
+	h.storer.RMTokens["test@test.com"] = []string{"token"}
+	h.session.ClientValues[authboss.SessionKey] = "test@test.com"
+	h.cookies.ClientValues[authboss.CookieRemember] = "token"
+
+	return user
+}
+
+func (h *testHarness) loadClientState(w http.ResponseWriter, r **http.Request) {
+	req, err := h.ab.LoadClientState(w, *r)
+	if err != nil {
+		panic(err)
+	}
+
+	*r = req
+}
+
+func TestGet(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	r := mocks.Request("GET")
+	w := httptest.NewRecorder()
+
+	if err := h.delete.Get(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.responder.Page != PageDeleteAccount {
+		t.Error("page wrong:", h.responder.Page)
+	}
+}
+
+func TestPostSuccess(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.putUser(t, "password")
+
+	var before, after bool
+	h.ab.Events.Before(authboss.EventAccountDelete, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		before = r.Context().Value(authboss.CTXKeyUser) != nil
+		return false, nil
+	})
+	h.ab.Events.After(authboss.EventAccountDelete, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		after = true
+		return false, nil
+	})
+
+	h.bodyReader.Return = mocks.Values{CurrentPassword: "password"}
+
+	r := mocks.Request("POST")
+	resp := httptest.NewRecorder()
+	w := h.ab.NewResponse(resp)
+	h.loadClientState(w, &r)
+
+	if err := h.delete.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if resp.Code != http.StatusTemporaryRedirect {
+		t.Error("code wrong:", resp.Code)
+	}
+	opts := h.redirector.Options
+	if opts.RedirectPath != "/deleted" {
+		t.Error("redirect path wrong:", opts.RedirectPath)
+	}
+	if len(opts.Success) == 0 {
+		t.Error("should have a success message")
+	}
+
+	if !before || !after {
+		t.Error("events should have fired with the user:", before, after)
+	}
+	if _, ok := h.storer.Users["test@test.com"]; ok {
+		t.Error("user should be deleted")
+	}
+	if _, ok := h.storer.RMTokens["test@test.com"]; ok {
+		t.Error("remember tokens should be deleted")
+	}
+	if _, ok := h.session.ClientValues[authboss.SessionKey]; ok {
+		t.Error("session should be cleared")
+	}
+	if _, ok := h.cookies.ClientValues[authboss.CookieRemember]; ok {
+		t.Error("remember cookie should be deleted")
+	}
+}
+
+func TestPostNoPassword(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.putUser(t, "")
+
+	h.bodyReader.Return = mocks.Values{}
+
+	// Without a recent login there's nothing to prove it's the user
+	r := mocks.Request("POST")
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	h.loadClientState(w, &r)
+
+	if err := h.delete.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.responder.Data[authboss.DataErr] != authboss.TxtDeleteLoginRequired.Default {
+		t.Error("error wrong:", h.responder.Data[authboss.DataErr])
+	}
+	if _, ok := h.storer.Users["test@test.com"]; !ok {
+		t.Error("user should not be deleted")
+	}
+
+	// A login that's too old is refused as well
+	h.session.ClientValues[authboss.SessionLastLogin] = strconv.FormatInt(h.clock.Now().Unix(), 10)
+	h.clock.Advance(h.ab.Config.Modules.DeleteRecentLogin + time.Second)
+
+	r = mocks.Request("POST")
+	w = h.ab.NewResponse(httptest.NewRecorder())
+	h.loadClientState(w, &r)
+
+	if err := h.delete.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := h.storer.Users["test@test.com"]; !ok {
+		t.Error("user should not be deleted")
+	}
+
+	h.session.ClientValues[authboss.SessionLastLogin] = strconv.FormatInt(h.clock.Now().Unix(), 10)
+
+	r = mocks.Request("POST")
+	w = h.ab.NewResponse(httptest.NewRecorder())
+	h.loadClientState(w, &r)
+
+	if err := h.delete.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := h.storer.Users["test@test.com"]; ok {
+		t.Error("user should be deleted")
+	}
+}
+
+func TestPostSMSAndWebAuthn(t *testing.T) {
+	t.Parallel()
+
+	setups := map[string]func(u *mocks.User){
+		"sms":      func(u *mocks.User) { u.SMSPhoneNumber = "555-555-5555" },
+		"webauthn": func(u *mocks.User) { u.WebAuthnCredentials = "creds" },
+	}
+
+	for name, setup := range setups {
+		name, setup := name, setup
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := testSetup()
+			setup(h.putUser(t, "password"))
+
+			h.bodyReader.Return = mocks.Values{CurrentPassword: "password"}
+			h.session.ClientValues[authboss.SessionLastLogin] = strconv.FormatInt(h.clock.Now().Unix(), 10)
+
+			// A recent login is not enough unless it passed the second factor
+			r := mocks.Request("POST")
+			w := h.ab.NewResponse(httptest.NewRecorder())
+			h.loadClientState(w, &r)
+
+			if err := h.delete.Post(w, r); err != nil {
+				t.Fatal(err)
+			}
+
+			if h.responder.Data[authboss.DataErr] != authboss.TxtDeleteLoginRequired.Default {
+				t.Error("error wrong:", h.responder.Data[authboss.DataErr])
+			}
+			if _, ok := h.storer.Users["test@test.com"]; !ok {
+				t.Error("user should not be deleted")
+			}
+
+			h.session.ClientValues[authboss.Session2FA] = name
+
+			r = mocks.Request("POST")
+			w = h.ab.NewResponse(httptest.NewRecorder())
+			h.loadClientState(w, &r)
+
+			if err := h.delete.Post(w, r); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, ok := h.storer.Users["test@test.com"]; ok {
+				t.Error("user should be deleted")
+			}
+		})
+	}
+}
+
+func TestPostWrongPassword(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.putUser(t, "password")
+
+	var authFail bool
+	h.ab.Events.After(authboss.EventAuthFail, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		authFail = r.Context().Value(authboss.CTXKeyUser) != nil
+		return false, nil
+	})
+
+	h.bodyReader.Return = mocks.Values{CurrentPassword: "wrong"}
+
+	r := mocks.Request("POST")
+	resp := httptest.NewRecorder()
+	w := h.ab.NewResponse(resp)
+	h.loadClientState(w, &r)
+
+	if err := h.delete.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Code != http.StatusOK {
+		t.Error("code wrong:", resp.Code)
+	}
+	if h.responder.Page != PageDeleteAccount {
+		t.Error("page wrong:", h.responder.Page)
+	}
+	if h.responder.Data[authboss.DataErr] != authboss.TxtInvalidCurrentPassword.Default {
+		t.Error("error wrong:", h.responder.Data[authboss.DataErr])
+	}
+	if !authFail {
+		t.Error("EventAuthFail should fire with the user so lock and ratelimit count it")
+	}
+	if _, ok := h.storer.Users["test@test.com"]; !ok {
+		t.Error("user should not be deleted")
+	}
+}
+
+func TestPostTOTP(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	user := h.putUser(t, "password")
+
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: "authboss", AccountName: user.Email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.TOTPSecretKey = key.Secret()
+
+	authFails := 0
+	h.ab.Events.After(authboss.EventAuthFail, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		authFails++
+		return false, nil
+	})
+
+	h.bodyReader.Return = mocks.Values{CurrentPassword: "password", Code: "000000"}
+
+	r := mocks.Request("POST")
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	h.loadClientState(w, &r)
+
+	if err := h.delete.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	errList := h.responder.Data[authboss.DataValidation].(map[string][]string)
+	if e := errList[FormValueCode][0]; e != authboss.TxtInvalid2FACode.Default {
+		t.Error("validation error wrong:", e)
+	}
+	if _, ok := h.storer.Users["test@test.com"]; !ok {
+		t.Error("user should not be deleted")
+	}
+
+	// Codes are checked against the configured clock
+	code, err := totp.GenerateCode(user.TOTPSecretKey, h.clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.bodyReader.Return = mocks.Values{CurrentPassword: "password", Code: code}
+
+	// A code that was already used (to log in) can't be replayed
+	user.TOTPLastCode = code
+
+	r = mocks.Request("POST")
+	w = h.ab.NewResponse(httptest.NewRecorder())
+	h.loadClientState(w, &r)
+
+	if err := h.delete.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	errList = h.responder.Data[authboss.DataValidation].(map[string][]string)
+	if e := errList[FormValueCode][0]; e != authboss.TxtRepeated2FACode.Default {
+		t.Error("validation error wrong:", e)
+	}
+	if authFails != 2 {
+		t.Error("each wrong code should fire EventAuthFail:", authFails)
+	}
+	if _, ok := h.storer.Users["test@test.com"]; !ok {
+		t.Error("user should not be deleted")
+	}
+
+	user.TOTPLastCode = ""
+
+	r = mocks.Request("POST")
+	w = h.ab.NewResponse(httptest.NewRecorder())
+	h.loadClientState(w, &r)
+
+	if err := h.delete.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := h.storer.Users["test@test.com"]; ok {
+		t.Error("user should be deleted")
+	}
+}
+
+func TestPostValidationFailure(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.putUser(t, "password")
+
+	h.bodyReader.Return = mocks.Values{
+		Errors: []error{errors.New("password required")},
+	}
+
+	r := mocks.Request("POST")
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	h.loadClientState(w, &r)
+
+	if err := h.delete.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.responder.Page != PageDeleteAccount {
+		t.Error("page wrong:", h.responder.Page)
+	}
+	if _, ok := h.storer.Users["test@test.com"]; !ok {
+		t.Error("user should not be deleted")
+	}
+}
+
+func TestPostHandled(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.putUser(t, "password")
+
+	h.ab.Events.Before(authboss.EventAccountDelete, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		return true, nil
+	})
+
+	h.bodyReader.Return = mocks.Values{CurrentPassword: "password"}
+
+	r := mocks.Request("POST")
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	h.loadClientState(w, &r)
+
+	if err := h.delete.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := h.storer.Users["test@test.com"]; !ok {
+		t.Error("user should not be deleted")
+	}
+	if len(h.redirector.Options.RedirectPath) != 0 {
+		t.Error("it should not redirect")
+	}
+}
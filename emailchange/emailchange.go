@@ -0,0 +1,398 @@
+// Package emailchange allows logged in users to change their e-mail address
+// once they have confirmed that they own the new one.
+package emailchange
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/friendsofgo/errors"
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/confirm"
+)
+
+// Constants for templates etc.
+const (
+	// PageEmailChange is the page to request an e-mail change
+	PageEmailChange = "email_change"
+	// PageEmailChangeConfirm is only really used for the BodyReader
+	PageEmailChangeConfirm = "email_change_confirm"
+
+	// EmailChangeHTML is the name of the html template for e-mails sent
+	// to the new address
+	EmailChangeHTML = "email_change_html"
+	// EmailChangeTxt is the name of the text template for e-mails sent
+	// to the new address
+	EmailChangeTxt = "email_change_txt"
+	// EmailChangeNoticeHTML is the name of the html template for e-mails
+	// sent to the old address
+	EmailChangeNoticeHTML = "email_change_notice_html"
+	// EmailChangeNoticeTxt is the name of the text template for e-mails
+	// sent to the old address
+	EmailChangeNoticeTxt = "email_change_notice_txt"
+
+	// DataEmailChangeURL is the name of the e-mail template variable
+	// that gives the url to send to the user for confirmation.
+	DataEmailChangeURL = "url"
+	// DataEmailChangeNewEmail is the name of the e-mail template variable
+	// that holds the new address in the notice sent to the old one.
+	DataEmailChangeNewEmail = "new_email"
+)
+
+func init() {
+	authboss.RegisterModule("emailchange", &EmailChange{})
+}
+
+// EmailChange module
+type EmailChange struct {
+	*authboss.Authboss
+}
+
+// Init module
+func (e *EmailChange) Init(ab *authboss.Authboss) error {
+	e.Authboss = ab
+
+	if err := e.Config.Core.ViewRenderer.Load(PageEmailChange); err != nil {
+		return err
+	}
+
+	err := e.Config.Core.MailRenderer.Load(EmailChangeHTML, EmailChangeTxt, EmailChangeNoticeHTML, EmailChangeNoticeTxt)
+	if err != nil {
+		return err
+	}
+
+	var unauthedResponse authboss.MWRespondOnFailure
+	if e.Config.Modules.ResponseOnUnauthed != 0 {
+		unauthedResponse = e.Config.Modules.ResponseOnUnauthed
+	} else if e.Config.Modules.RoutesRedirectOnUnauthed {
+		unauthedResponse = authboss.RespondRedirect
+	}
+	middleware := authboss.MountedMiddleware2(e.Authboss, true, authboss.RequireFullAuth, unauthedResponse)
+
+	e.Config.Core.Router.Get("/email/change", middleware(e.Core.ErrorHandler.Wrap(e.Get)))
+	e.Config.Core.Router.Post("/email/change", middleware(e.Core.ErrorHandler.Wrap(e.Post)))
+
+	var callbackMethod func(string, http.Handler)
+	switch e.Config.Modules.MailRouteMethod {
+	case http.MethodGet:
+		callbackMethod = e.Config.Core.Router.Get
+	case http.MethodPost:
+		callbackMethod = e.Config.Core.Router.Post
+	default:
+		panic("invalid config for MailRouteMethod")
+	}
+	callbackMethod("/email/change/confirm", e.Core.ErrorHandler.Wrap(e.Confirm))
+
+	// Users without a password must have logged in within
+	// Config.Modules.EmailChangeRecentLogin
+	e.Events.After(authboss.EventAuth, e.RecordLastLogin)
+	e.Events.After(authboss.EventOAuth2, e.RecordLastLogin)
+
+	return nil
+}
+
+// Get the e-mail change page
+func (e *EmailChange) Get(w http.ResponseWriter, r *http.Request) error {
+	return e.Config.Core.Responder.Respond(w, r, http.StatusOK, PageEmailChange, nil)
+}
+
+// Post stores the new e-mail address as pending and sends a confirmation
+// link to it, along with a notice to the old address. The user must give
+// their current password, or have logged in recently if they have none,
+// so that a hijacked session can't move the account to another address.
+func (e *EmailChange) Post(w http.ResponseWriter, r *http.Request) error {
+	logger := e.RequestLogger(r)
+
+	validatable, err := e.Core.BodyReader.Read(PageEmailChange, r)
+	if err != nil {
+		return err
+	}
+
+	if errs := validatable.Validate(); errs != nil {
+		logger.Info("email change validation failed")
+		data := authboss.HTMLData{authboss.DataValidation: authboss.ErrorMap(errs)}
+		return e.Core.Responder.Respond(w, r, http.StatusOK, PageEmailChange, data)
+	}
+
+	abUser, err := e.CurrentUser(r)
+	if err != nil {
+		return err
+	}
+	user := authboss.MustBeEmailChangeable(abUser)
+
+	if !e.canChangePID() && user.GetPID() == user.GetEmail() {
+		logger.Infof("user %s can't change their e-mail since it's their pid", user.GetPID())
+		data := authboss.HTMLData{authboss.DataErr: e.Localizef(r.Context(), authboss.TxtEmailChangeUnavailable)}
+		return e.Core.Responder.Respond(w, r, http.StatusOK, PageEmailChange, data)
+	}
+
+	values := authboss.MustHaveEmailChangeValues(validatable)
+
+	if authUser, ok := abUser.(authboss.AuthableUser); ok && len(authUser.GetPassword()) != 0 {
+		if err = e.VerifyPassword(authUser, values.GetCurrentPassword()); err != nil {
+			r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, abUser))
+			handled, err := e.Events.FireAfter(authboss.EventAuthFail, w, r)
+			if err != nil {
+				return err
+			} else if handled {
+				return nil
+			}
+
+			logger.Infof("user %s failed to change their e-mail, current password was wrong", user.GetPID())
+			data := authboss.HTMLData{authboss.DataErr: e.Localizef(r.Context(), authboss.TxtInvalidCurrentPassword)}
+			return e.Core.Responder.Respond(w, r, http.StatusOK, PageEmailChange, data)
+		}
+	} else if !e.LoggedInWithin(r, e.Config.Modules.EmailChangeRecentLogin) {
+		logger.Infof("user %s failed to change their e-mail, they have not logged in recently", user.GetPID())
+		data := authboss.HTMLData{authboss.DataErr: e.Localizef(r.Context(), authboss.TxtEmailChangeLoginRequired)}
+		return e.Core.Responder.Respond(w, r, http.StatusOK, PageEmailChange, data)
+	}
+
+	newEmail := values.GetEmail()
+
+	if inUse, err := e.emailInUse(r.Context(), user, newEmail); err != nil {
+		return err
+	} else if inUse {
+		logger.Infof("user %s tried to change their e-mail to one that is in use", user.GetPID())
+		data := authboss.HTMLData{authboss.DataErr: e.Localizef(r.Context(), authboss.TxtEmailInUse)}
+		return e.Core.Responder.Respond(w, r, http.StatusOK, PageEmailChange, data)
+	}
+
+	selector, verifier, token, err := e.Config.Core.OneTimeTokenGenerator.GenerateToken()
+	if err != nil {
+		return err
+	}
+
+	user.PutPendingEmail(newEmail)
+	user.PutEmailChangeSelector(selector)
+	user.PutEmailChangeVerifier(verifier)
+	user.PutEmailChangeExpiry(e.Now().Add(e.Config.Modules.EmailChangeTokenDuration))
+
+	if err := e.Storage.Server.Save(r.Context(), user); err != nil {
+		return err
+	}
+
+	oldEmail := user.GetEmail()
+	if e.Modules.MailNoGoroutine {
+		e.SendEmailChangeEmails(r.Context(), oldEmail, newEmail, token)
+	} else {
+		go e.SendEmailChangeEmails(r.Context(), oldEmail, newEmail, token)
+	}
+
+	logger.Infof("user %s e-mail change initiated", user.GetPID())
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: e.Config.Paths.EmailChangeOK,
+		Success:      e.Localizef(r.Context(), authboss.TxtEmailChangeStarted),
+	}
+	return e.Core.Redirector.Redirect(w, r, ro)
+}
+
+// SendEmailChangeEmails sends the confirmation link to the new address and a
+// notice that a change was requested to the old address.
+func (e *EmailChange) SendEmailChangeEmails(ctx context.Context, oldEmail, newEmail, token string) {
+	logger := e.Logger(ctx)
+
+	email := authboss.Email{
+		To:       []string{newEmail},
+		From:     e.Config.Mail.From,
+		FromName: e.Config.Mail.FromName,
+		Subject:  e.Config.Mail.SubjectPrefix + e.Localizef(ctx, authboss.TxtEmailChangeSubject),
+	}
+
+	ro := authboss.EmailResponseOptions{
+		Data:         authboss.NewHTMLData(DataEmailChangeURL, e.mailURL(token)),
+		HTMLTemplate: EmailChangeHTML,
+		TextTemplate: EmailChangeTxt,
+	}
+
+	logger.Infof("sending email change e-mail to: %s", newEmail)
+	if err := e.Email(ctx, email, ro); err != nil {
+		logger.Errorf("failed to send email change e-mail to %s: %+v", newEmail, err)
+	}
+
+	if len(oldEmail) == 0 {
+		return
+	}
+
+	email = authboss.Email{
+		To:       []string{oldEmail},
+		From:     e.Config.Mail.From,
+		FromName: e.Config.Mail.FromName,
+		Subject:  e.Config.Mail.SubjectPrefix + e.Localizef(ctx, authboss.TxtEmailChangeNoticeSubject),
+	}
+
+	ro = authboss.EmailResponseOptions{
+		Data:         authboss.NewHTMLData(DataEmailChangeNewEmail, newEmail),
+		HTMLTemplate: EmailChangeNoticeHTML,
+		TextTemplate: EmailChangeNoticeTxt,
+	}
+
+	logger.Infof("sending email change notice e-mail to: %s", oldEmail)
+	if err := e.Email(ctx, email, ro); err != nil {
+		logger.Errorf("failed to send email change notice e-mail to %s: %+v", oldEmail, err)
+	}
+}
+
+// Confirm swaps the user's e-mail for the pending one when given a valid
+// token from the link sent to the new address.
+func (e *EmailChange) Confirm(w http.ResponseWriter, r *http.Request) error {
+	logger := e.RequestLogger(r)
+
+	validator, err := e.Config.Core.BodyReader.Read(PageEmailChangeConfirm, r)
+	if err != nil {
+		return err
+	}
+
+	if errs := validator.Validate(); errs != nil {
+		logger.Infof("validation failed in EmailChange.Confirm, this typically means a bad token: %+v", errs)
+		return e.invalidToken(w, r)
+	}
+
+	values := authboss.MustHaveConfirmValues(validator)
+
+	selector, verifier, err := confirm.ParseToken(e.Config.Core.OneTimeTokenGenerator, values.GetToken())
+	if err != nil {
+		logger.Infof("invalid email change token submitted: %+v", err)
+		return e.invalidToken(w, r)
+	}
+
+	storer := authboss.EnsureCanChangeEmail(e.Config.Storage.Server)
+	user, err := storer.LoadByEmailChangeSelector(r.Context(), selector)
+	if err == authboss.ErrUserNotFound {
+		logger.Infof("email change selector was not found in database: %s", selector)
+		return e.invalidToken(w, r)
+	} else if err != nil {
+		return err
+	}
+
+	if !confirm.VerifierMatches(user.GetEmailChangeVerifier(), verifier) {
+		logger.Info("stored email change verifier does not match provided one")
+		return e.invalidToken(w, r)
+	}
+
+	if e.Now().After(user.GetEmailChangeExpiry()) {
+		logger.Infof("email change token for user %s has expired", user.GetPID())
+		return e.invalidToken(w, r)
+	}
+
+	if !e.canChangePID() && user.GetPID() == user.GetEmail() {
+		logger.Infof("user %s can't change their e-mail since it's their pid", user.GetPID())
+		ro := authboss.RedirectOptions{
+			Code:         http.StatusTemporaryRedirect,
+			RedirectPath: e.Config.Paths.EmailChangeOK,
+			Failure:      e.Localizef(r.Context(), authboss.TxtEmailChangeUnavailable),
+		}
+		return e.Core.Redirector.Redirect(w, r, ro)
+	}
+
+	newEmail := user.GetPendingEmail()
+	if inUse, err := e.emailInUse(r.Context(), user, newEmail); err != nil {
+		return err
+	} else if inUse {
+		logger.Infof("user %s tried to confirm an e-mail that has since been taken", user.GetPID())
+		ro := authboss.RedirectOptions{
+			Code:         http.StatusTemporaryRedirect,
+			RedirectPath: e.Config.Paths.EmailChangeOK,
+			Failure:      e.Localizef(r.Context(), authboss.TxtEmailInUse),
+		}
+		return e.Core.Redirector.Redirect(w, r, ro)
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	handled, err := e.Events.FireBefore(authboss.EventEmailChange, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	oldPID := user.GetPID()
+
+	user.PutEmail(newEmail)
+	user.PutPendingEmail("")
+	user.PutEmailChangeSelector("")
+	user.PutEmailChangeVerifier("")
+	user.PutEmailChangeExpiry(e.Now())
+	// Following the link proves ownership of the address
+	user.PutConfirmed(true)
+
+	// When the e-mail is the pid the user and everything keyed by their pid
+	// must be moved, the storer saves the user while doing that
+	if newPID := user.GetPID(); newPID != oldPID {
+		storer, ok := e.Config.Storage.Server.(authboss.PIDChangingServerStorer)
+		if !ok {
+			return errors.New("changing the e-mail changed the pid but the storer is not a PIDChangingServerStorer")
+		}
+		if err = storer.ChangePID(r.Context(), oldPID, user); err != nil {
+			return err
+		}
+
+		if pid, _ := authboss.GetSession(r, authboss.SessionKey); pid == oldPID {
+			authboss.PutSession(w, authboss.SessionKey, newPID)
+		}
+		// The remember token was for the old pid and has been deleted
+		authboss.DelCookie(w, authboss.CookieRemember)
+	} else if err = e.Config.Storage.Server.Save(r.Context(), user); err != nil {
+		return err
+	}
+
+	logger.Infof("user %s changed their e-mail address", user.GetPID())
+
+	handled, err = e.Events.FireAfter(authboss.EventEmailChange, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: e.Config.Paths.EmailChangeOK,
+		Success:      e.Localizef(r.Context(), authboss.TxtEmailChanged),
+	}
+	return e.Core.Redirector.Redirect(w, r, ro)
+}
+
+// canChangePID checks if the storer can move a user to a new pid, without
+// it users whose e-mail is their pid can't change it.
+func (e *EmailChange) canChangePID() bool {
+	_, ok := e.Config.Storage.Server.(authboss.PIDChangingServerStorer)
+	return ok
+}
+
+// emailInUse checks if the e-mail is the pid of a different user, if the pid
+// is not an e-mail address uniqueness must be enforced by the storer on Save.
+func (e *EmailChange) emailInUse(ctx context.Context, user authboss.User, email string) (bool, error) {
+	other, err := e.Config.Storage.Server.Load(ctx, email)
+	if err == authboss.ErrUserNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return other.GetPID() != user.GetPID(), nil
+}
+
+func (e *EmailChange) mailURL(token string) string {
+	query := url.Values{confirm.FormValueConfirm: []string{token}}
+
+	if len(e.Config.Mail.RootURL) != 0 {
+		return fmt.Sprintf("%s?%s", e.Config.Mail.RootURL+"/email/change/confirm", query.Encode())
+	}
+
+	p := path.Join(e.Config.Paths.Mount, "email/change/confirm")
+	return fmt.Sprintf("%s%s?%s", e.Config.Paths.RootURL, p, query.Encode())
+}
+
+func (e *EmailChange) invalidToken(w http.ResponseWriter, r *http.Request) error {
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		Failure:      e.Localizef(r.Context(), authboss.TxtInvalidEmailChangeToken),
+		RedirectPath: e.Config.Paths.EmailChangeOK,
+	}
+	return e.Core.Redirector.Redirect(w, r, ro)
+}
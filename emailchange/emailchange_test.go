@@ -0,0 +1,562 @@
+package emailchange
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+
+	router := &mocks.Router{}
+	renderer := &mocks.Renderer{}
+	mailRenderer := &mocks.Renderer{}
+	errHandler := &mocks.ErrorHandler{}
+	ab.Config.Core.Router = router
+	ab.Config.Core.ViewRenderer = renderer
+	ab.Config.Core.MailRenderer = mailRenderer
+	ab.Config.Core.ErrorHandler = errHandler
+
+	e := &EmailChange{}
+	if err := e.Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := renderer.HasLoadedViews(PageEmailChange); err != nil {
+		t.Error(err)
+	}
+	if err := mailRenderer.HasLoadedViews(EmailChangeHTML, EmailChangeTxt, EmailChangeNoticeHTML, EmailChangeNoticeTxt); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasGets("/email/change", "/email/change/confirm"); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasPosts("/email/change"); err != nil {
+		t.Error(err)
+	}
+}
+
+type testHarness struct {
+	emailchange *EmailChange
+	ab          *authboss.Authboss
+
+	bodyReader *mocks.BodyReader
+	mailer     *mocks.Emailer
+	redirector *mocks.Redirector
+	renderer   *mocks.Renderer
+	responder  *mocks.Responder
+	session    *mocks.ClientStateRW
+	storer     *mocks.ServerStorer
+}
+
+func testSetup() *testHarness {
+	harness := &testHarness{}
+
+	harness.ab = authboss.New()
+	harness.bodyReader = &mocks.BodyReader{}
+	harness.mailer = &mocks.Emailer{}
+	harness.redirector = &mocks.Redirector{}
+	harness.renderer = &mocks.Renderer{}
+	harness.responder = &mocks.Responder{}
+	harness.session = mocks.NewClientRW()
+	harness.storer = mocks.NewServerStorer()
+
+	harness.ab.Paths.EmailChangeOK = "/email/ok"
+	harness.ab.Modules.MailNoGoroutine = true
+
+	harness.ab.Config.Core.BodyReader = harness.bodyReader
+	harness.ab.Config.Core.Hasher = mocks.Hasher{}
+	harness.ab.Config.Core.Logger = mocks.Logger{}
+	harness.ab.Config.Core.Mailer = harness.mailer
+	harness.ab.Config.Core.Redirector = harness.redirector
+	harness.ab.Config.Core.MailRenderer = harness.renderer
+	harness.ab.Config.Core.Responder = harness.responder
+	harness.ab.Config.Storage.SessionState = harness.session
+	harness.ab.Config.Storage.CookieState = mocks.NewClientRW()
+	harness.ab.Config.Storage.Server = harness.storer
+
+	harness.emailchange = &EmailChange{harness.ab}
+
+	return harness
+}
+
+func (h *testHarness) putUser(t *testing.T, password string) *mocks.User {
+	t.Helper()
+
+	user := &mocks.User{Email: "test@test.com"}
+	if len(password) != 0 {
+		hash, err := h.ab.Config.Core.Hasher.GenerateHash(password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		user.Password = hash
+	}
+
+	h.storer.Users["test@test.com"] = user
+	h.session.ClientValues[authboss.SessionKey] = "test@test.com"
+
+	return user
+}
+
+func (h *testHarness) loadClientState(w http.ResponseWriter, r **http.Request) {
+	req, err := h.ab.LoadClientState(w, *r)
+	if err != nil {
+		panic(err)
+	}
+
+	*r = req
+}
+
+func TestGet(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	r := mocks.Request("GET")
+	w := httptest.NewRecorder()
+
+	if err := h.emailchange.Get(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.responder.Page != PageEmailChange {
+		t.Error("page wrong:", h.responder.Page)
+	}
+}
+
+func TestPostSuccess(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	user := h.putUser(t, "hello world")
+
+	h.bodyReader.Return = mocks.Values{CurrentPassword: "hello world", Email: "new@test.com"}
+
+	r := mocks.Request("POST")
+	resp := httptest.NewRecorder()
+	w := h.ab.NewResponse(resp)
+	h.loadClientState(w, &r)
+
+	if err := h.emailchange.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Code != http.StatusTemporaryRedirect {
+		t.Error("code wrong:", resp.Code)
+	}
+	opts := h.redirector.Options
+	if opts.RedirectPath != "/email/ok" {
+		t.Error("redirect path wrong:", opts.RedirectPath)
+	}
+	if len(opts.Success) == 0 {
+		t.Error("should have a success message")
+	}
+
+	if user.Email != "test@test.com" {
+		t.Error("e-mail should not be changed yet:", user.Email)
+	}
+	if user.PendingEmail != "new@test.com" {
+		t.Error("pending e-mail wrong:", user.PendingEmail)
+	}
+	if len(user.EmailChangeSelector) == 0 || len(user.EmailChangeVerifier) == 0 {
+		t.Error("token should be stored")
+	}
+	if !user.EmailChangeExpiry.After(time.Now()) {
+		t.Error("expiry should be in the future:", user.EmailChangeExpiry)
+	}
+
+	// The notice to the old address is sent last
+	if to := h.mailer.Email.To[0]; to != "test@test.com" {
+		t.Error("notice sent to wrong address:", to)
+	}
+}
+
+func TestPostInUse(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	user := h.putUser(t, "hello world")
+	h.storer.Users["taken@test.com"] = &mocks.User{Email: "taken@test.com"}
+
+	h.bodyReader.Return = mocks.Values{CurrentPassword: "hello world", Email: "taken@test.com"}
+
+	r := mocks.Request("POST")
+	resp := httptest.NewRecorder()
+	w := h.ab.NewResponse(resp)
+	h.loadClientState(w, &r)
+
+	if err := h.emailchange.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Code != http.StatusOK {
+		t.Error("code wrong:", resp.Code)
+	}
+	if h.responder.Page != PageEmailChange {
+		t.Error("page wrong:", h.responder.Page)
+	}
+	if h.responder.Data[authboss.DataErr] != authboss.TxtEmailInUse.Default {
+		t.Error("error wrong:", h.responder.Data[authboss.DataErr])
+	}
+	if len(user.PendingEmail) != 0 {
+		t.Error("pending e-mail should not be set")
+	}
+}
+
+func TestPostWrongPassword(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	user := h.putUser(t, "hello world")
+
+	var authFail bool
+	h.ab.Events.After(authboss.EventAuthFail, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		authFail = r.Context().Value(authboss.CTXKeyUser) != nil
+		return false, nil
+	})
+
+	for _, password := range []string{"", "wrong"} {
+		authFail = false
+		h.bodyReader.Return = mocks.Values{CurrentPassword: password, Email: "new@test.com"}
+
+		r := mocks.Request("POST")
+		w := h.ab.NewResponse(httptest.NewRecorder())
+		h.loadClientState(w, &r)
+
+		if err := h.emailchange.Post(w, r); err != nil {
+			t.Fatal(err)
+		}
+
+		if h.responder.Data[authboss.DataErr] != authboss.TxtInvalidCurrentPassword.Default {
+			t.Errorf("%q) error wrong: %v", password, h.responder.Data[authboss.DataErr])
+		}
+		if !authFail {
+			t.Errorf("%q) EventAuthFail should fire with the user", password)
+		}
+		if len(user.PendingEmail) != 0 {
+			t.Errorf("%q) pending e-mail should not be set", password)
+		}
+	}
+}
+
+func TestPostNoPassword(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	clock := mocks.NewClock(time.Now().UTC())
+	h.ab.Config.Core.Clock = clock
+	user := h.putUser(t, "")
+
+	h.bodyReader.Return = mocks.Values{Email: "new@test.com"}
+
+	post := func() {
+		t.Helper()
+
+		r := mocks.Request("POST")
+		w := h.ab.NewResponse(httptest.NewRecorder())
+		h.loadClientState(w, &r)
+
+		if err := h.emailchange.Post(w, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Without a recent login there's nothing to prove it's the user
+	post()
+	if h.responder.Data[authboss.DataErr] != authboss.TxtEmailChangeLoginRequired.Default {
+		t.Error("error wrong:", h.responder.Data[authboss.DataErr])
+	}
+
+	h.session.ClientValues[authboss.SessionLastLogin] = strconv.FormatInt(clock.Now().Unix(), 10)
+	clock.Advance(h.ab.Config.Modules.EmailChangeRecentLogin + time.Second)
+	post()
+	if len(user.PendingEmail) != 0 {
+		t.Error("a login that's too old should be refused")
+	}
+
+	h.session.ClientValues[authboss.SessionLastLogin] = strconv.FormatInt(clock.Now().Unix(), 10)
+	post()
+	if user.PendingEmail != "new@test.com" {
+		t.Error("a recent login should be allowed:", user.PendingEmail)
+	}
+}
+
+func TestPostValidationFailure(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	h.bodyReader.Return = mocks.Values{
+		Errors: []error{errors.New("invalid e-mail")},
+	}
+
+	r := mocks.Request("POST")
+	w := httptest.NewRecorder()
+
+	if err := h.emailchange.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.responder.Page != PageEmailChange {
+		t.Error("page wrong:", h.responder.Page)
+	}
+	errList := h.responder.Data[authboss.DataValidation].(map[string][]string)
+	if e := errList[""][0]; e != "invalid e-mail" {
+		t.Error("validation error wrong:", e)
+	}
+}
+
+func TestSendEmailChangeEmails(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Config.Mail.RootURL = "https://example.com/auth"
+
+	r := mocks.Request("POST")
+	h.emailchange.SendEmailChangeEmails(r.Context(), "", "new@test.com", "token")
+
+	if to := h.mailer.Email.To[0]; to != "new@test.com" {
+		t.Error("e-mail sent to wrong address:", to)
+	}
+	if len(h.mailer.Email.Subject) == 0 {
+		t.Error("subject should be set")
+	}
+
+	u := h.emailchange.mailURL("token")
+	if u != "https://example.com/auth/email/change/confirm?cnf=token" {
+		t.Error("url wrong:", u)
+	}
+}
+
+func (h *testHarness) putPendingUser(t *testing.T, expiry time.Time) (*mocks.User, string) {
+	t.Helper()
+
+	selector, verifier, token, err := h.ab.Config.Core.OneTimeTokenGenerator.GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &mocks.User{
+		Email:               "test@test.com",
+		PendingEmail:        "new@test.com",
+		EmailChangeSelector: selector,
+		EmailChangeVerifier: verifier,
+		EmailChangeExpiry:   expiry,
+	}
+	h.storer.Users["test@test.com"] = user
+	h.session.ClientValues[authboss.SessionKey] = "test@test.com"
+
+	return user, token
+}
+
+func TestConfirmSuccess(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	user, token := h.putPendingUser(t, time.Now().Add(time.Hour))
+	h.storer.RMTokens["test@test.com"] = []string{"token"}
+	h.storer.Sessions["session"] = authboss.SessionRecord{ID: "session", PID: "test@test.com"}
+
+	var before, after bool
+	h.ab.Events.Before(authboss.EventEmailChange, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		before = r.Context().Value(authboss.CTXKeyUser) != nil
+		return false, nil
+	})
+	h.ab.Events.After(authboss.EventEmailChange, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		after = true
+		return false, nil
+	})
+
+	h.bodyReader.Return = mocks.Values{Token: token}
+
+	r := mocks.Request("GET")
+	resp := httptest.NewRecorder()
+	w := h.ab.NewResponse(resp)
+	h.loadClientState(w, &r)
+
+	if err := h.emailchange.Confirm(w, r); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if resp.Code != http.StatusTemporaryRedirect {
+		t.Error("code wrong:", resp.Code)
+	}
+	opts := h.redirector.Options
+	if opts.RedirectPath != "/email/ok" {
+		t.Error("redirect path wrong:", opts.RedirectPath)
+	}
+	if len(opts.Success) == 0 {
+		t.Error("should have a success message")
+	}
+
+	if !before || !after {
+		t.Error("events should have fired with the user:", before, after)
+	}
+	if user.Email != "new@test.com" {
+		t.Error("e-mail should be changed:", user.Email)
+	}
+	if len(user.PendingEmail) != 0 || len(user.EmailChangeSelector) != 0 || len(user.EmailChangeVerifier) != 0 {
+		t.Error("pending change should be cleared")
+	}
+	if !user.Confirmed {
+		t.Error("user should be confirmed")
+	}
+	if pid := h.session.ClientValues[authboss.SessionKey]; pid != "new@test.com" {
+		t.Error("session should follow the new pid:", pid)
+	}
+
+	// The e-mail is the pid of the mock user so it must have been moved
+	if _, ok := h.storer.Users["test@test.com"]; ok {
+		t.Error("user should not be stored under the old pid")
+	}
+	if h.storer.Users["new@test.com"] != user {
+		t.Error("user should be stored under the new pid")
+	}
+	if len(h.storer.RMTokens["new@test.com"]) != 0 || len(h.storer.RMTokens["test@test.com"]) != 0 {
+		t.Error("remember tokens should be deleted:", h.storer.RMTokens)
+	}
+	if pid := h.storer.Sessions["session"].PID; pid != "new@test.com" {
+		t.Error("session record should have moved:", pid)
+	}
+}
+
+// pidKeepingStorer hides ChangePID from the mock storer
+type pidKeepingStorer struct {
+	authboss.EmailChangingServerStorer
+}
+
+func TestPostEmailIsPID(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Config.Storage.Server = pidKeepingStorer{h.storer}
+
+	user := &mocks.User{Email: "test@test.com"}
+	h.storer.Users["test@test.com"] = user
+	h.session.ClientValues[authboss.SessionKey] = "test@test.com"
+
+	h.bodyReader.Return = mocks.Values{Email: "new@test.com"}
+
+	r := mocks.Request("POST")
+	resp := httptest.NewRecorder()
+	w := h.ab.NewResponse(resp)
+	h.loadClientState(w, &r)
+
+	if err := h.emailchange.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.responder.Data[authboss.DataErr] != authboss.TxtEmailChangeUnavailable.Default {
+		t.Error("error wrong:", h.responder.Data[authboss.DataErr])
+	}
+	if len(user.PendingEmail) != 0 {
+		t.Error("pending e-mail should not be set")
+	}
+}
+
+func TestConfirmEmailIsPID(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Config.Storage.Server = pidKeepingStorer{h.storer}
+	_, token := h.putPendingUser(t, time.Now().Add(time.Hour))
+
+	h.bodyReader.Return = mocks.Values{Token: token}
+
+	r := mocks.Request("GET")
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	h.loadClientState(w, &r)
+
+	if err := h.emailchange.Confirm(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if f := h.redirector.Options.Failure; f != authboss.TxtEmailChangeUnavailable.Default {
+		t.Error("failure wrong:", f)
+	}
+	if stored := h.storer.Users["test@test.com"]; stored == nil || len(stored.PendingEmail) == 0 {
+		t.Error("the pending change should not have been saved")
+	}
+	if _, ok := h.storer.Users["new@test.com"]; ok {
+		t.Error("user should not be moved")
+	}
+	if pid := h.session.ClientValues[authboss.SessionKey]; pid != "test@test.com" {
+		t.Error("session should not change:", pid)
+	}
+}
+
+func TestConfirmExpired(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	user, token := h.putPendingUser(t, time.Now().Add(-time.Hour))
+
+	h.bodyReader.Return = mocks.Values{Token: token}
+
+	r := mocks.Request("GET")
+	w := httptest.NewRecorder()
+
+	if err := h.emailchange.Confirm(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := h.redirector.Options
+	if opts.Failure != authboss.TxtInvalidEmailChangeToken.Default {
+		t.Error("failure wrong:", opts.Failure)
+	}
+	if user.Email != "test@test.com" {
+		t.Error("e-mail should not be changed:", user.Email)
+	}
+}
+
+func TestConfirmInvalidToken(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	user, token := h.putPendingUser(t, time.Now().Add(time.Hour))
+
+	// Keep the selector half of the token but change the verifier
+	tampered := []byte(token)
+	i := len(tampered) - 5
+	if tampered[i] == 'a' {
+		tampered[i] = 'b'
+	} else {
+		tampered[i] = 'a'
+	}
+
+	tests := []string{"5", strings.Repeat("a", len(token)), string(tampered)}
+	for _, tok := range tests {
+		h.bodyReader.Return = mocks.Values{Token: tok}
+		h.redirector.Options = authboss.RedirectOptions{}
+
+		r := mocks.Request("GET")
+		w := httptest.NewRecorder()
+
+		if err := h.emailchange.Confirm(w, r); err != nil {
+			t.Fatal(err)
+		}
+
+		if w.Code != http.StatusTemporaryRedirect {
+			t.Error("code wrong:", w.Code)
+		}
+		if f := h.redirector.Options.Failure; f != authboss.TxtInvalidEmailChangeToken.Default {
+			t.Errorf("%q failure wrong: %s", tok, f)
+		}
+	}
+
+	if user.Email != "test@test.com" {
+		t.Error("e-mail should not be changed:", user.Email)
+	}
+}
@@ -32,6 +32,30 @@ const (
 	EventLogout
 	EventTwoFactorAdded
 	EventTwoFactorRemoved
+	// EventRememberAuth is fired after a user has been logged in using
+	// a remember me token, the pid is in the context under CTXKeyPID.
+	EventRememberAuth
+	// EventPasswordChange is fired when a logged in user changes their
+	// password, the user is in the context under CTXKeyUser.
+	EventPasswordChange
+	// EventEmailChange is fired when a user follows the link to confirm
+	// their new e-mail address, the user is in the context under CTXKeyUser.
+	EventEmailChange
+	// EventAccountDelete is fired when a user deletes their account, the
+	// user is in the context under CTXKeyUser.
+	EventAccountDelete
+	// EventUnlock is fired when a locked user follows the link they were
+	// e-mailed to unlock their account, the user is in the context under
+	// CTXKeyUser.
+	EventUnlock
+	// EventBearerAuth is fired after a user has logged in with the bearer
+	// module's token route, it's used instead of EventAuth because the
+	// user is not put in the session. The user is in the context under
+	// CTXKeyUser.
+	EventBearerAuth
+	// EventMagicLinkStart is fired when a user asks for a magic link to be
+	// e-mailed to them, the user is in the context under CTXKeyUser.
+	EventMagicLinkStart
 )
 
 // EventHandler reacts to events that are fired by Authboss controllers.
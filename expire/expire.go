@@ -9,15 +9,13 @@ import (
 	"github.com/volatiletech/authboss/v3"
 )
 
-var nowTime = time.Now
-
 // Setup the expire module
 //
 // This installs a hook into the login process so that the
 // LastAction is recorded immediately.
 func Setup(ab *authboss.Authboss) error {
 	ab.Events.After(authboss.EventAuth, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
-		refreshExpiry(w)
+		refreshExpiry(w, ab.Now())
 		return false, nil
 	})
 
@@ -25,12 +23,13 @@ func Setup(ab *authboss.Authboss) error {
 }
 
 // TimeToExpiry returns zero if the user session is expired else the time
-// until expiry. Takes in the allowed idle duration.
+// until expiry. Takes in the allowed idle duration. This uses the system
+// time, the Middleware uses Config.Core.Clock.
 func TimeToExpiry(r *http.Request, expireAfter time.Duration) time.Duration {
-	return timeToExpiry(r, expireAfter)
+	return timeToExpiry(r, expireAfter, time.Now().UTC())
 }
 
-func timeToExpiry(r *http.Request, expireAfter time.Duration) time.Duration {
+func timeToExpiry(r *http.Request, expireAfter time.Duration, now time.Time) time.Duration {
 	dateStr, ok := authboss.GetSession(r, authboss.SessionLastAction)
 	if !ok {
 		return expireAfter
@@ -41,7 +40,7 @@ func timeToExpiry(r *http.Request, expireAfter time.Duration) time.Duration {
 		panic("last_action is not a valid RFC3339 date")
 	}
 
-	remaining := date.Add(expireAfter).Sub(nowTime().UTC())
+	remaining := date.Add(expireAfter).Sub(now)
 	if remaining > 0 {
 		return remaining
 	}
@@ -50,16 +49,18 @@ func timeToExpiry(r *http.Request, expireAfter time.Duration) time.Duration {
 }
 
 // RefreshExpiry updates the last action for the user, so he doesn't
-// become expired.
+// become expired. This uses the system time, the Middleware uses
+// Config.Core.Clock.
 func RefreshExpiry(w http.ResponseWriter, r *http.Request) {
-	refreshExpiry(w)
+	refreshExpiry(w, time.Now().UTC())
 }
 
-func refreshExpiry(w http.ResponseWriter) {
-	authboss.PutSession(w, authboss.SessionLastAction, nowTime().UTC().Format(time.RFC3339))
+func refreshExpiry(w http.ResponseWriter, now time.Time) {
+	authboss.PutSession(w, authboss.SessionLastAction, now.Format(time.RFC3339))
 }
 
 type expireMiddleware struct {
+	now              func() time.Time
 	expireAfter      time.Duration
 	next             http.Handler
 	sessionWhitelist []string
@@ -73,6 +74,7 @@ type expireMiddleware struct {
 func Middleware(ab *authboss.Authboss) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return expireMiddleware{
+			now:              ab.Now,
 			expireAfter:      ab.Config.Modules.ExpireAfter,
 			next:             next,
 			sessionWhitelist: ab.Config.Storage.SessionStateWhitelistKeys,
@@ -84,7 +86,8 @@ func Middleware(ab *authboss.Authboss) func(http.Handler) http.Handler {
 // below it.
 func (m expireMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if _, ok := authboss.GetSession(r, authboss.SessionKey); ok {
-		ttl := timeToExpiry(r, m.expireAfter)
+		now := m.now()
+		ttl := timeToExpiry(r, m.expireAfter, now)
 
 		if ttl == 0 {
 			authboss.DelAllSession(w, m.sessionWhitelist)
@@ -108,7 +111,7 @@ func (m expireMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 			r = r.WithContext(ctx)
 		} else {
-			refreshExpiry(w)
+			refreshExpiry(w, now)
 		}
 	}
 
@@ -37,11 +37,15 @@ func TestExpireSetup(t *testing.T) {
 }
 
 func TestExpireIsExpired(t *testing.T) {
+	t.Parallel()
+
 	ab := authboss.New()
+	clock := mocks.NewClock(time.Now().UTC())
+	ab.Config.Core.Clock = clock
 
 	clientRW := mocks.NewClientRW()
 	clientRW.ClientValues[authboss.SessionKey] = "username"
-	clientRW.ClientValues[authboss.SessionLastAction] = time.Now().UTC().Format(time.RFC3339)
+	clientRW.ClientValues[authboss.SessionLastAction] = clock.Now().Format(time.RFC3339)
 	ab.Storage.SessionState = clientRW
 
 	r := httptest.NewRequest("GET", "/", nil)
@@ -53,13 +57,7 @@ func TestExpireIsExpired(t *testing.T) {
 		t.Error(err)
 	}
 
-	// No t.Parallel() - Also must be after refreshExpiry() call
-	nowTime = func() time.Time {
-		return time.Now().UTC().Add(time.Hour * 2)
-	}
-	defer func() {
-		nowTime = time.Now
-	}()
+	clock.Advance(time.Hour * 2)
 
 	called := false
 	hadUser := false
@@ -93,10 +91,15 @@ func TestExpireIsExpired(t *testing.T) {
 }
 
 func TestExpireNotExpired(t *testing.T) {
+	t.Parallel()
+
 	ab := authboss.New()
+	clock := mocks.NewClock(time.Now().UTC())
+	ab.Config.Core.Clock = clock
+
 	clientRW := mocks.NewClientRW()
 	clientRW.ClientValues[authboss.SessionKey] = "username"
-	clientRW.ClientValues[authboss.SessionLastAction] = time.Now().UTC().Format(time.RFC3339)
+	clientRW.ClientValues[authboss.SessionLastAction] = clock.Now().Format(time.RFC3339)
 	ab.Storage.SessionState = clientRW
 
 	var err error
@@ -110,14 +113,8 @@ func TestExpireNotExpired(t *testing.T) {
 		t.Error(err)
 	}
 
-	// No t.Parallel() - Also must be after refreshExpiry() call
-	newTime := time.Now().UTC().Add(ab.Modules.ExpireAfter / 2)
-	nowTime = func() time.Time {
-		return newTime
-	}
-	defer func() {
-		nowTime = time.Now
-	}()
+	clock.Advance(ab.Modules.ExpireAfter / 2)
+	newTime := clock.Now()
 
 	called := false
 	hadUser := true
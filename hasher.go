@@ -1,7 +1,27 @@
 package authboss
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/friendsofgo/errors"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+var (
+	// ErrMismatchedHashAndPassword is returned from CompareHashAndPassword
+	// when the password does not match the hash.
+	ErrMismatchedHashAndPassword = errors.New("hashedPassword is not the hash of the given password")
+	// ErrUnknownHash is returned from CompareHashAndPassword when the hash
+	// is malformed or was created by an algorithm the hasher doesn't know.
+	ErrUnknownHash = errors.New("hash was not recognized")
 )
 
 // Hasher is the interface that wraps the hashing and comparison of passwords
@@ -10,6 +30,21 @@ type Hasher interface {
 	GenerateHash(password string) (string, error)
 }
 
+// RehashingHasher is a Hasher that can tell which hashes it created and
+// whether they should be replaced. When the configured Hasher implements
+// this the auth module will rehash and save a user's password after they
+// log in if NeedsRehash returns true.
+type RehashingHasher interface {
+	Hasher
+
+	// Identify returns true if the hash was created by this hasher's
+	// algorithm, regardless of the parameters that were used.
+	Identify(hash string) bool
+	// NeedsRehash returns true if GenerateHash would not produce a hash
+	// with the same algorithm and parameters.
+	NeedsRehash(hash string) bool
+}
+
 // NewBCryptHasher creates a new bcrypt hasher with the given cost
 func NewBCryptHasher(cost int) *bcryptHasher {
 	return &bcryptHasher{cost: cost}
@@ -31,3 +66,339 @@ func (h *bcryptHasher) GenerateHash(password string) (string, error) {
 func (h *bcryptHasher) CompareHashAndPassword(hashedPassword, password string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
 }
+
+func (h *bcryptHasher) Identify(hash string) bool {
+	_, err := bcrypt.Cost([]byte(hash))
+	return err == nil
+}
+
+func (h *bcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return true
+	}
+
+	cost2 := h.cost
+	if cost2 < bcrypt.MinCost {
+		cost2 = bcrypt.DefaultCost
+	}
+
+	return cost != cost2
+}
+
+// Argon2Params are the parameters for argon2id hashing
+type Argon2Params struct {
+	// Time is the number of passes over the memory
+	Time uint32
+	// Memory is the amount of memory used in KiB
+	Memory uint32
+	// Threads is the degree of parallelism
+	Threads uint8
+	// SaltLength in bytes
+	SaltLength uint32
+	// KeyLength in bytes
+	KeyLength uint32
+}
+
+// DefaultArgon2Params returns the second recommended option from RFC 9106
+// with a 64 MiB memory cost.
+func DefaultArgon2Params() Argon2Params {
+	return Argon2Params{
+		Time:       3,
+		Memory:     64 * 1024,
+		Threads:    4,
+		SaltLength: 16,
+		KeyLength:  32,
+	}
+}
+
+// NewArgon2Hasher creates a new argon2id hasher with the given parameters.
+// Hashes are encoded in the PHC string format:
+// $argon2id$v=19$m=65536,t=3,p=4$salt$key
+func NewArgon2Hasher(params Argon2Params) *argon2Hasher {
+	return &argon2Hasher{params: params}
+}
+
+type argon2Hasher struct {
+	params Argon2Params
+}
+
+const argon2Prefix = "$argon2id$"
+
+func (h *argon2Hasher) GenerateHash(password string) (string, error) {
+	salt, err := generateSalt(h.params.SaltLength)
+	if err != nil {
+		return "", err
+	}
+
+	p := h.params
+	key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLength)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2Prefix, argon2.Version,
+		p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h *argon2Hasher) CompareHashAndPassword(hashedPassword, password string) error {
+	params, salt, key, err := parseArgon2Hash(hashedPassword)
+	if err != nil {
+		return err
+	}
+
+	other := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, params.KeyLength)
+	return compareKeys(key, other)
+}
+
+func (h *argon2Hasher) Identify(hash string) bool {
+	return strings.HasPrefix(hash, argon2Prefix)
+}
+
+func (h *argon2Hasher) NeedsRehash(hash string) bool {
+	params, _, _, err := parseArgon2Hash(hash)
+	if err != nil {
+		return true
+	}
+
+	return params != h.params
+}
+
+func parseArgon2Hash(hash string) (params Argon2Params, salt, key []byte, err error) {
+	// "", "argon2id", "v=19", "m=65536,t=3,p=4", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" || parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	values, err := parseHashParams(parts[3], "m", "t", "p")
+	if err != nil || values["p"] > 255 {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, nil, nil, ErrUnknownHash
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(key) == 0 {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	params = Argon2Params{
+		Memory:     uint32(values["m"]),
+		Time:       uint32(values["t"]),
+		Threads:    uint8(values["p"]),
+		SaltLength: uint32(len(salt)),
+		KeyLength:  uint32(len(key)),
+	}
+	return params, salt, key, nil
+}
+
+// ScryptParams are the parameters for scrypt hashing
+type ScryptParams struct {
+	// LogN is the log2 of the CPU/memory cost parameter N
+	LogN uint8
+	// R is the block size
+	R int
+	// P is the parallelization parameter
+	P int
+	// SaltLength in bytes
+	SaltLength int
+	// KeyLength in bytes
+	KeyLength int
+}
+
+// DefaultScryptParams returns N=2^15, r=8, p=1 which uses 32 MiB of memory
+func DefaultScryptParams() ScryptParams {
+	return ScryptParams{
+		LogN:       15,
+		R:          8,
+		P:          1,
+		SaltLength: 16,
+		KeyLength:  32,
+	}
+}
+
+// NewScryptHasher creates a new scrypt hasher with the given parameters.
+// Hashes are encoded in the PHC string format:
+// $scrypt$ln=15,r=8,p=1$salt$key
+func NewScryptHasher(params ScryptParams) *scryptHasher {
+	return &scryptHasher{params: params}
+}
+
+type scryptHasher struct {
+	params ScryptParams
+}
+
+const scryptPrefix = "$scrypt$"
+
+func (h *scryptHasher) GenerateHash(password string) (string, error) {
+	salt, err := generateSalt(uint32(h.params.SaltLength))
+	if err != nil {
+		return "", err
+	}
+
+	p := h.params
+	key, err := scrypt.Key([]byte(password), salt, 1<<p.LogN, p.R, p.P, p.KeyLength)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%sln=%d,r=%d,p=%d$%s$%s", scryptPrefix,
+		p.LogN, p.R, p.P,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h *scryptHasher) CompareHashAndPassword(hashedPassword, password string) error {
+	params, salt, key, err := parseScryptHash(hashedPassword)
+	if err != nil {
+		return err
+	}
+
+	other, err := scrypt.Key([]byte(password), salt, 1<<params.LogN, params.R, params.P, params.KeyLength)
+	if err != nil {
+		return ErrUnknownHash
+	}
+	return compareKeys(key, other)
+}
+
+func (h *scryptHasher) Identify(hash string) bool {
+	return strings.HasPrefix(hash, scryptPrefix)
+}
+
+func (h *scryptHasher) NeedsRehash(hash string) bool {
+	params, _, _, err := parseScryptHash(hash)
+	if err != nil {
+		return true
+	}
+
+	return params != h.params
+}
+
+func parseScryptHash(hash string) (params ScryptParams, salt, key []byte, err error) {
+	// "", "scrypt", "ln=15,r=8,p=1", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 5 || parts[1] != "scrypt" {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	values, err := parseHashParams(parts[2], "ln", "r", "p")
+	if err != nil || values["ln"] < 1 || values["ln"] > 63 {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[3]); err != nil {
+		return params, nil, nil, ErrUnknownHash
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil || len(key) == 0 {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	params = ScryptParams{
+		LogN:       uint8(values["ln"]),
+		R:          int(values["r"]),
+		P:          int(values["p"]),
+		SaltLength: len(salt),
+		KeyLength:  len(key),
+	}
+	return params, salt, key, nil
+}
+
+// NewCompositeHasher creates a hasher that generates hashes with current
+// and can compare passwords against hashes made by current or any of the
+// legacy hashers. Hashes not made by current, or made by it with different
+// parameters, are reported by NeedsRehash.
+//
+// This allows moving users to a new algorithm or cost as they log in:
+//
+//	NewCompositeHasher(NewArgon2Hasher(DefaultArgon2Params()), NewBCryptHasher(bcrypt.DefaultCost))
+func NewCompositeHasher(current RehashingHasher, legacy ...RehashingHasher) *compositeHasher {
+	return &compositeHasher{current: current, legacy: legacy}
+}
+
+type compositeHasher struct {
+	current RehashingHasher
+	legacy  []RehashingHasher
+}
+
+func (h *compositeHasher) GenerateHash(password string) (string, error) {
+	return h.current.GenerateHash(password)
+}
+
+func (h *compositeHasher) CompareHashAndPassword(hashedPassword, password string) error {
+	hasher := h.find(hashedPassword)
+	if hasher == nil {
+		return ErrUnknownHash
+	}
+
+	return hasher.CompareHashAndPassword(hashedPassword, password)
+}
+
+func (h *compositeHasher) Identify(hash string) bool {
+	return h.find(hash) != nil
+}
+
+func (h *compositeHasher) NeedsRehash(hash string) bool {
+	if !h.current.Identify(hash) {
+		return true
+	}
+
+	return h.current.NeedsRehash(hash)
+}
+
+func (h *compositeHasher) find(hash string) RehashingHasher {
+	if h.current.Identify(hash) {
+		return h.current
+	}
+	for _, hasher := range h.legacy {
+		if hasher.Identify(hash) {
+			return hasher
+		}
+	}
+
+	return nil
+}
+
+func generateSalt(length uint32) ([]byte, error) {
+	salt := make([]byte, length)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, errors.Wrap(err, "failed to generate salt")
+	}
+
+	return salt, nil
+}
+
+func compareKeys(key, other []byte) error {
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrMismatchedHashAndPassword
+	}
+
+	return nil
+}
+
+// parseHashParams parses the "k=v,k=v" section of a PHC string, all of
+// the given keys must be present.
+func parseHashParams(section string, keys ...string) (map[string]uint64, error) {
+	values := make(map[string]uint64)
+	for _, kv := range strings.Split(section, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, ErrUnknownHash
+		}
+
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return nil, ErrUnknownHash
+		}
+		values[k] = n
+	}
+
+	for _, k := range keys {
+		if _, ok := values[k]; !ok || values[k] == 0 {
+			return nil, ErrUnknownHash
+		}
+	}
+
+	return values, nil
+}
@@ -35,3 +35,168 @@ func TestBcryptHasher(t *testing.T) {
 		t.Error("compare-hash-and-password for invalid password must fail")
 	}
 }
+
+func testArgon2Params() Argon2Params {
+	return Argon2Params{Time: 1, Memory: 64, Threads: 1, SaltLength: 16, KeyLength: 32}
+}
+
+func testScryptParams() ScryptParams {
+	return ScryptParams{LogN: 4, R: 8, P: 1, SaltLength: 16, KeyLength: 32}
+}
+
+func TestArgon2Hasher(t *testing.T) {
+	t.Parallel()
+
+	hasher := NewArgon2Hasher(testArgon2Params())
+
+	hash, err := hasher.GenerateHash("qwerty")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Error("hash was wrong", hash)
+	}
+	if !hasher.Identify(hash) {
+		t.Error("hash should be identified")
+	}
+	if hasher.NeedsRehash(hash) {
+		t.Error("hash should not need a rehash")
+	}
+
+	if err := hasher.CompareHashAndPassword(hash, "qwerty"); err != nil {
+		t.Error("compare-hash-and-password for valid password must be ok", err)
+	}
+	if err := hasher.CompareHashAndPassword(hash, "qwerty-invalid"); err != ErrMismatchedHashAndPassword {
+		t.Error("compare-hash-and-password for invalid password must fail", err)
+	}
+
+	params := testArgon2Params()
+	params.Time = 2
+	if !NewArgon2Hasher(params).NeedsRehash(hash) {
+		t.Error("hash should need a rehash when the parameters change")
+	}
+
+	bad := []string{
+		"",
+		"$argon2i$v=19$m=64,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=16$m=64,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$!!$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA$",
+	}
+	for _, b := range bad {
+		if err := hasher.CompareHashAndPassword(b, "qwerty"); err != ErrUnknownHash {
+			t.Errorf("%q should not be recognized: %v", b, err)
+		}
+	}
+}
+
+func TestScryptHasher(t *testing.T) {
+	t.Parallel()
+
+	hasher := NewScryptHasher(testScryptParams())
+
+	hash, err := hasher.GenerateHash("qwerty")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(hash, "$scrypt$ln=4,r=8,p=1$") {
+		t.Error("hash was wrong", hash)
+	}
+	if !hasher.Identify(hash) {
+		t.Error("hash should be identified")
+	}
+	if hasher.NeedsRehash(hash) {
+		t.Error("hash should not need a rehash")
+	}
+
+	if err := hasher.CompareHashAndPassword(hash, "qwerty"); err != nil {
+		t.Error("compare-hash-and-password for valid password must be ok", err)
+	}
+	if err := hasher.CompareHashAndPassword(hash, "qwerty-invalid"); err != ErrMismatchedHashAndPassword {
+		t.Error("compare-hash-and-password for invalid password must fail", err)
+	}
+
+	params := testScryptParams()
+	params.LogN = 5
+	if !NewScryptHasher(params).NeedsRehash(hash) {
+		t.Error("hash should need a rehash when the parameters change")
+	}
+
+	if err := hasher.CompareHashAndPassword("$scrypt$ln=0,r=8,p=1$c2FsdA$a2V5", "qwerty"); err != ErrUnknownHash {
+		t.Error("bad hash should not be recognized", err)
+	}
+}
+
+func TestBcryptHasherRehash(t *testing.T) {
+	t.Parallel()
+
+	hasher := NewBCryptHasher(bcrypt.MinCost)
+
+	hash, err := hasher.GenerateHash("qwerty")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !hasher.Identify(hash) {
+		t.Error("hash should be identified")
+	}
+	if hasher.NeedsRehash(hash) {
+		t.Error("hash should not need a rehash")
+	}
+	if !NewBCryptHasher(bcrypt.MinCost + 1).NeedsRehash(hash) {
+		t.Error("hash should need a rehash when the cost changes")
+	}
+	if hasher.Identify("$argon2id$v=19$m=64,t=1,p=1$c2FsdA$a2V5") {
+		t.Error("argon2 hash should not be identified")
+	}
+}
+
+func TestCompositeHasher(t *testing.T) {
+	t.Parallel()
+
+	legacy := NewBCryptHasher(bcrypt.MinCost)
+	hasher := NewCompositeHasher(NewArgon2Hasher(testArgon2Params()), legacy)
+
+	oldHash, err := legacy.GenerateHash("qwerty")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := hasher.CompareHashAndPassword(oldHash, "qwerty"); err != nil {
+		t.Error("legacy hashes should be verified", err)
+	}
+	if err := hasher.CompareHashAndPassword(oldHash, "qwerty-invalid"); err == nil {
+		t.Error("legacy hashes should fail with the wrong password")
+	}
+	if !hasher.NeedsRehash(oldHash) {
+		t.Error("legacy hashes should need a rehash")
+	}
+
+	newHash, err := hasher.GenerateHash("qwerty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(newHash, "$argon2id$") {
+		t.Error("new hashes should use the current hasher", newHash)
+	}
+	if err := hasher.CompareHashAndPassword(newHash, "qwerty"); err != nil {
+		t.Error("new hashes should be verified", err)
+	}
+	if hasher.NeedsRehash(newHash) {
+		t.Error("new hashes should not need a rehash")
+	}
+
+	scryptHash, err := NewScryptHasher(testScryptParams()).GenerateHash("qwerty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasher.Identify(scryptHash) {
+		t.Error("scrypt hashes should not be identified")
+	}
+	if err := hasher.CompareHashAndPassword(scryptHash, "qwerty"); err != ErrUnknownHash {
+		t.Error("scrypt hashes should not be recognized", err)
+	}
+}
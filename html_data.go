@@ -35,6 +35,9 @@ const (
 	// The bool is largely extraneous and can be ignored, if the module is
 	// loaded it will be present in the map, if not it will be missing.
 	DataModules = "modules"
+	// DataCSRFToken is the csrf token that forms must send back in the
+	// FormValueCSRFToken field, it's a string.
+	DataCSRFToken = "csrf_token"
 )
 
 // HTMLData is used to render templates with.
@@ -41,6 +41,16 @@ var (
 		Default: "Account successfully created, you are now logged in",
 	}
 
+	// Used in the bearer module
+	TxtInvalidBearerToken = LocalizationKey{
+		ID:      "InvalidBearerToken",
+		Default: "Your access token is invalid or has expired",
+	}
+	TxtInvalidRefreshToken = LocalizationKey{
+		ID:      "InvalidRefreshToken",
+		Default: "Your refresh token is invalid or has expired",
+	}
+
 	// Used in the confirm module
 	TxtConfirmYourAccount = LocalizationKey{
 		ID:      "ConfirmYourAccount",
@@ -63,11 +73,77 @@ var (
 		Default: "Confirm New Account",
 	}
 
+	// Used in the delete module
+	TxtAccountDeleted = LocalizationKey{
+		ID:      "AccountDeleted",
+		Default: "Your account has been deleted",
+	}
+	TxtDeleteLoginRequired = LocalizationKey{
+		ID:      "DeleteLoginRequired",
+		Default: "Please log in again before deleting your account",
+	}
+
+	// Used in the emailchange module
+	TxtEmailChangeStarted = LocalizationKey{
+		ID:      "EmailChangeStarted",
+		Default: "An e-mail has been sent to your new address, follow the link inside to finish changing your e-mail.",
+	}
+	TxtEmailChangeSubject = LocalizationKey{
+		ID:      "EmailChangeSubject",
+		Default: "Confirm New E-mail Address",
+	}
+	TxtEmailChangeNoticeSubject = LocalizationKey{
+		ID:      "EmailChangeNoticeSubject",
+		Default: "E-mail Address Change Requested",
+	}
+	TxtEmailChanged = LocalizationKey{
+		ID:      "EmailChanged",
+		Default: "Your e-mail address has been changed.",
+	}
+	TxtEmailInUse = LocalizationKey{
+		ID:      "EmailInUse",
+		Default: "That e-mail address is already in use.",
+	}
+	TxtEmailChangeUnavailable = LocalizationKey{
+		ID:      "EmailChangeUnavailable",
+		Default: "Your e-mail address can't be changed.",
+	}
+	TxtEmailChangeLoginRequired = LocalizationKey{
+		ID:      "EmailChangeLoginRequired",
+		Default: "Please log in again before changing your e-mail address",
+	}
+	TxtInvalidEmailChangeToken = LocalizationKey{
+		ID:      "InvalidEmailChangeToken",
+		Default: "Your e-mail change token is invalid.",
+	}
+
 	// Used in the lock module
 	TxtLocked = LocalizationKey{
 		ID:      "Locked",
 		Default: "Your account has been locked, please contact the administrator.",
 	}
+	TxtLockedEmailSubject = LocalizationKey{
+		ID:      "LockedEmailSubject",
+		Default: "Your account has been locked",
+	}
+	TxtUnlocked = LocalizationKey{
+		ID:      "Unlocked",
+		Default: "Your account has been unlocked.",
+	}
+	TxtUnlockInitiateSuccessFlash = LocalizationKey{
+		ID:      "UnlockInitiateSuccessFlash",
+		Default: "If your account is locked you will receive an e-mail with a link to unlock it.",
+	}
+	TxtInvalidUnlockToken = LocalizationKey{
+		ID:      "InvalidUnlockToken",
+		Default: "Your unlock token is invalid.",
+	}
+
+	// Used in the ratelimit module
+	TxtRateLimited = LocalizationKey{
+		ID:      "RateLimited",
+		Default: "Too many requests, please try again later.",
+	}
 
 	// Used in the logout module
 	TxtLoggedOut = LocalizationKey{
@@ -75,6 +151,20 @@ var (
 		Default: "You have been logged out",
 	}
 
+	// Used in the magiclink module
+	TxtMagicLinkSent = LocalizationKey{
+		ID:      "MagicLinkSent",
+		Default: "An e-mail has been sent to you with a link to log in.",
+	}
+	TxtMagicLinkEmailSubject = LocalizationKey{
+		ID:      "MagicLinkEmailSubject",
+		Default: "Your Login Link",
+	}
+	TxtInvalidMagicLinkToken = LocalizationKey{
+		ID:      "InvalidMagicLinkToken",
+		Default: "Your login link is invalid or has expired, please request a new one.",
+	}
+
 	// Used in the oauth2 module
 	TxtOAuth2LoginOK = LocalizationKey{
 		ID:      "OAuth2LoginOK",
@@ -84,6 +174,36 @@ var (
 		ID:      "OAuth2LoginNotOK",
 		Default: "%s login cancelled or failed",
 	}
+	TxtOAuth2Linked = LocalizationKey{
+		ID:      "OAuth2Linked",
+		Default: "Your %s account has been linked",
+	}
+	TxtOAuth2Unlinked = LocalizationKey{
+		ID:      "OAuth2Unlinked",
+		Default: "Your %s account has been unlinked",
+	}
+	TxtOAuth2IdentityInUse = LocalizationKey{
+		ID:      "OAuth2IdentityInUse",
+		Default: "That %s account is already linked to another user",
+	}
+	TxtOAuth2IdentityNotFound = LocalizationKey{
+		ID:      "OAuth2IdentityNotFound",
+		Default: "The linked account could not be found",
+	}
+	TxtOAuth2CannotUnlink = LocalizationKey{
+		ID:      "OAuth2CannotUnlink",
+		Default: "You cannot unlink the only way you have to log in",
+	}
+
+	// Used in the password module
+	TxtPasswordChanged = LocalizationKey{
+		ID:      "PasswordChanged",
+		Default: "Your password has been changed",
+	}
+	TxtInvalidCurrentPassword = LocalizationKey{
+		ID:      "InvalidCurrentPassword",
+		Default: "Your current password was incorrect",
+	}
 
 	// Used in the recover module
 	TxtRecoverInitiateSuccessFlash = LocalizationKey{
@@ -146,6 +266,42 @@ var (
 		ID:      "SMSWaitToResend",
 		Default: "Please wait a few moments before resending the SMS code",
 	}
+	TxtInvalidWebAuthnCredential = LocalizationKey{
+		ID:      "InvalidWebAuthnCredential",
+		Default: "Security key could not be verified",
+	}
+	TxtWebAuthn2FANotActive = LocalizationKey{
+		ID:      "WebAuthn2FANotActive",
+		Default: "Security key 2FA is not active",
+	}
+
+	// Used in the sessions module
+	TxtSessionRevoked = LocalizationKey{
+		ID:      "SessionRevoked",
+		Default: "The session has been logged out",
+	}
+	TxtOtherSessionsRevoked = LocalizationKey{
+		ID:      "OtherSessionsRevoked",
+		Default: "All other sessions have been logged out",
+	}
+	TxtSessionNotFound = LocalizationKey{
+		ID:      "SessionNotFound",
+		Default: "The session could not be found",
+	}
+
+	// Used in the apikey module
+	TxtAPIKeyRevoked = LocalizationKey{
+		ID:      "APIKeyRevoked",
+		Default: "The API key has been revoked",
+	}
+	TxtAPIKeyNotFound = LocalizationKey{
+		ID:      "APIKeyNotFound",
+		Default: "The API key could not be found",
+	}
+	TxtInvalidAPIKeyScope = LocalizationKey{
+		ID:      "InvalidAPIKeyScope",
+		Default: "Unknown scope",
+	}
 )
 
 // // Translation constants
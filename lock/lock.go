@@ -3,10 +3,14 @@ package lock
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"net/url"
+	"path"
 	"time"
 
 	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/confirm"
 )
 
 // Storage key constants
@@ -16,6 +20,29 @@ const (
 	StoreLocked        = "locked"
 )
 
+// Constants for templates etc.
+const (
+	// PageUnlock is the page where a locked user asks for an unlock e-mail
+	PageUnlock = "unlock"
+	// PageUnlockConfirm is only really used for the BodyReader
+	PageUnlockConfirm = "unlock_confirm"
+
+	// EmailLockedHTML is the name of the html template for e-mails sent
+	// to a user when they're locked
+	EmailLockedHTML = "locked_html"
+	// EmailLockedTxt is the name of the text template for e-mails sent
+	// to a user when they're locked
+	EmailLockedTxt = "locked_txt"
+
+	// DataUnlockURL is the name of the e-mail template variable
+	// that gives the url to send to the user for unlocking.
+	DataUnlockURL = "url"
+
+	// FormValueToken is the name of the query parameter that holds the
+	// token in the unlock link
+	FormValueToken = "token"
+)
+
 func init() {
 	authboss.RegisterModule("lock", &Lock{})
 }
@@ -32,8 +59,37 @@ func (l *Lock) Init(ab *authboss.Authboss) error {
 	l.Events.Before(authboss.EventAuth, l.BeforeAuth)
 	l.Events.Before(authboss.EventOAuth2, l.BeforeAuth)
 	l.Events.After(authboss.EventAuth, l.AfterAuthSuccess)
+	l.Events.After(authboss.EventBearerAuth, l.AfterAuthSuccess)
 	l.Events.After(authboss.EventAuthFail, l.AfterAuthFail)
 
+	// Users can only unlock themselves when the storer can find them by
+	// the token in the link they're sent
+	if _, ok := l.Config.Storage.Server.(authboss.UnlockingServerStorer); !ok {
+		return nil
+	}
+
+	if err := l.Config.Core.ViewRenderer.Load(PageUnlock); err != nil {
+		return err
+	}
+
+	if err := l.Config.Core.MailRenderer.Load(EmailLockedHTML, EmailLockedTxt); err != nil {
+		return err
+	}
+
+	l.Config.Core.Router.Get("/lock/unlock", l.Core.ErrorHandler.Wrap(l.UnlockGet))
+	l.Config.Core.Router.Post("/lock/unlock", l.Core.ErrorHandler.Wrap(l.UnlockPost))
+
+	var callbackMethod func(string, http.Handler)
+	switch l.Config.Modules.MailRouteMethod {
+	case http.MethodGet:
+		callbackMethod = l.Config.Core.Router.Get
+	case http.MethodPost:
+		callbackMethod = l.Config.Core.Router.Post
+	default:
+		panic("invalid config for MailRouteMethod")
+	}
+	callbackMethod("/lock/unlock/confirm", l.Core.ErrorHandler.Wrap(l.ConfirmUnlock))
+
 	return nil
 }
 
@@ -51,7 +107,10 @@ func (l *Lock) AfterAuthSuccess(w http.ResponseWriter, r *http.Request, handled
 
 	lu := authboss.MustBeLockable(user)
 	lu.PutAttemptCount(0)
-	lu.PutLastAttempt(time.Now().UTC())
+	lu.PutLastAttempt(l.Now())
+	if lcu, ok := lu.(authboss.LockableUserWithLockCount); ok {
+		lcu.PutLockCount(0)
+	}
 
 	return false, l.Authboss.Config.Storage.Server.Save(r.Context(), lu)
 }
@@ -76,10 +135,13 @@ func (l *Lock) updateLockedState(w http.ResponseWriter, r *http.Request, wasCorr
 	attempts := lu.GetAttemptCount()
 	attempts++
 
+	var unlockToken string
 	if !wasCorrectPassword {
-		if time.Now().UTC().Sub(last) <= l.Modules.LockWindow {
-			if attempts >= l.Modules.LockAfter {
-				lu.PutLocked(time.Now().UTC().Add(l.Modules.LockDuration))
+		if l.Now().Sub(last) <= l.Modules.LockWindow {
+			if attempts >= l.Modules.LockAfter && !IsLockedAt(lu, l.Now()) {
+				if unlockToken, err = l.lockUser(lu); err != nil {
+					return false, err
+				}
 			}
 
 			lu.PutAttemptCount(attempts)
@@ -87,13 +149,22 @@ func (l *Lock) updateLockedState(w http.ResponseWriter, r *http.Request, wasCorr
 			lu.PutAttemptCount(1)
 		}
 	}
-	lu.PutLastAttempt(time.Now().UTC())
+	lu.PutLastAttempt(l.Now())
 
 	if err := l.Authboss.Config.Storage.Server.Save(r.Context(), lu); err != nil {
 		return false, err
 	}
 
-	if !IsLocked(lu) {
+	if len(unlockToken) != 0 {
+		email := lu.(authboss.LockableUserWithUnlockToken).GetEmail()
+		if l.Modules.MailNoGoroutine {
+			l.SendLockedEmail(r.Context(), email, unlockToken)
+		} else {
+			go l.SendLockedEmail(r.Context(), email, unlockToken)
+		}
+	}
+
+	if !IsLockedAt(lu, l.Now()) {
 		return false, nil
 	}
 
@@ -105,6 +176,236 @@ func (l *Lock) updateLockedState(w http.ResponseWriter, r *http.Request, wasCorr
 	return true, l.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
 }
 
+// lockUser locks the user for as long as the LockPolicy says to, when the
+// lock isn't permanent and the user can unlock themselves an unlock token
+// is stored on the user and returned so it can be e-mailed to them.
+func (l *Lock) lockUser(lu authboss.LockableUser) (token string, err error) {
+	count := 1
+	if lcu, ok := lu.(authboss.LockableUserWithLockCount); ok {
+		count = lcu.GetLockCount() + 1
+		lcu.PutLockCount(count)
+	}
+
+	policy := l.Config.Modules.LockPolicy
+	if policy == nil {
+		policy = FixedPolicy{Duration: l.Config.Modules.LockDuration}
+	}
+
+	duration, permanent := policy.LockDuration(count)
+	if permanent {
+		lu.PutLocked(PermanentLock)
+	} else {
+		lu.PutLocked(l.Now().Add(duration))
+	}
+
+	tu, ok := lu.(authboss.LockableUserWithUnlockToken)
+	if !ok {
+		return "", nil
+	}
+	if _, ok := l.Config.Storage.Server.(authboss.UnlockingServerStorer); !ok {
+		return "", nil
+	}
+
+	if permanent {
+		// Only an administrator can undo a permanent lock so any link
+		// sent for a previous lock must stop working
+		l.clearUnlockToken(tu)
+		return "", nil
+	}
+
+	return l.putUnlockToken(tu)
+}
+
+func (l *Lock) putUnlockToken(tu authboss.LockableUserWithUnlockToken) (string, error) {
+	selector, verifier, token, err := l.Config.Core.OneTimeTokenGenerator.GenerateToken()
+	if err != nil {
+		return "", err
+	}
+
+	tu.PutUnlockSelector(selector)
+	tu.PutUnlockVerifier(verifier)
+	tu.PutUnlockExpiry(l.Now().Add(l.Config.Modules.UnlockTokenDuration))
+
+	return token, nil
+}
+
+// UnlockGet renders a form for a locked user to ask for an unlock e-mail.
+func (l *Lock) UnlockGet(w http.ResponseWriter, r *http.Request) error {
+	return l.Config.Core.Responder.Respond(w, r, http.StatusOK, PageUnlock, nil)
+}
+
+// UnlockPost sends a new unlock e-mail to a locked user. The response is
+// the same whether or not the user exists or is locked so that it can't be
+// used to find out either.
+func (l *Lock) UnlockPost(w http.ResponseWriter, r *http.Request) error {
+	logger := l.RequestLogger(r)
+
+	validatable, err := l.Core.BodyReader.Read(PageUnlock, r)
+	if err != nil {
+		return err
+	}
+
+	if errs := validatable.Validate(); errs != nil {
+		logger.Info("unlock validation failed")
+		data := authboss.HTMLData{authboss.DataValidation: authboss.ErrorMap(errs)}
+		return l.Core.Responder.Respond(w, r, http.StatusOK, PageUnlock, data)
+	}
+
+	pid := authboss.MustHaveRecoverStartValues(validatable).GetPID()
+
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: l.Config.Paths.UnlockOK,
+		Success:      l.Localizef(r.Context(), authboss.TxtUnlockInitiateSuccessFlash),
+	}
+
+	user, err := l.Storage.Server.Load(r.Context(), pid)
+	if err == authboss.ErrUserNotFound {
+		logger.Infof("user %s was attempted to be unlocked, user does not exist, faking successful response", pid)
+		return l.Core.Redirector.Redirect(w, r, ro)
+	} else if err != nil {
+		return err
+	}
+
+	tu, ok := user.(authboss.LockableUserWithUnlockToken)
+	if !ok {
+		logger.Infof("user %s was attempted to be unlocked, user cannot be unlocked by e-mail, faking successful response", pid)
+		return l.Core.Redirector.Redirect(w, r, ro)
+	}
+	if !IsLockedAt(tu, l.Now()) || IsPermanentlyLocked(tu) {
+		logger.Infof("user %s was attempted to be unlocked, user is not locked or is locked permanently, faking successful response", pid)
+		return l.Core.Redirector.Redirect(w, r, ro)
+	}
+
+	token, err := l.putUnlockToken(tu)
+	if err != nil {
+		return err
+	}
+
+	if err := l.Storage.Server.Save(r.Context(), tu); err != nil {
+		return err
+	}
+
+	if l.Modules.MailNoGoroutine {
+		l.SendLockedEmail(r.Context(), tu.GetEmail(), token)
+	} else {
+		go l.SendLockedEmail(r.Context(), tu.GetEmail(), token)
+	}
+
+	logger.Infof("user %s unlock e-mail sent", pid)
+	return l.Core.Redirector.Redirect(w, r, ro)
+}
+
+// SendLockedEmail tells the user they've been locked and sends them a link
+// to unlock themselves.
+func (l *Lock) SendLockedEmail(ctx context.Context, to, token string) {
+	logger := l.Logger(ctx)
+
+	email := authboss.Email{
+		To:       []string{to},
+		From:     l.Config.Mail.From,
+		FromName: l.Config.Mail.FromName,
+		Subject:  l.Config.Mail.SubjectPrefix + l.Localizef(ctx, authboss.TxtLockedEmailSubject),
+	}
+
+	ro := authboss.EmailResponseOptions{
+		Data:         authboss.NewHTMLData(DataUnlockURL, l.mailURL(token)),
+		HTMLTemplate: EmailLockedHTML,
+		TextTemplate: EmailLockedTxt,
+	}
+
+	logger.Infof("sending locked e-mail to: %s", to)
+	if err := l.Email(ctx, email, ro); err != nil {
+		logger.Errorf("failed to send locked e-mail to %s: %+v", to, err)
+	}
+}
+
+// ConfirmUnlock unlocks a user when given a valid token from the link they
+// were sent when they were locked. Permanent locks are never undone here.
+func (l *Lock) ConfirmUnlock(w http.ResponseWriter, r *http.Request) error {
+	logger := l.RequestLogger(r)
+
+	validator, err := l.Config.Core.BodyReader.Read(PageUnlockConfirm, r)
+	if err != nil {
+		return err
+	}
+
+	if errs := validator.Validate(); errs != nil {
+		logger.Infof("validation failed in Lock.ConfirmUnlock, this typically means a bad token: %+v", errs)
+		return l.invalidToken(w, r)
+	}
+
+	values := authboss.MustHaveConfirmValues(validator)
+
+	selector, verifier, err := confirm.ParseToken(l.Config.Core.OneTimeTokenGenerator, values.GetToken())
+	if err != nil {
+		logger.Infof("invalid unlock token submitted: %+v", err)
+		return l.invalidToken(w, r)
+	}
+
+	storer := authboss.EnsureCanUnlock(l.Config.Storage.Server)
+	user, err := storer.LoadByUnlockSelector(r.Context(), selector)
+	if err == authboss.ErrUserNotFound {
+		logger.Infof("unlock selector was not found in database: %s", selector)
+		return l.invalidToken(w, r)
+	} else if err != nil {
+		return err
+	}
+
+	if !confirm.VerifierMatches(user.GetUnlockVerifier(), verifier) {
+		logger.Info("stored unlock verifier does not match provided one")
+		return l.invalidToken(w, r)
+	}
+
+	if l.Now().After(user.GetUnlockExpiry()) {
+		logger.Infof("unlock token for user %s has expired", user.GetPID())
+		return l.invalidToken(w, r)
+	}
+
+	if IsPermanentlyLocked(user) {
+		logger.Infof("user %s tried to unlock a permanent lock", user.GetPID())
+		ro := authboss.RedirectOptions{
+			Code:         http.StatusTemporaryRedirect,
+			Failure:      l.Localizef(r.Context(), authboss.TxtLocked),
+			RedirectPath: l.Config.Paths.LockNotOK,
+		}
+		return l.Core.Redirector.Redirect(w, r, ro)
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	handled, err := l.Events.FireBefore(authboss.EventUnlock, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	// The lock count is kept so that locks keep getting longer until the
+	// user manages to log in
+	l.resetLock(user)
+	l.clearUnlockToken(user)
+
+	if err := l.Config.Storage.Server.Save(r.Context(), user); err != nil {
+		return err
+	}
+
+	logger.Infof("user %s unlocked their account", user.GetPID())
+
+	handled, err = l.Events.FireAfter(authboss.EventUnlock, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: l.Config.Paths.UnlockOK,
+		Success:      l.Localizef(r.Context(), authboss.TxtUnlocked),
+	}
+	return l.Core.Redirector.Redirect(w, r, ro)
+}
+
 // Lock a user manually.
 func (l *Lock) Lock(ctx context.Context, key string) error {
 	user, err := l.Authboss.Config.Storage.Server.Load(ctx, key)
@@ -113,7 +414,7 @@ func (l *Lock) Lock(ctx context.Context, key string) error {
 	}
 
 	lu := authboss.MustBeLockable(user)
-	lu.PutLocked(time.Now().UTC().Add(l.Authboss.Config.Modules.LockDuration))
+	lu.PutLocked(l.Now().Add(l.Authboss.Config.Modules.LockDuration))
 
 	return l.Authboss.Config.Storage.Server.Save(ctx, lu)
 }
@@ -126,17 +427,53 @@ func (l *Lock) Unlock(ctx context.Context, key string) error {
 	}
 
 	lu := authboss.MustBeLockable(user)
+	l.resetLock(lu)
+
+	if lcu, ok := lu.(authboss.LockableUserWithLockCount); ok {
+		lcu.PutLockCount(0)
+	}
+	if tu, ok := lu.(authboss.LockableUserWithUnlockToken); ok {
+		l.clearUnlockToken(tu)
+	}
 
+	return l.Authboss.Config.Storage.Server.Save(ctx, lu)
+}
+
+func (l *Lock) resetLock(lu authboss.LockableUser) {
 	// Set the last attempt to be -window*2 to avoid immediately
 	// giving another login failure. Don't reset Locked to Zero time
 	// because some databases may have trouble storing values before
 	// unix_time(0): Jan 1st, 1970
-	now := time.Now().UTC()
+	now := l.Now()
 	lu.PutAttemptCount(0)
 	lu.PutLastAttempt(now.Add(-l.Authboss.Config.Modules.LockWindow * 2))
 	lu.PutLocked(now.Add(-l.Authboss.Config.Modules.LockDuration))
+}
 
-	return l.Authboss.Config.Storage.Server.Save(ctx, lu)
+func (l *Lock) clearUnlockToken(tu authboss.LockableUserWithUnlockToken) {
+	tu.PutUnlockSelector("")
+	tu.PutUnlockVerifier("")
+	tu.PutUnlockExpiry(l.Now())
+}
+
+func (l *Lock) mailURL(token string) string {
+	query := url.Values{FormValueToken: []string{token}}
+
+	if len(l.Config.Mail.RootURL) != 0 {
+		return fmt.Sprintf("%s?%s", l.Config.Mail.RootURL+"/lock/unlock/confirm", query.Encode())
+	}
+
+	p := path.Join(l.Config.Paths.Mount, "lock/unlock/confirm")
+	return fmt.Sprintf("%s%s?%s", l.Config.Paths.RootURL, p, query.Encode())
+}
+
+func (l *Lock) invalidToken(w http.ResponseWriter, r *http.Request) error {
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		Failure:      l.Localizef(r.Context(), authboss.TxtInvalidUnlockToken),
+		RedirectPath: l.Config.Paths.LockNotOK,
+	}
+	return l.Core.Redirector.Redirect(w, r, ro)
 }
 
 // Middleware ensures that a user is not locked, or else it will intercept
@@ -149,7 +486,7 @@ func Middleware(ab *authboss.Authboss) func(http.Handler) http.Handler {
 			user := ab.LoadCurrentUserP(&r)
 
 			lu := authboss.MustBeLockable(user)
-			if !IsLocked(lu) {
+			if !IsLockedAt(lu, ab.Now()) {
 				next.ServeHTTP(w, r)
 				return
 			}
@@ -170,5 +507,11 @@ func Middleware(ab *authboss.Authboss) func(http.Handler) http.Handler {
 
 // IsLocked checks if a user is locked
 func IsLocked(lu authboss.LockableUser) bool {
-	return lu.GetLocked().After(time.Now().UTC())
+	return IsLockedAt(lu, time.Now().UTC())
+}
+
+// IsLockedAt checks if a user is locked at a given time, the module uses
+// this with the time from Config.Core.Clock
+func IsLockedAt(lu authboss.LockableUser, now time.Time) bool {
+	return lu.GetLocked().After(now)
 }
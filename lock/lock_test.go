@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -22,6 +23,40 @@ func TestInit(t *testing.T) {
 	}
 }
 
+func TestInitUnlock(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+
+	router := &mocks.Router{}
+	renderer := &mocks.Renderer{}
+	mailRenderer := &mocks.Renderer{}
+	errHandler := &mocks.ErrorHandler{}
+	ab.Config.Core.Router = router
+	ab.Config.Core.ViewRenderer = renderer
+	ab.Config.Core.MailRenderer = mailRenderer
+	ab.Config.Core.ErrorHandler = errHandler
+	ab.Config.Storage.Server = mocks.NewServerStorer()
+
+	l := &Lock{}
+	if err := l.Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := renderer.HasLoadedViews(PageUnlock); err != nil {
+		t.Error(err)
+	}
+	if err := mailRenderer.HasLoadedViews(EmailLockedHTML, EmailLockedTxt); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasGets("/lock/unlock", "/lock/unlock/confirm"); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasPosts("/lock/unlock"); err != nil {
+		t.Error(err)
+	}
+}
+
 type testHarness struct {
 	lock *Lock
 	ab   *authboss.Authboss
@@ -48,9 +83,11 @@ func testSetup() *testHarness {
 	harness.storer = mocks.NewServerStorer()
 
 	harness.ab.Paths.LockNotOK = "/lock/not/ok"
+	harness.ab.Paths.UnlockOK = "/unlock/ok"
 	harness.ab.Modules.LockAfter = 3
 	harness.ab.Modules.LockDuration = time.Hour
 	harness.ab.Modules.LockWindow = time.Minute
+	harness.ab.Modules.MailNoGoroutine = true
 
 	harness.ab.Config.Core.BodyReader = harness.bodyReader
 	harness.ab.Config.Core.Logger = mocks.Logger{}
@@ -230,6 +267,383 @@ func TestAfterAuthFailure(t *testing.T) {
 	}
 }
 
+func TestAfterAuthFailureClock(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+	clock := mocks.NewClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	harness.ab.Config.Core.Clock = clock
+
+	user := &mocks.User{Email: "test@test.com"}
+	harness.storer.Users["test@test.com"] = user
+
+	// Failures outside of the window start the count over
+	harness.failAuth(t, user, 2)
+	clock.Advance(2 * time.Minute)
+	if harness.failAuth(t, user, 1) {
+		t.Error("should not be locked after the window passed")
+	}
+	if user.AttemptCount != 1 {
+		t.Error("attempt count should have been reset:", user.AttemptCount)
+	}
+
+	if !harness.failAuth(t, user, 2) {
+		t.Fatal("should be locked")
+	}
+	if want := clock.Now().Add(time.Hour); !user.Locked.Equal(want) {
+		t.Error("locked until wrong:", user.Locked)
+	}
+
+	clock.Advance(59 * time.Minute)
+	if !IsLockedAt(user, clock.Now()) {
+		t.Error("should still be locked")
+	}
+	clock.Advance(time.Minute + time.Second)
+	if IsLockedAt(user, clock.Now()) {
+		t.Error("the lock should have expired")
+	}
+
+	r := mocks.Request("GET")
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	if handled, err := harness.lock.BeforeAuth(httptest.NewRecorder(), r, false); err != nil || handled {
+		t.Error("an expired lock should not stop auth:", handled, err)
+	}
+}
+
+func (h *testHarness) failAuth(t *testing.T, user *mocks.User, times int) bool {
+	t.Helper()
+
+	var handled bool
+	for i := 0; i < times; i++ {
+		r := mocks.Request("GET")
+		r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+
+		var err error
+		handled, err = h.lock.AfterAuthFail(httptest.NewRecorder(), r, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return handled
+}
+
+func TestAfterAuthFailureUnlockEmail(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+
+	user := &mocks.User{Email: "test@test.com"}
+	harness.storer.Users["test@test.com"] = user
+
+	if !harness.failAuth(t, user, 3) {
+		t.Fatal("should have been locked")
+	}
+
+	if user.LockCount != 1 {
+		t.Error("lock count wrong:", user.LockCount)
+	}
+	if len(user.UnlockSelector) == 0 || len(user.UnlockVerifier) == 0 {
+		t.Error("unlock token should be stored")
+	}
+	if !user.UnlockExpiry.After(time.Now()) {
+		t.Error("unlock token should not be expired")
+	}
+
+	if to := harness.mailer.Email.To; len(to) != 1 || to[0] != "test@test.com" {
+		t.Error("e-mail sent to wrong address:", to)
+	}
+	if harness.mailer.Email.Subject != authboss.TxtLockedEmailSubject.Default {
+		t.Error("subject wrong:", harness.mailer.Email.Subject)
+	}
+	u := harness.renderer.Data[DataUnlockURL].(string)
+	if !strings.HasPrefix(u, "http://localhost:8080/auth/lock/unlock/confirm?token=") {
+		t.Error("url wrong:", u)
+	}
+}
+
+func TestAfterAuthFailurePolicy(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+	harness.ab.Modules.LockPolicy = BackoffPolicy{Base: time.Hour, PermanentAfter: 1}
+
+	user := &mocks.User{
+		Email: "test@test.com",
+		// A previous unlock link should stop working once permanently locked
+		UnlockSelector: "selector",
+		UnlockVerifier: "verifier",
+		LockCount:      1,
+	}
+	harness.storer.Users["test@test.com"] = user
+
+	if !harness.failAuth(t, user, 3) {
+		t.Fatal("should have been locked")
+	}
+
+	if !IsPermanentlyLocked(user) {
+		t.Error("should be locked permanently:", user.Locked)
+	}
+	if user.LockCount != 2 {
+		t.Error("lock count wrong:", user.LockCount)
+	}
+	if len(user.UnlockSelector) != 0 || len(user.UnlockVerifier) != 0 {
+		t.Error("unlock token should be cleared")
+	}
+	if len(harness.mailer.Email.To) != 0 {
+		t.Error("no unlock e-mail should be sent for a permanent lock")
+	}
+}
+
+func TestUnlockGet(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+
+	r := mocks.Request("GET")
+	w := httptest.NewRecorder()
+
+	if err := harness.lock.UnlockGet(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if harness.responder.Status != http.StatusOK {
+		t.Error("status wrong:", harness.responder.Status)
+	}
+	if harness.responder.Page != PageUnlock {
+		t.Error("page wrong:", harness.responder.Page)
+	}
+}
+
+func TestUnlockPost(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+
+	user := &mocks.User{
+		Email:  "test@test.com",
+		Locked: time.Now().UTC().Add(time.Hour),
+	}
+	harness.storer.Users["test@test.com"] = user
+
+	harness.bodyReader.Return = mocks.Values{PID: "test@test.com"}
+
+	r := mocks.Request("POST")
+	w := httptest.NewRecorder()
+
+	if err := harness.lock.UnlockPost(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := harness.redirector.Options
+	if opts.RedirectPath != "/unlock/ok" {
+		t.Error("redirect path wrong:", opts.RedirectPath)
+	}
+	if opts.Success != authboss.TxtUnlockInitiateSuccessFlash.Default {
+		t.Error("success wrong:", opts.Success)
+	}
+
+	if len(user.UnlockSelector) == 0 || len(user.UnlockVerifier) == 0 {
+		t.Error("unlock token should be stored")
+	}
+	if to := harness.mailer.Email.To; len(to) != 1 || to[0] != "test@test.com" {
+		t.Error("e-mail sent to wrong address:", to)
+	}
+}
+
+func TestUnlockPostNoEmail(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name   string
+		PID    string
+		Locked time.Time
+	}{
+		{"NotFound", "other@test.com", time.Now().UTC().Add(time.Hour)},
+		{"NotLocked", "test@test.com", time.Now().UTC().Add(-time.Hour)},
+		{"Permanent", "test@test.com", PermanentLock},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			harness := testSetup()
+
+			user := &mocks.User{
+				Email:  "test@test.com",
+				Locked: test.Locked,
+			}
+			harness.storer.Users["test@test.com"] = user
+
+			harness.bodyReader.Return = mocks.Values{PID: test.PID}
+
+			r := mocks.Request("POST")
+			w := httptest.NewRecorder()
+
+			if err := harness.lock.UnlockPost(w, r); err != nil {
+				t.Fatal(err)
+			}
+
+			// It should look the same as when the e-mail is sent
+			opts := harness.redirector.Options
+			if opts.RedirectPath != "/unlock/ok" {
+				t.Error("redirect path wrong:", opts.RedirectPath)
+			}
+			if opts.Success != authboss.TxtUnlockInitiateSuccessFlash.Default {
+				t.Error("success wrong:", opts.Success)
+			}
+
+			if len(user.UnlockSelector) != 0 {
+				t.Error("no unlock token should be stored")
+			}
+			if len(harness.mailer.Email.To) != 0 {
+				t.Error("no e-mail should be sent")
+			}
+		})
+	}
+}
+
+func (h *testHarness) putLockedUser(t *testing.T, locked, expiry time.Time) (*mocks.User, string) {
+	t.Helper()
+
+	selector, verifier, token, err := h.ab.Config.Core.OneTimeTokenGenerator.GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &mocks.User{
+		Email:          "test@test.com",
+		AttemptCount:   3,
+		LastAttempt:    time.Now().UTC(),
+		Locked:         locked,
+		LockCount:      2,
+		UnlockSelector: selector,
+		UnlockVerifier: verifier,
+		UnlockExpiry:   expiry,
+	}
+	h.storer.Users["test@test.com"] = user
+
+	return user, token
+}
+
+func TestConfirmUnlock(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+	user, token := harness.putLockedUser(t, time.Now().UTC().Add(time.Hour), time.Now().UTC().Add(time.Hour))
+
+	var before, after bool
+	harness.ab.Events.Before(authboss.EventUnlock, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		before = r.Context().Value(authboss.CTXKeyUser) != nil
+		return false, nil
+	})
+	harness.ab.Events.After(authboss.EventUnlock, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		after = true
+		return false, nil
+	})
+
+	harness.bodyReader.Return = mocks.Values{Token: token}
+
+	r := mocks.Request("GET")
+	w := httptest.NewRecorder()
+
+	if err := harness.lock.ConfirmUnlock(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if !before || !after {
+		t.Error("events should have fired with the user:", before, after)
+	}
+
+	opts := harness.redirector.Options
+	if opts.RedirectPath != "/unlock/ok" {
+		t.Error("redirect path wrong:", opts.RedirectPath)
+	}
+	if opts.Success != authboss.TxtUnlocked.Default {
+		t.Error("success wrong:", opts.Success)
+	}
+
+	if IsLocked(user) {
+		t.Error("should be unlocked")
+	}
+	if user.AttemptCount != 0 {
+		t.Error("attempt count should be reset:", user.AttemptCount)
+	}
+	if user.LockCount != 2 {
+		t.Error("lock count should be kept:", user.LockCount)
+	}
+	if len(user.UnlockSelector) != 0 || len(user.UnlockVerifier) != 0 {
+		t.Error("unlock token should be cleared")
+	}
+}
+
+func TestConfirmUnlockFailures(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name    string
+		Locked  time.Time
+		Expiry  time.Time
+		Token   func(token string) string
+		Failure string
+	}{
+		{
+			Name:    "Expired",
+			Locked:  time.Now().UTC().Add(time.Hour),
+			Expiry:  time.Now().UTC().Add(-time.Hour),
+			Failure: authboss.TxtInvalidUnlockToken.Default,
+		},
+		{
+			Name:    "Invalid",
+			Locked:  time.Now().UTC().Add(time.Hour),
+			Expiry:  time.Now().UTC().Add(time.Hour),
+			Token:   func(token string) string { return strings.Repeat("a", len(token)) },
+			Failure: authboss.TxtInvalidUnlockToken.Default,
+		},
+		{
+			Name:    "Permanent",
+			Locked:  PermanentLock,
+			Expiry:  time.Now().UTC().Add(time.Hour),
+			Failure: authboss.TxtLocked.Default,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			harness := testSetup()
+			user, token := harness.putLockedUser(t, test.Locked, test.Expiry)
+			if test.Token != nil {
+				token = test.Token(token)
+			}
+
+			harness.bodyReader.Return = mocks.Values{Token: token}
+
+			r := mocks.Request("GET")
+			w := httptest.NewRecorder()
+
+			if err := harness.lock.ConfirmUnlock(w, r); err != nil {
+				t.Fatal(err)
+			}
+
+			opts := harness.redirector.Options
+			if opts.RedirectPath != "/lock/not/ok" {
+				t.Error("redirect path wrong:", opts.RedirectPath)
+			}
+			if opts.Failure != test.Failure {
+				t.Error("failure wrong:", opts.Failure)
+			}
+			if !IsLocked(user) {
+				t.Error("should still be locked")
+			}
+		})
+	}
+}
+
 func TestLock(t *testing.T) {
 	t.Parallel()
 
@@ -259,8 +673,10 @@ func TestUnlock(t *testing.T) {
 	harness := testSetup()
 
 	user := &mocks.User{
-		Email:  "test@test.com",
-		Locked: time.Now().UTC().Add(time.Hour),
+		Email:          "test@test.com",
+		Locked:         PermanentLock,
+		LockCount:      3,
+		UnlockSelector: "selector",
 	}
 	harness.storer.Users["test@test.com"] = user
 
@@ -275,6 +691,12 @@ func TestUnlock(t *testing.T) {
 	if IsLocked(harness.storer.Users["test@test.com"]) {
 		t.Error("should no longer be locked")
 	}
+	if user.LockCount != 0 {
+		t.Error("lock count should be reset:", user.LockCount)
+	}
+	if len(user.UnlockSelector) != 0 {
+		t.Error("unlock token should be cleared")
+	}
 }
 
 func TestMiddlewareAllow(t *testing.T) {
@@ -0,0 +1,74 @@
+package lock
+
+import (
+	"math"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// PermanentLock is what a user's locked time is set to when the
+// LockPolicy locks them permanently. It's far enough in the future to
+// never pass but still storable by most databases.
+var PermanentLock = time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+// maxDuration is the longest time.Duration, BackoffPolicy stops doubling
+// at it when there is no Max.
+const maxDuration = time.Duration(math.MaxInt64)
+
+// FixedPolicy locks a user for the same Duration every time, it's used
+// with Modules.LockDuration when Modules.LockPolicy is not set.
+type FixedPolicy struct {
+	Duration time.Duration
+}
+
+// LockDuration is always Duration
+func (f FixedPolicy) LockDuration(count int) (time.Duration, bool) {
+	return f.Duration, false
+}
+
+// BackoffPolicy locks a user for Base the first time and doubles it each
+// time they're locked again before logging in successfully.
+type BackoffPolicy struct {
+	// Base is how long the first lock lasts
+	Base time.Duration
+	// Max caps how long a lock lasts, zero means no cap
+	Max time.Duration
+	// PermanentAfter is the number of locks after which the user is locked
+	// permanently and must be unlocked by an administrator, zero means
+	// they're never locked permanently. This requires the user to
+	// implement authboss.LockableUserWithLockCount.
+	PermanentAfter int
+}
+
+// LockDuration doubles Base for each lock after the first
+func (b BackoffPolicy) LockDuration(count int) (time.Duration, bool) {
+	if b.PermanentAfter > 0 && count > b.PermanentAfter {
+		return 0, true
+	}
+
+	duration := b.Base
+	for i := 1; i < count; i++ {
+		if b.Max > 0 && duration >= b.Max {
+			break
+		}
+		// Stop before overflowing when there is no cap
+		if duration > maxDuration/2 {
+			duration = maxDuration
+			break
+		}
+		duration *= 2
+	}
+
+	if b.Max > 0 && duration > b.Max {
+		duration = b.Max
+	}
+
+	return duration, false
+}
+
+// IsPermanentlyLocked checks if a user was locked permanently by the
+// LockPolicy, these users can only be unlocked with Lock.Unlock.
+func IsPermanentlyLocked(lu authboss.LockableUser) bool {
+	return !lu.GetLocked().Before(PermanentLock)
+}
@@ -0,0 +1,59 @@
+package lock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFixedPolicy(t *testing.T) {
+	t.Parallel()
+
+	p := FixedPolicy{Duration: time.Hour}
+	for _, count := range []int{1, 2, 100} {
+		if d, permanent := p.LockDuration(count); d != time.Hour || permanent {
+			t.Errorf("%d) wrong: %v %t", count, d, permanent)
+		}
+	}
+}
+
+func TestBackoffPolicy(t *testing.T) {
+	t.Parallel()
+
+	p := BackoffPolicy{Base: time.Minute, Max: 10 * time.Minute, PermanentAfter: 6}
+
+	tests := []struct {
+		Count     int
+		Duration  time.Duration
+		Permanent bool
+	}{
+		{1, time.Minute, false},
+		{2, 2 * time.Minute, false},
+		{3, 4 * time.Minute, false},
+		{4, 8 * time.Minute, false},
+		{5, 10 * time.Minute, false},
+		{6, 10 * time.Minute, false},
+		{7, 0, true},
+	}
+
+	for _, test := range tests {
+		d, permanent := p.LockDuration(test.Count)
+		if d != test.Duration || permanent != test.Permanent {
+			t.Errorf("%d) wrong: %v %t", test.Count, d, permanent)
+		}
+	}
+
+	// Without a cap it should stop doubling before overflowing
+	p = BackoffPolicy{Base: time.Hour}
+	if d, _ := p.LockDuration(1000); d != maxDuration {
+		t.Error("duration overflowed:", d)
+	}
+
+	// Including when a doubling lands exactly on 1<<62
+	p = BackoffPolicy{Base: 1 << 61}
+	if d, _ := p.LockDuration(2); d != 1<<62 {
+		t.Error("duration wrong:", d)
+	}
+	if d, _ := p.LockDuration(3); d != maxDuration {
+		t.Error("duration overflowed:", d)
+	}
+}
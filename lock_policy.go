@@ -0,0 +1,12 @@
+package authboss
+
+import "time"
+
+// LockPolicy decides how long a user is locked for, the lock package has
+// fixed and exponential backoff policies.
+type LockPolicy interface {
+	// LockDuration returns how long to lock a user for the count'th time
+	// in a row (starting at 1). A permanent lock can only be undone by
+	// an administrator with lock.Unlock.
+	LockDuration(count int) (duration time.Duration, permanent bool)
+}
@@ -0,0 +1,283 @@
+// Package magiclink allows users to log in with a link sent to their e-mail
+// address instead of a password.
+package magiclink
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/confirm"
+)
+
+// Constants for templates etc.
+const (
+	// PageMagicLink is the page where a user requests a magic link
+	PageMagicLink = "magiclink"
+	// PageMagicLinkLogin is only really used for the BodyReader
+	PageMagicLinkLogin = "magiclink_login"
+
+	// EmailMagicLinkHTML is the name of the html template for magic link
+	// e-mails
+	EmailMagicLinkHTML = "magiclink_html"
+	// EmailMagicLinkTxt is the name of the text template for magic link
+	// e-mails
+	EmailMagicLinkTxt = "magiclink_txt"
+
+	// FormValueToken is the name of the query parameter that holds the
+	// token in the magic link
+	FormValueToken = "token"
+
+	// DataMagicLinkURL is the name of the e-mail template variable
+	// that gives the url to send to the user for logging in.
+	DataMagicLinkURL = "url"
+)
+
+func init() {
+	authboss.RegisterModule("magiclink", &MagicLink{})
+}
+
+// MagicLink module
+type MagicLink struct {
+	*authboss.Authboss
+}
+
+// Init module
+func (m *MagicLink) Init(ab *authboss.Authboss) error {
+	m.Authboss = ab
+
+	if err := m.Config.Core.ViewRenderer.Load(PageMagicLink); err != nil {
+		return err
+	}
+
+	if err := m.Config.Core.MailRenderer.Load(EmailMagicLinkHTML, EmailMagicLinkTxt); err != nil {
+		return err
+	}
+
+	m.Config.Core.Router.Get("/magiclink", m.Core.ErrorHandler.Wrap(m.Get))
+	m.Config.Core.Router.Post("/magiclink", m.Core.ErrorHandler.Wrap(m.Post))
+
+	var callbackMethod func(string, http.Handler)
+	switch m.Config.Modules.MailRouteMethod {
+	case http.MethodGet:
+		callbackMethod = m.Config.Core.Router.Get
+	case http.MethodPost:
+		callbackMethod = m.Config.Core.Router.Post
+	default:
+		panic("invalid config for MailRouteMethod")
+	}
+	callbackMethod("/magiclink/login", m.Core.ErrorHandler.Wrap(m.Login))
+
+	return nil
+}
+
+// Get the page to request a magic link
+func (m *MagicLink) Get(w http.ResponseWriter, r *http.Request) error {
+	return m.Config.Core.Responder.Respond(w, r, http.StatusOK, PageMagicLink, nil)
+}
+
+// Post sends a magic link to the user's e-mail address
+func (m *MagicLink) Post(w http.ResponseWriter, r *http.Request) error {
+	logger := m.RequestLogger(r)
+
+	validatable, err := m.Core.BodyReader.Read(PageMagicLink, r)
+	if err != nil {
+		return err
+	}
+
+	if errs := validatable.Validate(); errs != nil {
+		logger.Info("magic link validation failed")
+		data := authboss.HTMLData{authboss.DataValidation: authboss.ErrorMap(errs)}
+		return m.Core.Responder.Respond(w, r, http.StatusOK, PageMagicLink, data)
+	}
+
+	pid := authboss.MustHaveMagicLinkStartValues(validatable).GetPID()
+
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: m.Config.Paths.MagicLinkOK,
+		Success:      m.Localizef(r.Context(), authboss.TxtMagicLinkSent),
+	}
+
+	abUser, err := m.Storage.Server.Load(r.Context(), pid)
+	if err == authboss.ErrUserNotFound {
+		logger.Infof("user %s requested a magic link, user does not exist, faking successful response", pid)
+		return m.Core.Redirector.Redirect(w, r, ro)
+	} else if err != nil {
+		return err
+	}
+
+	user := authboss.MustBeMagicLinkable(abUser)
+
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, abUser))
+	handled, err := m.Events.FireBefore(authboss.EventMagicLinkStart, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	selector, verifier, token, err := m.Config.Core.OneTimeTokenGenerator.GenerateToken()
+	if err != nil {
+		return err
+	}
+
+	user.PutMagicLinkSelector(selector)
+	user.PutMagicLinkVerifier(verifier)
+	user.PutMagicLinkExpiry(m.Now().Add(m.Config.Modules.MagicLinkTokenDuration))
+
+	if err := m.Storage.Server.Save(r.Context(), user); err != nil {
+		return err
+	}
+
+	var remember bool
+	if rm, ok := validatable.(authboss.RememberValuer); ok {
+		remember = rm.GetShouldRemember()
+	}
+
+	if m.Modules.MailNoGoroutine {
+		m.SendMagicLinkEmail(r.Context(), user.GetEmail(), token, remember)
+	} else {
+		go m.SendMagicLinkEmail(r.Context(), user.GetEmail(), token, remember)
+	}
+
+	if _, err := m.Events.FireAfter(authboss.EventMagicLinkStart, w, r); err != nil {
+		return err
+	}
+
+	logger.Infof("user %s magic link sent", user.GetPID())
+	return m.Core.Redirector.Redirect(w, r, ro)
+}
+
+// SendMagicLinkEmail to a specific e-mail address passing along the token
+// in an escaped URL to the templates.
+func (m *MagicLink) SendMagicLinkEmail(ctx context.Context, to, token string, remember bool) {
+	logger := m.Logger(ctx)
+
+	email := authboss.Email{
+		To:       []string{to},
+		From:     m.Config.Mail.From,
+		FromName: m.Config.Mail.FromName,
+		Subject:  m.Config.Mail.SubjectPrefix + m.Localizef(ctx, authboss.TxtMagicLinkEmailSubject),
+	}
+
+	ro := authboss.EmailResponseOptions{
+		Data:         authboss.NewHTMLData(DataMagicLinkURL, m.mailURL(token, remember)),
+		HTMLTemplate: EmailMagicLinkHTML,
+		TextTemplate: EmailMagicLinkTxt,
+	}
+
+	logger.Infof("sending magic link e-mail to: %s", to)
+	if err := m.Email(ctx, email, ro); err != nil {
+		logger.Errorf("failed to send magic link e-mail to %s: %+v", to, err)
+	}
+}
+
+// Login the user with the token from the magic link. The token is used up
+// before the auth events are fired so it can only ever be used once.
+func (m *MagicLink) Login(w http.ResponseWriter, r *http.Request) error {
+	logger := m.RequestLogger(r)
+
+	validatable, err := m.Config.Core.BodyReader.Read(PageMagicLinkLogin, r)
+	if err != nil {
+		return err
+	}
+
+	if errs := validatable.Validate(); errs != nil {
+		logger.Infof("validation failed in MagicLink.Login, this typically means a bad token: %+v", errs)
+		return m.invalidToken(w, r)
+	}
+
+	values := authboss.MustHaveMagicLinkValues(validatable)
+
+	selector, verifier, err := confirm.ParseToken(m.Config.Core.OneTimeTokenGenerator, values.GetToken())
+	if err != nil {
+		logger.Infof("invalid magic link token submitted: %+v", err)
+		return m.invalidToken(w, r)
+	}
+
+	storer := authboss.EnsureCanMagicLink(m.Config.Storage.Server)
+	user, err := storer.LoadByMagicLinkSelector(r.Context(), selector)
+	if err == authboss.ErrUserNotFound {
+		logger.Infof("magic link selector was not found in database: %s", selector)
+		return m.invalidToken(w, r)
+	} else if err != nil {
+		return err
+	}
+
+	if !confirm.VerifierMatches(user.GetMagicLinkVerifier(), verifier) {
+		logger.Info("stored magic link verifier does not match provided one")
+		return m.invalidToken(w, r)
+	}
+
+	if m.Now().After(user.GetMagicLinkExpiry()) {
+		logger.Infof("magic link for user %s has expired", user.GetPID())
+		return m.invalidToken(w, r)
+	}
+
+	user.PutMagicLinkSelector("")
+	user.PutMagicLinkVerifier("")
+	user.PutMagicLinkExpiry(m.Now())
+	if err = m.Storage.Server.Save(r.Context(), user); err != nil {
+		return err
+	}
+
+	pid := user.GetPID()
+
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyValues, validatable))
+
+	handled, err := m.Events.FireBefore(authboss.EventAuth, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	handled, err = m.Events.FireBefore(authboss.EventAuthHijack, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	logger.Infof("user %s logged in with a magic link", pid)
+	authboss.PutSession(w, authboss.SessionKey, pid)
+	authboss.DelSession(w, authboss.SessionHalfAuthKey)
+
+	handled, err = m.Events.FireAfter(authboss.EventAuth, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	ro := authboss.RedirectOptions{
+		Code:             http.StatusTemporaryRedirect,
+		RedirectPath:     m.Config.Paths.AuthLoginOK,
+		FollowRedirParam: true,
+	}
+	return m.Core.Redirector.Redirect(w, r, ro)
+}
+
+func (m *MagicLink) mailURL(token string, remember bool) string {
+	query := url.Values{FormValueToken: []string{token}}
+	if remember {
+		query.Set(authboss.CookieRemember, "true")
+	}
+
+	if len(m.Config.Mail.RootURL) != 0 {
+		return fmt.Sprintf("%s?%s", m.Config.Mail.RootURL+"/magiclink/login", query.Encode())
+	}
+
+	p := path.Join(m.Config.Paths.Mount, "magiclink/login")
+	return fmt.Sprintf("%s%s?%s", m.Config.Paths.RootURL, p, query.Encode())
+}
+
+func (m *MagicLink) invalidToken(w http.ResponseWriter, r *http.Request) error {
+	data := authboss.HTMLData{authboss.DataErr: m.Localizef(r.Context(), authboss.TxtInvalidMagicLinkToken)}
+	return m.Config.Core.Responder.Respond(w, r, http.StatusOK, PageMagicLink, data)
+}
@@ -0,0 +1,416 @@
+package magiclink
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+
+	router := &mocks.Router{}
+	renderer := &mocks.Renderer{}
+	mailRenderer := &mocks.Renderer{}
+	errHandler := &mocks.ErrorHandler{}
+	ab.Config.Core.Router = router
+	ab.Config.Core.ViewRenderer = renderer
+	ab.Config.Core.MailRenderer = mailRenderer
+	ab.Config.Core.ErrorHandler = errHandler
+
+	m := &MagicLink{}
+	if err := m.Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := renderer.HasLoadedViews(PageMagicLink); err != nil {
+		t.Error(err)
+	}
+	if err := mailRenderer.HasLoadedViews(EmailMagicLinkHTML, EmailMagicLinkTxt); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasGets("/magiclink", "/magiclink/login"); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasPosts("/magiclink"); err != nil {
+		t.Error(err)
+	}
+}
+
+type testHarness struct {
+	magiclink *MagicLink
+	ab        *authboss.Authboss
+
+	bodyReader *mocks.BodyReader
+	mailer     *mocks.Emailer
+	redirector *mocks.Redirector
+	renderer   *mocks.Renderer
+	responder  *mocks.Responder
+	session    *mocks.ClientStateRW
+	storer     *mocks.ServerStorer
+}
+
+func testSetup() *testHarness {
+	harness := &testHarness{}
+
+	harness.ab = authboss.New()
+	harness.bodyReader = &mocks.BodyReader{}
+	harness.mailer = &mocks.Emailer{}
+	harness.redirector = &mocks.Redirector{}
+	harness.renderer = &mocks.Renderer{}
+	harness.responder = &mocks.Responder{}
+	harness.session = mocks.NewClientRW()
+	harness.storer = mocks.NewServerStorer()
+
+	harness.ab.Paths.AuthLoginOK = "/login/ok"
+	harness.ab.Paths.MagicLinkOK = "/magiclink/ok"
+	harness.ab.Modules.MailNoGoroutine = true
+
+	harness.ab.Config.Core.BodyReader = harness.bodyReader
+	harness.ab.Config.Core.Logger = mocks.Logger{}
+	harness.ab.Config.Core.Mailer = harness.mailer
+	harness.ab.Config.Core.Redirector = harness.redirector
+	harness.ab.Config.Core.MailRenderer = harness.renderer
+	harness.ab.Config.Core.Responder = harness.responder
+	harness.ab.Config.Storage.SessionState = harness.session
+	harness.ab.Config.Storage.CookieState = mocks.NewClientRW()
+	harness.ab.Config.Storage.Server = harness.storer
+
+	harness.magiclink = &MagicLink{harness.ab}
+
+	return harness
+}
+
+func (h *testHarness) loadClientState(w http.ResponseWriter, r **http.Request) {
+	req, err := h.ab.LoadClientState(w, *r)
+	if err != nil {
+		panic(err)
+	}
+
+	*r = req
+}
+
+func TestGet(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	r := mocks.Request("GET")
+	w := httptest.NewRecorder()
+
+	if err := h.magiclink.Get(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.responder.Page != PageMagicLink {
+		t.Error("page wrong:", h.responder.Page)
+	}
+}
+
+func TestPostSuccess(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	user := &mocks.User{Email: "test@test.com"}
+	h.storer.Users["test@test.com"] = user
+
+	h.bodyReader.Return = mocks.Values{PID: "test@test.com", Remember: true}
+
+	r := mocks.Request("POST")
+	w := httptest.NewRecorder()
+
+	if err := h.magiclink.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Error("code wrong:", w.Code)
+	}
+	opts := h.redirector.Options
+	if opts.RedirectPath != "/magiclink/ok" {
+		t.Error("redirect path wrong:", opts.RedirectPath)
+	}
+	if len(opts.Success) == 0 {
+		t.Error("should have a success message")
+	}
+
+	if len(user.MagicLinkSelector) == 0 || len(user.MagicLinkVerifier) == 0 {
+		t.Error("token should be stored")
+	}
+	if !user.MagicLinkExpiry.After(time.Now()) {
+		t.Error("expiry should be in the future:", user.MagicLinkExpiry)
+	}
+
+	if to := h.mailer.Email.To[0]; to != "test@test.com" {
+		t.Error("e-mail sent to wrong address:", to)
+	}
+	if url := h.renderer.Data[DataMagicLinkURL].(string); len(url) == 0 {
+		t.Error("url should be given to the template")
+	}
+}
+
+func TestPostUserNotFound(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	h.bodyReader.Return = mocks.Values{PID: "unknown@test.com"}
+
+	r := mocks.Request("POST")
+	w := httptest.NewRecorder()
+
+	if err := h.magiclink.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := h.redirector.Options
+	if opts.RedirectPath != "/magiclink/ok" {
+		t.Error("redirect path wrong:", opts.RedirectPath)
+	}
+	if len(opts.Success) == 0 {
+		t.Error("should fake a success message")
+	}
+	if len(h.mailer.Email.To) != 0 {
+		t.Error("should not send an e-mail")
+	}
+}
+
+func TestPostHandled(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	user := &mocks.User{Email: "test@test.com"}
+	h.storer.Users["test@test.com"] = user
+
+	var gotUser authboss.User
+	h.ab.Events.Before(authboss.EventMagicLinkStart, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		gotUser = r.Context().Value(authboss.CTXKeyUser).(authboss.User)
+		return true, nil
+	})
+
+	h.bodyReader.Return = mocks.Values{PID: "test@test.com"}
+
+	if err := h.magiclink.Post(httptest.NewRecorder(), mocks.Request("POST")); err != nil {
+		t.Fatal(err)
+	}
+
+	if gotUser == nil || gotUser.GetPID() != "test@test.com" {
+		t.Error("the user should be in the context")
+	}
+	if len(user.MagicLinkSelector) != 0 {
+		t.Error("no token should be stored")
+	}
+	if len(h.mailer.Email.To) != 0 {
+		t.Error("should not send an e-mail")
+	}
+}
+
+func TestPostValidationFailure(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	h.bodyReader.Return = mocks.Values{
+		Errors: []error{errors.New("invalid e-mail")},
+	}
+
+	r := mocks.Request("POST")
+	w := httptest.NewRecorder()
+
+	if err := h.magiclink.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.responder.Page != PageMagicLink {
+		t.Error("page wrong:", h.responder.Page)
+	}
+	errList := h.responder.Data[authboss.DataValidation].(map[string][]string)
+	if e := errList[""][0]; e != "invalid e-mail" {
+		t.Error("validation error wrong:", e)
+	}
+}
+
+func TestMailURL(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Config.Paths.RootURL = "https://example.com"
+	h.ab.Config.Paths.Mount = "/auth"
+
+	if u := h.magiclink.mailURL("abc", false); u != "https://example.com/auth/magiclink/login?token=abc" {
+		t.Error("url wrong:", u)
+	}
+	if u := h.magiclink.mailURL("abc", true); u != "https://example.com/auth/magiclink/login?rm=true&token=abc" {
+		t.Error("url wrong:", u)
+	}
+
+	h.ab.Config.Mail.RootURL = "https://mail.example.com/auth"
+	if u := h.magiclink.mailURL("abc", false); u != "https://mail.example.com/auth/magiclink/login?token=abc" {
+		t.Error("url wrong:", u)
+	}
+}
+
+func (h *testHarness) putLinkedUser(t *testing.T, expiry time.Time) (*mocks.User, string) {
+	t.Helper()
+
+	selector, verifier, token, err := h.ab.Config.Core.OneTimeTokenGenerator.GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &mocks.User{
+		Email:             "test@test.com",
+		MagicLinkSelector: selector,
+		MagicLinkVerifier: verifier,
+		MagicLinkExpiry:   expiry,
+	}
+	h.storer.Users["test@test.com"] = user
+
+	return user, token
+}
+
+func TestLoginSuccess(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	user, token := h.putLinkedUser(t, time.Now().Add(time.Hour))
+
+	var beforeAuth, hijack, afterAuth, remember bool
+	h.ab.Events.Before(authboss.EventAuth, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		beforeAuth = r.Context().Value(authboss.CTXKeyUser) != nil
+		return false, nil
+	})
+	h.ab.Events.Before(authboss.EventAuthHijack, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		hijack = true
+		return false, nil
+	})
+	h.ab.Events.After(authboss.EventAuth, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		afterAuth = true
+		rm, ok := r.Context().Value(authboss.CTXKeyValues).(authboss.RememberValuer)
+		remember = ok && rm.GetShouldRemember()
+		return false, nil
+	})
+
+	h.bodyReader.Return = mocks.Values{Token: token, Remember: true}
+
+	r := mocks.Request("GET")
+	resp := httptest.NewRecorder()
+	w := h.ab.NewResponse(resp)
+	h.loadClientState(w, &r)
+
+	if err := h.magiclink.Login(w, r); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if resp.Code != http.StatusTemporaryRedirect {
+		t.Error("code wrong:", resp.Code)
+	}
+	opts := h.redirector.Options
+	if opts.RedirectPath != "/login/ok" {
+		t.Error("redirect path wrong:", opts.RedirectPath)
+	}
+	if !opts.FollowRedirParam {
+		t.Error("should follow the redir param")
+	}
+
+	if !beforeAuth || !hijack || !afterAuth {
+		t.Error("events should have fired:", beforeAuth, hijack, afterAuth)
+	}
+	if !remember {
+		t.Error("remember values should be in the context")
+	}
+	if pid := h.session.ClientValues[authboss.SessionKey]; pid != "test@test.com" {
+		t.Error("user should be logged in:", pid)
+	}
+	if len(user.MagicLinkSelector) != 0 || len(user.MagicLinkVerifier) != 0 {
+		t.Error("token should be used up")
+	}
+}
+
+func TestLoginHijacked(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	user, token := h.putLinkedUser(t, time.Now().Add(time.Hour))
+
+	h.ab.Events.Before(authboss.EventAuthHijack, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		return true, nil
+	})
+
+	h.bodyReader.Return = mocks.Values{Token: token}
+
+	r := mocks.Request("GET")
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	h.loadClientState(w, &r)
+
+	if err := h.magiclink.Login(w, r); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if _, ok := h.session.ClientValues[authboss.SessionKey]; ok {
+		t.Error("user should not be logged in")
+	}
+	if len(user.MagicLinkSelector) != 0 {
+		t.Error("token should be used up even when the login is hijacked")
+	}
+}
+
+func TestLoginExpired(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	user, token := h.putLinkedUser(t, time.Now().Add(-time.Hour))
+
+	h.bodyReader.Return = mocks.Values{Token: token}
+
+	r := mocks.Request("GET")
+	w := httptest.NewRecorder()
+
+	if err := h.magiclink.Login(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.responder.Page != PageMagicLink {
+		t.Error("page wrong:", h.responder.Page)
+	}
+	if h.responder.Data[authboss.DataErr] != authboss.TxtInvalidMagicLinkToken.Default {
+		t.Error("error wrong:", h.responder.Data[authboss.DataErr])
+	}
+	if len(user.MagicLinkSelector) == 0 {
+		t.Error("token should not be touched")
+	}
+}
+
+func TestLoginInvalidToken(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.putLinkedUser(t, time.Now().Add(time.Hour))
+
+	for _, token := range []string{"5", "dGVzdA=="} {
+		h.bodyReader.Return = mocks.Values{Token: token}
+		h.responder.Data = nil
+
+		r := mocks.Request("GET")
+		w := httptest.NewRecorder()
+
+		if err := h.magiclink.Login(w, r); err != nil {
+			t.Fatal(err)
+		}
+
+		if h.responder.Data[authboss.DataErr] != authboss.TxtInvalidMagicLinkToken.Default {
+			t.Errorf("%q error wrong: %v", token, h.responder.Data[authboss.DataErr])
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/friendsofgo/errors"
@@ -16,18 +17,29 @@ import (
 
 // User represents all possible fields a authboss User may have
 type User struct {
-	Username           string
-	Email              string
-	Password           string
-	RecoverSelector    string
-	RecoverVerifier    string
-	RecoverTokenExpiry time.Time
-	ConfirmSelector    string
-	ConfirmVerifier    string
-	Confirmed          bool
-	AttemptCount       int
-	LastAttempt        time.Time
-	Locked             time.Time
+	Username            string
+	Email               string
+	Password            string
+	RecoverSelector     string
+	RecoverVerifier     string
+	RecoverTokenExpiry  time.Time
+	ConfirmSelector     string
+	ConfirmVerifier     string
+	Confirmed           bool
+	PendingEmail        string
+	EmailChangeSelector string
+	EmailChangeVerifier string
+	EmailChangeExpiry   time.Time
+	MagicLinkSelector   string
+	MagicLinkVerifier   string
+	MagicLinkExpiry     time.Time
+	AttemptCount        int
+	LastAttempt         time.Time
+	Locked              time.Time
+	LockCount           int
+	UnlockSelector      string
+	UnlockVerifier      string
+	UnlockExpiry        time.Time
 
 	OAuth2UID      string
 	OAuth2Provider string
@@ -43,6 +55,8 @@ type User struct {
 
 	SMSPhoneNumberSeed string
 
+	WebAuthnCredentials string
+
 	Arbitrary map[string]string
 }
 
@@ -76,6 +90,27 @@ func (u User) GetConfirmVerifier() string { return u.ConfirmVerifier }
 // GetConfirmed from user
 func (u User) GetConfirmed() bool { return u.Confirmed }
 
+// GetPendingEmail from user
+func (u User) GetPendingEmail() string { return u.PendingEmail }
+
+// GetMagicLinkSelector from user
+func (u User) GetMagicLinkSelector() string { return u.MagicLinkSelector }
+
+// GetMagicLinkVerifier from user
+func (u User) GetMagicLinkVerifier() string { return u.MagicLinkVerifier }
+
+// GetMagicLinkExpiry from user
+func (u User) GetMagicLinkExpiry() time.Time { return u.MagicLinkExpiry }
+
+// GetEmailChangeSelector from user
+func (u User) GetEmailChangeSelector() string { return u.EmailChangeSelector }
+
+// GetEmailChangeVerifier from user
+func (u User) GetEmailChangeVerifier() string { return u.EmailChangeVerifier }
+
+// GetEmailChangeExpiry from user
+func (u User) GetEmailChangeExpiry() time.Time { return u.EmailChangeExpiry }
+
 // GetAttemptCount from user
 func (u User) GetAttemptCount() int { return u.AttemptCount }
 
@@ -85,6 +120,18 @@ func (u User) GetLastAttempt() time.Time { return u.LastAttempt }
 // GetLocked from user
 func (u User) GetLocked() time.Time { return u.Locked }
 
+// GetLockCount from user
+func (u User) GetLockCount() int { return u.LockCount }
+
+// GetUnlockSelector from user
+func (u User) GetUnlockSelector() string { return u.UnlockSelector }
+
+// GetUnlockVerifier from user
+func (u User) GetUnlockVerifier() string { return u.UnlockVerifier }
+
+// GetUnlockExpiry from user
+func (u User) GetUnlockExpiry() time.Time { return u.UnlockExpiry }
+
 // IsOAuth2User returns true if the user is an oauth2 user
 func (u User) IsOAuth2User() bool { return len(u.OAuth2Provider) != 0 }
 
@@ -124,6 +171,9 @@ func (u User) GetSMSPhoneNumberSeed() string { return u.SMSPhoneNumberSeed }
 // GetRecoveryCodes from user
 func (u User) GetRecoveryCodes() string { return u.RecoveryCodes }
 
+// GetWebAuthnCredentials from user
+func (u User) GetWebAuthnCredentials() string { return u.WebAuthnCredentials }
+
 // PutPID into user
 func (u *User) PutPID(email string) { u.Email = email }
 
@@ -156,6 +206,27 @@ func (u *User) PutConfirmVerifier(confirmVerifier string) { u.ConfirmVerifier =
 // PutConfirmed into user
 func (u *User) PutConfirmed(confirmed bool) { u.Confirmed = confirmed }
 
+// PutPendingEmail into user
+func (u *User) PutPendingEmail(email string) { u.PendingEmail = email }
+
+// PutMagicLinkSelector into user
+func (u *User) PutMagicLinkSelector(selector string) { u.MagicLinkSelector = selector }
+
+// PutMagicLinkVerifier into user
+func (u *User) PutMagicLinkVerifier(verifier string) { u.MagicLinkVerifier = verifier }
+
+// PutMagicLinkExpiry into user
+func (u *User) PutMagicLinkExpiry(expiry time.Time) { u.MagicLinkExpiry = expiry }
+
+// PutEmailChangeSelector into user
+func (u *User) PutEmailChangeSelector(selector string) { u.EmailChangeSelector = selector }
+
+// PutEmailChangeVerifier into user
+func (u *User) PutEmailChangeVerifier(verifier string) { u.EmailChangeVerifier = verifier }
+
+// PutEmailChangeExpiry into user
+func (u *User) PutEmailChangeExpiry(expiry time.Time) { u.EmailChangeExpiry = expiry }
+
 // PutAttemptCount into user
 func (u *User) PutAttemptCount(attemptCount int) { u.AttemptCount = attemptCount }
 
@@ -165,6 +236,18 @@ func (u *User) PutLastAttempt(attemptTime time.Time) { u.LastAttempt = attemptTi
 // PutLocked into user
 func (u *User) PutLocked(locked time.Time) { u.Locked = locked }
 
+// PutLockCount into user
+func (u *User) PutLockCount(count int) { u.LockCount = count }
+
+// PutUnlockSelector into user
+func (u *User) PutUnlockSelector(selector string) { u.UnlockSelector = selector }
+
+// PutUnlockVerifier into user
+func (u *User) PutUnlockVerifier(verifier string) { u.UnlockVerifier = verifier }
+
+// PutUnlockExpiry into user
+func (u *User) PutUnlockExpiry(expiry time.Time) { u.UnlockExpiry = expiry }
+
 // PutOAuth2UID into user
 func (u *User) PutOAuth2UID(uid string) { u.OAuth2UID = uid }
 
@@ -198,10 +281,20 @@ func (u *User) PutSMSPhoneNumber(number string) { u.SMSPhoneNumber = number }
 // PutRecoveryCodes into user
 func (u *User) PutRecoveryCodes(codes string) { u.RecoveryCodes = codes }
 
+// PutWebAuthnCredentials into user
+func (u *User) PutWebAuthnCredentials(creds string) { u.WebAuthnCredentials = creds }
+
 // ServerStorer should be valid for any module storer defined in authboss.
 type ServerStorer struct {
 	Users    map[string]*User
 	RMTokens map[string][]string
+	Sessions map[string]authboss.SessionRecord
+	APIKeys  map[string]authboss.APIKey
+
+	OAuth2Identities map[string]authboss.OAuth2Identity
+
+	RefreshTokens map[string]map[string]time.Time
+	RevokedTokens map[string]time.Time
 }
 
 // NewServerStorer constructor
@@ -209,6 +302,13 @@ func NewServerStorer() *ServerStorer {
 	return &ServerStorer{
 		Users:    make(map[string]*User),
 		RMTokens: make(map[string][]string),
+		Sessions: make(map[string]authboss.SessionRecord),
+		APIKeys:  make(map[string]authboss.APIKey),
+
+		OAuth2Identities: make(map[string]authboss.OAuth2Identity),
+
+		RefreshTokens: make(map[string]map[string]time.Time),
+		RevokedTokens: make(map[string]time.Time),
 	}
 }
 
@@ -247,11 +347,77 @@ func (s *ServerStorer) Save(ctx context.Context, user authboss.User) error {
 	return nil
 }
 
+// Delete a user along with their tokens, sessions and api keys
+func (s *ServerStorer) Delete(ctx context.Context, key string) error {
+	if _, ok := s.Users[key]; !ok {
+		return authboss.ErrUserNotFound
+	}
+
+	delete(s.Users, key)
+	delete(s.RMTokens, key)
+	delete(s.RefreshTokens, key)
+	for id, record := range s.Sessions {
+		if record.PID == key {
+			delete(s.Sessions, id)
+		}
+	}
+	for id, apiKey := range s.APIKeys {
+		if apiKey.PID == key {
+			delete(s.APIKeys, id)
+		}
+	}
+	for id, identity := range s.OAuth2Identities {
+		if identity.PID == key {
+			delete(s.OAuth2Identities, id)
+		}
+	}
+	return nil
+}
+
+// ChangePID moves and saves a user along with their sessions and api keys,
+// their tokens are deleted
+func (s *ServerStorer) ChangePID(ctx context.Context, oldPID string, user authboss.User) error {
+	u := user.(*User)
+	newPID := u.GetPID()
+
+	if _, ok := s.Users[oldPID]; !ok {
+		return authboss.ErrUserNotFound
+	}
+	if _, ok := s.Users[newPID]; ok {
+		return authboss.ErrUserFound
+	}
+
+	delete(s.Users, oldPID)
+	s.Users[newPID] = u
+
+	delete(s.RMTokens, oldPID)
+	delete(s.RefreshTokens, oldPID)
+	for id, record := range s.Sessions {
+		if record.PID == oldPID {
+			record.PID = newPID
+			s.Sessions[id] = record
+		}
+	}
+	for id, apiKey := range s.APIKeys {
+		if apiKey.PID == oldPID {
+			apiKey.PID = newPID
+			s.APIKeys[id] = apiKey
+		}
+	}
+	for id, identity := range s.OAuth2Identities {
+		if identity.PID == oldPID {
+			identity.PID = newPID
+			s.OAuth2Identities[id] = identity
+		}
+	}
+	return nil
+}
+
 // NewFromOAuth2 finds a user with the given details, or returns a new one
 func (s *ServerStorer) NewFromOAuth2(ctx context.Context, provider string, details map[string]string) (authboss.OAuth2User, error) {
-	uid := details["uid"]
-	email := details["email"]
-	name := details["name"]
+	uid := details[authboss.OAuth2UID]
+	email := details[authboss.OAuth2Email]
+	name := details[authboss.OAuth2Name]
 	pid := authboss.MakeOAuth2PID(provider, uid)
 
 	u, ok := s.Users[pid]
@@ -291,6 +457,28 @@ func (s *ServerStorer) LoadByConfirmSelector(ctx context.Context, selector strin
 	return nil, authboss.ErrUserNotFound
 }
 
+// LoadByMagicLinkSelector finds a user by his magic link token
+func (s *ServerStorer) LoadByMagicLinkSelector(ctx context.Context, selector string) (authboss.MagicLinkableUser, error) {
+	for _, v := range s.Users {
+		if v.MagicLinkSelector == selector {
+			return v, nil
+		}
+	}
+
+	return nil, authboss.ErrUserNotFound
+}
+
+// LoadByEmailChangeSelector finds a user by his e-mail change token
+func (s *ServerStorer) LoadByEmailChangeSelector(ctx context.Context, selector string) (authboss.EmailChangeableUser, error) {
+	for _, v := range s.Users {
+		if v.EmailChangeSelector == selector {
+			return v, nil
+		}
+	}
+
+	return nil, authboss.ErrUserNotFound
+}
+
 // LoadByRecoverSelector finds a user by his recover token
 func (s *ServerStorer) LoadByRecoverSelector(ctx context.Context, selector string) (authboss.RecoverableUser, error) {
 	for _, v := range s.Users {
@@ -302,6 +490,17 @@ func (s *ServerStorer) LoadByRecoverSelector(ctx context.Context, selector strin
 	return nil, authboss.ErrUserNotFound
 }
 
+// LoadByUnlockSelector finds a user by their unlock token
+func (s *ServerStorer) LoadByUnlockSelector(ctx context.Context, selector string) (authboss.LockableUserWithUnlockToken, error) {
+	for _, v := range s.Users {
+		if v.UnlockSelector == selector {
+			return v, nil
+		}
+	}
+
+	return nil, authboss.ErrUserNotFound
+}
+
 // AddRememberToken for remember me
 func (s *ServerStorer) AddRememberToken(ctx context.Context, key, token string) error {
 	arr := s.RMTokens[key]
@@ -338,6 +537,187 @@ func (s *ServerStorer) UseRememberToken(ctx context.Context, givenKey, token str
 	return authboss.ErrTokenNotFound
 }
 
+// AddRefreshToken for a user
+func (s *ServerStorer) AddRefreshToken(ctx context.Context, key, token string, expires time.Time) error {
+	if s.RefreshTokens[key] == nil {
+		s.RefreshTokens[key] = make(map[string]time.Time)
+	}
+	s.RefreshTokens[key][token] = expires
+	return nil
+}
+
+// UseRefreshToken if it exists and has not expired, deleting it in the process
+func (s *ServerStorer) UseRefreshToken(ctx context.Context, key, token string) error {
+	expires, ok := s.RefreshTokens[key][token]
+	if !ok {
+		return authboss.ErrTokenNotFound
+	}
+
+	delete(s.RefreshTokens[key], token)
+	if time.Now().After(expires) {
+		return authboss.ErrTokenNotFound
+	}
+	return nil
+}
+
+// DelRefreshTokens for a user
+func (s *ServerStorer) DelRefreshTokens(ctx context.Context, key string) error {
+	delete(s.RefreshTokens, key)
+	return nil
+}
+
+// RevokeAccessToken by id
+func (s *ServerStorer) RevokeAccessToken(ctx context.Context, id string, expires time.Time) error {
+	s.RevokedTokens[id] = expires
+	return nil
+}
+
+// IsAccessTokenRevoked by id
+func (s *ServerStorer) IsAccessTokenRevoked(ctx context.Context, id string) (bool, error) {
+	_, ok := s.RevokedTokens[id]
+	return ok, nil
+}
+
+// CreateSession record
+func (s *ServerStorer) CreateSession(ctx context.Context, record authboss.SessionRecord) error {
+	s.Sessions[record.ID] = record
+	return nil
+}
+
+// LoadSession record
+func (s *ServerStorer) LoadSession(ctx context.Context, id string) (authboss.SessionRecord, error) {
+	record, ok := s.Sessions[id]
+	if !ok {
+		return authboss.SessionRecord{}, authboss.ErrSessionNotFound
+	}
+
+	return record, nil
+}
+
+// ListSessions for a user
+func (s *ServerStorer) ListSessions(ctx context.Context, pid string) ([]authboss.SessionRecord, error) {
+	var records []authboss.SessionRecord
+	for _, record := range s.Sessions {
+		if record.PID == pid {
+			records = append(records, record)
+		}
+	}
+
+	return records, nil
+}
+
+// TouchSession record
+func (s *ServerStorer) TouchSession(ctx context.Context, id string, lastSeen time.Time) error {
+	record, ok := s.Sessions[id]
+	if !ok {
+		return authboss.ErrSessionNotFound
+	}
+
+	record.LastSeen = lastSeen
+	s.Sessions[id] = record
+	return nil
+}
+
+// DeleteSession record
+func (s *ServerStorer) DeleteSession(ctx context.Context, id string) error {
+	delete(s.Sessions, id)
+	return nil
+}
+
+// CreateAPIKey for a user
+func (s *ServerStorer) CreateAPIKey(ctx context.Context, key authboss.APIKey) error {
+	s.APIKeys[key.ID] = key
+	return nil
+}
+
+// LoadAPIKey by id
+func (s *ServerStorer) LoadAPIKey(ctx context.Context, id string) (authboss.APIKey, error) {
+	key, ok := s.APIKeys[id]
+	if !ok {
+		return authboss.APIKey{}, authboss.ErrAPIKeyNotFound
+	}
+
+	return key, nil
+}
+
+// LoadAPIKeyByHash of the key
+func (s *ServerStorer) LoadAPIKeyByHash(ctx context.Context, hash string) (authboss.APIKey, error) {
+	for _, key := range s.APIKeys {
+		if key.Hash == hash {
+			return key, nil
+		}
+	}
+
+	return authboss.APIKey{}, authboss.ErrAPIKeyNotFound
+}
+
+// ListAPIKeys for a user
+func (s *ServerStorer) ListAPIKeys(ctx context.Context, pid string) ([]authboss.APIKey, error) {
+	var keys []authboss.APIKey
+	for _, key := range s.APIKeys {
+		if key.PID == pid {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+// TouchAPIKey updates the last used time
+func (s *ServerStorer) TouchAPIKey(ctx context.Context, id string, lastUsed time.Time) error {
+	key, ok := s.APIKeys[id]
+	if !ok {
+		return authboss.ErrAPIKeyNotFound
+	}
+
+	key.LastUsed = lastUsed
+	s.APIKeys[id] = key
+	return nil
+}
+
+// DeleteAPIKey by id
+func (s *ServerStorer) DeleteAPIKey(ctx context.Context, id string) error {
+	delete(s.APIKeys, id)
+	return nil
+}
+
+// LoadByOAuth2Identity finds the user the identity is linked to
+func (s *ServerStorer) LoadByOAuth2Identity(ctx context.Context, provider, uid string) (authboss.User, error) {
+	identity, ok := s.OAuth2Identities[authboss.MakeOAuth2PID(provider, uid)]
+	if !ok {
+		return nil, authboss.ErrUserNotFound
+	}
+
+	return s.Load(ctx, identity.PID)
+}
+
+// LinkOAuth2Identity to a user
+func (s *ServerStorer) LinkOAuth2Identity(ctx context.Context, identity authboss.OAuth2Identity) error {
+	s.OAuth2Identities[authboss.MakeOAuth2PID(identity.Provider, identity.UID)] = identity
+	return nil
+}
+
+// UnlinkOAuth2Identity from a user
+func (s *ServerStorer) UnlinkOAuth2Identity(ctx context.Context, pid, provider, uid string) error {
+	key := authboss.MakeOAuth2PID(provider, uid)
+	if identity, ok := s.OAuth2Identities[key]; ok && identity.PID == pid {
+		delete(s.OAuth2Identities, key)
+	}
+	return nil
+}
+
+// ListOAuth2Identities linked to a user
+func (s *ServerStorer) ListOAuth2Identities(ctx context.Context, pid string) ([]authboss.OAuth2Identity, error) {
+	var identities []authboss.OAuth2Identity
+	for _, identity := range s.OAuth2Identities {
+		if identity.PID == pid {
+			identities = append(identities, identity)
+		}
+	}
+
+	return identities, nil
+}
+
 // FailStorer is used for testing module initialize functions that
 // recover more than the base storer
 type FailStorer struct {
@@ -595,13 +975,23 @@ func (b BodyReader) Read(page string, r *http.Request) (authboss.Validator, erro
 
 // Values is returned from the BodyReader
 type Values struct {
-	PID         string
-	Password    string
-	Token       string
-	Code        string
-	Recovery    string
-	PhoneNumber string
-	Remember    bool
+	PID             string
+	Email           string
+	Password        string
+	CurrentPassword string
+	Token           string
+	Code            string
+	Recovery        string
+	PhoneNumber     string
+	Credential      string
+	SessionID       string
+	RefreshToken    string
+	APIKeyID        string
+	Name            string
+	Scopes          []string
+	Provider        string
+	UID             string
+	Remember        bool
 
 	Errors []error
 }
@@ -611,11 +1001,21 @@ func (v Values) GetPID() string {
 	return v.PID
 }
 
+// GetEmail from values
+func (v Values) GetEmail() string {
+	return v.Email
+}
+
 // GetPassword from values
 func (v Values) GetPassword() string {
 	return v.Password
 }
 
+// GetCurrentPassword from values
+func (v Values) GetCurrentPassword() string {
+	return v.CurrentPassword
+}
+
 // GetToken from values
 func (v Values) GetToken() string {
 	return v.Token
@@ -636,6 +1036,46 @@ func (v Values) GetRecoveryCode() string {
 	return v.Recovery
 }
 
+// GetCredential from values
+func (v Values) GetCredential() string {
+	return v.Credential
+}
+
+// GetSessionID from values
+func (v Values) GetSessionID() string {
+	return v.SessionID
+}
+
+// GetRefreshToken from values
+func (v Values) GetRefreshToken() string {
+	return v.RefreshToken
+}
+
+// GetAPIKeyID from values
+func (v Values) GetAPIKeyID() string {
+	return v.APIKeyID
+}
+
+// GetName from values
+func (v Values) GetName() string {
+	return v.Name
+}
+
+// GetScopes from values
+func (v Values) GetScopes() []string {
+	return v.Scopes
+}
+
+// GetProvider from values
+func (v Values) GetProvider() string {
+	return v.Provider
+}
+
+// GetUID from values
+func (v Values) GetUID() string {
+	return v.UID
+}
+
 // GetShouldRemember gets the value that tells
 // the remember module if it should remember the user
 func (v Values) GetShouldRemember() bool {
@@ -768,3 +1208,38 @@ func (m Hasher) GenerateHash(s string) (string, error) {
 func (m Hasher) CompareHashAndPassword(hashedPassword, password string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
 }
+
+// Clock is an authboss.Clock that only moves when it's told to
+type Clock struct {
+	mut sync.Mutex
+	now time.Time
+}
+
+// NewClock creates a clock set to a time
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns the clock's time
+func (c *Clock) Now() time.Time {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	return c.now
+}
+
+// Advance the clock
+func (c *Clock) Advance(d time.Duration) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// Set the clock's time
+func (c *Clock) Set(now time.Time) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	c.now = now
+}
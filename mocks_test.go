@@ -103,6 +103,56 @@ func (m *mockServerStorer) LoadByRecoverSelector(ctx context.Context, selector s
 }
 func (m *mockServerStorer) SaveOAuth2(ctx context.Context, user OAuth2User) error { panic("not impl") }
 
+type mockSessionServerStorer struct {
+	*mockServerStorer
+
+	Sessions map[string]SessionRecord
+}
+
+func newMockSessionServerStorer() *mockSessionServerStorer {
+	return &mockSessionServerStorer{
+		mockServerStorer: newMockServerStorer(),
+		Sessions:         make(map[string]SessionRecord),
+	}
+}
+
+func (m *mockSessionServerStorer) CreateSession(ctx context.Context, record SessionRecord) error {
+	m.Sessions[record.ID] = record
+	return nil
+}
+
+func (m *mockSessionServerStorer) LoadSession(ctx context.Context, id string) (SessionRecord, error) {
+	record, ok := m.Sessions[id]
+	if !ok {
+		return SessionRecord{}, ErrSessionNotFound
+	}
+
+	return record, nil
+}
+
+func (m *mockSessionServerStorer) ListSessions(ctx context.Context, pid string) ([]SessionRecord, error) {
+	var records []SessionRecord
+	for _, record := range m.Sessions {
+		if record.PID == pid {
+			records = append(records, record)
+		}
+	}
+
+	return records, nil
+}
+
+func (m *mockSessionServerStorer) TouchSession(ctx context.Context, id string, lastSeen time.Time) error {
+	record := m.Sessions[id]
+	record.LastSeen = lastSeen
+	m.Sessions[id] = record
+	return nil
+}
+
+func (m *mockSessionServerStorer) DeleteSession(ctx context.Context, id string) error {
+	delete(m.Sessions, id)
+	return nil
+}
+
 func (m mockUser) GetPID() string                             { return m.Email }
 func (m mockUser) GetEmail() string                           { return m.Email }
 func (m mockUser) GetUsername() string                        { return m.Username }
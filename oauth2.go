@@ -23,9 +23,19 @@ authenticated token to be created, a call is then made to a known endpoint that
 return details about the user we've retrieved the token for. Those details are returned
 as a map[string]string and subsequently passed into OAuth2ServerStorer.NewFromOAuth2.
 API this must be handled for each provider separately.
+
+OIDCIssuer makes the provider an OpenID Connect provider. The oauth2 module loads
+the issuer's discovery document to fill in the OAuth2Config's Endpoint (if it's empty),
+and the user's details come from the claims of the verified id token so FindUserDetails
+is optional. If FindUserDetails is given its details are preferred over the claims.
+
+PKCE enables Proof Key for Code Exchange (RFC 7636) using the S256 method, some
+providers require it even for confidential clients.
 */
 type OAuth2Provider struct {
 	OAuth2Config     *oauth2.Config
 	AdditionalParams url.Values
 	FindUserDetails  func(context.Context, oauth2.Config, *oauth2.Token) (map[string]string, error)
+	OIDCIssuer       string
+	PKCE             bool
 }
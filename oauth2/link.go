@@ -0,0 +1,303 @@
+package oauth2
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/friendsofgo/errors"
+	"golang.org/x/oauth2"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// Pages
+const (
+	PageOAuth2Links = "oauth2_links"
+	// PageOAuth2Unlink is only really used for the BodyReader
+	PageOAuth2Unlink = "oauth2_unlink"
+)
+
+// Data constants
+const (
+	// DataOAuth2Identities is the list of the user's OAuth2Identities,
+	// their tokens are removed.
+	DataOAuth2Identities = "oauth2_identities"
+	// DataOAuth2Providers is the sorted list of providers that can be linked
+	DataOAuth2Providers = "oauth2_providers"
+)
+
+// OAuth2Link module allows logged in users to link identities from the
+// oauth2 providers to their account so they can log in with them. It
+// requires the oauth2 module to be loaded as well since that handles
+// the callback from the provider.
+type OAuth2Link struct {
+	*authboss.Authboss
+}
+
+// UnlinkValuer returns the identity to unlink
+type UnlinkValuer interface {
+	authboss.Validator
+
+	GetProvider() string
+	GetUID() string
+}
+
+// MustHaveUnlinkValues upgrades a validatable set of values
+// to ones specific to unlinking an identity.
+func MustHaveUnlinkValues(v authboss.Validator) UnlinkValuer {
+	if u, ok := v.(UnlinkValuer); ok {
+		return u
+	}
+
+	panic(fmt.Sprintf("bodyreader returned a type that could not be upgraded to UnlinkValuer: %T", v))
+}
+
+func init() {
+	authboss.RegisterModule("oauth2link", &OAuth2Link{})
+}
+
+// Init module
+func (l *OAuth2Link) Init(ab *authboss.Authboss) error {
+	l.Authboss = ab
+
+	// Ensure the storer is capable before anything is registered
+	_ = authboss.EnsureCanLinkOAuth2(l.Config.Storage.Server)
+
+	if err := l.Core.ViewRenderer.Load(PageOAuth2Links); err != nil {
+		return err
+	}
+
+	var unauthedResponse authboss.MWRespondOnFailure
+	if l.Config.Modules.ResponseOnUnauthed != 0 {
+		unauthedResponse = l.Config.Modules.ResponseOnUnauthed
+	} else if l.Config.Modules.RoutesRedirectOnUnauthed {
+		unauthedResponse = authboss.RespondRedirect
+	}
+	middleware := authboss.MountedMiddleware2(l.Authboss, true, authboss.RequireFullAuth, unauthedResponse)
+
+	l.Core.Router.Get("/oauth2/links", middleware(l.Core.ErrorHandler.Wrap(l.Get)))
+	l.Core.Router.Post("/oauth2/unlink", middleware(l.Core.ErrorHandler.Wrap(l.PostUnlink)))
+
+	for _, provider := range l.providers() {
+		link := fmt.Sprintf("/oauth2/link/%s", provider)
+		l.Core.Router.Get(link, middleware(l.Core.ErrorHandler.Wrap(l.Start)))
+	}
+
+	return nil
+}
+
+// Get lists the identities linked to the current user
+func (l *OAuth2Link) Get(w http.ResponseWriter, r *http.Request) error {
+	storer := authboss.EnsureCanLinkOAuth2(l.Config.Storage.Server)
+
+	identities, err := storer.ListOAuth2Identities(r.Context(), l.CurrentUserIDP(r))
+	if err != nil {
+		return err
+	}
+
+	// The tokens have no use in a view, don't hand them out
+	for i := range identities {
+		identities[i].AccessToken = ""
+		identities[i].RefreshToken = ""
+	}
+
+	data := authboss.HTMLData{
+		DataOAuth2Identities: identities,
+		DataOAuth2Providers:  l.providers(),
+	}
+	return l.Core.Responder.Respond(w, r, http.StatusOK, PageOAuth2Links, data)
+}
+
+// Start the oauth2 flow to link an identity to the current user, the
+// provider redirects back to the oauth2 module's callback which finishes
+// the linking.
+func (l *OAuth2Link) Start(w http.ResponseWriter, r *http.Request) error {
+	return (&OAuth2{l.Authboss}).start(w, r, true)
+}
+
+// PostUnlink removes one of the current user's identities. An identity
+// cannot be unlinked when it's the only way the user can log in.
+func (l *OAuth2Link) PostUnlink(w http.ResponseWriter, r *http.Request) error {
+	logger := l.RequestLogger(r)
+
+	validatable, err := l.Core.BodyReader.Read(PageOAuth2Unlink, r)
+	if err != nil {
+		return err
+	}
+	values := MustHaveUnlinkValues(validatable)
+	provider, uid := values.GetProvider(), values.GetUID()
+
+	user, err := l.CurrentUser(r)
+	if err != nil {
+		return err
+	}
+	pid := user.GetPID()
+
+	storer := authboss.EnsureCanLinkOAuth2(l.Config.Storage.Server)
+	identities, err := storer.ListOAuth2Identities(r.Context(), pid)
+	if err != nil {
+		return err
+	}
+
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: l.Paths.OAuth2LinkOK,
+	}
+
+	if _, ok := findIdentity(identities, provider, uid); !ok {
+		logger.Infof("user %s tried to unlink an identity that does not exist or is not theirs", pid)
+		ro.Failure = l.Localizef(r.Context(), authboss.TxtOAuth2IdentityNotFound)
+		return l.Core.Redirector.Redirect(w, r, ro)
+	}
+
+	if len(identities) == 1 && !hasOtherLogin(user) {
+		logger.Infof("user %s tried to unlink their only login", pid)
+		ro.Failure = l.Localizef(r.Context(), authboss.TxtOAuth2CannotUnlink)
+		return l.Core.Redirector.Redirect(w, r, ro)
+	}
+
+	if err = storer.UnlinkOAuth2Identity(r.Context(), pid, provider, uid); err != nil {
+		return err
+	}
+
+	logger.Infof("user %s unlinked their %s identity", pid, provider)
+
+	ro.Success = l.Localizef(r.Context(), authboss.TxtOAuth2Unlinked, provider)
+	return l.Core.Redirector.Redirect(w, r, ro)
+}
+
+func (l *OAuth2Link) providers() []string {
+	var providers []string
+	for provider := range l.Config.Modules.OAuth2Providers {
+		providers = append(providers, strings.ToLower(provider))
+	}
+	sort.Strings(providers)
+
+	return providers
+}
+
+// hasOtherLogin checks if the user can log in without a linked identity,
+// either with a password or because they're an oauth2 user themselves.
+func hasOtherLogin(user authboss.User) bool {
+	if _, _, err := authboss.ParseOAuth2PID(user.GetPID()); err == nil {
+		return true
+	}
+
+	authUser, ok := user.(authboss.AuthableUser)
+	return ok && len(authUser.GetPassword()) != 0
+}
+
+func findIdentity(identities []authboss.OAuth2Identity, provider, uid string) (authboss.OAuth2Identity, bool) {
+	for _, identity := range identities {
+		if identity.Provider == provider && identity.UID == uid {
+			return identity, true
+		}
+	}
+
+	return authboss.OAuth2Identity{}, false
+}
+
+// link the identity to the logged in user, this is called by End when the
+// flow was started by OAuth2Link.Start
+func (o *OAuth2) link(w http.ResponseWriter, r *http.Request, provider string, details map[string]string, token *oauth2.Token, redirect string) error {
+	logger := o.Authboss.RequestLogger(r)
+
+	pid, err := o.Authboss.CurrentUserID(r)
+	if err != nil {
+		return err
+	} else if len(pid) == 0 {
+		return errors.New("oauth2 identity link finished without a logged in user")
+	}
+
+	uid := details[OAuth2UID]
+	storer := authboss.EnsureCanLinkOAuth2(o.Authboss.Config.Storage.Server)
+
+	if len(redirect) == 0 {
+		redirect = o.Authboss.Config.Paths.OAuth2LinkOK
+	}
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: redirect,
+	}
+
+	linked, err := storer.LoadByOAuth2Identity(r.Context(), provider, uid)
+	if err == nil && linked.GetPID() != pid {
+		logger.Infof("user %s tried to link a %s identity that belongs to %s", pid, provider, linked.GetPID())
+		ro.Failure = o.Localizef(r.Context(), authboss.TxtOAuth2IdentityInUse, provider)
+		return o.Authboss.Core.Redirector.Redirect(w, r, ro)
+	} else if err != nil && err != authboss.ErrUserNotFound {
+		return err
+	}
+
+	identity := authboss.OAuth2Identity{
+		Provider:  provider,
+		UID:       uid,
+		PID:       pid,
+		CreatedAt: o.Now(),
+	}
+	if err == nil {
+		// Linking again only refreshes the identity
+		identities, err := storer.ListOAuth2Identities(r.Context(), pid)
+		if err != nil {
+			return err
+		}
+		if existing, ok := findIdentity(identities, provider, uid); ok {
+			identity = existing
+		}
+	}
+	if err = updateIdentity(r.Context(), storer, identity, details, token); err != nil {
+		return err
+	}
+
+	logger.Infof("user %s linked their %s identity", pid, provider)
+
+	ro.Success = o.Localizef(r.Context(), authboss.TxtOAuth2Linked, provider)
+	return o.Authboss.Core.Redirector.Redirect(w, r, ro)
+}
+
+// loadLinkedUser returns the user the identity has been linked to, or nil
+// if the storer can't link identities or it has not been linked. The
+// identity's tokens are updated.
+func (o *OAuth2) loadLinkedUser(ctx context.Context, provider string, details map[string]string, token *oauth2.Token) (authboss.User, error) {
+	storer, ok := o.Authboss.Config.Storage.Server.(authboss.OAuth2LinkingServerStorer)
+	if !ok {
+		return nil, nil
+	}
+
+	uid := details[OAuth2UID]
+	user, err := storer.LoadByOAuth2Identity(ctx, provider, uid)
+	if err == authboss.ErrUserNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	identities, err := storer.ListOAuth2Identities(ctx, user.GetPID())
+	if err != nil {
+		return nil, err
+	}
+	identity, ok := findIdentity(identities, provider, uid)
+	if !ok {
+		return nil, errors.Errorf("linked %s identity not in list of identities for %s", provider, user.GetPID())
+	}
+
+	if err = updateIdentity(ctx, storer, identity, details, token); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func updateIdentity(ctx context.Context, storer authboss.OAuth2LinkingServerStorer, identity authboss.OAuth2Identity, details map[string]string, token *oauth2.Token) error {
+	identity.Email = details[OAuth2Email]
+	identity.AccessToken = token.AccessToken
+	identity.Expiry = token.Expiry
+	if len(token.RefreshToken) != 0 {
+		identity.RefreshToken = token.RefreshToken
+	}
+
+	return storer.LinkOAuth2Identity(ctx, identity)
+}
@@ -0,0 +1,354 @@
+package oauth2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+func TestLinkInit(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+
+	router := &mocks.Router{}
+	renderer := &mocks.Renderer{}
+	ab.Config.Modules.OAuth2Providers = testProviders
+	ab.Config.Core.Router = router
+	ab.Config.Core.ViewRenderer = renderer
+	ab.Config.Core.ErrorHandler = &mocks.ErrorHandler{}
+	ab.Config.Storage.Server = mocks.NewServerStorer()
+
+	link := &OAuth2Link{}
+	if err := link.Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := renderer.HasLoadedViews(PageOAuth2Links); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasGets("/oauth2/links", "/oauth2/link/facebook", "/oauth2/link/google"); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasPosts("/oauth2/unlink"); err != nil {
+		t.Error(err)
+	}
+}
+
+type linkHarness struct {
+	*testHarness
+
+	link       *OAuth2Link
+	bodyReader *mocks.BodyReader
+	responder  *mocks.Responder
+}
+
+func linkSetup() *linkHarness {
+	h := &linkHarness{testHarness: testSetup()}
+
+	h.bodyReader = &mocks.BodyReader{}
+	h.responder = &mocks.Responder{}
+
+	h.ab.Paths.OAuth2LinkOK = "/auth/oauth2/links"
+	h.ab.Config.Core.BodyReader = h.bodyReader
+	h.ab.Config.Core.Responder = h.responder
+
+	h.storer.Users["test@test.com"] = &mocks.User{Email: "test@test.com", Password: "hash"}
+	h.link = &OAuth2Link{h.ab}
+
+	return h
+}
+
+func (h *linkHarness) putIdentity(provider, uid, pid string) {
+	h.storer.OAuth2Identities[authboss.MakeOAuth2PID(provider, uid)] = authboss.OAuth2Identity{
+		Provider:    provider,
+		UID:         uid,
+		PID:         pid,
+		AccessToken: "secret",
+	}
+}
+
+func TestLinkGet(t *testing.T) {
+	t.Parallel()
+
+	h := linkSetup()
+	h.putIdentity("google", "id", "test@test.com")
+	h.putIdentity("google", "other", "other@test.com")
+
+	r := mocks.Request("GET")
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyPID, "test@test.com"))
+
+	if err := h.link.Get(httptest.NewRecorder(), r); err != nil {
+		t.Fatal(err)
+	}
+
+	identities := h.responder.Data[DataOAuth2Identities].([]authboss.OAuth2Identity)
+	if len(identities) != 1 || identities[0].UID != "id" {
+		t.Errorf("identities wrong: %#v", identities)
+	}
+	if len(identities[0].AccessToken) != 0 {
+		t.Error("tokens should not be given to the view")
+	}
+	if providers := h.responder.Data[DataOAuth2Providers].([]string); len(providers) != 2 || providers[0] != "facebook" {
+		t.Error("providers wrong:", providers)
+	}
+}
+
+func TestLinkStart(t *testing.T) {
+	t.Parallel()
+
+	h := linkSetup()
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	r := httptest.NewRequest("GET", "/oauth2/link/google", nil)
+
+	if err := h.link.Start(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.session.ClientValues[authboss.SessionOAuth2Link] != "google" {
+		t.Error("the provider should be stored in the session")
+	}
+	if len(h.session.ClientValues[authboss.SessionOAuth2State]) == 0 {
+		t.Error("it should start the oauth2 flow")
+	}
+}
+
+func TestStartForgetsLink(t *testing.T) {
+	t.Parallel()
+
+	h := linkSetup()
+
+	// A link that was started but never finished
+	h.session.ClientValues[authboss.SessionOAuth2Link] = "google"
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/google", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.oauth.Start(w, r); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK) // Flush headers
+
+	if _, ok := h.session.ClientValues[authboss.SessionOAuth2Link]; ok {
+		t.Error("the unfinished link should be removed from the session")
+	}
+	if len(h.session.ClientValues[authboss.SessionOAuth2State]) == 0 {
+		t.Error("it should start the oauth2 flow")
+	}
+}
+
+func (h *linkHarness) endLink(t *testing.T, pid string) {
+	t.Helper()
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+
+	h.session.ClientValues[authboss.SessionKey] = pid
+	h.session.ClientValues[authboss.SessionOAuth2State] = "state"
+	h.session.ClientValues[authboss.SessionOAuth2Link] = "google"
+	r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/google?state=state", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.oauth.End(w, r); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK) // Flush headers
+}
+
+func TestLinkEnd(t *testing.T) {
+	t.Parallel()
+
+	h := linkSetup()
+	h.endLink(t, "test@test.com")
+
+	identity, ok := h.storer.OAuth2Identities[authboss.MakeOAuth2PID("google", "id")]
+	if !ok {
+		t.Fatal("identity should be linked")
+	}
+	if identity.PID != "test@test.com" || identity.AccessToken != "token" || identity.CreatedAt.IsZero() {
+		t.Errorf("identity wrong: %#v", identity)
+	}
+
+	opts := h.redirector.Options
+	if opts.RedirectPath != "/auth/oauth2/links" {
+		t.Error("redirect path wrong:", opts.RedirectPath)
+	}
+	if len(opts.Success) == 0 {
+		t.Error("should have a success message")
+	}
+	if s := h.session.ClientValues[authboss.SessionKey]; s != "test@test.com" {
+		t.Error("the user should still be logged in as themselves:", s)
+	}
+	if _, ok := h.session.ClientValues[authboss.SessionOAuth2Link]; ok {
+		t.Error("the link should be removed from the session")
+	}
+	if _, ok := h.storer.Users["oauth2;;google;;id"]; ok {
+		t.Error("no oauth2 user should be created")
+	}
+}
+
+func TestLinkEndInUse(t *testing.T) {
+	t.Parallel()
+
+	h := linkSetup()
+	h.storer.Users["other@test.com"] = &mocks.User{Email: "other@test.com"}
+	h.putIdentity("google", "id", "other@test.com")
+
+	h.endLink(t, "test@test.com")
+
+	if identity := h.storer.OAuth2Identities[authboss.MakeOAuth2PID("google", "id")]; identity.PID != "other@test.com" {
+		t.Error("the identity should not be moved:", identity.PID)
+	}
+	if opts := h.redirector.Options; len(opts.Failure) == 0 {
+		t.Error("should have a failure message")
+	}
+}
+
+func TestEndLinkedIdentity(t *testing.T) {
+	t.Parallel()
+
+	h := linkSetup()
+	h.putIdentity("google", "id", "test@test.com")
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+
+	h.session.ClientValues[authboss.SessionOAuth2State] = "state"
+	r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/google?state=state", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.oauth.End(w, r); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK) // Flush headers
+
+	if s := h.session.ClientValues[authboss.SessionKey]; s != "test@test.com" {
+		t.Error("it should log in as the linked user:", s)
+	}
+	if _, ok := h.storer.Users["oauth2;;google;;id"]; ok {
+		t.Error("no oauth2 user should be created")
+	}
+	if identity := h.storer.OAuth2Identities[authboss.MakeOAuth2PID("google", "id")]; identity.AccessToken != "token" {
+		t.Error("the identity's token should be updated:", identity.AccessToken)
+	}
+}
+
+func TestEndLinkedIdentityHijacked(t *testing.T) {
+	t.Parallel()
+
+	h := linkSetup()
+	h.putIdentity("google", "id", "test@test.com")
+
+	var query string
+	h.ab.Events.Before(authboss.EventAuthHijack, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		query = r.URL.RawQuery
+		w.WriteHeader(http.StatusTeapot)
+		return true, nil
+	})
+
+	rec := httptest.NewRecorder()
+	w := h.ab.NewResponse(rec)
+
+	h.session.ClientValues[authboss.SessionOAuth2State] = "state"
+	h.session.ClientValues[authboss.SessionOAuth2Params] = `{"redir":"/somewhere"}`
+	r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/google?state=state&code=code", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.oauth.End(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec.Code != http.StatusTeapot {
+		t.Error("the hijacking hook should have responded:", rec.Code)
+	}
+	if query != "redir=%2Fsomewhere" {
+		t.Error("only the redirect should be passed to the hook:", query)
+	}
+	if _, ok := h.session.ClientValues[authboss.SessionKey]; ok {
+		t.Error("the user should not be logged in")
+	}
+}
+
+func TestEndHijackOnlyLinked(t *testing.T) {
+	t.Parallel()
+
+	h := linkSetup()
+
+	hijacked := false
+	h.ab.Events.Before(authboss.EventAuthHijack, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		hijacked = true
+		return true, nil
+	})
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+
+	h.session.ClientValues[authboss.SessionOAuth2State] = "state"
+	r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/google?state=state", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.oauth.End(w, r); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK) // Flush headers
+
+	if hijacked {
+		t.Error("oauth2 users have no second factor to ask for")
+	}
+	if s := h.session.ClientValues[authboss.SessionKey]; s != "oauth2;;google;;id" {
+		t.Error("the oauth2 user should be logged in:", s)
+	}
+}
+
+func TestPostUnlink(t *testing.T) {
+	t.Parallel()
+
+	h := linkSetup()
+	h.putIdentity("google", "id", "test@test.com")
+	h.putIdentity("google", "other", "other@test.com")
+
+	tests := []struct {
+		User     *mocks.User
+		UID      string
+		Unlinked bool
+	}{
+		// Not theirs
+		{h.storer.Users["test@test.com"], "other", false},
+		// Only way to log in
+		{&mocks.User{Email: "test@test.com"}, "id", false},
+		{h.storer.Users["test@test.com"], "id", true},
+	}
+
+	for i, test := range tests {
+		h.redirector.Options = authboss.RedirectOptions{}
+		h.bodyReader.Return = mocks.Values{Provider: "google", UID: test.UID}
+
+		r := mocks.Request("POST")
+		r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, test.User))
+
+		if err := h.link.PostUnlink(httptest.NewRecorder(), r); err != nil {
+			t.Fatal(err)
+		}
+
+		_, linked := h.storer.OAuth2Identities[authboss.MakeOAuth2PID("google", test.UID)]
+		if linked == test.Unlinked {
+			t.Errorf("%d) linked wrong: %t", i, linked)
+		}
+		if opts := h.redirector.Options; test.Unlinked != (len(opts.Success) != 0) {
+			t.Errorf("%d) message wrong: %#v", i, opts)
+		}
+	}
+}
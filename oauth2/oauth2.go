@@ -9,7 +9,8 @@
 //     parameters and generally checks that everything is ok. It uses the
 //     token received to get an access token from the oauth2 library
 //  3. Calls the OAuth2Provider.FindUserDetails which should return the user's
-//     details in a generic form.
+//     details in a generic form. For OpenID Connect providers the id token
+//     is verified and its claims are used instead.
 //  4. Passes the user details into the OAuth2ServerStorer.NewFromOAuth2 in
 //     order to create a user object we can work with.
 //  5. Saves the user in the database, logs them in, redirects.
@@ -38,6 +39,7 @@ package oauth2
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -48,6 +50,7 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/friendsofgo/errors"
 	"golang.org/x/oauth2"
@@ -55,6 +58,15 @@ import (
 	"github.com/volatiletech/authboss/v3"
 )
 
+type contextKey string
+
+const (
+	ctxKeyCallbackForm contextKey = "oauth2_callback_form"
+	// ctxKeyNow holds Authboss.Now() for FindUserDetails functions that
+	// check the expiry of an id token
+	ctxKeyNow contextKey = "oauth2_now"
+)
+
 // FormValue constants
 const (
 	FormValueOAuth2State = "state"
@@ -94,12 +106,24 @@ func (o *OAuth2) Init(ab *authboss.Authboss) error {
 
 		o.Authboss.Config.Core.Router.Get(init, o.Authboss.Core.ErrorHandler.Wrap(o.Start))
 		o.Authboss.Config.Core.Router.Get(callback, o.Authboss.Core.ErrorHandler.Wrap(o.End))
+		// Providers using response_mode=form_post (like Apple) post to it,
+		// the oauth2 state protects it instead of a csrf token
+		o.Authboss.Config.Core.Router.Post(callback, authboss.CSRFExempt(o.Authboss.Core.ErrorHandler.Wrap(o.End)))
 
 		if mount := o.Authboss.Config.Paths.Mount; len(mount) > 0 {
 			callback = path.Join(mount, callback)
 		}
 
 		cfg.OAuth2Config.RedirectURL = o.Authboss.Config.Paths.RootURL + callback
+
+		if len(cfg.OIDCIssuer) != 0 {
+			ctx, cancel := context.WithTimeout(context.Background(), oidcInitTimeout)
+			err := configureOIDC(ctx, cfg.OAuth2Config, cfg.OIDCIssuer, o.Authboss.Now())
+			cancel()
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
@@ -107,6 +131,13 @@ func (o *OAuth2) Init(ab *authboss.Authboss) error {
 
 // Start the oauth2 process
 func (o *OAuth2) Start(w http.ResponseWriter, r *http.Request) error {
+	return o.start(w, r, false)
+}
+
+// start the oauth2 process, when link is set End links the identity to the
+// logged in user instead of logging in with it. Otherwise a link that was
+// started but never finished is forgotten.
+func (o *OAuth2) start(w http.ResponseWriter, r *http.Request, link bool) error {
 	logger := o.Authboss.RequestLogger(r)
 
 	provider := strings.ToLower(filepath.Base(r.URL.Path))
@@ -116,15 +147,39 @@ func (o *OAuth2) Start(w http.ResponseWriter, r *http.Request) error {
 		return errors.Errorf("oauth2 provider %q not found", provider)
 	}
 
-	// Create nonce
-	nonce := make([]byte, 32)
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return errors.Wrap(err, "failed to create nonce")
+	if link {
+		authboss.PutSession(w, authboss.SessionOAuth2Link, provider)
+	} else {
+		authboss.DelSession(w, authboss.SessionOAuth2Link)
 	}
 
-	state := base64.URLEncoding.EncodeToString(nonce)
+	state, err := generateNonce()
+	if err != nil {
+		return err
+	}
 	authboss.PutSession(w, authboss.SessionOAuth2State, state)
 
+	var opts []oauth2.AuthCodeOption
+	if len(cfg.OIDCIssuer) != 0 {
+		nonce, err := generateNonce()
+		if err != nil {
+			return err
+		}
+		authboss.PutSession(w, authboss.SessionOAuth2Nonce, nonce)
+		opts = append(opts, oauth2.SetAuthURLParam("nonce", nonce))
+	}
+	if cfg.PKCE {
+		verifier, err := generateNonce()
+		if err != nil {
+			return err
+		}
+		authboss.PutSession(w, authboss.SessionOAuth2PKCEVerifier, verifier)
+		opts = append(opts,
+			oauth2.SetAuthURLParam("code_challenge", pkceChallenge(verifier)),
+			oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+		)
+	}
+
 	// This clearly ignores the fact that query parameters can have multiple
 	// values but I guess we're ignoring that
 	passAlongs := make(map[string]string)
@@ -144,7 +199,7 @@ func (o *OAuth2) Start(w http.ResponseWriter, r *http.Request) error {
 		authboss.DelSession(w, authboss.SessionOAuth2Params)
 	}
 
-	authCodeUrl := cfg.OAuth2Config.AuthCodeURL(state)
+	authCodeUrl := cfg.OAuth2Config.AuthCodeURL(state, opts...)
 
 	extraParams := cfg.AdditionalParams.Encode()
 	if len(extraParams) > 0 {
@@ -194,8 +249,15 @@ func (o *OAuth2) End(w http.ResponseWriter, r *http.Request) error {
 		}
 	}
 
+	nonce, _ := authboss.GetSession(r, authboss.SessionOAuth2Nonce)
+	verifier, _ := authboss.GetSession(r, authboss.SessionOAuth2PKCEVerifier)
+	linking, _ := authboss.GetSession(r, authboss.SessionOAuth2Link)
+
 	authboss.DelSession(w, authboss.SessionOAuth2State)
 	authboss.DelSession(w, authboss.SessionOAuth2Params)
+	authboss.DelSession(w, authboss.SessionOAuth2Nonce)
+	authboss.DelSession(w, authboss.SessionOAuth2PKCEVerifier)
+	authboss.DelSession(w, authboss.SessionOAuth2Link)
 
 	hasErr := r.FormValue("error")
 	if len(hasErr) > 0 {
@@ -219,31 +281,64 @@ func (o *OAuth2) End(w http.ResponseWriter, r *http.Request) error {
 
 	// Get the code which we can use to make an access token
 	code := r.FormValue("code")
-	token, err := exchanger(cfg.OAuth2Config, r.Context(), code)
+
+	var opts []oauth2.AuthCodeOption
+	if cfg.PKCE {
+		if len(verifier) == 0 {
+			return errors.New("oauth2 endpoint hit without pkce verifier")
+		}
+		opts = append(opts, oauth2.SetAuthURLParam("code_verifier", verifier))
+	}
+
+	token, err := exchanger(cfg.OAuth2Config, r.Context(), code, opts...)
 	if err != nil {
 		return errors.Wrap(err, "could not validate oauth2 code")
 	}
 
-	details, err := cfg.FindUserDetails(r.Context(), *cfg.OAuth2Config, token)
+	// Some providers send user details to the callback, let the
+	// FindUserDetails functions see them
+	now := o.Authboss.Now()
+	ctx := context.WithValue(r.Context(), ctxKeyCallbackForm, r.Form)
+	ctx = context.WithValue(ctx, ctxKeyNow, now)
+	details, err := findUserDetails(ctx, cfg, token, nonce, now)
 	if err != nil {
 		return err
 	}
 
-	storer := authboss.EnsureCanOAuth2(o.Authboss.Config.Storage.Server)
-	user, err := storer.NewFromOAuth2(r.Context(), provider, details)
-	if err != nil {
-		return errors.Wrap(err, "failed to create oauth2 user from values")
+	if linking == provider {
+		return o.link(w, r, provider, details, token, params[FormValueOAuth2Redir])
 	}
 
-	user.PutOAuth2Provider(provider)
-	user.PutOAuth2AccessToken(token.AccessToken)
-	user.PutOAuth2Expiry(token.Expiry)
-	if len(token.RefreshToken) != 0 {
-		user.PutOAuth2RefreshToken(token.RefreshToken)
+	// An identity that has been linked to a user logs in as that user
+	user, err := o.loadLinkedUser(r.Context(), provider, details, token)
+	if err != nil {
+		return err
 	}
 
-	if err := storer.SaveOAuth2(r.Context(), user); err != nil {
-		return err
+	var pid string
+	linked := user != nil
+	if linked {
+		pid = user.GetPID()
+	} else {
+		storer := authboss.EnsureCanOAuth2(o.Authboss.Config.Storage.Server)
+		oauthUser, err := storer.NewFromOAuth2(r.Context(), provider, details)
+		if err != nil {
+			return errors.Wrap(err, "failed to create oauth2 user from values")
+		}
+
+		oauthUser.PutOAuth2Provider(provider)
+		oauthUser.PutOAuth2AccessToken(token.AccessToken)
+		oauthUser.PutOAuth2Expiry(token.Expiry)
+		if len(token.RefreshToken) != 0 {
+			oauthUser.PutOAuth2RefreshToken(token.RefreshToken)
+		}
+
+		if err := storer.SaveOAuth2(r.Context(), oauthUser); err != nil {
+			return err
+		}
+
+		user = oauthUser
+		pid = authboss.MakeOAuth2PID(provider, oauthUser.GetOAuth2UID())
 	}
 
 	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
@@ -255,8 +350,26 @@ func (o *OAuth2) End(w http.ResponseWriter, r *http.Request) error {
 		return nil
 	}
 
+	// A linked identity logs in to a local account which may have a second
+	// factor, let the modules that add one ask for it like they do when
+	// logging in with a password. Only the redirect is passed along to them.
+	if linked {
+		hijack := r.Clone(r.Context())
+		hijack.URL.RawQuery = ""
+		if redir, ok := params[FormValueOAuth2Redir]; ok {
+			hijack.URL.RawQuery = url.Values{authboss.FormValueRedirect: []string{redir}}.Encode()
+		}
+
+		handled, err = o.Authboss.Events.FireBefore(authboss.EventAuthHijack, w, hijack)
+		if err != nil {
+			return err
+		} else if handled {
+			return nil
+		}
+	}
+
 	// Fully log user in
-	authboss.PutSession(w, authboss.SessionKey, authboss.MakeOAuth2PID(provider, user.GetOAuth2UID()))
+	authboss.PutSession(w, authboss.SessionKey, pid)
 	authboss.DelSession(w, authboss.SessionHalfAuthKey)
 
 	// Create a query string from all the pieces we've received
@@ -295,6 +408,50 @@ func (o *OAuth2) End(w http.ResponseWriter, r *http.Request) error {
 	return o.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
 }
 
+// findUserDetails calls the provider's FindUserDetails, for OpenID Connect
+// providers the claims of the id token fill in anything it did not return
+func findUserDetails(ctx context.Context, cfg authboss.OAuth2Provider, token *oauth2.Token, nonce string, now time.Time) (map[string]string, error) {
+	if len(cfg.OIDCIssuer) == 0 {
+		return cfg.FindUserDetails(ctx, *cfg.OAuth2Config, token)
+	}
+
+	claims, err := oidcUserDetails(ctx, *cfg.OAuth2Config, cfg.OIDCIssuer, token, nonce, now)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.FindUserDetails == nil {
+		return claims, nil
+	}
+
+	details, err := cfg.FindUserDetails(ctx, *cfg.OAuth2Config, token)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range claims {
+		if _, ok := details[k]; !ok {
+			details[k] = v
+		}
+	}
+
+	return details, nil
+}
+
+// pkceChallenge creates the S256 code challenge for a PKCE verifier
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// generateNonce creates a random string that is also a valid PKCE verifier
+func generateNonce() (string, error) {
+	nonce := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", errors.Wrap(err, "failed to create nonce")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(nonce), nil
+}
+
 // RMTrue is a dummy struct implementing authboss.RememberValuer
 // in order to tell the remember me module to remember them.
 type RMTrue struct{}
@@ -2,6 +2,7 @@ package oauth2
 
 import (
 	"context"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -17,7 +18,13 @@ import (
 )
 
 func init() {
-	exchanger = func(_ *oauth2.Config, _ context.Context, _ string, _ ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	exchanger = func(cfg *oauth2.Config, ctx context.Context, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+		switch cfg.ClientID {
+		case oidcClientID:
+			return oidcTestToken(), nil
+		case pkceClientID:
+			return cfg.Exchange(ctx, code, opts...)
+		}
 		return testToken, nil
 	}
 }
@@ -80,6 +87,9 @@ func TestInit(t *testing.T) {
 	if err := router.HasGets(gets...); err != nil {
 		t.Error(err)
 	}
+	if err := router.HasPosts("/oauth2/callback/facebook", "/oauth2/callback/google"); err != nil {
+		t.Error(err)
+	}
 }
 
 type testHarness struct {
@@ -372,3 +382,112 @@ func TestEndHandling(t *testing.T) {
 		}
 	})
 }
+
+const pkceClientID = "pkce-client"
+
+// pkceProvider uses a token endpoint that only gives out a token when the
+// code_verifier matches the challenge, which the tests send as the code.
+func pkceProvider(t *testing.T) authboss.OAuth2Provider {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		if pkceChallenge(r.PostForm.Get("code_verifier")) != r.PostForm.Get("code") {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = io.WriteString(w, `{"error":"invalid_grant"}`)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"access_token":"token","token_type":"Bearer","expires_in":3600}`)
+	}))
+	t.Cleanup(server.Close)
+
+	return authboss.OAuth2Provider{
+		OAuth2Config: &oauth2.Config{
+			ClientID:     pkceClientID,
+			ClientSecret: "secret",
+			Endpoint:     oauth2.Endpoint{AuthURL: server.URL + "/authorize", TokenURL: server.URL + "/token"},
+			RedirectURL:  "https://www.example.com/auth/oauth2/callback/pkce",
+		},
+		FindUserDetails: func(context.Context, oauth2.Config, *oauth2.Token) (map[string]string, error) {
+			return map[string]string{OAuth2UID: "pkce-uid", OAuth2Email: "pkce@test.com"}, nil
+		},
+		PKCE: true,
+	}
+}
+
+func TestPKCE(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Modules.OAuth2Providers = map[string]authboss.OAuth2Provider{"pkce": pkceProvider(t)}
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	if err := h.oauth.Start(w, httptest.NewRequest("GET", "/oauth2/pkce", nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	redirectPathUrl, err := url.Parse(h.redirector.Options.RedirectPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := redirectPathUrl.Query()
+	if method := query.Get("code_challenge_method"); method != "S256" {
+		t.Error("challenge method wrong:", method)
+	}
+	challenge := query.Get("code_challenge")
+	verifier := h.session.ClientValues[authboss.SessionOAuth2PKCEVerifier]
+	if len(verifier) < 43 || pkceChallenge(verifier) != challenge {
+		t.Errorf("verifier %q does not match challenge %q", verifier, challenge)
+	}
+
+	w = h.ab.NewResponse(httptest.NewRecorder())
+	h.session.ClientValues[authboss.SessionOAuth2State] = "state"
+	r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/pkce?state=state&code="+challenge, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.oauth.End(w, r); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK) // Flush headers
+
+	if s := h.session.ClientValues[authboss.SessionKey]; s != "oauth2;;pkce;;pkce-uid" {
+		t.Error("session id should have been set:", s)
+	}
+	if _, ok := h.session.ClientValues[authboss.SessionOAuth2PKCEVerifier]; ok {
+		t.Error("verifier should be removed from the session")
+	}
+}
+
+func TestPKCEBadVerifier(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Modules.OAuth2Providers = map[string]authboss.OAuth2Provider{"pkce": pkceProvider(t)}
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	h.session.ClientValues[authboss.SessionOAuth2State] = "state"
+	r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/pkce?state=state&code=code", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = h.oauth.End(w, r)
+	if err == nil || !strings.Contains(err.Error(), "without pkce verifier") {
+		t.Error("it should require a verifier:", err)
+	}
+
+	h.session.ClientValues[authboss.SessionOAuth2State] = "state"
+	h.session.ClientValues[authboss.SessionOAuth2PKCEVerifier] = "wrong"
+	r, err = h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/pkce?state=state&code=code", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = h.oauth.End(w, r); err == nil {
+		t.Error("the token endpoint should reject the verifier")
+	}
+}
@@ -0,0 +1,480 @@
+package oauth2
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // Hashes used to verify id tokens
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/friendsofgo/errors"
+	"golang.org/x/oauth2"
+)
+
+// Additional constants returned for OpenID Connect providers, they're taken
+// from the standard claims of the same name when present.
+const (
+	OIDCEmailVerified     = "email_verified"
+	OIDCGivenName         = "given_name"
+	OIDCFamilyName        = "family_name"
+	OIDCPreferredUsername = "preferred_username"
+	OIDCPicture           = "picture"
+)
+
+const (
+	oidcDiscoveryPath = "/.well-known/openid-configuration"
+	oidcScope         = "openid"
+
+	// oidcCacheDuration is how long a discovery document or set of keys is
+	// used before it's loaded again
+	oidcCacheDuration = time.Hour
+	// oidcInitTimeout bounds loading the discovery documents in Init
+	oidcInitTimeout = 30 * time.Second
+	// oidcMaxResponseSize bounds how much of a discovery document or jwks
+	// is read
+	oidcMaxResponseSize = 1 << 20
+)
+
+var (
+	// ErrInvalidIDToken is returned when an id token fails verification
+	ErrInvalidIDToken = errors.New("oidc id token is invalid")
+
+	oidcMut       sync.Mutex
+	oidcProviders = make(map[string]*oidcProvider)
+
+	// oidcHTTPClient is used for discovery and jwks requests unless the
+	// context has an oauth2.HTTPClient
+	oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcProvider holds the discovery document and signing keys of an issuer,
+// they're shared by every OAuth2Provider that uses the issuer.
+type oidcProvider struct {
+	discovery oidcDiscovery
+	loaded    time.Time
+
+	mut        sync.RWMutex
+	keys       map[string]crypto.PublicKey
+	keysLoaded time.Time
+}
+
+// getOIDCProvider loads the discovery document of the issuer the first
+// time it's asked for and again once it's older than oidcCacheDuration. If
+// loading it again fails the old document keeps being used. The lock is not
+// held while it's loaded so a slow issuer can't hold up the others, logins
+// that happen at the same time may both load it which is harmless.
+func getOIDCProvider(ctx context.Context, issuer string, now time.Time) (*oidcProvider, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+
+	oidcMut.Lock()
+	cached, ok := oidcProviders[issuer]
+	oidcMut.Unlock()
+
+	if ok && now.Sub(cached.loaded) < oidcCacheDuration {
+		return cached, nil
+	}
+
+	var discovery oidcDiscovery
+	err := getJSON(ctx, issuer+oidcDiscoveryPath, &discovery)
+	switch {
+	case err != nil:
+		err = errors.Wrapf(err, "failed to load oidc discovery document for %s", issuer)
+	case strings.TrimSuffix(discovery.Issuer, "/") != issuer:
+		err = errors.Errorf("oidc discovery document issuer %q does not match %q", discovery.Issuer, issuer)
+	case len(discovery.AuthorizationEndpoint) == 0 || len(discovery.TokenEndpoint) == 0 || len(discovery.JWKSURI) == 0:
+		err = errors.Errorf("oidc discovery document for %s is missing endpoints", issuer)
+	}
+	if err != nil {
+		if ok {
+			return cached, nil
+		}
+		return nil, err
+	}
+
+	p := &oidcProvider{discovery: discovery, loaded: now}
+	oidcMut.Lock()
+	oidcProviders[issuer] = p
+	oidcMut.Unlock()
+
+	return p, nil
+}
+
+// configureOIDC fills in the endpoint of the provider from the discovery
+// document and makes sure the openid scope is asked for
+func configureOIDC(ctx context.Context, cfg *oauth2.Config, issuer string, now time.Time) error {
+	p, err := getOIDCProvider(ctx, issuer, now)
+	if err != nil {
+		return err
+	}
+
+	if len(cfg.Endpoint.AuthURL) == 0 {
+		cfg.Endpoint.AuthURL = p.discovery.AuthorizationEndpoint
+		cfg.Endpoint.TokenURL = p.discovery.TokenEndpoint
+	}
+
+	for _, scope := range cfg.Scopes {
+		if scope == oidcScope {
+			return nil
+		}
+	}
+	cfg.Scopes = append([]string{oidcScope}, cfg.Scopes...)
+
+	return nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetchKeys replaces the provider's keys with the ones in its JWKS
+func (p *oidcProvider) fetchKeys(ctx context.Context, now time.Time) error {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := getJSON(ctx, p.discovery.JWKSURI, &set); err != nil {
+		return errors.Wrapf(err, "failed to load jwks for %s", p.discovery.Issuer)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if len(k.Use) != 0 && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.publicKey()
+		if err != nil {
+			// Skip keys we don't understand, they may never be used
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	p.mut.Lock()
+	p.keys = keys
+	p.keysLoaded = now
+	p.mut.Unlock()
+
+	return nil
+}
+
+// key finds the key with the kid, if there's no kid and there's only one
+// key that one is used. Keys older than oidcCacheDuration are not returned
+// so that they're loaded again.
+func (p *oidcProvider) key(kid string, now time.Time) (crypto.PublicKey, bool) {
+	p.mut.RLock()
+	defer p.mut.RUnlock()
+
+	if now.Sub(p.keysLoaded) >= oidcCacheDuration {
+		return nil, false
+	}
+
+	if len(kid) == 0 && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.Errorf("unknown curve: %s", k.Crv)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	default:
+		return nil, errors.Errorf("unknown key type: %s", k.Kty)
+	}
+}
+
+// audience can be either a string or a list of strings in the id token
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+func (a audience) contains(s string) bool {
+	for _, aud := range a {
+		if aud == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+type idTokenClaims struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"`
+	Audience        audience `json:"aud"`
+	AuthorizedParty string   `json:"azp"`
+	ExpiresAt       int64    `json:"exp"`
+	Nonce           string   `json:"nonce"`
+
+	Email             string      `json:"email"`
+	EmailVerified     interface{} `json:"email_verified"`
+	Name              string      `json:"name"`
+	GivenName         string      `json:"given_name"`
+	FamilyName        string      `json:"family_name"`
+	PreferredUsername string      `json:"preferred_username"`
+	Picture           string      `json:"picture"`
+}
+
+// details turns the claims into the map that is given to NewFromOAuth2
+func (c idTokenClaims) details() map[string]string {
+	details := map[string]string{OAuth2UID: c.Subject}
+
+	add := func(key, value string) {
+		if len(value) != 0 {
+			details[key] = value
+		}
+	}
+
+	add(OAuth2Email, c.Email)
+	add(OAuth2Name, c.Name)
+	add(OIDCGivenName, c.GivenName)
+	add(OIDCFamilyName, c.FamilyName)
+	add(OIDCPreferredUsername, c.PreferredUsername)
+	add(OIDCPicture, c.Picture)
+
+	// Some providers send this as a string
+	switch v := c.EmailVerified.(type) {
+	case bool:
+		if v {
+			details[OIDCEmailVerified] = "true"
+		} else {
+			details[OIDCEmailVerified] = "false"
+		}
+	case string:
+		add(OIDCEmailVerified, v)
+	}
+
+	return details
+}
+
+// oidcUserDetails verifies the id token in the token response at the time
+// now and returns the details from its claims
+func oidcUserDetails(ctx context.Context, cfg oauth2.Config, issuer string, token *oauth2.Token, nonce string, now time.Time) (map[string]string, error) {
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || len(rawIDToken) == 0 {
+		return nil, errors.New("oidc token response did not contain an id_token")
+	}
+
+	p, err := getOIDCProvider(ctx, issuer, now)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := p.verify(ctx, rawIDToken, cfg.ClientID, nonce, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return claims.details(), nil
+}
+
+// verify the signature and claims of an id token
+func (p *oidcProvider) verify(ctx context.Context, rawIDToken, clientID, nonce string, now time.Time) (idTokenClaims, error) {
+	var claims idTokenClaims
+
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return claims, ErrInvalidIDToken
+	}
+
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return claims, ErrInvalidIDToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err = json.Unmarshal(rawHeader, &header); err != nil {
+		return claims, ErrInvalidIDToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, ErrInvalidIDToken
+	}
+
+	key, ok := p.key(header.Kid, now)
+	if !ok {
+		// The keys may have been rotated since they were loaded
+		if err = p.fetchKeys(ctx, now); err != nil {
+			return claims, err
+		}
+		if key, ok = p.key(header.Kid, now); !ok {
+			return claims, ErrInvalidIDToken
+		}
+	}
+
+	if !verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature) {
+		return claims, ErrInvalidIDToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, ErrInvalidIDToken
+	}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return claims, ErrInvalidIDToken
+	}
+
+	switch {
+	case strings.TrimSuffix(claims.Issuer, "/") != strings.TrimSuffix(p.discovery.Issuer, "/"),
+		len(claims.Subject) == 0,
+		!claims.Audience.contains(clientID),
+		len(claims.AuthorizedParty) != 0 && claims.AuthorizedParty != clientID,
+		!now.Before(time.Unix(claims.ExpiresAt, 0)),
+		claims.Nonce != nonce:
+		return claims, ErrInvalidIDToken
+	}
+
+	return claims, nil
+}
+
+// verifySignature checks the signature of an id token, only the RSA and
+// ECDSA algorithms are supported.
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) bool {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return false
+	}
+
+	h := hash.New()
+	h.Write(signed)
+	sum := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if alg[0] != 'R' {
+			return false
+		}
+		return rsa.VerifyPKCS1v15(k, hash, sum, signature) == nil
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if alg[0] != 'E' || len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(k, sum, r, s)
+	default:
+		return false
+	}
+}
+
+// getJSON uses the oauth2.HTTPClient in the context if there is one, the
+// same as the oauth2 package does for token requests.
+func getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	client := oidcHTTPClient
+	if c, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok && c != nil {
+		client = c
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+
+	byt, err := io.ReadAll(io.LimitReader(resp.Body, oidcMaxResponseSize))
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(byt, v)
+}
@@ -0,0 +1,563 @@
+package oauth2
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/mocks"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcClientID = "oidc-client"
+	oidcNonce    = "nonce"
+)
+
+// idp is a stand-in OpenID Connect provider
+type idp struct {
+	server *httptest.Server
+
+	mut   sync.Mutex
+	kid   string
+	key   *rsa.PrivateKey
+	ecKey *ecdsa.PrivateKey
+
+	// Number of times the discovery document and jwks were requested
+	discoveries int
+	jwksLoads   int
+}
+
+var (
+	testIdPOnce sync.Once
+	testIdPVal  *idp
+)
+
+func testIdP() *idp {
+	testIdPOnce.Do(func() {
+		testIdPVal = newIdP()
+	})
+	return testIdPVal
+}
+
+func newIdP() *idp {
+	i := &idp{kid: "1"}
+
+	var err error
+	if i.key, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		panic(err)
+	}
+	if i.ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		panic(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(oidcDiscoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		i.mut.Lock()
+		i.discoveries++
+		i.mut.Unlock()
+
+		_ = json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                i.server.URL,
+			AuthorizationEndpoint: i.server.URL + "/authorize",
+			TokenEndpoint:         i.server.URL + "/token",
+			JWKSURI:               i.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		i.mut.Lock()
+		defer i.mut.Unlock()
+		i.jwksLoads++
+
+		enc := base64.RawURLEncoding
+		keys := []jwk{
+			{
+				Kty: "RSA", Kid: i.kid, Use: "sig",
+				N: enc.EncodeToString(i.key.N.Bytes()),
+				E: enc.EncodeToString(big.NewInt(int64(i.key.E)).Bytes()),
+			},
+			{
+				Kty: "EC", Kid: "ec", Crv: "P-256",
+				X: enc.EncodeToString(i.ecKey.X.FillBytes(make([]byte, 32))),
+				Y: enc.EncodeToString(i.ecKey.Y.FillBytes(make([]byte, 32))),
+			},
+			{Kty: "oct", Kid: "symmetric"},
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	})
+	i.server = httptest.NewServer(mux)
+
+	return i
+}
+
+// rotate replaces the rsa key and its kid
+func (i *idp) rotate() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	i.mut.Lock()
+	i.key = key
+	i.kid += "1"
+	i.mut.Unlock()
+}
+
+func (i *idp) claims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":            i.server.URL,
+		"sub":            "oidc-uid",
+		"aud":            []string{oidcClientID, "other"},
+		"azp":            oidcClientID,
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"nonce":          oidcNonce,
+		"email":          "oidc@test.com",
+		"email_verified": true,
+		"name":           "OIDC User",
+	}
+}
+
+func (i *idp) sign(alg string, claims map[string]interface{}) string {
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": i.kidFor(alg), "typ": "JWT"})
+	if err != nil {
+		panic(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		panic(err)
+	}
+
+	enc := base64.RawURLEncoding
+	signed := enc.EncodeToString(header) + "." + enc.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	if alg == "ES256" {
+		r, s, err := ecdsa.Sign(rand.Reader, i.ecKey, sum[:])
+		if err != nil {
+			panic(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	} else {
+		i.mut.Lock()
+		signature, err = rsa.SignPKCS1v15(rand.Reader, i.key, crypto.SHA256, sum[:])
+		i.mut.Unlock()
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	return signed + "." + enc.EncodeToString(signature)
+}
+
+func (i *idp) kidFor(alg string) string {
+	if alg == "ES256" {
+		return "ec"
+	}
+
+	i.mut.Lock()
+	defer i.mut.Unlock()
+	return i.kid
+}
+
+// oidcTestToken is returned by the exchanger for the oidc test provider
+func oidcTestToken() *oauth2.Token {
+	i := testIdP()
+	return testToken.WithExtra(map[string]interface{}{
+		"id_token": i.sign("RS256", i.claims()),
+	})
+}
+
+func oidcProviderConfig() authboss.OAuth2Provider {
+	return authboss.OAuth2Provider{
+		OAuth2Config: &oauth2.Config{
+			ClientID:     oidcClientID,
+			ClientSecret: "secret",
+			Scopes:       []string{"email"},
+		},
+		OIDCIssuer: testIdP().server.URL,
+	}
+}
+
+func TestOIDCInit(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+	ab.Config.Core.Router = &mocks.Router{}
+	ab.Config.Core.ErrorHandler = &mocks.ErrorHandler{}
+	ab.Config.Paths.RootURL = "https://www.example.com"
+
+	cfg := oidcProviderConfig()
+	ab.Config.Modules.OAuth2Providers = map[string]authboss.OAuth2Provider{"oidc": cfg}
+
+	if err := (&OAuth2{}).Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.OAuth2Config.Endpoint.AuthURL != testIdP().server.URL+"/authorize" {
+		t.Error("auth url wrong:", cfg.OAuth2Config.Endpoint.AuthURL)
+	}
+	if cfg.OAuth2Config.Endpoint.TokenURL != testIdP().server.URL+"/token" {
+		t.Error("token url wrong:", cfg.OAuth2Config.Endpoint.TokenURL)
+	}
+	if scopes := cfg.OAuth2Config.Scopes; len(scopes) != 2 || scopes[0] != "openid" {
+		t.Error("scopes wrong:", scopes)
+	}
+}
+
+func TestOIDCInitBadIssuer(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	cfg := &oauth2.Config{}
+	if err := configureOIDC(context.Background(), cfg, server.URL, time.Now()); err == nil {
+		t.Error("it should fail without a discovery document")
+	}
+}
+
+func TestOIDCStart(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Modules.OAuth2Providers = map[string]authboss.OAuth2Provider{"oidc": oidcProviderConfig()}
+	if err := configureOIDC(context.Background(), h.ab.Modules.OAuth2Providers["oidc"].OAuth2Config, testIdP().server.URL, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	r := httptest.NewRequest("GET", "/oauth2/oidc", nil)
+
+	if err := h.oauth.Start(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(h.redirector.Options.RedirectPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := u.Query().Get("nonce")
+	if len(nonce) == 0 {
+		t.Error("nonce should be in the url")
+	}
+	if h.session.ClientValues[authboss.SessionOAuth2Nonce] != nonce {
+		t.Error("the nonce should have been saved in the session")
+	}
+}
+
+func TestOIDCEnd(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Modules.OAuth2Providers = map[string]authboss.OAuth2Provider{"oidc": oidcProviderConfig()}
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+
+	h.session.ClientValues[authboss.SessionOAuth2State] = "state"
+	h.session.ClientValues[authboss.SessionOAuth2Nonce] = oidcNonce
+	r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/oidc?state=state", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.oauth.End(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	w.WriteHeader(http.StatusOK) // Flush headers
+
+	if s := h.session.ClientValues[authboss.SessionKey]; s != "oauth2;;oidc;;oidc-uid" {
+		t.Error("session id should have been set:", s)
+	}
+	if _, ok := h.session.ClientValues[authboss.SessionOAuth2Nonce]; ok {
+		t.Error("nonce should be removed from the session")
+	}
+
+	user := h.storer.Users["oauth2;;oidc;;oidc-uid"]
+	if user == nil || user.Username != "OIDC User" || user.Email != "oidc@test.com" {
+		t.Errorf("user wrong: %#v", user)
+	}
+}
+
+func TestOIDCEndWrongNonce(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Modules.OAuth2Providers = map[string]authboss.OAuth2Provider{"oidc": oidcProviderConfig()}
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+
+	h.session.ClientValues[authboss.SessionOAuth2State] = "state"
+	h.session.ClientValues[authboss.SessionOAuth2Nonce] = "another nonce"
+	r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/oidc?state=state", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.oauth.End(w, r); err != ErrInvalidIDToken {
+		t.Error("it should fail to verify the id token:", err)
+	}
+	if _, ok := h.session.ClientValues[authboss.SessionKey]; ok {
+		t.Error("user should not be logged in")
+	}
+}
+
+func TestOIDCEndClock(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Modules.OAuth2Providers = map[string]authboss.OAuth2Provider{"oidc": oidcProviderConfig()}
+	clock := mocks.NewClock(time.Now().Add(2 * time.Hour))
+	h.ab.Config.Core.Clock = clock
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+
+	h.session.ClientValues[authboss.SessionOAuth2State] = "state"
+	h.session.ClientValues[authboss.SessionOAuth2Nonce] = oidcNonce
+	r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/oidc?state=state", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The id token expires in an hour by the system clock but the
+	// configured clock is past that
+	if err := h.oauth.End(w, r); err != ErrInvalidIDToken {
+		t.Error("the id token should have expired:", err)
+	}
+	if _, ok := h.session.ClientValues[authboss.SessionKey]; ok {
+		t.Error("user should not be logged in")
+	}
+}
+
+func TestOIDCVerify(t *testing.T) {
+	t.Parallel()
+
+	i := newIdP()
+	defer i.server.Close()
+
+	ctx := context.Background()
+	now := time.Now()
+	p, err := getOIDCProvider(ctx, i.server.URL, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verify := func(token string) error {
+		_, err := p.verify(ctx, token, oidcClientID, oidcNonce, now)
+		return err
+	}
+
+	claims, err := p.verify(ctx, i.sign("RS256", i.claims()), oidcClientID, oidcNonce, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	details := claims.details()
+	if details[OAuth2UID] != "oidc-uid" || details[OAuth2Email] != "oidc@test.com" || details[OIDCEmailVerified] != "true" {
+		t.Errorf("details wrong: %#v", details)
+	}
+
+	if err = verify(i.sign("ES256", i.claims())); err != nil {
+		t.Error("ecdsa signature should be valid:", err)
+	}
+
+	// A rotated key is picked up by loading the jwks again
+	i.rotate()
+	if err = verify(i.sign("RS256", i.claims())); err != nil {
+		t.Error("rotated key should be found:", err)
+	}
+
+	tests := map[string]func(map[string]interface{}){
+		"issuer":   func(c map[string]interface{}) { c["iss"] = "https://evil.com" },
+		"audience": func(c map[string]interface{}) { c["aud"] = "other" },
+		"azp":      func(c map[string]interface{}) { c["azp"] = "other" },
+		"expired":  func(c map[string]interface{}) { c["exp"] = now.Add(-time.Minute).Unix() },
+		"nonce":    func(c map[string]interface{}) { c["nonce"] = "replayed" },
+		"subject":  func(c map[string]interface{}) { delete(c, "sub") },
+	}
+	for name, modify := range tests {
+		claims := i.claims()
+		modify(claims)
+		if err = verify(i.sign("RS256", claims)); err != ErrInvalidIDToken {
+			t.Errorf("%s) it should be invalid: %v", name, err)
+		}
+	}
+
+	good := i.sign("RS256", i.claims())
+	other := newIdP()
+	defer other.server.Close()
+	other.kid = i.kid
+	forged := other.sign("RS256", i.claims())
+
+	for name, token := range map[string]string{
+		"forged":    forged,
+		"truncated": good[:len(good)-10],
+		"parts":     "a.b",
+		"none":      base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + good[len(good)/2:] + ".",
+	} {
+		if err = verify(token); err != ErrInvalidIDToken {
+			t.Errorf("%s) it should be invalid: %v", name, err)
+		}
+	}
+}
+
+func (i *idp) loads() (discoveries, jwksLoads int) {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+	return i.discoveries, i.jwksLoads
+}
+
+func TestOIDCCache(t *testing.T) {
+	t.Parallel()
+
+	i := newIdP()
+	ctx := context.Background()
+	now := time.Now()
+
+	verify := func(p *oidcProvider, now time.Time) {
+		t.Helper()
+		claims := i.claims()
+		claims["exp"] = now.Add(time.Hour).Unix()
+		if _, err := p.verify(ctx, i.sign("RS256", claims), oidcClientID, oidcNonce, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p, err := getOIDCProvider(ctx, i.server.URL, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify(p, now)
+
+	soon := now.Add(oidcCacheDuration - time.Minute)
+	if again, err := getOIDCProvider(ctx, i.server.URL, soon); err != nil {
+		t.Fatal(err)
+	} else if again != p {
+		t.Error("the cached provider should be used")
+	}
+	verify(p, soon)
+	if discoveries, jwksLoads := i.loads(); discoveries != 1 || jwksLoads != 1 {
+		t.Error("nothing should be loaded again yet:", discoveries, jwksLoads)
+	}
+
+	later := now.Add(oidcCacheDuration)
+	verify(p, later)
+	if _, jwksLoads := i.loads(); jwksLoads != 2 {
+		t.Error("old keys should be loaded again:", jwksLoads)
+	}
+
+	refreshed, err := getOIDCProvider(ctx, i.server.URL, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if discoveries, _ := i.loads(); refreshed == p || discoveries != 2 {
+		t.Error("an old discovery document should be loaded again:", discoveries)
+	}
+
+	// If the issuer can't be reached the old document is still used
+	i.server.Close()
+	if stale, err := getOIDCProvider(ctx, i.server.URL, later.Add(oidcCacheDuration)); err != nil {
+		t.Error(err)
+	} else if stale != refreshed {
+		t.Error("the old provider should be kept")
+	}
+}
+
+func TestOIDCSlowIssuer(t *testing.T) {
+	t.Parallel()
+
+	arrived, release := make(chan struct{}), make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(arrived)
+		<-release
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	go func() {
+		_, _ = getOIDCProvider(context.Background(), slow.URL, time.Now())
+	}()
+	<-arrived
+
+	i := newIdP()
+	defer i.server.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := getOIDCProvider(context.Background(), i.server.URL, time.Now())
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("a slow issuer should not hold up the others")
+	}
+}
+
+func TestOIDCResponseSize(t *testing.T) {
+	t.Parallel()
+
+	large := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `{"issuer":"`+strings.Repeat("a", oidcMaxResponseSize)+`"}`)
+	}))
+	defer large.Close()
+
+	var discovery oidcDiscovery
+	if err := getJSON(context.Background(), large.URL, &discovery); err == nil {
+		t.Error("a response over the limit should not be read")
+	}
+}
+
+type countingTransport struct {
+	mut      sync.Mutex
+	requests int
+}
+
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	c.mut.Lock()
+	c.requests++
+	c.mut.Unlock()
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestOIDCHTTPClient(t *testing.T) {
+	t.Parallel()
+
+	i := newIdP()
+	defer i.server.Close()
+
+	if oidcHTTPClient.Timeout == 0 {
+		t.Error("the default client should have a timeout")
+	}
+
+	transport := &countingTransport{}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: transport})
+	if _, err := getOIDCProvider(ctx, i.server.URL, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if transport.requests != 1 {
+		t.Error("the client from the context should be used:", transport.requests)
+	}
+}
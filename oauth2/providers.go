@@ -2,24 +2,43 @@ package oauth2
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/friendsofgo/errors"
+	"github.com/volatiletech/authboss/v3"
 	"golang.org/x/oauth2"
 )
 
 // Constants for returning in the FindUserDetails call
 const (
-	OAuth2UID   = "uid"
-	OAuth2Email = "email"
-	OAuth2Name  = "name"
+	OAuth2UID   = authboss.OAuth2UID
+	OAuth2Email = authboss.OAuth2Email
+	OAuth2Name  = authboss.OAuth2Name
 )
 
+// MicrosoftUserPrincipalName is returned by MicrosoftUserDetails, it's
+// the user principal name which is the name the user signs in with.
+const MicrosoftUserPrincipalName = "microsoft_upn"
+
 const (
-	googleInfoEndpoint   = `https://www.googleapis.com/userinfo/v2/me`
-	facebookInfoEndpoint = `https://graph.facebook.com/me?fields=name,email`
+	googleInfoEndpoint    = `https://www.googleapis.com/userinfo/v2/me`
+	facebookInfoEndpoint  = `https://graph.facebook.com/me?fields=name,email`
+	githubInfoEndpoint    = `https://api.github.com/user`
+	githubEmailsEndpoint  = `https://api.github.com/user/emails`
+	microsoftInfoEndpoint = `https://graph.microsoft.com/v1.0/me`
+	gitlabInfoEndpoint    = `/api/v4/user`
+
+	// GitLabURL is the address of gitlab.com, see NewGitLabUserDetails
+	GitLabURL = `https://gitlab.com`
+	// AppleIssuer is the issuer of Sign in with Apple id tokens
+	AppleIssuer = `https://appleid.apple.com`
 )
 
 type googleMeResponse struct {
@@ -88,3 +107,229 @@ func FacebookUserDetails(ctx context.Context, cfg oauth2.Config, token *oauth2.T
 		OAuth2Name:  response.Name,
 	}, nil
 }
+
+// getJSONResponse gets the url with the client and decodes the response
+// into v, name is used for error messages. A response that isn't a 2xx is
+// an error since providers send error messages as json as well.
+func getJSONResponse(client *http.Client, url, name string, v interface{}) error {
+	resp, err := clientGet(client, url)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	byt, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read body from %s oauth2 endpoint", name)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("%s oauth2 endpoint responded with status %d: %s", name, resp.StatusCode, byt)
+	}
+
+	if err = json.Unmarshal(byt, v); err != nil {
+		return errors.Wrapf(err, "failed to parse json from %s oauth2 endpoint", name)
+	}
+
+	return nil
+}
+
+type githubMeResponse struct {
+	ID    int64  `json:"id"`
+	Login string `json:"login"`
+	Name  string `json:"name"`
+}
+
+type githubEmailsResponse []struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+// GitHubUserDetails can be used as a FindUserDetails function
+// for an authboss.OAuth2Provider. The e-mail is the user's primary
+// verified address which requires the user:email scope, the name falls
+// back to the user's login when they have not set one.
+func GitHubUserDetails(ctx context.Context, cfg oauth2.Config, token *oauth2.Token) (map[string]string, error) {
+	client := cfg.Client(ctx, token)
+
+	var response githubMeResponse
+	if err := getJSONResponse(client, githubInfoEndpoint, "github", &response); err != nil {
+		return nil, err
+	}
+	if response.ID == 0 {
+		return nil, errors.New("github oauth2 endpoint did not return a user id")
+	}
+
+	var emails githubEmailsResponse
+	if err := getJSONResponse(client, githubEmailsEndpoint, "github", &emails); err != nil {
+		return nil, err
+	}
+
+	details := map[string]string{
+		OAuth2UID:  strconv.FormatInt(response.ID, 10),
+		OAuth2Name: response.Name,
+	}
+	if len(response.Name) == 0 {
+		details[OAuth2Name] = response.Login
+	}
+
+	for _, email := range emails {
+		if email.Primary && email.Verified {
+			details[OAuth2Email] = email.Email
+			break
+		}
+	}
+
+	return details, nil
+}
+
+type microsoftMeResponse struct {
+	ID                string `json:"id"`
+	DisplayName       string `json:"displayName"`
+	Mail              string `json:"mail"`
+	UserPrincipalName string `json:"userPrincipalName"`
+}
+
+// MicrosoftUserDetails can be used as a FindUserDetails function
+// for an authboss.OAuth2Provider. It uses Microsoft Graph and needs the
+// User.Read scope. The e-mail is Graph's mail property which the user's
+// tenant sets and Microsoft does not verify, it's empty for accounts
+// without a mailbox. The user principal name is a sign in name and not
+// an address, it's under MicrosoftUserPrincipalName.
+func MicrosoftUserDetails(ctx context.Context, cfg oauth2.Config, token *oauth2.Token) (map[string]string, error) {
+	client := cfg.Client(ctx, token)
+
+	var response microsoftMeResponse
+	if err := getJSONResponse(client, microsoftInfoEndpoint, "microsoft", &response); err != nil {
+		return nil, err
+	}
+	if len(response.ID) == 0 {
+		return nil, errors.New("microsoft oauth2 endpoint did not return a user id")
+	}
+
+	return map[string]string{
+		OAuth2UID:                  response.ID,
+		OAuth2Email:                response.Mail,
+		OAuth2Name:                 response.DisplayName,
+		MicrosoftUserPrincipalName: response.UserPrincipalName,
+	}, nil
+}
+
+type gitlabMeResponse struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+}
+
+// GitLabUserDetails can be used as a FindUserDetails function
+// for an authboss.OAuth2Provider that uses gitlab.com, it needs the
+// read_user scope.
+func GitLabUserDetails(ctx context.Context, cfg oauth2.Config, token *oauth2.Token) (map[string]string, error) {
+	return NewGitLabUserDetails(GitLabURL)(ctx, cfg, token)
+}
+
+// NewGitLabUserDetails creates a FindUserDetails function for a self
+// hosted GitLab at baseURL
+func NewGitLabUserDetails(baseURL string) func(context.Context, oauth2.Config, *oauth2.Token) (map[string]string, error) {
+	endpoint := strings.TrimSuffix(baseURL, "/") + gitlabInfoEndpoint
+
+	return func(ctx context.Context, cfg oauth2.Config, token *oauth2.Token) (map[string]string, error) {
+		client := cfg.Client(ctx, token)
+
+		var response gitlabMeResponse
+		if err := getJSONResponse(client, endpoint, "gitlab", &response); err != nil {
+			return nil, err
+		}
+		if response.ID == 0 {
+			return nil, errors.New("gitlab oauth2 endpoint did not return a user id")
+		}
+
+		name := response.Name
+		if len(name) == 0 {
+			name = response.Username
+		}
+
+		return map[string]string{
+			OAuth2UID:   strconv.FormatInt(response.ID, 10),
+			OAuth2Email: response.Email,
+			OAuth2Name:  name,
+		}, nil
+	}
+}
+
+type appleUser struct {
+	Name struct {
+		FirstName string `json:"firstName"`
+		LastName  string `json:"lastName"`
+	} `json:"name"`
+}
+
+// AppleUserDetails can be used as a FindUserDetails function
+// for an authboss.OAuth2Provider using Sign in with Apple. Apple has no
+// endpoint for user details so they're taken from the claims of the id
+// token that was returned from its token endpoint. The token's issuer,
+// audience and expiry are checked but not its signature, set the
+// provider's OIDCIssuer to AppleIssuer to have it fully verified. When
+// called by the oauth2 module the expiry is checked against Authboss.Now().
+//
+// Apple only sends the user's name the first time they sign in, in the
+// user parameter posted to the callback. Because of this the name is
+// missing from the details on later logins and the OAuth2ServerStorer
+// should keep the one it has. Asking for the name or email scopes makes
+// Apple post to the callback (response_mode=form_post) which needs the
+// session cookie to be sent on cross-site posts.
+func AppleUserDetails(ctx context.Context, cfg oauth2.Config, token *oauth2.Token) (map[string]string, error) {
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || len(rawIDToken) == 0 {
+		return nil, errors.New("apple token response did not contain an id_token")
+	}
+
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidIDToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	var claims idTokenClaims
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	if claims.Issuer != AppleIssuer || !claims.Audience.contains(cfg.ClientID) ||
+		len(claims.Subject) == 0 || !contextNow(ctx).Before(time.Unix(claims.ExpiresAt, 0)) {
+		return nil, ErrInvalidIDToken
+	}
+
+	details := claims.details()
+
+	if form, ok := ctx.Value(ctxKeyCallbackForm).(url.Values); ok {
+		if rawUser := form.Get("user"); len(rawUser) != 0 {
+			var user appleUser
+			if err = json.Unmarshal([]byte(rawUser), &user); err != nil {
+				return nil, errors.Wrap(err, "failed to parse apple user")
+			}
+
+			name := strings.TrimSpace(user.Name.FirstName + " " + user.Name.LastName)
+			if len(name) != 0 {
+				details[OAuth2Name] = name
+			}
+		}
+	}
+
+	return details, nil
+}
+
+// contextNow returns the time the oauth2 module put in the context, the
+// system time is used when a FindUserDetails function is called directly.
+func contextNow(ctx context.Context) time.Time {
+	if now, ok := ctx.Value(ctxKeyNow).(time.Time); ok {
+		return now
+	}
+
+	return time.Now()
+}
@@ -2,25 +2,72 @@ package oauth2
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/volatiletech/authboss/v3/mocks"
 	"golang.org/x/oauth2"
 )
 
 func init() {
 	// This has an extra parameter that the Google client wouldn't normally
 	// get, but it'll safely be ignored.
-	clientGet = func(_ *http.Client, url string) (*http.Response, error) {
+	clientGet = func(client *http.Client, url string) (*http.Response, error) {
+		// Tests use the access token to ask for a failed response
+		var accessToken string
+		if transport, ok := client.Transport.(*oauth2.Transport); ok {
+			if tok, err := transport.Source.Token(); err == nil {
+				accessToken = tok.AccessToken
+			}
+		}
+
+		switch accessToken {
+		case "unauthorized":
+			return &http.Response{
+				StatusCode: http.StatusUnauthorized,
+				Body:       io.NopCloser(strings.NewReader(`{"message":"401 Unauthorized"}`)),
+			}, nil
+		case "empty":
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(`{}`)),
+			}, nil
+		}
+
+		body := `{"id":"id", "email":"email", "name": "name"}`
+		switch url {
+		case githubInfoEndpoint:
+			body = `{"id":42, "login":"login", "name":"", "email":"public"}`
+		case githubEmailsEndpoint:
+			body = `[{"email":"other","primary":false,"verified":true},{"email":"email","primary":true,"verified":true}]`
+		case microsoftInfoEndpoint:
+			body = `{"id":"id", "displayName":"name", "mail":null, "userPrincipalName":"email"}`
+		case GitLabURL + gitlabInfoEndpoint, "https://git.example.com" + gitlabInfoEndpoint:
+			body = `{"id":42, "username":"login", "name":"name", "email":"email"}`
+		}
+
 		return &http.Response{
-			Body: io.NopCloser(strings.NewReader(`{"id":"id", "email":"email", "name": "name"}`)),
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(body)),
 		}, nil
 	}
 }
 
+func testProviderToken() *oauth2.Token {
+	return &oauth2.Token{
+		AccessToken:  "token",
+		TokenType:    "Bearer",
+		RefreshToken: "refresh",
+		Expiry:       time.Now().Add(60 * time.Minute),
+	}
+}
+
 func TestGoogle(t *testing.T) {
 	t.Parallel()
 
@@ -71,3 +118,218 @@ func TestFacebook(t *testing.T) {
 		t.Error("Name wrong:", name)
 	}
 }
+
+func TestGitHub(t *testing.T) {
+	t.Parallel()
+
+	cfg := *testProviders["google"].OAuth2Config
+	details, err := GitHubUserDetails(context.Background(), cfg, testProviderToken())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uid := details[OAuth2UID]; uid != "42" {
+		t.Error("UID wrong:", uid)
+	}
+	if email := details[OAuth2Email]; email != "email" {
+		t.Error("Email should be the primary verified one:", email)
+	}
+	if name := details[OAuth2Name]; name != "login" {
+		t.Error("Name should fall back to the login:", name)
+	}
+}
+
+func TestMicrosoft(t *testing.T) {
+	t.Parallel()
+
+	cfg := *testProviders["google"].OAuth2Config
+	details, err := MicrosoftUserDetails(context.Background(), cfg, testProviderToken())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uid := details[OAuth2UID]; uid != "id" {
+		t.Error("UID wrong:", uid)
+	}
+	if email := details[OAuth2Email]; email != "" {
+		t.Error("Email should be empty without a mail address:", email)
+	}
+	if upn := details[MicrosoftUserPrincipalName]; upn != "email" {
+		t.Error("UPN wrong:", upn)
+	}
+	if name := details[OAuth2Name]; name != "name" {
+		t.Error("Name wrong:", name)
+	}
+}
+
+func TestGitLab(t *testing.T) {
+	t.Parallel()
+
+	cfg := *testProviders["google"].OAuth2Config
+	fetchers := []func(context.Context, oauth2.Config, *oauth2.Token) (map[string]string, error){
+		GitLabUserDetails,
+		NewGitLabUserDetails("https://git.example.com/"),
+	}
+
+	for i, fetch := range fetchers {
+		details, err := fetch(context.Background(), cfg, testProviderToken())
+		if err != nil {
+			t.Fatal(i, err)
+		}
+
+		if uid := details[OAuth2UID]; uid != "42" {
+			t.Errorf("%d) UID wrong: %s", i, uid)
+		}
+		if email := details[OAuth2Email]; email != "email" {
+			t.Errorf("%d) Email wrong: %s", i, email)
+		}
+		if name := details[OAuth2Name]; name != "name" {
+			t.Errorf("%d) Name wrong: %s", i, name)
+		}
+	}
+}
+
+func TestProviderFailures(t *testing.T) {
+	t.Parallel()
+
+	cfg := *testProviders["google"].OAuth2Config
+	fetchers := map[string]func(context.Context, oauth2.Config, *oauth2.Token) (map[string]string, error){
+		"github":    GitHubUserDetails,
+		"microsoft": MicrosoftUserDetails,
+		"gitlab":    GitLabUserDetails,
+	}
+
+	for name, fetch := range fetchers {
+		for _, accessToken := range []string{"unauthorized", "empty"} {
+			tok := testProviderToken()
+			tok.AccessToken = accessToken
+
+			details, err := fetch(context.Background(), cfg, tok)
+			if err == nil {
+				t.Errorf("%s %s) it should fail: %v", name, accessToken, details)
+				continue
+			}
+			if accessToken == "unauthorized" && (!strings.Contains(err.Error(), name) || !strings.Contains(err.Error(), "401")) {
+				t.Errorf("%s) error should have the name and status: %v", name, err)
+			}
+		}
+	}
+}
+
+func appleToken(claims map[string]interface{}) *oauth2.Token {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		panic(err)
+	}
+
+	idToken := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString(payload) + ".signature"
+
+	return testProviderToken().WithExtra(map[string]interface{}{"id_token": idToken})
+}
+
+func TestApple(t *testing.T) {
+	t.Parallel()
+
+	cfg := *testProviders["google"].OAuth2Config
+	claims := map[string]interface{}{
+		"iss":            AppleIssuer,
+		"sub":            "uid",
+		"aud":            cfg.ClientID,
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"email":          "email",
+		"email_verified": "true",
+	}
+
+	// Later logins don't have the name
+	details, err := AppleUserDetails(context.Background(), cfg, appleToken(claims))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid := details[OAuth2UID]; uid != "uid" {
+		t.Error("UID wrong:", uid)
+	}
+	if email := details[OAuth2Email]; email != "email" {
+		t.Error("Email wrong:", email)
+	}
+	if verified := details[OIDCEmailVerified]; verified != "true" {
+		t.Error("Email verified wrong:", verified)
+	}
+	if _, ok := details[OAuth2Name]; ok {
+		t.Error("there should be no name")
+	}
+
+	// The first login posts the user to the callback
+	form := url.Values{"user": []string{`{"name":{"firstName":"first","lastName":"last"},"email":"email"}`}}
+	ctx := context.WithValue(context.Background(), ctxKeyCallbackForm, form)
+	details, err = AppleUserDetails(ctx, cfg, appleToken(claims))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := details[OAuth2Name]; name != "first last" {
+		t.Error("Name wrong:", name)
+	}
+}
+
+func TestAppleInvalid(t *testing.T) {
+	t.Parallel()
+
+	cfg := *testProviders["google"].OAuth2Config
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss": AppleIssuer,
+			"sub": "uid",
+			"aud": cfg.ClientID,
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+	}
+
+	tests := []struct {
+		Key   string
+		Value interface{}
+	}{
+		{"iss", "https://example.com"},
+		{"aud", "other"},
+		{"sub", ""},
+		{"exp", time.Now().Add(-time.Hour).Unix()},
+	}
+
+	for _, test := range tests {
+		claims := valid()
+		claims[test.Key] = test.Value
+
+		if _, err := AppleUserDetails(context.Background(), cfg, appleToken(claims)); err != ErrInvalidIDToken {
+			t.Errorf("%s) error wrong: %v", test.Key, err)
+		}
+	}
+
+	if _, err := AppleUserDetails(context.Background(), cfg, testProviderToken()); err == nil {
+		t.Error("it should fail without an id token")
+	}
+}
+
+func TestAppleClock(t *testing.T) {
+	t.Parallel()
+
+	cfg := *testProviders["google"].OAuth2Config
+	claims := map[string]interface{}{
+		"iss": AppleIssuer,
+		"sub": "uid",
+		"aud": cfg.ClientID,
+		"exp": time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC).Unix(),
+	}
+
+	// The token expired long ago by the system clock but not by the
+	// time the oauth2 module puts in the context
+	clock := mocks.NewClock(time.Date(2020, 1, 1, 11, 0, 0, 0, time.UTC))
+	ctx := context.WithValue(context.Background(), ctxKeyNow, clock.Now())
+	if _, err := AppleUserDetails(ctx, cfg, appleToken(claims)); err != nil {
+		t.Error("the id token should be valid:", err)
+	}
+
+	clock.Advance(time.Hour)
+	ctx = context.WithValue(context.Background(), ctxKeyNow, clock.Now())
+	if _, err := AppleUserDetails(ctx, cfg, appleToken(claims)); err != ErrInvalidIDToken {
+		t.Error("the id token should have expired:", err)
+	}
+}
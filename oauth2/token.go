@@ -0,0 +1,200 @@
+package oauth2
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/friendsofgo/errors"
+	"golang.org/x/oauth2"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// TokenSource returns a token source for calling the provider's APIs on
+// behalf of the user. When the access token has expired it is refreshed
+// using the user's refresh token and the new tokens are persisted with
+// OAuth2ServerStorer.SaveOAuth2. As with the oauth2 package the context
+// is used for the refreshes (and saves) so it should outlive the request
+// if the token source does.
+func TokenSource(ctx context.Context, ab *authboss.Authboss, user authboss.OAuth2User) (oauth2.TokenSource, error) {
+	source, err := userTokenSource(ctx, ab, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return source, nil
+}
+
+func userTokenSource(ctx context.Context, ab *authboss.Authboss, user authboss.OAuth2User) (*persistingTokenSource, error) {
+	if !user.IsOAuth2User() {
+		return nil, errors.Errorf("user %s is not an oauth2 user", user.GetPID())
+	}
+
+	token := &oauth2.Token{
+		AccessToken:  user.GetOAuth2AccessToken(),
+		RefreshToken: user.GetOAuth2RefreshToken(),
+		Expiry:       user.GetOAuth2Expiry(),
+	}
+
+	storer := authboss.EnsureCanOAuth2(ab.Config.Storage.Server)
+	return newTokenSource(ctx, ab, user.GetOAuth2Provider(), token, func(token *oauth2.Token) error {
+		user.PutOAuth2AccessToken(token.AccessToken)
+		user.PutOAuth2Expiry(token.Expiry)
+		if len(token.RefreshToken) != 0 {
+			user.PutOAuth2RefreshToken(token.RefreshToken)
+		}
+
+		return storer.SaveOAuth2(ctx, user)
+	})
+}
+
+// IdentityTokenSource is TokenSource for an identity that was linked with
+// the oauth2link module, new tokens are persisted with
+// OAuth2LinkingServerStorer.LinkOAuth2Identity.
+func IdentityTokenSource(ctx context.Context, ab *authboss.Authboss, identity authboss.OAuth2Identity) (oauth2.TokenSource, error) {
+	token := &oauth2.Token{
+		AccessToken:  identity.AccessToken,
+		RefreshToken: identity.RefreshToken,
+		Expiry:       identity.Expiry,
+	}
+
+	storer := authboss.EnsureCanLinkOAuth2(ab.Config.Storage.Server)
+	source, err := newTokenSource(ctx, ab, identity.Provider, token, func(token *oauth2.Token) error {
+		identity.AccessToken = token.AccessToken
+		identity.Expiry = token.Expiry
+		if len(token.RefreshToken) != 0 {
+			identity.RefreshToken = token.RefreshToken
+		}
+
+		return storer.LinkOAuth2Identity(ctx, identity)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return source, nil
+}
+
+// Refresh the user's access token now regardless of its expiry, the new
+// tokens are persisted with OAuth2ServerStorer.SaveOAuth2.
+func Refresh(ctx context.Context, ab *authboss.Authboss, user authboss.OAuth2User) error {
+	if len(user.GetOAuth2RefreshToken()) == 0 {
+		return errors.Errorf("user %s has no oauth2 refresh token", user.GetPID())
+	}
+
+	source, err := userTokenSource(ctx, ab, user)
+	if err != nil {
+		return err
+	}
+
+	// An empty access token is never valid which forces the refresh
+	source.current.AccessToken = ""
+	_, err = source.Token()
+	return err
+}
+
+// RefreshMiddleware refreshes the access token of a logged in oauth2 user
+// when it expires within the given duration, so handlers calling the
+// provider's APIs don't run into expired tokens. It loads the user if they
+// haven't been loaded yet. Failures are logged and the request carries on.
+func RefreshMiddleware(ab *authboss.Authboss, within time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logger := ab.RequestLogger(r)
+
+			user, err := ab.LoadCurrentUser(&r)
+			if err != nil && err != authboss.ErrUserNotFound {
+				logger.Errorf("failed to load user to refresh oauth2 token: %+v", err)
+			}
+
+			if oauthUser, ok := user.(authboss.OAuth2User); ok && needsRefresh(oauthUser, within, ab.Now()) {
+				if err := Refresh(r.Context(), ab, oauthUser); err != nil {
+					logger.Errorf("failed to refresh oauth2 token for %s: %+v", oauthUser.GetPID(), err)
+				} else {
+					logger.Infof("refreshed oauth2 token for %s", oauthUser.GetPID())
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func needsRefresh(user authboss.OAuth2User, within time.Duration, now time.Time) bool {
+	if !user.IsOAuth2User() || len(user.GetOAuth2RefreshToken()) == 0 {
+		return false
+	}
+
+	expiry := user.GetOAuth2Expiry()
+	return !expiry.IsZero() && expiry.Sub(now) < within
+}
+
+// tokenExpiryDelta is how long before its expiry a token is refreshed, it's
+// the same as the oauth2 package uses
+const tokenExpiryDelta = 10 * time.Second
+
+// persistingTokenSource hands out the current token until it expires by
+// Authboss.Now(), then refreshes it with the provider and saves the new one
+type persistingTokenSource struct {
+	mut     sync.Mutex
+	ctx     context.Context
+	config  *oauth2.Config
+	current *oauth2.Token
+	now     func() time.Time
+	save    func(*oauth2.Token) error
+}
+
+func newTokenSource(ctx context.Context, ab *authboss.Authboss, provider string, token *oauth2.Token, save func(*oauth2.Token) error) (*persistingTokenSource, error) {
+	for name, cfg := range ab.Config.Modules.OAuth2Providers {
+		if !strings.EqualFold(name, provider) {
+			continue
+		}
+
+		return &persistingTokenSource{
+			ctx:     ctx,
+			config:  cfg.OAuth2Config,
+			current: token,
+			now:     ab.Now,
+			save:    save,
+		}, nil
+	}
+
+	return nil, errors.Errorf("oauth2 provider %s is not configured", provider)
+}
+
+// Token returns a valid token, refreshing and saving it if necessary
+func (p *persistingTokenSource) Token() (*oauth2.Token, error) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	if p.valid() {
+		return p.current, nil
+	}
+
+	// The provider's token source always refreshes a token that has no
+	// access token, it keeps the refresh token if it doesn't send a new one
+	source := p.config.TokenSource(p.ctx, &oauth2.Token{RefreshToken: p.current.RefreshToken})
+	token, err := source.Token()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to refresh oauth2 token")
+	}
+
+	if err = p.save(token); err != nil {
+		return nil, errors.Wrap(err, "failed to save refreshed oauth2 token")
+	}
+
+	p.current = token
+	return token, nil
+}
+
+func (p *persistingTokenSource) valid() bool {
+	if len(p.current.AccessToken) == 0 {
+		return false
+	}
+
+	expiry := p.current.Expiry
+	return expiry.IsZero() || p.now().Add(tokenExpiryDelta).Before(expiry)
+}
@@ -0,0 +1,303 @@
+package oauth2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+type tokenHarness struct {
+	ab     *authboss.Authboss
+	storer *mocks.ServerStorer
+
+	refreshes int32
+}
+
+func tokenSetup(t *testing.T) *tokenHarness {
+	t.Helper()
+
+	h := &tokenHarness{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&h.refreshes, 1)
+		if r.FormValue("grant_type") != "refresh_token" || r.FormValue("refresh_token") != "refresh" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"new","token_type":"Bearer","expires_in":3600}`))
+	}))
+	t.Cleanup(server.Close)
+
+	h.ab = authboss.New()
+	h.storer = mocks.NewServerStorer()
+	h.ab.Config.Storage.Server = h.storer
+	h.ab.Config.Core.Logger = mocks.Logger{}
+	h.ab.Config.Modules.OAuth2Providers = map[string]authboss.OAuth2Provider{
+		"Google": {
+			OAuth2Config: &oauth2.Config{
+				ClientID: "id",
+				Endpoint: oauth2.Endpoint{
+					TokenURL:  server.URL,
+					AuthStyle: oauth2.AuthStyleInParams,
+				},
+			},
+		},
+	}
+
+	return h
+}
+
+func tokenUser(expiry time.Time) *mocks.User {
+	return &mocks.User{
+		OAuth2UID:      "uid",
+		OAuth2Provider: "google",
+		OAuth2Token:    "old",
+		OAuth2Refresh:  "refresh",
+		OAuth2Expiry:   expiry,
+	}
+}
+
+func TestTokenSourceValid(t *testing.T) {
+	t.Parallel()
+
+	h := tokenSetup(t)
+	user := tokenUser(time.Now().Add(time.Hour))
+
+	source, err := TokenSource(context.Background(), h.ab, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := source.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token.AccessToken != "old" {
+		t.Error("access token wrong:", token.AccessToken)
+	}
+	if atomic.LoadInt32(&h.refreshes) != 0 {
+		t.Error("it should not refresh a valid token")
+	}
+	if len(h.storer.Users) != 0 {
+		t.Error("it should not save the user")
+	}
+}
+
+func TestTokenSourceRefresh(t *testing.T) {
+	t.Parallel()
+
+	h := tokenSetup(t)
+	user := tokenUser(time.Now().Add(-time.Hour))
+
+	source, err := TokenSource(context.Background(), h.ab, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		token, err := source.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token.AccessToken != "new" {
+			t.Error("access token wrong:", token.AccessToken)
+		}
+	}
+
+	if refreshes := atomic.LoadInt32(&h.refreshes); refreshes != 1 {
+		t.Error("it should refresh once, refreshed:", refreshes)
+	}
+	if user.OAuth2Token != "new" || user.OAuth2Refresh != "refresh" || !user.OAuth2Expiry.After(time.Now()) {
+		t.Errorf("user tokens wrong: %#v", user)
+	}
+	if h.storer.Users["oauth2;;google;;uid"] != user {
+		t.Error("the user should be saved")
+	}
+}
+
+func TestTokenSourceClock(t *testing.T) {
+	t.Parallel()
+
+	h := tokenSetup(t)
+	clock := mocks.NewClock(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+	h.ab.Config.Core.Clock = clock
+
+	// Long expired by the system clock but not by the configured one
+	user := tokenUser(clock.Now().Add(time.Hour))
+
+	source, err := TokenSource(context.Background(), h.ab, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token, err := source.Token(); err != nil {
+		t.Fatal(err)
+	} else if token.AccessToken != "old" {
+		t.Error("the token should still be valid:", token.AccessToken)
+	}
+
+	clock.Advance(time.Hour)
+	if token, err := source.Token(); err != nil {
+		t.Fatal(err)
+	} else if token.AccessToken != "new" {
+		t.Error("the token should have been refreshed:", token.AccessToken)
+	}
+	if refreshes := atomic.LoadInt32(&h.refreshes); refreshes != 1 {
+		t.Error("it should refresh once, refreshed:", refreshes)
+	}
+}
+
+func TestTokenSourceErrors(t *testing.T) {
+	t.Parallel()
+
+	h := tokenSetup(t)
+
+	user := tokenUser(time.Now().Add(-time.Hour))
+	user.OAuth2Provider = "github"
+	if _, err := TokenSource(context.Background(), h.ab, user); err == nil {
+		t.Error("it should fail for an unknown provider")
+	}
+
+	user = tokenUser(time.Now().Add(-time.Hour))
+	user.OAuth2Refresh = "bad"
+	source, err := TokenSource(context.Background(), h.ab, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = source.Token(); err == nil {
+		t.Error("it should fail when the refresh fails")
+	}
+	if len(h.storer.Users) != 0 {
+		t.Error("it should not save the user")
+	}
+}
+
+func TestIdentityTokenSource(t *testing.T) {
+	t.Parallel()
+
+	h := tokenSetup(t)
+	identity := authboss.OAuth2Identity{
+		Provider:     "google",
+		UID:          "uid",
+		PID:          "test@test.com",
+		AccessToken:  "old",
+		RefreshToken: "refresh",
+		Expiry:       time.Now().Add(-time.Hour),
+	}
+
+	source, err := IdentityTokenSource(context.Background(), h.ab, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := source.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "new" {
+		t.Error("access token wrong:", token.AccessToken)
+	}
+
+	saved := h.storer.OAuth2Identities[authboss.MakeOAuth2PID("google", "uid")]
+	if saved.AccessToken != "new" || saved.RefreshToken != "refresh" || saved.PID != "test@test.com" {
+		t.Errorf("saved identity wrong: %#v", saved)
+	}
+}
+
+func TestRefreshMiddleware(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Expiry    time.Duration
+		Refreshed bool
+	}{
+		{time.Minute, true},
+		{-time.Minute, true},
+		{time.Hour, false},
+	}
+
+	for i, test := range tests {
+		h := tokenSetup(t)
+		user := tokenUser(time.Now().Add(test.Expiry))
+
+		called := false
+		server := RefreshMiddleware(h.ab, 5*time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+		}))
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+		server.ServeHTTP(httptest.NewRecorder(), r)
+
+		if !called {
+			t.Errorf("%d) the next handler should be called", i)
+		}
+		if refreshed := user.OAuth2Token == "new"; refreshed != test.Refreshed {
+			t.Errorf("%d) refreshed wrong: %t", i, refreshed)
+		}
+	}
+}
+
+func TestRefreshMiddlewareClock(t *testing.T) {
+	t.Parallel()
+
+	h := tokenSetup(t)
+	clock := mocks.NewClock(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+	h.ab.Config.Core.Clock = clock
+	user := tokenUser(clock.Now().Add(time.Hour))
+
+	server := RefreshMiddleware(h.ab, 5*time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func() {
+		r := httptest.NewRequest("GET", "/", nil)
+		r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+		server.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	serve()
+	if user.OAuth2Token != "old" {
+		t.Error("the token should not be refreshed yet")
+	}
+
+	clock.Advance(56 * time.Minute)
+	serve()
+	if user.OAuth2Token != "new" {
+		t.Error("the token should be refreshed once it expires within the duration")
+	}
+}
+
+func TestRefreshMiddlewareNoUser(t *testing.T) {
+	t.Parallel()
+
+	h := tokenSetup(t)
+
+	called := false
+	server := RefreshMiddleware(h.ab, time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	h.ab.Config.Storage.SessionState = mocks.NewClientRW()
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.ServeHTTP(w, r)
+
+	if !called {
+		t.Error("the next handler should be called")
+	}
+	if atomic.LoadInt32(&h.refreshes) != 0 {
+		t.Error("nothing should be refreshed")
+	}
+}
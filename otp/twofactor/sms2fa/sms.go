@@ -11,7 +11,6 @@ import (
 	"path"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/friendsofgo/errors"
 	"github.com/volatiletech/authboss/v3"
@@ -202,7 +201,7 @@ func (s *SMS) SendCodeToUser(w http.ResponseWriter, r *http.Request, pid, number
 		if err != nil {
 			return err
 		}
-		suppress = time.Now().UTC().Unix()-last < smsRateLimitSeconds
+		suppress = s.Now().Unix()-last < smsRateLimitSeconds
 	}
 
 	if suppress {
@@ -210,7 +209,7 @@ func (s *SMS) SendCodeToUser(w http.ResponseWriter, r *http.Request, pid, number
 		return errSMSRateLimit
 	}
 
-	authboss.PutSession(w, SessionSMSLast, strconv.FormatInt(time.Now().UTC().Unix(), 10))
+	authboss.PutSession(w, SessionSMSLast, strconv.FormatInt(s.Now().Unix(), 10))
 	authboss.PutSession(w, SessionSMSSecret, code)
 
 	logger.Infof("sending sms for %s to %s", pid, number)
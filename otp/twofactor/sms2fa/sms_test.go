@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/volatiletech/authboss/v3/otp/twofactor"
 	"golang.org/x/crypto/bcrypt"
@@ -196,6 +197,8 @@ func TestSendCodeSuppression(t *testing.T) {
 	t.Parallel()
 
 	h := testSetup()
+	clock := mocks.NewClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	h.ab.Config.Core.Clock = clock
 	r, w, _ := h.newHTTP("POST")
 
 	if err := h.sms.SendCodeToUser(w, r, "pid", "phonenumber"); err != nil {
@@ -206,12 +209,18 @@ func TestSendCodeSuppression(t *testing.T) {
 	w.WriteHeader(http.StatusOK)
 	h.loadClientState(w, &r)
 
-	// Send again within 10s, hopefully Go can execute that fast :D
+	// Send again within 10s
+	clock.Advance(9 * time.Second)
 	if err := h.sms.SendCodeToUser(w, r, "pid", "phonenumber"); err == nil {
 		t.Error("should have errored")
 	} else if err != errSMSRateLimit {
 		t.Error("it should have blocked the second send")
 	}
+
+	clock.Advance(time.Second)
+	if err := h.sms.SendCodeToUser(w, r, "pid", "phonenumber"); err != nil {
+		t.Error("it should send again after 10s:", err)
+	}
 }
 
 func TestGetSetup(t *testing.T) {
@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"time"
 
 	"github.com/friendsofgo/errors"
 	"github.com/pquerna/otp"
@@ -265,7 +266,7 @@ func (t *TOTP) PostConfirm(w http.ResponseWriter, r *http.Request) error {
 	totpCodeValues := MustHaveTOTPCodeValues(validator)
 	inputCode := totpCodeValues.GetCode()
 
-	ok = totp.Validate(inputCode, totpSecret)
+	ok = ValidCode(inputCode, totpSecret, t.Now())
 	if !ok {
 		data := authboss.HTMLData{
 			authboss.DataValidation: map[string][]string{FormValueCode: {
@@ -477,19 +478,40 @@ func (t *TOTP) validate(r *http.Request) (User, string, error) {
 		return user, t.Localizef(r.Context(), authboss.TxtSuccess), nil
 	}
 
-	input := totpCodeValues.GetCode()
+	if failure := CheckCode(user, totpCodeValues.GetCode(), t.Now()); failure != nil {
+		return user, t.Localizef(r.Context(), *failure), nil
+	}
+
+	return user, t.Localizef(r.Context(), authboss.TxtSuccess), nil
+}
 
+// CheckCode checks a code against the user's secret at the time now and
+// returns the message to show when it's wrong, or nil when it's valid.
+// Users that implement UserOneTime can't use their last code again, the
+// code is remembered on the user so the user must be saved afterwards.
+func CheckCode(user User, code string, now time.Time) *authboss.LocalizationKey {
 	if oneTime, ok := user.(UserOneTime); ok {
-		oldCode := oneTime.GetTOTPLastCode()
-		if oldCode == input {
-			return user, t.Localizef(r.Context(), authboss.TxtRepeated2FACode), nil
+		if oneTime.GetTOTPLastCode() == code {
+			return &authboss.TxtRepeated2FACode
 		}
-		oneTime.PutTOTPLastCode(input)
+		oneTime.PutTOTPLastCode(code)
 	}
 
-	if !totp.Validate(input, secret) {
-		return user, t.Localizef(r.Context(), authboss.TxtInvalid2FACode), nil
+	if !ValidCode(code, user.GetTOTPSecretKey(), now) {
+		return &authboss.TxtInvalid2FACode
 	}
 
-	return user, t.Localizef(r.Context(), authboss.TxtSuccess), nil
+	return nil
+}
+
+// ValidCode checks a code against the secret at the time now, it accepts
+// the same codes totp.Validate would (one period either side of now).
+func ValidCode(code, secret string, now time.Time) bool {
+	ok, err := totp.ValidateCustom(code, secret, now, totp.ValidateOpts{
+		Period:    30,
+		Skew:      1,
+		Digits:    otp.DigitsSix,
+		Algorithm: otp.AlgorithmSHA1,
+	})
+	return err == nil && ok
 }
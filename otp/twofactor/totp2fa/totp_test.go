@@ -625,6 +625,47 @@ func TestPostValidate(t *testing.T) {
 			t.Error("data wrong:", got)
 		}
 	})
+
+	t.Run("Clock", func(t *testing.T) {
+		h := testSetup()
+		clock := mocks.NewClock(time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC))
+		h.ab.Config.Core.Clock = clock
+
+		user := setupMore(h)
+		secret := makeSecretKey(h, user.Email)
+		user.TOTPSecretKey = secret
+
+		code, err := totp.GenerateCode(secret, clock.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.bodyReader.Return = mocks.Values{Code: code}
+
+		// Codes are accepted from one period either side of the clock
+		clock.Advance(2 * time.Minute)
+		r, w, _ := h.newHTTP("POST")
+		h.setSession(SessionTOTPPendingPID, user.Email)
+		h.loadClientState(w, &r)
+
+		if err := h.totp.PostValidate(w, r); err != nil {
+			t.Fatal(err)
+		}
+		if got := h.responder.Data[authboss.DataValidation].(map[string][]string); got[FormValueCode][0] != h.ab.Localizef(context.Background(), authboss.TxtInvalid2FACode) {
+			t.Error("data wrong:", got)
+		}
+
+		clock.Advance(-2*time.Minute + 30*time.Second)
+		user.TOTPLastCode = ""
+		r, w, _ = h.newHTTP("POST")
+		h.loadClientState(w, &r)
+
+		if err := h.totp.PostValidate(w, r); err != nil {
+			t.Fatal(err)
+		}
+		if opts := h.redirector.Options; opts.RedirectPath != h.ab.Paths.AuthLoginOK {
+			t.Error("the code should have been accepted:", opts.RedirectPath, h.responder.Data)
+		}
+	})
 }
 
 func makeSecretKey(h *testHarness, email string) string {
@@ -638,3 +679,36 @@ func makeSecretKey(h *testHarness, email string) string {
 
 	return key.Secret()
 }
+
+func TestCheckCode(t *testing.T) {
+	t.Parallel()
+
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: "authboss", AccountName: "test@test.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &mocks.User{TOTPSecretKey: key.Secret()}
+
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	code, err := totp.GenerateCode(user.TOTPSecretKey, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if failure := CheckCode(user, "000000", now); failure == nil || *failure != authboss.TxtInvalid2FACode {
+		t.Error("a wrong code should fail:", failure)
+	}
+	other := &mocks.User{TOTPSecretKey: key.Secret()}
+	if failure := CheckCode(other, code, now.Add(time.Hour)); failure == nil || *failure != authboss.TxtInvalid2FACode {
+		t.Error("the code should be checked at the time given:", failure)
+	}
+	if failure := CheckCode(user, code, now); failure != nil {
+		t.Error("the code should be valid:", *failure)
+	}
+	if user.TOTPLastCode != code {
+		t.Error("the code should be remembered:", user.TOTPLastCode)
+	}
+	if failure := CheckCode(user, code, now); failure == nil || *failure != authboss.TxtRepeated2FACode {
+		t.Error("a repeated code should fail:", failure)
+	}
+}
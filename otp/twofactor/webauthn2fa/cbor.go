@@ -0,0 +1,303 @@
+package webauthn2fa
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"math/big"
+
+	"github.com/friendsofgo/errors"
+)
+
+// The WebAuthn structures we consume (attestation objects and COSE keys)
+// are encoded in the CTAP2 canonical CBOR form. This is a small decoder for
+// that subset: definite lengths only, integers, strings, arrays, maps, tags
+// and simple values.
+
+const cborMaxDepth = 16
+
+var errCBORTruncated = errors.New("cbor: unexpected end of data")
+
+// cborDecode decodes a single item from b and returns the remaining bytes.
+// Integers are always returned as int64, byte strings as []byte, text strings
+// as string, arrays as []interface{} and maps as map[interface{}]interface{}.
+func cborDecode(b []byte) (interface{}, []byte, error) {
+	return cborDecodeDepth(b, 0)
+}
+
+func cborDecodeDepth(b []byte, depth int) (interface{}, []byte, error) {
+	if depth > cborMaxDepth {
+		return nil, nil, errors.New("cbor: nesting too deep")
+	}
+	if len(b) == 0 {
+		return nil, nil, errCBORTruncated
+	}
+
+	major := b[0] >> 5
+	info := b[0] & 0x1f
+
+	if major == 7 {
+		return cborDecodeSimple(b)
+	}
+
+	arg, rest, err := cborArgument(b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, nil, errors.New("cbor: integer overflow")
+		}
+		return int64(arg), rest, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, nil, errors.New("cbor: integer overflow")
+		}
+		return -1 - int64(arg), rest, nil
+	case 2, 3:
+		if uint64(len(rest)) < arg {
+			return nil, nil, errCBORTruncated
+		}
+		if major == 2 {
+			byt := make([]byte, arg)
+			copy(byt, rest[:arg])
+			return byt, rest[arg:], nil
+		}
+		return string(rest[:arg]), rest[arg:], nil
+	case 4:
+		if uint64(len(rest)) < arg {
+			return nil, nil, errCBORTruncated
+		}
+		arr := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			var item interface{}
+			item, rest, err = cborDecodeDepth(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			arr = append(arr, item)
+		}
+		return arr, rest, nil
+	case 5:
+		if uint64(len(rest)) < arg {
+			return nil, nil, errCBORTruncated
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			var key, val interface{}
+			key, rest, err = cborDecodeDepth(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errors.Errorf("cbor: unsupported map key type %T", key)
+			}
+			val, rest, err = cborDecodeDepth(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			if _, ok := m[key]; ok {
+				return nil, nil, errors.Errorf("cbor: duplicate map key %v", key)
+			}
+			m[key] = val
+		}
+		return m, rest, nil
+	case 6:
+		// Tags carry no meaning for the structures we decode, return the
+		// tagged item itself.
+		return cborDecodeDepth(rest, depth+1)
+	}
+
+	return nil, nil, errors.Errorf("cbor: unsupported major type %d (info %d)", major, info)
+}
+
+// cborArgument reads the argument (length or value) following the initial
+// byte of a data item.
+func cborArgument(b []byte) (uint64, []byte, error) {
+	info := b[0] & 0x1f
+	b = b[1:]
+
+	switch {
+	case info < 24:
+		return uint64(info), b, nil
+	case info == 24:
+		if len(b) < 1 {
+			return 0, nil, errCBORTruncated
+		}
+		return uint64(b[0]), b[1:], nil
+	case info == 25:
+		if len(b) < 2 {
+			return 0, nil, errCBORTruncated
+		}
+		return uint64(binary.BigEndian.Uint16(b)), b[2:], nil
+	case info == 26:
+		if len(b) < 4 {
+			return 0, nil, errCBORTruncated
+		}
+		return uint64(binary.BigEndian.Uint32(b)), b[4:], nil
+	case info == 27:
+		if len(b) < 8 {
+			return 0, nil, errCBORTruncated
+		}
+		return binary.BigEndian.Uint64(b), b[8:], nil
+	}
+
+	return 0, nil, errors.New("cbor: indefinite lengths are not supported")
+}
+
+func cborDecodeSimple(b []byte) (interface{}, []byte, error) {
+	info := b[0] & 0x1f
+	b = b[1:]
+
+	switch info {
+	case 20:
+		return false, b, nil
+	case 21:
+		return true, b, nil
+	case 22, 23:
+		return nil, b, nil
+	case 26:
+		if len(b) < 4 {
+			return nil, nil, errCBORTruncated
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), b[4:], nil
+	case 27:
+		if len(b) < 8 {
+			return nil, nil, errCBORTruncated
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), b[8:], nil
+	}
+
+	return nil, nil, errors.Errorf("cbor: unsupported simple value %d", info)
+}
+
+// COSE algorithm identifiers that are supported
+const (
+	coseAlgES256 = -7
+	coseAlgEdDSA = -8
+	coseAlgRS256 = -257
+)
+
+// COSE key types and curves
+const (
+	coseKtyOKP = 1
+	coseKtyEC2 = 2
+	coseKtyRSA = 3
+
+	coseCrvP256    = 1
+	coseCrvEd25519 = 6
+)
+
+// coseKey is a public key decoded from its COSE_Key representation
+type coseKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+// parseCOSEKey decodes a COSE_Key public key that must be one of the
+// algorithms advertised in the creation options.
+func parseCOSEKey(b []byte) (coseKey, error) {
+	item, rest, err := cborDecode(b)
+	if err != nil {
+		return coseKey{}, err
+	}
+	if len(rest) != 0 {
+		return coseKey{}, errors.New("cose key had trailing data")
+	}
+
+	m, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return coseKey{}, errors.New("cose key was not a map")
+	}
+
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+
+	switch kty {
+	case coseKtyEC2:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		y, _ := m[int64(-3)].([]byte)
+		if alg != coseAlgES256 || crv != coseCrvP256 {
+			return coseKey{}, errors.Errorf("unsupported ec2 cose key alg=%d crv=%d", alg, crv)
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return coseKey{}, errors.New("invalid p-256 coordinates in cose key")
+		}
+
+		// ecdh validates that the point is on the curve for us
+		point := append(append([]byte{4}, x...), y...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return coseKey{}, errors.Wrap(err, "invalid p-256 point in cose key")
+		}
+
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		return coseKey{alg: alg, key: pub}, nil
+	case coseKtyRSA:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if alg != coseAlgRS256 {
+			return coseKey{}, errors.Errorf("unsupported rsa cose key alg=%d", alg)
+		}
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return coseKey{}, errors.New("invalid rsa cose key parameters")
+		}
+
+		var exponent int
+		for _, byt := range e {
+			exponent = exponent<<8 | int(byt)
+		}
+
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}
+		return coseKey{alg: alg, key: pub}, nil
+	case coseKtyOKP:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		if alg != coseAlgEdDSA || crv != coseCrvEd25519 {
+			return coseKey{}, errors.Errorf("unsupported okp cose key alg=%d crv=%d", alg, crv)
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return coseKey{}, errors.New("invalid ed25519 cose key")
+		}
+
+		return coseKey{alg: alg, key: ed25519.PublicKey(x)}, nil
+	}
+
+	return coseKey{}, errors.Errorf("unsupported cose key type: %d", kty)
+}
+
+// verify the signature over data
+func (c coseKey) verify(data, sig []byte) error {
+	switch pub := c.key.(type) {
+	case *ecdsa.PublicKey:
+		sum := sha256.Sum256(data)
+		if !ecdsa.VerifyASN1(pub, sum[:], sig) {
+			return errors.New("ecdsa signature mismatch")
+		}
+		return nil
+	case *rsa.PublicKey:
+		sum := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, data, sig) {
+			return errors.New("ed25519 signature mismatch")
+		}
+		return nil
+	}
+
+	return errors.Errorf("unsupported public key type: %T", c.key)
+}
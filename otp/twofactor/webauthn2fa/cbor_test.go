@@ -0,0 +1,209 @@
+package webauthn2fa
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/binary"
+	"testing"
+)
+
+// The helpers below encode the small subset of CBOR needed to build
+// attestation objects and COSE keys in tests.
+
+type cborPair struct {
+	key interface{}
+	val interface{}
+}
+
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= 0xffff:
+		b := []byte{major<<5 | 25, 0, 0}
+		binary.BigEndian.PutUint16(b[1:], uint16(n))
+		return b
+	default:
+		b := []byte{major<<5 | 26, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		return b
+	}
+}
+
+func cborEncode(v interface{}) []byte {
+	switch val := v.(type) {
+	case int:
+		if val < 0 {
+			return cborHead(1, uint64(-1-val))
+		}
+		return cborHead(0, uint64(val))
+	case []byte:
+		return append(cborHead(2, uint64(len(val))), val...)
+	case string:
+		return append(cborHead(3, uint64(len(val))), val...)
+	case []interface{}:
+		b := cborHead(4, uint64(len(val)))
+		for _, item := range val {
+			b = append(b, cborEncode(item)...)
+		}
+		return b
+	case []cborPair:
+		b := cborHead(5, uint64(len(val)))
+		for _, p := range val {
+			b = append(b, cborEncode(p.key)...)
+			b = append(b, cborEncode(p.val)...)
+		}
+		return b
+	case bool:
+		if val {
+			return []byte{0xf5}
+		}
+		return []byte{0xf4}
+	}
+
+	panic("cannot encode type in test")
+}
+
+func TestCBORDecode(t *testing.T) {
+	t.Parallel()
+
+	encoded := cborEncode([]cborPair{
+		{key: 1, val: -7},
+		{key: "bytes", val: []byte{1, 2, 3}},
+		{key: "array", val: []interface{}{"a", 500, true}},
+		{key: -2, val: 70000},
+	})
+
+	item, rest, err := cborDecode(append(encoded, 0xff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rest, []byte{0xff}) {
+		t.Error("rest was wrong:", rest)
+	}
+
+	m := item.(map[interface{}]interface{})
+	if got := m[int64(1)]; got != int64(-7) {
+		t.Error("int key wrong:", got)
+	}
+	if got := m["bytes"].([]byte); !bytes.Equal(got, []byte{1, 2, 3}) {
+		t.Error("bytes wrong:", got)
+	}
+	if got := m["array"].([]interface{}); got[0] != "a" || got[1] != int64(500) || got[2] != true {
+		t.Error("array wrong:", got)
+	}
+	if got := m[int64(-2)]; got != int64(70000) {
+		t.Error("large int wrong:", got)
+	}
+}
+
+func TestCBORDecodeErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string][]byte{
+		"Empty":         {},
+		"Truncated":     {0x43, 1, 2},
+		"TruncatedArg":  {0x19, 1},
+		"Indefinite":    {0x5f},
+		"DuplicateKey":  cborEncode([]cborPair{{key: 1, val: 1}, {key: 1, val: 2}}),
+		"BadKeyType":    append(cborHead(5, 1), append(cborEncode([]byte{1}), 0x01)...),
+		"HugeLength":    {0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"UnknownSimple": {0xe0},
+	}
+
+	deep := make([]byte, 0, cborMaxDepth+2)
+	for i := 0; i < cborMaxDepth+2; i++ {
+		deep = append(deep, 0x81)
+	}
+	tests["TooDeep"] = append(deep, 0x01)
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			if _, _, err := cborDecode(test); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestParseCOSEKey(t *testing.T) {
+	t.Parallel()
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("ES256", func(t *testing.T) {
+		key, err := parseCOSEKey(coseEC2Key(&ecKey.PublicKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sig, err := ecdsa.SignASN1(rand.Reader, ecKey, sha256Sum([]byte("data")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = key.verify([]byte("data"), sig); err != nil {
+			t.Error(err)
+		}
+		if err = key.verify([]byte("other"), sig); err == nil {
+			t.Error("signature should not verify")
+		}
+	})
+
+	t.Run("EdDSA", func(t *testing.T) {
+		encoded := cborEncode([]cborPair{
+			{key: 1, val: coseKtyOKP},
+			{key: 3, val: coseAlgEdDSA},
+			{key: -1, val: coseCrvEd25519},
+			{key: -2, val: []byte(edPub)},
+		})
+		key, err := parseCOSEKey(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = key.verify([]byte("data"), ed25519.Sign(edPriv, []byte("data"))); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("PointNotOnCurve", func(t *testing.T) {
+		x := ecKey.PublicKey.X.FillBytes(make([]byte, 32))
+		y := ecKey.PublicKey.Y.FillBytes(make([]byte, 32))
+		y[31] ^= 1
+
+		encoded := cborEncode([]cborPair{
+			{key: 1, val: coseKtyEC2},
+			{key: 3, val: coseAlgES256},
+			{key: -1, val: coseCrvP256},
+			{key: -2, val: x},
+			{key: -3, val: y},
+		})
+		if _, err := parseCOSEKey(encoded); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("UnsupportedAlg", func(t *testing.T) {
+		encoded := cborEncode([]cborPair{
+			{key: 1, val: coseKtyEC2},
+			{key: 3, val: -35},
+			{key: -1, val: 2},
+		})
+		if _, err := parseCOSEKey(encoded); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
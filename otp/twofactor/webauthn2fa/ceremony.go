@@ -0,0 +1,439 @@
+package webauthn2fa
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/friendsofgo/errors"
+)
+
+const (
+	challengeSize = 32
+
+	flagUserPresent      = 0x01
+	flagUserVerified     = 0x04
+	flagAttestedCredData = 0x40
+
+	ceremonyTypeCreate = "webauthn.create"
+	ceremonyTypeGet    = "webauthn.get"
+)
+
+// User verification requirements
+const (
+	UserVerificationRequired  = "required"
+	UserVerificationPreferred = "preferred"
+)
+
+// Credential is a public key credential that was registered by a user's
+// authenticator. A user may have many of them.
+type Credential struct {
+	ID        []byte    `json:"id"`
+	PublicKey []byte    `json:"public_key"`
+	SignCount uint32    `json:"sign_count"`
+	AAGUID    []byte    `json:"aaguid,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// EncodeCredentials turns the credentials into a single string for storage
+// in the user.
+func EncodeCredentials(creds []Credential) (string, error) {
+	if len(creds) == 0 {
+		return "", nil
+	}
+
+	byt, err := json.Marshal(creds)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode webauthn credentials")
+	}
+
+	return string(byt), nil
+}
+
+// DecodeCredentials is the inverse of EncodeCredentials
+func DecodeCredentials(creds string) ([]Credential, error) {
+	if len(creds) == 0 {
+		return nil, nil
+	}
+
+	var decoded []Credential
+	if err := json.Unmarshal([]byte(creds), &decoded); err != nil {
+		return nil, errors.Wrap(err, "failed to decode webauthn credentials")
+	}
+
+	return decoded, nil
+}
+
+// CreationOptions are handed to navigator.credentials.create() in the
+// browser to register a new credential. All binary values are
+// base64url-encoded without padding.
+type CreationOptions struct {
+	PublicKey PublicKeyCreationOptions `json:"publicKey"`
+}
+
+// PublicKeyCreationOptions is the PublicKeyCredentialCreationOptions
+// dictionary from the WebAuthn specification.
+type PublicKeyCreationOptions struct {
+	RP                     RelyingParty           `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	Challenge              string                 `json:"challenge"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout,omitempty"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials,omitempty"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions are handed to navigator.credentials.get() in the browser
+// to produce an assertion. All binary values are base64url-encoded without
+// padding.
+type RequestOptions struct {
+	PublicKey PublicKeyRequestOptions `json:"publicKey"`
+}
+
+// PublicKeyRequestOptions is the PublicKeyCredentialRequestOptions
+// dictionary from the WebAuthn specification.
+type PublicKeyRequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int64                  `json:"timeout,omitempty"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// RelyingParty identifies the site to the authenticator
+type RelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// UserEntity identifies the user to the authenticator
+type UserEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// CredentialParameter is an acceptable credential algorithm
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+// CredentialDescriptor references an existing credential
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// AuthenticatorSelection describes the authenticator we'd like to use
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// relyingParty holds the values a ceremony is verified against
+type relyingParty struct {
+	id      string
+	origins []string
+}
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// credentialResponse is the JSON serialization of a PublicKeyCredential
+// as produced by PublicKeyCredential.toJSON() in the browser.
+type credentialResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response"`
+}
+
+type authenticatorData struct {
+	raw       []byte
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte
+}
+
+// generateChallenge creates a new random challenge
+func generateChallenge() (string, error) {
+	byt := make([]byte, challengeSize)
+	if _, err := io.ReadFull(rand.Reader, byt); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(byt), nil
+}
+
+// userHandle derives the opaque user handle given to authenticators from
+// the pid, this avoids handing out e-mail addresses as user handles.
+func userHandle(pid string) string {
+	sum := sha256.Sum256([]byte(pid))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func descriptors(creds []Credential) []CredentialDescriptor {
+	descs := make([]CredentialDescriptor, len(creds))
+	for i, c := range creds {
+		descs[i] = CredentialDescriptor{
+			Type: "public-key",
+			ID:   base64.RawURLEncoding.EncodeToString(c.ID),
+		}
+	}
+	return descs
+}
+
+// decodeB64 accepts base64url with or without padding since browsers
+// and javascript helper libraries differ here.
+func decodeB64(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// verifyRegistration checks a response from navigator.credentials.create()
+// and returns the newly created credential.
+//
+// Attestation statements are not verified, the creation options ask for
+// "none" conveyance since we do not make trust decisions based on the
+// authenticator's make and model.
+func (rp relyingParty) verifyRegistration(challenge, response string, requireUV bool) (Credential, error) {
+	var cred credentialResponse
+	if err := json.Unmarshal([]byte(response), &cred); err != nil {
+		return Credential{}, errors.Wrap(err, "failed to parse credential json")
+	}
+	if cred.Type != "public-key" {
+		return Credential{}, errors.Errorf("unexpected credential type: %s", cred.Type)
+	}
+
+	rawClientData, err := decodeB64(cred.Response.ClientDataJSON)
+	if err != nil {
+		return Credential{}, errors.Wrap(err, "failed to decode client data")
+	}
+	if err = rp.verifyClientData(rawClientData, ceremonyTypeCreate, challenge); err != nil {
+		return Credential{}, err
+	}
+
+	rawAttestation, err := decodeB64(cred.Response.AttestationObject)
+	if err != nil {
+		return Credential{}, errors.Wrap(err, "failed to decode attestation object")
+	}
+	item, _, err := cborDecode(rawAttestation)
+	if err != nil {
+		return Credential{}, errors.Wrap(err, "failed to parse attestation object")
+	}
+	attestation, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return Credential{}, errors.New("attestation object was not a map")
+	}
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return Credential{}, errors.New("attestation object was missing authData")
+	}
+
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return Credential{}, err
+	}
+	if err = rp.verifyAuthenticatorData(authData, requireUV); err != nil {
+		return Credential{}, err
+	}
+	if authData.flags&flagAttestedCredData == 0 {
+		return Credential{}, errors.New("authenticator data did not include a credential")
+	}
+
+	if _, err = parseCOSEKey(authData.publicKey); err != nil {
+		return Credential{}, err
+	}
+
+	if rawID, err := decodeB64(cred.RawID); err != nil || !bytes.Equal(rawID, authData.credentialID) {
+		return Credential{}, errors.New("credential id did not match authenticator data")
+	}
+
+	return Credential{
+		ID:        authData.credentialID,
+		PublicKey: authData.publicKey,
+		SignCount: authData.signCount,
+		AAGUID:    authData.aaguid,
+	}, nil
+}
+
+// verifyAssertion checks a response from navigator.credentials.get() against
+// the given credentials and returns the index of the credential that was
+// used along with its new signature counter.
+func (rp relyingParty) verifyAssertion(challenge, response string, creds []Credential, requireUV bool) (int, uint32, error) {
+	var cred credentialResponse
+	if err := json.Unmarshal([]byte(response), &cred); err != nil {
+		return 0, 0, errors.Wrap(err, "failed to parse credential json")
+	}
+	if cred.Type != "public-key" {
+		return 0, 0, errors.Errorf("unexpected credential type: %s", cred.Type)
+	}
+
+	rawID, err := decodeB64(cred.RawID)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to decode credential id")
+	}
+
+	index := -1
+	for i, c := range creds {
+		if subtle.ConstantTimeCompare(c.ID, rawID) == 1 {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return 0, 0, errors.New("credential is not registered to the user")
+	}
+
+	rawClientData, err := decodeB64(cred.Response.ClientDataJSON)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to decode client data")
+	}
+	if err = rp.verifyClientData(rawClientData, ceremonyTypeGet, challenge); err != nil {
+		return 0, 0, err
+	}
+
+	rawAuthData, err := decodeB64(cred.Response.AuthenticatorData)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to decode authenticator data")
+	}
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err = rp.verifyAuthenticatorData(authData, requireUV); err != nil {
+		return 0, 0, err
+	}
+
+	sig, err := decodeB64(cred.Response.Signature)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to decode signature")
+	}
+
+	key, err := parseCOSEKey(creds[index].PublicKey)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "stored credential public key is invalid")
+	}
+
+	clientDataHash := sha256.Sum256(rawClientData)
+	signed := make([]byte, 0, len(rawAuthData)+len(clientDataHash))
+	signed = append(signed, rawAuthData...)
+	signed = append(signed, clientDataHash[:]...)
+	if err = key.verify(signed, sig); err != nil {
+		return 0, 0, errors.Wrap(err, "assertion signature was invalid")
+	}
+
+	// Authenticators that do not implement counters always send 0, for those
+	// that do a counter that has not increased indicates a cloned
+	// authenticator.
+	stored := creds[index].SignCount
+	if (authData.signCount != 0 || stored != 0) && authData.signCount <= stored {
+		return 0, 0, errors.Errorf("signature counter did not increase (stored %d, got %d)", stored, authData.signCount)
+	}
+
+	return index, authData.signCount, nil
+}
+
+func (rp relyingParty) verifyClientData(raw []byte, ceremony, challenge string) error {
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return errors.Wrap(err, "failed to parse client data json")
+	}
+
+	if cd.Type != ceremony {
+		return errors.Errorf("client data type was %q, expected %q", cd.Type, ceremony)
+	}
+	if len(challenge) == 0 || subtle.ConstantTimeCompare([]byte(strings.TrimRight(cd.Challenge, "=")), []byte(challenge)) != 1 {
+		return errors.New("client data challenge did not match")
+	}
+	if cd.CrossOrigin {
+		return errors.New("cross origin ceremonies are not allowed")
+	}
+
+	for _, o := range rp.origins {
+		if cd.Origin == o {
+			return nil
+		}
+	}
+
+	return errors.Errorf("client data origin %q is not allowed", cd.Origin)
+}
+
+func (rp relyingParty) verifyAuthenticatorData(authData authenticatorData, requireUV bool) error {
+	want := sha256.Sum256([]byte(rp.id))
+	if subtle.ConstantTimeCompare(want[:], authData.rpIDHash) != 1 {
+		return errors.New("authenticator data was for a different relying party")
+	}
+	if authData.flags&flagUserPresent == 0 {
+		return errors.New("user was not present during ceremony")
+	}
+	if requireUV && authData.flags&flagUserVerified == 0 {
+		return errors.New("user was not verified during ceremony")
+	}
+
+	return nil
+}
+
+func parseAuthenticatorData(b []byte) (authenticatorData, error) {
+	if len(b) < 37 {
+		return authenticatorData{}, errors.New("authenticator data too short")
+	}
+
+	authData := authenticatorData{
+		raw:       b,
+		rpIDHash:  b[:32],
+		flags:     b[32],
+		signCount: binary.BigEndian.Uint32(b[33:37]),
+	}
+
+	if authData.flags&flagAttestedCredData == 0 {
+		return authData, nil
+	}
+
+	rest := b[37:]
+	if len(rest) < 18 {
+		return authenticatorData{}, errors.New("attested credential data too short")
+	}
+
+	authData.aaguid = rest[:16]
+	idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if idLen == 0 || len(rest) < idLen {
+		return authenticatorData{}, errors.New("invalid credential id length")
+	}
+
+	authData.credentialID = rest[:idLen]
+	rest = rest[idLen:]
+
+	// The public key is followed by optional extension data so decode it
+	// once to find out how long it is.
+	_, after, err := cborDecode(rest)
+	if err != nil {
+		return authenticatorData{}, errors.Wrap(err, "failed to parse credential public key")
+	}
+	authData.publicKey = rest[:len(rest)-len(after)]
+
+	return authData, nil
+}
@@ -0,0 +1,312 @@
+package webauthn2fa
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+)
+
+const (
+	testRPID   = "localhost"
+	testOrigin = "http://localhost:8080"
+)
+
+func sha256Sum(b []byte) []byte {
+	sum := sha256.Sum256(b)
+	return sum[:]
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func coseEC2Key(pub *ecdsa.PublicKey) []byte {
+	return cborEncode([]cborPair{
+		{key: 1, val: coseKtyEC2},
+		{key: 3, val: coseAlgES256},
+		{key: -1, val: coseCrvP256},
+		{key: -2, val: pub.X.FillBytes(make([]byte, 32))},
+		{key: -3, val: pub.Y.FillBytes(make([]byte, 32))},
+	})
+}
+
+// testAuthenticator simulates a platform authenticator holding a single
+// ES256 credential.
+type testAuthenticator struct {
+	key    *ecdsa.PrivateKey
+	id     []byte
+	count  uint32
+	uv     bool
+	rpID   string
+	origin string
+}
+
+func newTestAuthenticator(t *testing.T) *testAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := make([]byte, 16)
+	if _, err = rand.Read(id); err != nil {
+		t.Fatal(err)
+	}
+
+	return &testAuthenticator{key: key, id: id, uv: true, rpID: testRPID, origin: testOrigin}
+}
+
+func (a *testAuthenticator) clientData(typ, challenge string) []byte {
+	byt, err := json.Marshal(clientData{Type: typ, Challenge: challenge, Origin: a.origin})
+	if err != nil {
+		panic(err)
+	}
+	return byt
+}
+
+func (a *testAuthenticator) authData(attested bool) []byte {
+	flags := byte(flagUserPresent)
+	if a.uv {
+		flags |= flagUserVerified
+	}
+	if attested {
+		flags |= flagAttestedCredData
+	}
+
+	b := append(sha256Sum([]byte(a.rpID)), flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(b[33:], a.count)
+
+	if attested {
+		b = append(b, make([]byte, 16)...)
+		b = append(b, byte(len(a.id)>>8), byte(len(a.id)))
+		b = append(b, a.id...)
+		b = append(b, coseEC2Key(&a.key.PublicKey)...)
+	}
+
+	return b
+}
+
+// create simulates navigator.credentials.create()
+func (a *testAuthenticator) create(challenge string) string {
+	attestation := cborEncode([]cborPair{
+		{key: "fmt", val: "none"},
+		{key: "attStmt", val: []cborPair{}},
+		{key: "authData", val: a.authData(true)},
+	})
+
+	resp := map[string]interface{}{
+		"id":    b64(a.id),
+		"rawId": b64(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(a.clientData(ceremonyTypeCreate, challenge)),
+			"attestationObject": b64(attestation),
+		},
+	}
+
+	byt, err := json.Marshal(resp)
+	if err != nil {
+		panic(err)
+	}
+	return string(byt)
+}
+
+// get simulates navigator.credentials.get()
+func (a *testAuthenticator) get(challenge string) string {
+	a.count++
+
+	authData := a.authData(false)
+	clientData := a.clientData(ceremonyTypeGet, challenge)
+	signed := append(append([]byte{}, authData...), sha256Sum(clientData)...)
+
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, sha256Sum(signed))
+	if err != nil {
+		panic(err)
+	}
+
+	resp := map[string]interface{}{
+		"id":    b64(a.id),
+		"rawId": b64(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(clientData),
+			"authenticatorData": b64(authData),
+			"signature":         b64(sig),
+			"userHandle":        userHandle("test@test.com"),
+		},
+	}
+
+	byt, err := json.Marshal(resp)
+	if err != nil {
+		panic(err)
+	}
+	return string(byt)
+}
+
+func testRP() relyingParty {
+	return relyingParty{id: testRPID, origins: []string{testOrigin}}
+}
+
+func TestCredentialsEncoding(t *testing.T) {
+	t.Parallel()
+
+	if s, err := EncodeCredentials(nil); err != nil || s != "" {
+		t.Error("empty credentials should encode to empty string:", s, err)
+	}
+
+	creds := []Credential{{ID: []byte{1, 2}, PublicKey: []byte{3}, SignCount: 5}}
+	s, err := EncodeCredentials(creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := DecodeCredentials(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 1 || string(decoded[0].ID) != string(creds[0].ID) || decoded[0].SignCount != 5 {
+		t.Error("round trip failed:", decoded)
+	}
+}
+
+func TestVerifyRegistration(t *testing.T) {
+	t.Parallel()
+
+	rp := testRP()
+
+	t.Run("Ok", func(t *testing.T) {
+		auth := newTestAuthenticator(t)
+		cred, err := rp.verifyRegistration("challenge", auth.create("challenge"), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(cred.ID) != string(auth.id) {
+			t.Error("credential id wrong")
+		}
+		if _, err := parseCOSEKey(cred.PublicKey); err != nil {
+			t.Error(err)
+		}
+	})
+
+	tests := map[string]func(a *testAuthenticator) (string, string, bool){
+		"WrongChallenge": func(a *testAuthenticator) (string, string, bool) {
+			return "challenge", a.create("other"), false
+		},
+		"EmptyChallenge": func(a *testAuthenticator) (string, string, bool) {
+			return "", a.create(""), false
+		},
+		"WrongOrigin": func(a *testAuthenticator) (string, string, bool) {
+			a.origin = "https://evil.com"
+			return "challenge", a.create("challenge"), false
+		},
+		"WrongRPID": func(a *testAuthenticator) (string, string, bool) {
+			a.rpID = "evil.com"
+			return "challenge", a.create("challenge"), false
+		},
+		"NotVerified": func(a *testAuthenticator) (string, string, bool) {
+			a.uv = false
+			return "challenge", a.create("challenge"), true
+		},
+		"Assertion": func(a *testAuthenticator) (string, string, bool) {
+			return "challenge", a.get("challenge"), false
+		},
+		"Garbage": func(a *testAuthenticator) (string, string, bool) {
+			return "challenge", "{", false
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			challenge, response, requireUV := test(newTestAuthenticator(t))
+			if _, err := rp.verifyRegistration(challenge, response, requireUV); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	t.Parallel()
+
+	rp := testRP()
+
+	register := func(t *testing.T) (*testAuthenticator, []Credential) {
+		auth := newTestAuthenticator(t)
+		cred, err := rp.verifyRegistration("challenge", auth.create("challenge"), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return auth, []Credential{cred}
+	}
+
+	t.Run("Ok", func(t *testing.T) {
+		auth, creds := register(t)
+
+		index, count, err := rp.verifyAssertion("challenge", auth.get("challenge"), creds, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if index != 0 {
+			t.Error("index wrong:", index)
+		}
+		if count != 1 {
+			t.Error("count wrong:", count)
+		}
+	})
+
+	t.Run("ClonedAuthenticator", func(t *testing.T) {
+		auth, creds := register(t)
+		creds[0].SignCount = 10
+
+		if _, _, err := rp.verifyAssertion("challenge", auth.get("challenge"), creds, false); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("UnknownCredential", func(t *testing.T) {
+		auth, creds := register(t)
+		creds[0].ID = []byte("other")
+
+		if _, _, err := rp.verifyAssertion("challenge", auth.get("challenge"), creds, false); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("WrongKey", func(t *testing.T) {
+		auth, creds := register(t)
+		other := newTestAuthenticator(t)
+		creds[0].PublicKey = coseEC2Key(&other.key.PublicKey)
+
+		if _, _, err := rp.verifyAssertion("challenge", auth.get("challenge"), creds, false); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("WrongChallenge", func(t *testing.T) {
+		auth, creds := register(t)
+
+		if _, _, err := rp.verifyAssertion("challenge", auth.get("other"), creds, false); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("NotVerified", func(t *testing.T) {
+		auth, creds := register(t)
+		auth.uv = false
+
+		if _, _, err := rp.verifyAssertion("challenge", auth.get("challenge"), creds, true); err == nil {
+			t.Error("expected an error")
+		}
+		if _, _, err := rp.verifyAssertion("challenge", auth.get("challenge"), creds, false); err != nil {
+			t.Error(err)
+		}
+	})
+}
@@ -0,0 +1,798 @@
+// Package webauthn2fa implements two factor auth and passwordless login
+// using WebAuthn credentials (security keys and passkeys).
+package webauthn2fa
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/friendsofgo/errors"
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/otp/twofactor"
+)
+
+const (
+	// ceremonyTimeout is the time in milliseconds the browser is asked to
+	// wait for the user to interact with their authenticator.
+	ceremonyTimeout = 300000
+
+	// fakeCredentialKeySize is the size of the random key used for fake
+	// credentials when Modules.WebAuthnFakeCredentialKey is not set.
+	fakeCredentialKeySize = 32
+
+	sessionValue2FA = "webauthn"
+)
+
+// Session keys
+const (
+	SessionWebAuthnChallenge  = "webauthn_challenge"
+	SessionWebAuthnPendingPID = "webauthn_pending"
+)
+
+// Pages
+const (
+	PageWebAuthnConfirm        = "webauthn2fa_confirm"
+	PageWebAuthnConfirmSuccess = "webauthn2fa_confirm_success"
+	PageWebAuthnLogin          = "webauthn2fa_login"
+	PageWebAuthnRemove         = "webauthn2fa_remove"
+	PageWebAuthnRemoveSuccess  = "webauthn2fa_remove_success"
+	PageWebAuthnSetup          = "webauthn2fa_setup"
+	PageWebAuthnValidate       = "webauthn2fa_validate"
+)
+
+// Form value constants
+const (
+	FormValueCredential = "credential"
+)
+
+// Data constants
+const (
+	// DataWebAuthnOptions holds the CreationOptions or RequestOptions that
+	// must be passed to navigator.credentials.create() or
+	// navigator.credentials.get() respectively.
+	DataWebAuthnOptions = "webauthn_options"
+)
+
+var errNoWebAuthnEnabled = errors.New("user does not have webauthn 2fa enabled")
+
+// User for WebAuthn
+type User interface {
+	twofactor.User
+
+	// GetWebAuthnCredentials returns the user's credentials encoded with
+	// EncodeCredentials.
+	GetWebAuthnCredentials() string
+	PutWebAuthnCredentials(string)
+}
+
+// WebAuthn implements two factor authentication and passwordless login
+// using public key credentials.
+type WebAuthn struct {
+	*authboss.Authboss
+
+	rp      relyingParty
+	fakeKey []byte
+}
+
+// Setup the module
+func (wa *WebAuthn) Setup() error {
+	rp, err := wa.relyingParty()
+	if err != nil {
+		return err
+	}
+	wa.rp = rp
+
+	wa.fakeKey = wa.Config.Modules.WebAuthnFakeCredentialKey
+	if len(wa.fakeKey) == 0 {
+		wa.fakeKey = make([]byte, fakeCredentialKeySize)
+		if _, err := io.ReadFull(rand.Reader, wa.fakeKey); err != nil {
+			return errors.Wrap(err, "failed to create webauthn fake credential key")
+		}
+	}
+
+	var unauthedResponse authboss.MWRespondOnFailure
+	if wa.Config.Modules.ResponseOnUnauthed != 0 {
+		unauthedResponse = wa.Config.Modules.ResponseOnUnauthed
+	} else if wa.Config.Modules.RoutesRedirectOnUnauthed {
+		unauthedResponse = authboss.RespondRedirect
+	}
+	abmw := authboss.MountedMiddleware2(wa.Authboss, true, authboss.RequireFullAuth, unauthedResponse)
+
+	var middleware, verified func(func(w http.ResponseWriter, r *http.Request) error) http.Handler
+	middleware = func(handler func(http.ResponseWriter, *http.Request) error) http.Handler {
+		return abmw(wa.Core.ErrorHandler.Wrap(handler))
+	}
+
+	if wa.Authboss.Config.Modules.TwoFactorEmailAuthRequired {
+		setupPath := path.Join(wa.Authboss.Paths.Mount, "/2fa/webauthn/setup")
+		emailVerify, err := twofactor.SetupEmailVerify(wa.Authboss, "webauthn", setupPath)
+		if err != nil {
+			return err
+		}
+		verified = func(handler func(http.ResponseWriter, *http.Request) error) http.Handler {
+			return abmw(emailVerify.Wrap(wa.Core.ErrorHandler.Wrap(handler)))
+		}
+	} else {
+		verified = middleware
+	}
+
+	wa.Authboss.Core.Router.Get("/2fa/webauthn/setup", verified(wa.GetSetup))
+	wa.Authboss.Core.Router.Post("/2fa/webauthn/setup", verified(wa.PostSetup))
+
+	wa.Authboss.Core.Router.Get("/2fa/webauthn/confirm", verified(wa.GetConfirm))
+	wa.Authboss.Core.Router.Post("/2fa/webauthn/confirm", verified(wa.PostConfirm))
+
+	wa.Authboss.Core.Router.Get("/2fa/webauthn/remove", middleware(wa.GetRemove))
+	wa.Authboss.Core.Router.Post("/2fa/webauthn/remove", middleware(wa.PostRemove))
+
+	wa.Authboss.Core.Router.Get("/2fa/webauthn/validate", wa.Core.ErrorHandler.Wrap(wa.GetValidate))
+	wa.Authboss.Core.Router.Post("/2fa/webauthn/validate", wa.Core.ErrorHandler.Wrap(wa.PostValidate))
+
+	pages := []string{
+		PageWebAuthnSetup,
+		PageWebAuthnValidate,
+		PageWebAuthnConfirm,
+		PageWebAuthnConfirmSuccess,
+		PageWebAuthnRemove,
+		PageWebAuthnRemoveSuccess,
+	}
+
+	if wa.Authboss.Config.Modules.WebAuthnPasswordless {
+		wa.Authboss.Core.Router.Get("/webauthn/login", wa.Core.ErrorHandler.Wrap(wa.GetLogin))
+		wa.Authboss.Core.Router.Post("/webauthn/login", wa.Core.ErrorHandler.Wrap(wa.PostLogin))
+		pages = append(pages, PageWebAuthnLogin)
+	}
+
+	wa.Authboss.Events.Before(authboss.EventAuthHijack, wa.HijackAuth)
+
+	return wa.Authboss.Core.ViewRenderer.Load(pages...)
+}
+
+// relyingParty builds the relying party from the config, falling back
+// to Paths.RootURL for anything that is not set.
+func (wa *WebAuthn) relyingParty() (relyingParty, error) {
+	rp := relyingParty{
+		id:      wa.Config.Modules.WebAuthnRPID,
+		origins: wa.Config.Modules.WebAuthnOrigins,
+	}
+
+	if len(rp.id) != 0 && len(rp.origins) != 0 {
+		return rp, nil
+	}
+
+	root, err := url.Parse(wa.Config.Paths.RootURL)
+	if err != nil {
+		return rp, errors.Wrap(err, "failed to parse root url for webauthn")
+	}
+	if len(root.Scheme) == 0 || len(root.Host) == 0 {
+		return rp, errors.New("webauthn requires Modules.WebAuthnRPID and Modules.WebAuthnOrigins or an absolute Paths.RootURL")
+	}
+
+	if len(rp.id) == 0 {
+		rp.id = root.Hostname()
+	}
+	if len(rp.origins) == 0 {
+		rp.origins = []string{root.Scheme + "://" + root.Host}
+	}
+
+	return rp, nil
+}
+
+// HijackAuth stores the user's pid in a special temporary session variable
+// and redirects them to the validation endpoint.
+func (wa *WebAuthn) HijackAuth(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+	if handled {
+		return false, nil
+	}
+
+	user := r.Context().Value(authboss.CTXKeyUser).(User)
+
+	if len(user.GetWebAuthnCredentials()) == 0 {
+		return false, nil
+	}
+
+	authboss.PutSession(w, SessionWebAuthnPendingPID, user.GetPID())
+
+	var query string
+	if len(r.URL.RawQuery) != 0 {
+		query = "?" + r.URL.RawQuery
+	}
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: wa.Paths.Mount + "/2fa/webauthn/validate" + query,
+	}
+	return true, wa.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
+}
+
+// GetSetup shows a screen allows a user to opt in to adding a security key
+func (wa *WebAuthn) GetSetup(w http.ResponseWriter, r *http.Request) error {
+	authboss.DelSession(w, SessionWebAuthnChallenge)
+	return wa.Core.Responder.Respond(w, r, http.StatusOK, PageWebAuthnSetup, nil)
+}
+
+// PostSetup sends the user on to the confirm page where the credential
+// is created.
+func (wa *WebAuthn) PostSetup(w http.ResponseWriter, r *http.Request) error {
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: wa.Paths.Mount + "/2fa/webauthn/confirm",
+	}
+	return wa.Core.Redirector.Redirect(w, r, ro)
+}
+
+// GetConfirm issues a challenge and the options required to create
+// a credential in the browser.
+func (wa *WebAuthn) GetConfirm(w http.ResponseWriter, r *http.Request) error {
+	abUser, err := wa.CurrentUser(r)
+	if err != nil {
+		return err
+	}
+	user := abUser.(User)
+
+	options, err := wa.creationOptions(w, user)
+	if err != nil {
+		return err
+	}
+
+	data := authboss.HTMLData{DataWebAuthnOptions: options}
+	return wa.Core.Responder.Respond(w, r, http.StatusOK, PageWebAuthnConfirm, data)
+}
+
+// PostConfirm verifies the newly created credential and adds it to the user
+func (wa *WebAuthn) PostConfirm(w http.ResponseWriter, r *http.Request) error {
+	logger := wa.RequestLogger(r)
+
+	abUser, err := wa.CurrentUser(r)
+	if err != nil {
+		return err
+	}
+	user := abUser.(User)
+
+	challenge, ok := authboss.GetSession(r, SessionWebAuthnChallenge)
+	if !ok {
+		return errors.New("request failed, no webauthn challenge present in session")
+	}
+	authboss.DelSession(w, SessionWebAuthnChallenge)
+
+	validator, err := wa.Authboss.Config.Core.BodyReader.Read(PageWebAuthnConfirm, r)
+	if err != nil {
+		return err
+	}
+	webauthnValues := MustHaveWebAuthnValues(validator)
+
+	creds, err := DecodeCredentials(user.GetWebAuthnCredentials())
+	if err != nil {
+		return err
+	}
+
+	requireUV := wa.Config.Modules.WebAuthnPasswordless
+	cred, err := wa.rp.verifyRegistration(challenge, webauthnValues.GetCredential(), requireUV)
+	if err == nil {
+		for _, c := range creds {
+			if string(c.ID) == string(cred.ID) {
+				err = errors.New("credential is already registered")
+				break
+			}
+		}
+	}
+	if err != nil {
+		logger.Infof("user %s webauthn registration failure: %v", user.GetPID(), err)
+
+		options, err := wa.creationOptions(w, user)
+		if err != nil {
+			return err
+		}
+		data := authboss.HTMLData{
+			authboss.DataValidation: map[string][]string{FormValueCredential: {
+				wa.Localizef(r.Context(), authboss.TxtInvalidWebAuthnCredential),
+			}},
+			DataWebAuthnOptions: options,
+		}
+		return wa.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageWebAuthnConfirm, data)
+	}
+
+	cred.CreatedAt = wa.Now()
+	creds = append(creds, cred)
+
+	encoded, err := EncodeCredentials(creds)
+	if err != nil {
+		return err
+	}
+
+	// Users adding a second key or who already have another 2fa method
+	// keep their existing recovery codes.
+	var codes []string
+	if len(user.GetRecoveryCodes()) == 0 {
+		codes, err = twofactor.GenerateRecoveryCodes()
+		if err != nil {
+			return err
+		}
+
+		crypted, err := twofactor.BCryptRecoveryCodes(codes)
+		if err != nil {
+			return err
+		}
+		user.PutRecoveryCodes(twofactor.EncodeRecoveryCodes(crypted))
+	}
+
+	// Save the user which activates 2fa
+	user.PutWebAuthnCredentials(encoded)
+	if err = wa.Authboss.Config.Storage.Server.Save(r.Context(), user); err != nil {
+		return err
+	}
+
+	authboss.DelSession(w, authboss.Session2FAAuthed)
+
+	logger.Infof("user %s enabled webauthn 2fa", user.GetPID())
+
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	if handled, err := wa.Authboss.Events.FireAfter(authboss.EventTwoFactorAdded, w, r); err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	var data authboss.HTMLData
+	if len(codes) != 0 {
+		data = authboss.HTMLData{twofactor.DataRecoveryCodes: codes}
+	}
+	return wa.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageWebAuthnConfirmSuccess, data)
+}
+
+// GetRemove starts removal, the user must prove possession of one of their
+// keys (or a recovery code) to remove them.
+func (wa *WebAuthn) GetRemove(w http.ResponseWriter, r *http.Request) error {
+	abUser, err := wa.CurrentUser(r)
+	if err != nil {
+		return err
+	}
+
+	data, err := wa.requestData(w, abUser.(User))
+	if err != nil {
+		return err
+	}
+
+	return wa.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageWebAuthnRemove, data)
+}
+
+// PostRemove removes all of the user's webauthn credentials
+func (wa *WebAuthn) PostRemove(w http.ResponseWriter, r *http.Request) error {
+	logger := wa.RequestLogger(r)
+
+	user, status, err := wa.validate(w, r, PageWebAuthnRemove)
+	switch {
+	case err == errNoWebAuthnEnabled:
+		data := authboss.HTMLData{authboss.DataErr: wa.Localizef(r.Context(), authboss.TxtWebAuthn2FANotActive)}
+		return wa.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageWebAuthnRemove, data)
+	case err != nil:
+		return err
+	case status != wa.Localizef(r.Context(), authboss.TxtSuccess):
+		logger.Infof("user %s webauthn 2fa removal failure (%s)", user.GetPID(), status)
+		data, err := wa.requestData(w, user)
+		if err != nil {
+			return err
+		}
+		data[authboss.DataValidation] = map[string][]string{FormValueCredential: {status}}
+		return wa.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageWebAuthnRemove, data)
+	}
+
+	authboss.DelSession(w, authboss.Session2FA)
+	user.PutWebAuthnCredentials("")
+	if err = wa.Authboss.Config.Storage.Server.Save(r.Context(), user); err != nil {
+		return err
+	}
+
+	logger.Infof("user %s disabled webauthn 2fa", user.GetPID())
+
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	if handled, err := wa.Authboss.Events.FireAfter(authboss.EventTwoFactorRemoved, w, r); err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	return wa.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageWebAuthnRemoveSuccess, nil)
+}
+
+// GetValidate issues a challenge for the user to sign with their key
+func (wa *WebAuthn) GetValidate(w http.ResponseWriter, r *http.Request) error {
+	user, err := wa.pendingUser(r)
+	if err != nil {
+		return err
+	}
+
+	data, err := wa.requestData(w, user)
+	if err != nil {
+		return err
+	}
+
+	return wa.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageWebAuthnValidate, data)
+}
+
+// PostValidate redirects on success
+func (wa *WebAuthn) PostValidate(w http.ResponseWriter, r *http.Request) error {
+	logger := wa.RequestLogger(r)
+
+	user, status, err := wa.validate(w, r, PageWebAuthnValidate)
+	switch {
+	case err == errNoWebAuthnEnabled:
+		logger.Infof("user %s webauthn failure (not enabled)", user.GetPID())
+		data := authboss.HTMLData{authboss.DataErr: wa.Localizef(
+			r.Context(), authboss.TxtWebAuthn2FANotActive)}
+		return wa.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageWebAuthnValidate, data)
+	case err != nil:
+		return err
+	case status != wa.Localizef(r.Context(), authboss.TxtSuccess):
+		r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+		handled, err := wa.Authboss.Events.FireAfter(authboss.EventAuthFail, w, r)
+		if err != nil {
+			return err
+		} else if handled {
+			return nil
+		}
+
+		logger.Infof("user %s webauthn 2fa failure (%s)", user.GetPID(), status)
+		data, err := wa.requestData(w, user)
+		if err != nil {
+			return err
+		}
+		data[authboss.DataValidation] = map[string][]string{FormValueCredential: {status}}
+		return wa.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageWebAuthnValidate, data)
+	}
+
+	authboss.PutSession(w, authboss.SessionKey, user.GetPID())
+	authboss.PutSession(w, authboss.Session2FA, sessionValue2FA)
+
+	authboss.DelSession(w, authboss.SessionHalfAuthKey)
+	authboss.DelSession(w, SessionWebAuthnPendingPID)
+
+	logger.Infof("user %s webauthn 2fa success", user.GetPID())
+
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	handled, err := wa.Authboss.Events.FireAfter(authboss.EventAuth, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	ro := authboss.RedirectOptions{
+		Code:             http.StatusTemporaryRedirect,
+		RedirectPath:     wa.Authboss.Config.Paths.AuthLoginOK,
+		FollowRedirParam: true,
+	}
+	return wa.Authboss.Core.Redirector.Redirect(w, r, ro)
+}
+
+// GetLogin shows the passwordless login page
+func (wa *WebAuthn) GetLogin(w http.ResponseWriter, r *http.Request) error {
+	authboss.DelSession(w, SessionWebAuthnChallenge)
+	authboss.DelSession(w, SessionWebAuthnPendingPID)
+	return wa.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageWebAuthnLogin, nil)
+}
+
+// PostLogin is done in two steps. The first post contains only the pid and
+// responds with the options for navigator.credentials.get(), the second
+// contains the resulting credential and logs the user in.
+func (wa *WebAuthn) PostLogin(w http.ResponseWriter, r *http.Request) error {
+	logger := wa.RequestLogger(r)
+
+	validator, err := wa.Authboss.Config.Core.BodyReader.Read(PageWebAuthnLogin, r)
+	if err != nil {
+		return err
+	}
+	loginValues := MustHaveWebAuthnLoginValues(validator)
+
+	if len(loginValues.GetCredential()) == 0 {
+		return wa.loginOptions(w, r, loginValues.GetPID())
+	}
+
+	pid, ok := authboss.GetSession(r, SessionWebAuthnPendingPID)
+	challenge, hasChallenge := authboss.GetSession(r, SessionWebAuthnChallenge)
+	authboss.DelSession(w, SessionWebAuthnPendingPID)
+	authboss.DelSession(w, SessionWebAuthnChallenge)
+	if !ok || !hasChallenge || len(pid) == 0 {
+		return errors.New("request failed, no webauthn login in progress")
+	}
+
+	failed := func(user authboss.User) error {
+		if user != nil {
+			r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+			handled, err := wa.Authboss.Events.FireAfter(authboss.EventAuthFail, w, r)
+			if err != nil {
+				return err
+			} else if handled {
+				return nil
+			}
+		}
+
+		data := authboss.HTMLData{
+			authboss.DataErr: wa.Localizef(r.Context(), authboss.TxtInvalidWebAuthnCredential),
+		}
+		return wa.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageWebAuthnLogin, data)
+	}
+
+	abUser, err := wa.Authboss.Config.Storage.Server.Load(r.Context(), pid)
+	if err == authboss.ErrUserNotFound {
+		logger.Infof("failed to load user requested by webauthn login: %s", pid)
+		return failed(nil)
+	} else if err != nil {
+		return err
+	}
+	user := abUser.(User)
+
+	creds, err := DecodeCredentials(user.GetWebAuthnCredentials())
+	if err != nil {
+		return err
+	}
+
+	index, signCount, err := wa.rp.verifyAssertion(challenge, loginValues.GetCredential(), creds, true)
+	if err != nil {
+		logger.Infof("user %s webauthn login failure: %v", pid, err)
+		return failed(user)
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	handled, err := wa.Authboss.Events.FireBefore(authboss.EventAuth, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	creds[index].SignCount = signCount
+	if err = wa.saveCredentials(r.Context(), user, creds); err != nil {
+		return err
+	}
+
+	logger.Infof("user %s logged in with webauthn", pid)
+	authboss.PutSession(w, authboss.SessionKey, pid)
+	authboss.PutSession(w, authboss.Session2FA, sessionValue2FA)
+	authboss.DelSession(w, authboss.SessionHalfAuthKey)
+
+	handled, err = wa.Authboss.Events.FireAfter(authboss.EventAuth, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	ro := authboss.RedirectOptions{
+		Code:             http.StatusTemporaryRedirect,
+		RedirectPath:     wa.Authboss.Config.Paths.AuthLoginOK,
+		FollowRedirParam: true,
+	}
+	return wa.Authboss.Core.Redirector.Redirect(w, r, ro)
+}
+
+// loginOptions starts a passwordless login for pid. A user that does not
+// exist or has no credentials is given a fake one so that the response
+// looks the same as for a real user, the browser's prompt simply fails.
+func (wa *WebAuthn) loginOptions(w http.ResponseWriter, r *http.Request, pid string) error {
+	if len(pid) == 0 {
+		data := authboss.HTMLData{
+			authboss.DataErr: wa.Localizef(r.Context(), authboss.TxtInvalidWebAuthnCredential),
+		}
+		return wa.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageWebAuthnLogin, data)
+	}
+
+	var creds []Credential
+	abUser, err := wa.Authboss.Config.Storage.Server.Load(r.Context(), pid)
+	switch {
+	case err == authboss.ErrUserNotFound:
+	case err != nil:
+		return err
+	default:
+		if user, ok := abUser.(User); ok {
+			if creds, err = DecodeCredentials(user.GetWebAuthnCredentials()); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(creds) == 0 {
+		creds = wa.fakeCredentials(pid)
+	}
+
+	options, err := wa.requestOptions(w, creds, UserVerificationRequired)
+	if err != nil {
+		return err
+	}
+	authboss.PutSession(w, SessionWebAuthnPendingPID, pid)
+
+	data := authboss.HTMLData{DataWebAuthnOptions: options}
+	return wa.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageWebAuthnLogin, data)
+}
+
+// fakeCredentials returns a credential for pid that no authenticator has.
+// Its id is derived from the pid so it's the same every time it's asked
+// for, a random one would show that the user doesn't exist.
+func (wa *WebAuthn) fakeCredentials(pid string) []Credential {
+	mac := hmac.New(sha256.New, wa.fakeKey)
+	mac.Write([]byte(pid))
+	return []Credential{{ID: mac.Sum(nil)}}
+}
+
+// validate returns the user, a string representing a validation status and
+// an error. The string return is completely invalid if err != nil.
+//
+// The challenge is single use and is removed from the session, a successful
+// assertion updates the stored signature counter.
+func (wa *WebAuthn) validate(w http.ResponseWriter, r *http.Request, page string) (User, string, error) {
+	logger := wa.RequestLogger(r)
+
+	user, err := wa.pendingUser(r)
+	if err != nil {
+		return nil, "", err
+	}
+
+	creds, err := DecodeCredentials(user.GetWebAuthnCredentials())
+	if err != nil {
+		return nil, "", err
+	}
+	if len(creds) == 0 {
+		return user, "", errNoWebAuthnEnabled
+	}
+
+	challenge, _ := authboss.GetSession(r, SessionWebAuthnChallenge)
+	authboss.DelSession(w, SessionWebAuthnChallenge)
+
+	validator, err := wa.Authboss.Config.Core.BodyReader.Read(page, r)
+	if err != nil {
+		return nil, "", err
+	}
+
+	webauthnValues := MustHaveWebAuthnValues(validator)
+
+	if recoveryCode := webauthnValues.GetRecoveryCode(); len(recoveryCode) != 0 {
+		var ok bool
+		recoveryCodes := twofactor.DecodeRecoveryCodes(user.GetRecoveryCodes())
+		recoveryCodes, ok = twofactor.UseRecoveryCode(recoveryCodes, recoveryCode)
+
+		if !ok {
+			return user, wa.Localizef(r.Context(), authboss.TxtInvalid2FACode), nil
+		}
+
+		logger.Infof("user %s used recovery code instead of webauthn2fa", user.GetPID())
+		user.PutRecoveryCodes(twofactor.EncodeRecoveryCodes(recoveryCodes))
+		if err := wa.Authboss.Config.Storage.Server.Save(r.Context(), user); err != nil {
+			return nil, "", err
+		}
+
+		return user, wa.Localizef(r.Context(), authboss.TxtSuccess), nil
+	}
+
+	index, signCount, err := wa.rp.verifyAssertion(challenge, webauthnValues.GetCredential(), creds, false)
+	if err != nil {
+		logger.Infof("user %s webauthn assertion rejected: %v", user.GetPID(), err)
+		return user, wa.Localizef(r.Context(), authboss.TxtInvalidWebAuthnCredential), nil
+	}
+
+	creds[index].SignCount = signCount
+	if err = wa.saveCredentials(r.Context(), user, creds); err != nil {
+		return nil, "", err
+	}
+
+	return user, wa.Localizef(r.Context(), authboss.TxtSuccess), nil
+}
+
+// pendingUser looks up CurrentUser first, otherwise session persistence can
+// allow a previous login attempt's user to be recalled here by a logged in
+// user for 2fa removal and verification.
+func (wa *WebAuthn) pendingUser(r *http.Request) (User, error) {
+	abUser, err := wa.CurrentUser(r)
+	if err == authboss.ErrUserNotFound {
+		pid, ok := authboss.GetSession(r, SessionWebAuthnPendingPID)
+		if ok && len(pid) != 0 {
+			abUser, err = wa.Authboss.Config.Storage.Server.Load(r.Context(), pid)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return abUser.(User), nil
+}
+
+func (wa *WebAuthn) saveCredentials(ctx context.Context, user User, creds []Credential) error {
+	encoded, err := EncodeCredentials(creds)
+	if err != nil {
+		return err
+	}
+
+	user.PutWebAuthnCredentials(encoded)
+	return wa.Authboss.Config.Storage.Server.Save(ctx, user)
+}
+
+// creationOptions stores a fresh challenge in the session and returns the
+// options to create a new credential for the user.
+func (wa *WebAuthn) creationOptions(w http.ResponseWriter, user User) (CreationOptions, error) {
+	creds, err := DecodeCredentials(user.GetWebAuthnCredentials())
+	if err != nil {
+		return CreationOptions{}, err
+	}
+
+	challenge, err := generateChallenge()
+	if err != nil {
+		return CreationOptions{}, errors.Wrap(err, "failed to create webauthn challenge")
+	}
+	authboss.PutSession(w, SessionWebAuthnChallenge, challenge)
+
+	userVerification := UserVerificationPreferred
+	residentKey := "discouraged"
+	if wa.Config.Modules.WebAuthnPasswordless {
+		userVerification = UserVerificationRequired
+		residentKey = "preferred"
+	}
+
+	rpName := wa.Config.Modules.WebAuthnRPName
+	if len(rpName) == 0 {
+		rpName = wa.rp.id
+	}
+
+	return CreationOptions{
+		PublicKey: PublicKeyCreationOptions{
+			RP: RelyingParty{ID: wa.rp.id, Name: rpName},
+			User: UserEntity{
+				ID:          userHandle(user.GetPID()),
+				Name:        user.GetEmail(),
+				DisplayName: user.GetEmail(),
+			},
+			Challenge: challenge,
+			PubKeyCredParams: []CredentialParameter{
+				{Type: "public-key", Alg: coseAlgES256},
+				{Type: "public-key", Alg: coseAlgEdDSA},
+				{Type: "public-key", Alg: coseAlgRS256},
+			},
+			Timeout:            ceremonyTimeout,
+			ExcludeCredentials: descriptors(creds),
+			AuthenticatorSelection: AuthenticatorSelection{
+				ResidentKey:      residentKey,
+				UserVerification: userVerification,
+			},
+			Attestation: "none",
+		},
+	}, nil
+}
+
+// requestOptions stores a fresh challenge in the session and returns the
+// options to assert one of creds.
+func (wa *WebAuthn) requestOptions(w http.ResponseWriter, creds []Credential, userVerification string) (RequestOptions, error) {
+	challenge, err := generateChallenge()
+	if err != nil {
+		return RequestOptions{}, errors.Wrap(err, "failed to create webauthn challenge")
+	}
+	authboss.PutSession(w, SessionWebAuthnChallenge, challenge)
+
+	return RequestOptions{
+		PublicKey: PublicKeyRequestOptions{
+			Challenge:        challenge,
+			Timeout:          ceremonyTimeout,
+			RPID:             wa.rp.id,
+			AllowCredentials: descriptors(creds),
+			UserVerification: userVerification,
+		},
+	}, nil
+}
+
+// requestData returns template data containing request options for the
+// user's credentials.
+func (wa *WebAuthn) requestData(w http.ResponseWriter, user User) (authboss.HTMLData, error) {
+	creds, err := DecodeCredentials(user.GetWebAuthnCredentials())
+	if err != nil {
+		return nil, err
+	}
+
+	options, err := wa.requestOptions(w, creds, UserVerificationPreferred)
+	if err != nil {
+		return nil, err
+	}
+
+	return authboss.HTMLData{DataWebAuthnOptions: options}, nil
+}
@@ -0,0 +1,44 @@
+package webauthn2fa
+
+import (
+	"fmt"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// WebAuthnValuer returns a credential from the body
+type WebAuthnValuer interface {
+	authboss.Validator
+
+	GetCredential() string
+	GetRecoveryCode() string
+}
+
+// MustHaveWebAuthnValues upgrades a validatable set of values
+// to ones specific to webauthn.
+func MustHaveWebAuthnValues(v authboss.Validator) WebAuthnValuer {
+	if u, ok := v.(WebAuthnValuer); ok {
+		return u
+	}
+
+	panic(fmt.Sprintf("bodyreader returned a type that could not be upgraded to WebAuthnValuer: %T", v))
+}
+
+// WebAuthnLoginValuer returns the pid and credential from the body of a
+// passwordless login.
+type WebAuthnLoginValuer interface {
+	authboss.Validator
+
+	GetPID() string
+	GetCredential() string
+}
+
+// MustHaveWebAuthnLoginValues upgrades a validatable set of values
+// to ones specific to a passwordless webauthn login.
+func MustHaveWebAuthnLoginValues(v authboss.Validator) WebAuthnLoginValuer {
+	if u, ok := v.(WebAuthnLoginValuer); ok {
+		return u
+	}
+
+	panic(fmt.Sprintf("bodyreader returned a type that could not be upgraded to WebAuthnLoginValuer: %T", v))
+}
@@ -0,0 +1,880 @@
+package webauthn2fa
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/mocks"
+	"github.com/volatiletech/authboss/v3/otp/twofactor"
+)
+
+func TestWebAuthnSetup(t *testing.T) {
+	t.Parallel()
+
+	setup := func(passwordless bool) (*mocks.Router, error) {
+		ab := authboss.New()
+		router := &mocks.Router{}
+
+		ab.Config.Core.Router = router
+		ab.Config.Core.ViewRenderer = &mocks.Renderer{}
+		ab.Config.Core.ErrorHandler = &mocks.ErrorHandler{}
+		ab.Config.Paths.RootURL = "https://example.com:8443"
+		ab.Config.Modules.WebAuthnPasswordless = passwordless
+
+		wa := &WebAuthn{Authboss: ab}
+		if err := wa.Setup(); err != nil {
+			return nil, err
+		}
+
+		if wa.rp.id != "example.com" {
+			t.Error("rp id wrong:", wa.rp.id)
+		}
+		if len(wa.rp.origins) != 1 || wa.rp.origins[0] != "https://example.com:8443" {
+			t.Error("origins wrong:", wa.rp.origins)
+		}
+		if len(wa.fakeKey) != fakeCredentialKeySize {
+			t.Error("a random fake credential key should be made:", len(wa.fakeKey))
+		}
+
+		return router, nil
+	}
+
+	router, err := setup(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gets := []string{"/2fa/webauthn/setup", "/2fa/webauthn/confirm", "/2fa/webauthn/remove", "/2fa/webauthn/validate"}
+	posts := []string{"/2fa/webauthn/setup", "/2fa/webauthn/confirm", "/2fa/webauthn/remove", "/2fa/webauthn/validate"}
+	if err := router.HasGets(gets...); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasPosts(posts...); err != nil {
+		t.Error(err)
+	}
+
+	router, err = setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := router.HasGets(append(gets, "/webauthn/login")...); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasPosts(append(posts, "/webauthn/login")...); err != nil {
+		t.Error(err)
+	}
+
+	ab := authboss.New()
+	ab.Config.Core.Router = &mocks.Router{}
+	ab.Config.Core.ViewRenderer = &mocks.Renderer{}
+	ab.Config.Core.ErrorHandler = &mocks.ErrorHandler{}
+	ab.Config.Paths.RootURL = ""
+	if err := (&WebAuthn{Authboss: ab}).Setup(); err == nil {
+		t.Error("it should fail without a relying party")
+	}
+}
+
+type testHarness struct {
+	webauthn *WebAuthn
+	ab       *authboss.Authboss
+
+	bodyReader *mocks.BodyReader
+	responder  *mocks.Responder
+	redirector *mocks.Redirector
+	session    *mocks.ClientStateRW
+	storer     *mocks.ServerStorer
+}
+
+func testSetup() *testHarness {
+	harness := &testHarness{}
+
+	harness.ab = authboss.New()
+	harness.bodyReader = &mocks.BodyReader{}
+	harness.redirector = &mocks.Redirector{}
+	harness.responder = &mocks.Responder{}
+	harness.session = mocks.NewClientRW()
+	harness.storer = mocks.NewServerStorer()
+
+	harness.ab.Config.Paths.AuthLoginOK = "/login/ok"
+
+	harness.ab.Config.Core.BodyReader = harness.bodyReader
+	harness.ab.Config.Core.Logger = mocks.Logger{}
+	harness.ab.Config.Core.Responder = harness.responder
+	harness.ab.Config.Core.Redirector = harness.redirector
+	harness.ab.Config.Storage.SessionState = harness.session
+	harness.ab.Config.Storage.Server = harness.storer
+
+	harness.webauthn = &WebAuthn{Authboss: harness.ab, rp: testRP(), fakeKey: []byte("fake credential key")}
+
+	return harness
+}
+
+func (h *testHarness) loadClientState(w http.ResponseWriter, r **http.Request) {
+	req, err := h.ab.LoadClientState(w, *r)
+	if err != nil {
+		panic(err)
+	}
+
+	*r = req
+}
+
+func (h *testHarness) putUserInCtx(u *mocks.User, r **http.Request) {
+	req := (*r).WithContext(context.WithValue((*r).Context(), authboss.CTXKeyUser, u))
+	*r = req
+}
+
+func (h *testHarness) newHTTP(method string, bodyArgs ...string) (*http.Request, *authboss.ClientStateResponseWriter, *httptest.ResponseRecorder) {
+	r := mocks.Request(method, bodyArgs...)
+	wr := httptest.NewRecorder()
+	w := h.ab.NewResponse(wr)
+
+	return r, w, wr
+}
+
+func (h *testHarness) setSession(key, value string) {
+	h.session.ClientValues[key] = value
+}
+
+// registerUser creates a user with a credential belonging to a new test
+// authenticator.
+func (h *testHarness) registerUser(t *testing.T) (*mocks.User, *testAuthenticator) {
+	t.Helper()
+
+	auth := newTestAuthenticator(t)
+	cred, err := testRP().verifyRegistration("challenge", auth.create("challenge"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := EncodeCredentials([]Credential{cred})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &mocks.User{Email: "test@test.com", WebAuthnCredentials: encoded}
+	h.storer.Users[user.Email] = user
+
+	return user, auth
+}
+
+func TestHijackAuth(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Handled", func(t *testing.T) {
+		harness := testSetup()
+
+		handled, err := harness.webauthn.HijackAuth(nil, nil, true)
+		if handled {
+			t.Error("should not be handled")
+		}
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("UserNoWebAuthn", func(t *testing.T) {
+		harness := testSetup()
+
+		r, w, _ := harness.newHTTP("POST")
+
+		user := &mocks.User{Email: "test@test.com"}
+		harness.putUserInCtx(user, &r)
+
+		harness.loadClientState(w, &r)
+		handled, err := harness.webauthn.HijackAuth(w, r, false)
+		if handled {
+			t.Error("should not be handled")
+		}
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("Ok", func(t *testing.T) {
+		harness := testSetup()
+
+		r, w, _ := harness.newHTTP("POST")
+		r.URL.RawQuery = "test=query"
+
+		user, _ := harness.registerUser(t)
+		harness.putUserInCtx(user, &r)
+		harness.loadClientState(w, &r)
+
+		handled, err := harness.webauthn.HijackAuth(w, r, false)
+		if !handled {
+			t.Error("should be handled")
+		}
+		if err != nil {
+			t.Error(err)
+		}
+
+		// Flush client state
+		w.WriteHeader(http.StatusOK)
+
+		if pid := harness.session.ClientValues[SessionWebAuthnPendingPID]; pid != user.Email {
+			t.Error("pending pid wrong:", pid)
+		}
+
+		opts := harness.redirector.Options
+		if opts.Code != http.StatusTemporaryRedirect {
+			t.Error("status wrong:", opts.Code)
+		}
+		if opts.RedirectPath != "/auth/2fa/webauthn/validate?test=query" {
+			t.Error("redir path wrong:", opts.RedirectPath)
+		}
+	})
+}
+
+func TestGetSetup(t *testing.T) {
+	t.Parallel()
+	h := testSetup()
+
+	r, w, _ := h.newHTTP("GET")
+
+	h.setSession(SessionWebAuthnChallenge, "challenge")
+	h.loadClientState(w, &r)
+
+	if err := h.webauthn.GetSetup(w, r); err != nil {
+		t.Error(err)
+	}
+
+	// Flush ClientState
+	w.WriteHeader(http.StatusOK)
+
+	if _, ok := h.session.ClientValues[SessionWebAuthnChallenge]; ok {
+		t.Error("session challenge should be cleared")
+	}
+	if h.responder.Page != PageWebAuthnSetup {
+		t.Error("page wrong:", h.responder.Page)
+	}
+}
+
+func TestPostSetup(t *testing.T) {
+	t.Parallel()
+	h := testSetup()
+
+	r, w, _ := h.newHTTP("POST")
+
+	if err := h.webauthn.PostSetup(w, r); err != nil {
+		t.Error(err)
+	}
+
+	opts := h.redirector.Options
+	if opts.Code != http.StatusTemporaryRedirect {
+		t.Error("status wrong:", opts.Code)
+	}
+	if opts.RedirectPath != "/auth/2fa/webauthn/confirm" {
+		t.Error("redir path wrong:", opts.RedirectPath)
+	}
+}
+
+func TestGetConfirm(t *testing.T) {
+	t.Parallel()
+	h := testSetup()
+
+	r, w, _ := h.newHTTP("GET")
+
+	user, _ := h.registerUser(t)
+	h.setSession(authboss.SessionKey, user.Email)
+	h.loadClientState(w, &r)
+
+	if err := h.webauthn.GetConfirm(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	// Flush ClientState
+	w.WriteHeader(http.StatusOK)
+
+	challenge := h.session.ClientValues[SessionWebAuthnChallenge]
+	if len(challenge) == 0 {
+		t.Error("challenge was not stored in session")
+	}
+
+	if h.responder.Page != PageWebAuthnConfirm {
+		t.Error("page wrong:", h.responder.Page)
+	}
+
+	options := h.responder.Data[DataWebAuthnOptions].(CreationOptions)
+	if options.PublicKey.Challenge != challenge {
+		t.Error("challenge wrong:", options.PublicKey.Challenge)
+	}
+	if options.PublicKey.RP.ID != testRPID {
+		t.Error("rp id wrong:", options.PublicKey.RP.ID)
+	}
+	if options.PublicKey.User.ID != userHandle(user.Email) {
+		t.Error("user handle wrong:", options.PublicKey.User.ID)
+	}
+	if len(options.PublicKey.ExcludeCredentials) != 1 {
+		t.Error("existing credentials should be excluded:", options.PublicKey.ExcludeCredentials)
+	}
+}
+
+func TestPostConfirm(t *testing.T) {
+	t.Parallel()
+
+	t.Run("NoChallenge", func(t *testing.T) {
+		h := testSetup()
+
+		r, w, _ := h.newHTTP("POST")
+
+		user := &mocks.User{Email: "test@test.com"}
+		h.storer.Users[user.Email] = user
+		h.setSession(authboss.SessionKey, user.Email)
+		h.loadClientState(w, &r)
+
+		if err := h.webauthn.PostConfirm(w, r); err == nil {
+			t.Error("should fail because there is no challenge")
+		}
+	})
+
+	t.Run("InvalidCredential", func(t *testing.T) {
+		h := testSetup()
+
+		r, w, _ := h.newHTTP("POST")
+
+		user := &mocks.User{Email: "test@test.com"}
+		h.storer.Users[user.Email] = user
+		h.setSession(authboss.SessionKey, user.Email)
+		h.setSession(SessionWebAuthnChallenge, "challenge")
+		h.loadClientState(w, &r)
+
+		auth := newTestAuthenticator(t)
+		h.bodyReader.Return = mocks.Values{Credential: auth.create("stale")}
+
+		if err := h.webauthn.PostConfirm(w, r); err != nil {
+			t.Fatal(err)
+		}
+
+		// Flush ClientState
+		w.WriteHeader(http.StatusOK)
+
+		if len(user.WebAuthnCredentials) != 0 {
+			t.Error("credential should not be saved")
+		}
+		if h.responder.Page != PageWebAuthnConfirm {
+			t.Error("page wrong:", h.responder.Page)
+		}
+		if got := h.responder.Data[authboss.DataValidation].(map[string][]string); got[FormValueCredential][0] != h.ab.Localizef(context.Background(), authboss.TxtInvalidWebAuthnCredential) {
+			t.Error("data wrong:", got)
+		}
+		if challenge := h.session.ClientValues[SessionWebAuthnChallenge]; challenge == "challenge" || len(challenge) == 0 {
+			t.Error("a new challenge should be issued:", challenge)
+		}
+	})
+
+	t.Run("Ok", func(t *testing.T) {
+		h := testSetup()
+
+		r, w, _ := h.newHTTP("POST")
+
+		user := &mocks.User{Email: "test@test.com"}
+		h.storer.Users[user.Email] = user
+		h.setSession(authboss.SessionKey, user.Email)
+		h.setSession(SessionWebAuthnChallenge, "challenge")
+		h.loadClientState(w, &r)
+
+		auth := newTestAuthenticator(t)
+		h.bodyReader.Return = mocks.Values{Credential: auth.create("challenge")}
+
+		if err := h.webauthn.PostConfirm(w, r); err != nil {
+			t.Fatal(err)
+		}
+
+		// Flush ClientState
+		w.WriteHeader(http.StatusOK)
+
+		creds, err := DecodeCredentials(user.WebAuthnCredentials)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(creds) != 1 || string(creds[0].ID) != string(auth.id) || creds[0].CreatedAt.IsZero() {
+			t.Error("credential was not saved:", creds)
+		}
+		if len(user.RecoveryCodes) == 0 {
+			t.Error("user recovery codes unset")
+		}
+		if _, ok := h.session.ClientValues[SessionWebAuthnChallenge]; ok {
+			t.Error("session challenge not deleted")
+		}
+
+		if h.responder.Page != PageWebAuthnConfirmSuccess {
+			t.Error("page wrong:", h.responder.Page)
+		}
+		if got := h.responder.Data[twofactor.DataRecoveryCodes].([]string); len(got) == 0 {
+			t.Error("data wrong:", got)
+		}
+	})
+
+	t.Run("SecondKeyKeepsRecoveryCodes", func(t *testing.T) {
+		h := testSetup()
+
+		r, w, _ := h.newHTTP("POST")
+
+		user, first := h.registerUser(t)
+		user.RecoveryCodes = "existing"
+		h.setSession(authboss.SessionKey, user.Email)
+		h.setSession(SessionWebAuthnChallenge, "challenge")
+		h.loadClientState(w, &r)
+
+		auth := newTestAuthenticator(t)
+		h.bodyReader.Return = mocks.Values{Credential: auth.create("challenge")}
+
+		if err := h.webauthn.PostConfirm(w, r); err != nil {
+			t.Fatal(err)
+		}
+
+		creds, err := DecodeCredentials(user.WebAuthnCredentials)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(creds) != 2 || string(creds[0].ID) != string(first.id) || string(creds[1].ID) != string(auth.id) {
+			t.Error("credentials wrong:", creds)
+		}
+		if user.RecoveryCodes != "existing" {
+			t.Error("recovery codes should not be replaced")
+		}
+		if h.responder.Data != nil {
+			t.Error("no recovery codes should be displayed:", h.responder.Data)
+		}
+	})
+
+	t.Run("DuplicateKey", func(t *testing.T) {
+		h := testSetup()
+
+		r, w, _ := h.newHTTP("POST")
+
+		user, auth := h.registerUser(t)
+		h.setSession(authboss.SessionKey, user.Email)
+		h.setSession(SessionWebAuthnChallenge, "challenge")
+		h.loadClientState(w, &r)
+
+		h.bodyReader.Return = mocks.Values{Credential: auth.create("challenge")}
+
+		if err := h.webauthn.PostConfirm(w, r); err != nil {
+			t.Fatal(err)
+		}
+
+		if h.responder.Page != PageWebAuthnConfirm {
+			t.Error("page wrong:", h.responder.Page)
+		}
+	})
+}
+
+func TestGetRemove(t *testing.T) {
+	t.Parallel()
+	h := testSetup()
+
+	r, w, _ := h.newHTTP("GET")
+
+	user, _ := h.registerUser(t)
+	h.setSession(authboss.SessionKey, user.Email)
+	h.loadClientState(w, &r)
+
+	if err := h.webauthn.GetRemove(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.responder.Page != PageWebAuthnRemove {
+		t.Error("page wrong:", h.responder.Page)
+	}
+	options := h.responder.Data[DataWebAuthnOptions].(RequestOptions)
+	if len(options.PublicKey.AllowCredentials) != 1 {
+		t.Error("allowed credentials wrong:", options.PublicKey.AllowCredentials)
+	}
+}
+
+func TestPostRemove(t *testing.T) {
+	t.Parallel()
+
+	t.Run("NoWebAuthnActivated", func(t *testing.T) {
+		h := testSetup()
+
+		r, w, _ := h.newHTTP("POST")
+		user := &mocks.User{Email: "test@test.com"}
+		h.storer.Users[user.Email] = user
+		h.setSession(authboss.SessionKey, user.Email)
+		h.loadClientState(w, &r)
+
+		if err := h.webauthn.PostRemove(w, r); err != nil {
+			t.Fatal(err)
+		}
+
+		if h.responder.Page != PageWebAuthnRemove {
+			t.Error("page wrong:", h.responder.Page)
+		}
+		if got := h.responder.Data[authboss.DataErr]; got != h.ab.Localizef(context.Background(), authboss.TxtWebAuthn2FANotActive) {
+			t.Error("data wrong:", got)
+		}
+	})
+
+	t.Run("WrongCredential", func(t *testing.T) {
+		h := testSetup()
+
+		r, w, _ := h.newHTTP("POST")
+		user, auth := h.registerUser(t)
+		h.setSession(authboss.SessionKey, user.Email)
+		h.setSession(SessionWebAuthnChallenge, "challenge")
+		h.loadClientState(w, &r)
+
+		h.bodyReader.Return = mocks.Values{Credential: auth.get("other")}
+
+		if err := h.webauthn.PostRemove(w, r); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(user.WebAuthnCredentials) == 0 {
+			t.Error("credentials should not be removed")
+		}
+		if h.responder.Page != PageWebAuthnRemove {
+			t.Error("page wrong:", h.responder.Page)
+		}
+		if got := h.responder.Data[authboss.DataValidation].(map[string][]string); got[FormValueCredential][0] != h.ab.Localizef(context.Background(), authboss.TxtInvalidWebAuthnCredential) {
+			t.Error("data wrong:", got)
+		}
+	})
+
+	t.Run("Ok", func(t *testing.T) {
+		h := testSetup()
+
+		r, w, _ := h.newHTTP("POST")
+		user, auth := h.registerUser(t)
+		h.setSession(authboss.SessionKey, user.Email)
+		h.setSession(authboss.Session2FA, "webauthn")
+		h.setSession(SessionWebAuthnChallenge, "challenge")
+		h.loadClientState(w, &r)
+
+		h.bodyReader.Return = mocks.Values{Credential: auth.get("challenge")}
+
+		if err := h.webauthn.PostRemove(w, r); err != nil {
+			t.Fatal(err)
+		}
+
+		// Flush ClientState
+		w.WriteHeader(http.StatusOK)
+
+		if len(user.WebAuthnCredentials) != 0 {
+			t.Error("credentials should be removed")
+		}
+		if _, ok := h.session.ClientValues[authboss.Session2FA]; ok {
+			t.Error("session 2fa should be cleared")
+		}
+		if h.responder.Page != PageWebAuthnRemoveSuccess {
+			t.Error("page wrong:", h.responder.Page)
+		}
+	})
+}
+
+func TestGetValidate(t *testing.T) {
+	t.Parallel()
+	h := testSetup()
+
+	r, w, _ := h.newHTTP("GET")
+
+	user, _ := h.registerUser(t)
+	h.setSession(SessionWebAuthnPendingPID, user.Email)
+	h.loadClientState(w, &r)
+
+	if err := h.webauthn.GetValidate(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	// Flush ClientState
+	w.WriteHeader(http.StatusOK)
+
+	if h.responder.Page != PageWebAuthnValidate {
+		t.Error("page wrong:", h.responder.Page)
+	}
+	options := h.responder.Data[DataWebAuthnOptions].(RequestOptions)
+	if options.PublicKey.Challenge != h.session.ClientValues[SessionWebAuthnChallenge] {
+		t.Error("challenge was not stored in session")
+	}
+}
+
+func TestPostValidate(t *testing.T) {
+	t.Parallel()
+
+	t.Run("WrongCredential", func(t *testing.T) {
+		h := testSetup()
+
+		r, w, _ := h.newHTTP("POST")
+		user, auth := h.registerUser(t)
+		h.setSession(SessionWebAuthnPendingPID, user.Email)
+		h.setSession(SessionWebAuthnChallenge, "challenge")
+		h.loadClientState(w, &r)
+
+		h.bodyReader.Return = mocks.Values{Credential: auth.get("other")}
+
+		if err := h.webauthn.PostValidate(w, r); err != nil {
+			t.Fatal(err)
+		}
+
+		// Flush ClientState
+		w.WriteHeader(http.StatusOK)
+
+		if _, ok := h.session.ClientValues[authboss.SessionKey]; ok {
+			t.Error("user should not be logged in")
+		}
+		if h.responder.Page != PageWebAuthnValidate {
+			t.Error("page wrong:", h.responder.Page)
+		}
+		if got := h.responder.Data[authboss.DataValidation].(map[string][]string); got[FormValueCredential][0] != h.ab.Localizef(context.Background(), authboss.TxtInvalidWebAuthnCredential) {
+			t.Error("data wrong:", got)
+		}
+	})
+
+	t.Run("ReplayedChallenge", func(t *testing.T) {
+		h := testSetup()
+
+		r, w, _ := h.newHTTP("POST")
+		user, auth := h.registerUser(t)
+		h.setSession(SessionWebAuthnPendingPID, user.Email)
+		h.loadClientState(w, &r)
+
+		// No challenge in the session, it was consumed by an earlier attempt
+		h.bodyReader.Return = mocks.Values{Credential: auth.get("")}
+
+		if err := h.webauthn.PostValidate(w, r); err != nil {
+			t.Fatal(err)
+		}
+
+		if h.responder.Page != PageWebAuthnValidate {
+			t.Error("page wrong:", h.responder.Page)
+		}
+	})
+
+	t.Run("Ok", func(t *testing.T) {
+		h := testSetup()
+
+		r, w, _ := h.newHTTP("POST")
+		user, auth := h.registerUser(t)
+		h.setSession(SessionWebAuthnPendingPID, user.Email)
+		h.setSession(SessionWebAuthnChallenge, "challenge")
+		h.setSession(authboss.SessionHalfAuthKey, "true")
+		h.loadClientState(w, &r)
+
+		h.bodyReader.Return = mocks.Values{Credential: auth.get("challenge")}
+
+		if err := h.webauthn.PostValidate(w, r); err != nil {
+			t.Fatal(err)
+		}
+
+		// Flush ClientState
+		w.WriteHeader(http.StatusOK)
+
+		if pid := h.session.ClientValues[authboss.SessionKey]; pid != user.Email {
+			t.Error("session pid should be set:", pid)
+		}
+		if twofa := h.session.ClientValues[authboss.Session2FA]; twofa != "webauthn" {
+			t.Error("session 2fa should be webauthn:", twofa)
+		}
+
+		cleared := []string{SessionWebAuthnChallenge, SessionWebAuthnPendingPID, authboss.SessionHalfAuthKey}
+		for _, c := range cleared {
+			if _, ok := h.session.ClientValues[c]; ok {
+				t.Error(c, "was not cleared")
+			}
+		}
+
+		creds, err := DecodeCredentials(user.WebAuthnCredentials)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if creds[0].SignCount != 1 {
+			t.Error("sign count should be updated:", creds[0].SignCount)
+		}
+
+		opts := h.redirector.Options
+		if opts.Code != http.StatusTemporaryRedirect {
+			t.Error("status wrong:", opts.Code)
+		}
+		if !opts.FollowRedirParam {
+			t.Error("it should follow redirects")
+		}
+		if opts.RedirectPath != h.ab.Paths.AuthLoginOK {
+			t.Error("path wrong:", opts.RedirectPath)
+		}
+	})
+
+	t.Run("OkRecovery", func(t *testing.T) {
+		h := testSetup()
+
+		r, w, _ := h.newHTTP("POST")
+		user, _ := h.registerUser(t)
+
+		// Create a single recovery code
+		codes, err := twofactor.GenerateRecoveryCodes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := bcrypt.GenerateFromPassword([]byte(codes[0]), bcrypt.DefaultCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		user.RecoveryCodes = string(b)
+
+		h.bodyReader.Return = mocks.Values{Recovery: codes[0]}
+
+		h.setSession(SessionWebAuthnPendingPID, user.Email)
+		h.loadClientState(w, &r)
+
+		if err := h.webauthn.PostValidate(w, r); err != nil {
+			t.Fatal(err)
+		}
+
+		// Flush ClientState
+		w.WriteHeader(http.StatusOK)
+
+		if pid := h.session.ClientValues[authboss.SessionKey]; pid != user.Email {
+			t.Error("session pid should be set:", pid)
+		}
+		if len(user.RecoveryCodes) != 0 {
+			t.Error("recovery code should be used up")
+		}
+	})
+}
+
+func TestPostLogin(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Options", func(t *testing.T) {
+		h := testSetup()
+
+		r, w, _ := h.newHTTP("POST")
+		user, _ := h.registerUser(t)
+		h.loadClientState(w, &r)
+
+		h.bodyReader.Return = mocks.Values{PID: user.Email}
+
+		if err := h.webauthn.PostLogin(w, r); err != nil {
+			t.Fatal(err)
+		}
+
+		// Flush ClientState
+		w.WriteHeader(http.StatusOK)
+
+		if pid := h.session.ClientValues[SessionWebAuthnPendingPID]; pid != user.Email {
+			t.Error("pending pid wrong:", pid)
+		}
+
+		options := h.responder.Data[DataWebAuthnOptions].(RequestOptions)
+		if options.PublicKey.Challenge != h.session.ClientValues[SessionWebAuthnChallenge] {
+			t.Error("challenge was not stored in session")
+		}
+		if options.PublicKey.UserVerification != UserVerificationRequired {
+			t.Error("user verification should be required")
+		}
+		if len(options.PublicKey.AllowCredentials) != 1 {
+			t.Error("allowed credentials wrong:", options.PublicKey.AllowCredentials)
+		}
+	})
+
+	t.Run("OptionsUnknownUser", func(t *testing.T) {
+		h := testSetup()
+
+		allowed := func(pid string) []CredentialDescriptor {
+			t.Helper()
+
+			r, w, _ := h.newHTTP("POST")
+			h.loadClientState(w, &r)
+
+			h.bodyReader.Return = mocks.Values{PID: pid}
+
+			if err := h.webauthn.PostLogin(w, r); err != nil {
+				t.Fatal(err)
+			}
+
+			return h.responder.Data[DataWebAuthnOptions].(RequestOptions).PublicKey.AllowCredentials
+		}
+
+		// An unknown user must look like a user with a credential
+		first := allowed("nobody@test.com")
+		if len(first) != 1 || len(first[0].ID) == 0 {
+			t.Fatal("allowed credentials wrong:", first)
+		}
+		if again := allowed("nobody@test.com"); again[0].ID != first[0].ID {
+			t.Error("the fake credential should be the same every time")
+		}
+		if other := allowed("other@test.com"); other[0].ID == first[0].ID {
+			t.Error("the fake credential should differ between users")
+		}
+
+		// So must a user without any credentials
+		h.storer.Users["nokeys@test.com"] = &mocks.User{Email: "nokeys@test.com"}
+		if nokeys := allowed("nokeys@test.com"); len(nokeys) != 1 {
+			t.Error("allowed credentials wrong:", nokeys)
+		}
+	})
+
+	t.Run("NotVerified", func(t *testing.T) {
+		h := testSetup()
+
+		r, w, _ := h.newHTTP("POST")
+		user, auth := h.registerUser(t)
+		auth.uv = false
+		h.setSession(SessionWebAuthnPendingPID, user.Email)
+		h.setSession(SessionWebAuthnChallenge, "challenge")
+		h.loadClientState(w, &r)
+
+		h.bodyReader.Return = mocks.Values{Credential: auth.get("challenge")}
+
+		if err := h.webauthn.PostLogin(w, r); err != nil {
+			t.Fatal(err)
+		}
+
+		// Flush ClientState
+		w.WriteHeader(http.StatusOK)
+
+		if _, ok := h.session.ClientValues[authboss.SessionKey]; ok {
+			t.Error("user should not be logged in")
+		}
+		if h.responder.Page != PageWebAuthnLogin {
+			t.Error("page wrong:", h.responder.Page)
+		}
+	})
+
+	t.Run("Ok", func(t *testing.T) {
+		h := testSetup()
+
+		r, w, _ := h.newHTTP("POST")
+		user, auth := h.registerUser(t)
+		h.setSession(SessionWebAuthnPendingPID, user.Email)
+		h.setSession(SessionWebAuthnChallenge, "challenge")
+		h.loadClientState(w, &r)
+
+		h.bodyReader.Return = mocks.Values{Credential: auth.get("challenge")}
+
+		var beforeCalled, afterCalled bool
+		h.ab.Events.Before(authboss.EventAuth, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+			beforeCalled = true
+			return false, nil
+		})
+		h.ab.Events.After(authboss.EventAuth, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+			afterCalled = true
+			return false, nil
+		})
+
+		if err := h.webauthn.PostLogin(w, r); err != nil {
+			t.Fatal(err)
+		}
+
+		// Flush ClientState
+		w.WriteHeader(http.StatusOK)
+
+		if !beforeCalled || !afterCalled {
+			t.Error("auth events should be fired")
+		}
+		if pid := h.session.ClientValues[authboss.SessionKey]; pid != user.Email {
+			t.Error("session pid should be set:", pid)
+		}
+		if twofa := h.session.ClientValues[authboss.Session2FA]; twofa != "webauthn" {
+			t.Error("session 2fa should be webauthn:", twofa)
+		}
+		if h.redirector.Options.RedirectPath != h.ab.Paths.AuthLoginOK {
+			t.Error("path wrong:", h.redirector.Options.RedirectPath)
+		}
+	})
+}
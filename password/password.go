@@ -0,0 +1,132 @@
+// Package password allows logged in users to change their password
+package password
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// Pages
+const (
+	PagePasswordChange = "password_change"
+)
+
+func init() {
+	authboss.RegisterModule("password", &Password{})
+}
+
+// Password module
+type Password struct {
+	*authboss.Authboss
+}
+
+// Init module
+func (p *Password) Init(ab *authboss.Authboss) error {
+	p.Authboss = ab
+
+	if err := p.Core.ViewRenderer.Load(PagePasswordChange); err != nil {
+		return err
+	}
+
+	var unauthedResponse authboss.MWRespondOnFailure
+	if p.Config.Modules.ResponseOnUnauthed != 0 {
+		unauthedResponse = p.Config.Modules.ResponseOnUnauthed
+	} else if p.Config.Modules.RoutesRedirectOnUnauthed {
+		unauthedResponse = authboss.RespondRedirect
+	}
+	middleware := authboss.MountedMiddleware2(p.Authboss, true, authboss.RequireFullAuth, unauthedResponse)
+
+	p.Core.Router.Get("/password/change", middleware(p.Core.ErrorHandler.Wrap(p.Get)))
+	p.Core.Router.Post("/password/change", middleware(p.Core.ErrorHandler.Wrap(p.Post)))
+
+	return nil
+}
+
+// Get the password change page
+func (p *Password) Get(w http.ResponseWriter, r *http.Request) error {
+	return p.Core.Responder.Respond(w, r, http.StatusOK, PagePasswordChange, nil)
+}
+
+// Post changes the user's password after checking their current one
+func (p *Password) Post(w http.ResponseWriter, r *http.Request) error {
+	logger := p.RequestLogger(r)
+
+	validatable, err := p.Core.BodyReader.Read(PagePasswordChange, r)
+	if err != nil {
+		return err
+	}
+
+	abUser, err := p.CurrentUser(r)
+	if err != nil {
+		return err
+	}
+	user := authboss.MustBeAuthable(abUser)
+
+	if errs := validatable.Validate(); errs != nil {
+		logger.Infof("user %s password change validation failed", user.GetPID())
+		data := authboss.HTMLData{authboss.DataValidation: authboss.ErrorMap(errs)}
+		return p.Core.Responder.Respond(w, r, http.StatusOK, PagePasswordChange, data)
+	}
+
+	values := authboss.MustHavePasswordChangeValues(validatable)
+
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+
+	if err = p.VerifyPassword(user, values.GetCurrentPassword()); err != nil {
+		// Lock and ratelimit count this the same as a failed login so a
+		// session can't be used to guess the password
+		handled, err := p.Events.FireAfter(authboss.EventAuthFail, w, r)
+		if err != nil {
+			return err
+		} else if handled {
+			return nil
+		}
+
+		logger.Infof("user %s failed to change password, current password was wrong", user.GetPID())
+		data := authboss.HTMLData{authboss.DataErr: p.Localizef(r.Context(), authboss.TxtInvalidCurrentPassword)}
+		return p.Core.Responder.Respond(w, r, http.StatusOK, PagePasswordChange, data)
+	}
+
+	handled, err := p.Events.FireBefore(authboss.EventPasswordChange, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	pass, err := p.Core.Hasher.GenerateHash(values.GetPassword())
+	if err != nil {
+		return err
+	}
+
+	user.PutPassword(pass)
+	if err = p.Config.Storage.Server.Save(r.Context(), user); err != nil {
+		return err
+	}
+
+	if p.Config.Modules.PasswordChangeRevokeRemember {
+		storer := authboss.EnsureCanRemember(p.Config.Storage.Server)
+		if err = storer.DelRememberTokens(r.Context(), user.GetPID()); err != nil {
+			return err
+		}
+		authboss.DelCookie(w, authboss.CookieRemember)
+	}
+
+	logger.Infof("user %s changed their password", user.GetPID())
+
+	handled, err = p.Events.FireAfter(authboss.EventPasswordChange, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: p.Config.Paths.PasswordChangeOK,
+		Success:      p.Localizef(r.Context(), authboss.TxtPasswordChanged),
+	}
+	return p.Core.Redirector.Redirect(w, r, ro)
+}
@@ -0,0 +1,327 @@
+package password
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+
+	router := &mocks.Router{}
+	renderer := &mocks.Renderer{}
+	errHandler := &mocks.ErrorHandler{}
+	ab.Config.Core.Router = router
+	ab.Config.Core.ViewRenderer = renderer
+	ab.Config.Core.ErrorHandler = errHandler
+
+	p := &Password{}
+	if err := p.Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := renderer.HasLoadedViews(PagePasswordChange); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasGets("/password/change"); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasPosts("/password/change"); err != nil {
+		t.Error(err)
+	}
+}
+
+type testHarness struct {
+	password *Password
+	ab       *authboss.Authboss
+
+	bodyReader *mocks.BodyReader
+	responder  *mocks.Responder
+	redirector *mocks.Redirector
+	session    *mocks.ClientStateRW
+	cookies    *mocks.ClientStateRW
+	storer     *mocks.ServerStorer
+}
+
+func testSetup() *testHarness {
+	harness := &testHarness{}
+
+	harness.ab = authboss.New()
+	harness.bodyReader = &mocks.BodyReader{}
+	harness.responder = &mocks.Responder{}
+	harness.redirector = &mocks.Redirector{}
+	harness.session = mocks.NewClientRW()
+	harness.cookies = mocks.NewClientRW()
+	harness.storer = mocks.NewServerStorer()
+
+	harness.ab.Paths.PasswordChangeOK = "/password/ok"
+
+	harness.ab.Config.Core.BodyReader = harness.bodyReader
+	harness.ab.Config.Core.Logger = mocks.Logger{}
+	harness.ab.Config.Core.Hasher = mocks.Hasher{}
+	harness.ab.Config.Core.Responder = harness.responder
+	harness.ab.Config.Core.Redirector = harness.redirector
+	harness.ab.Config.Storage.SessionState = harness.session
+	harness.ab.Config.Storage.CookieState = harness.cookies
+	harness.ab.Config.Storage.Server = harness.storer
+
+	harness.password = &Password{harness.ab}
+
+	return harness
+}
+
+func (h *testHarness) putUser(t *testing.T, password string) *mocks.User {
+	t.Helper()
+
+	hash, err := h.ab.Config.Core.Hasher.GenerateHash(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &mocks.User{Email: "test@test.com", Password: hash}
+	h.storer.Users["test@test.com"] = user
+	h.session.ClientValues[authboss.SessionKey] = "test@test.com"
+	h.storer.RMTokens["test@test.com"] = []string{"token"}
+	h.cookies.ClientValues[authboss.CookieRemember] = "token"
+
+	return user
+}
+
+func (h *testHarness) loadClientState(w http.ResponseWriter, r **http.Request) {
+	req, err := h.ab.LoadClientState(w, *r)
+	if err != nil {
+		panic(err)
+	}
+
+	*r = req
+}
+
+func TestGet(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	r := mocks.Request("GET")
+	w := httptest.NewRecorder()
+
+	if err := h.password.Get(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.responder.Page != PagePasswordChange {
+		t.Error("page wrong:", h.responder.Page)
+	}
+}
+
+func TestPostSuccess(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	user := h.putUser(t, "old password")
+
+	var before, after bool
+	h.ab.Events.Before(authboss.EventPasswordChange, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		before = r.Context().Value(authboss.CTXKeyUser) != nil
+		return false, nil
+	})
+	h.ab.Events.After(authboss.EventPasswordChange, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		after = true
+		return false, nil
+	})
+
+	h.bodyReader.Return = mocks.Values{CurrentPassword: "old password", Password: "new password"}
+
+	r := mocks.Request("POST")
+	resp := httptest.NewRecorder()
+	w := h.ab.NewResponse(resp)
+	h.loadClientState(w, &r)
+
+	if err := h.password.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Code != http.StatusTemporaryRedirect {
+		t.Error("code wrong:", resp.Code)
+	}
+	opts := h.redirector.Options
+	if opts.RedirectPath != "/password/ok" {
+		t.Error("redirect path wrong:", opts.RedirectPath)
+	}
+	if len(opts.Success) == 0 {
+		t.Error("should have a success message")
+	}
+
+	if !before || !after {
+		t.Error("events should have fired with the user:", before, after)
+	}
+	if err := h.ab.Config.Core.Hasher.CompareHashAndPassword(user.Password, "new password"); err != nil {
+		t.Error("password should be changed:", err)
+	}
+
+	if len(h.storer.RMTokens["test@test.com"]) == 0 {
+		t.Error("remember tokens should be kept")
+	}
+	if _, ok := h.cookies.ClientValues[authboss.CookieRemember]; !ok {
+		t.Error("remember cookie should be kept")
+	}
+}
+
+func TestPostRevokeRemember(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Config.Modules.PasswordChangeRevokeRemember = true
+	h.putUser(t, "old password")
+
+	h.bodyReader.Return = mocks.Values{CurrentPassword: "old password", Password: "new password"}
+
+	r := mocks.Request("POST")
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	h.loadClientState(w, &r)
+
+	if err := h.password.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := h.storer.RMTokens["test@test.com"]; ok {
+		t.Error("remember tokens should be deleted")
+	}
+	if _, ok := h.cookies.ClientValues[authboss.CookieRemember]; ok {
+		t.Error("remember cookie should be deleted")
+	}
+}
+
+func TestPostWrongPassword(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	user := h.putUser(t, "old password")
+	hash := user.Password
+
+	var authFail bool
+	h.ab.Events.After(authboss.EventAuthFail, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		authFail = r.Context().Value(authboss.CTXKeyUser) != nil
+		return false, nil
+	})
+
+	h.bodyReader.Return = mocks.Values{CurrentPassword: "wrong password", Password: "new password"}
+
+	r := mocks.Request("POST")
+	resp := httptest.NewRecorder()
+	w := h.ab.NewResponse(resp)
+	h.loadClientState(w, &r)
+
+	if err := h.password.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Code != http.StatusOK {
+		t.Error("code wrong:", resp.Code)
+	}
+	if h.responder.Page != PagePasswordChange {
+		t.Error("page wrong:", h.responder.Page)
+	}
+	if h.responder.Data[authboss.DataErr] != authboss.TxtInvalidCurrentPassword.Default {
+		t.Error("error wrong:", h.responder.Data[authboss.DataErr])
+	}
+	if !authFail {
+		t.Error("EventAuthFail should fire with the user so lock and ratelimit count it")
+	}
+	if user.Password != hash {
+		t.Error("password should not be changed")
+	}
+}
+
+func TestPostWrongPasswordHandled(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.putUser(t, "old password")
+
+	// The lock module handles the failure once the user is locked
+	h.ab.Events.After(authboss.EventAuthFail, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		return true, nil
+	})
+
+	h.bodyReader.Return = mocks.Values{CurrentPassword: "wrong password", Password: "new password"}
+
+	r := mocks.Request("POST")
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	h.loadClientState(w, &r)
+
+	if err := h.password.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(h.responder.Page) != 0 {
+		t.Error("it should not respond when the failure was handled:", h.responder.Page)
+	}
+}
+
+func TestPostValidationFailure(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	user := h.putUser(t, "old password")
+	hash := user.Password
+
+	h.bodyReader.Return = mocks.Values{
+		CurrentPassword: "old password",
+		Errors:          []error{errors.New("password too short")},
+	}
+
+	r := mocks.Request("POST")
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	h.loadClientState(w, &r)
+
+	if err := h.password.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.responder.Page != PagePasswordChange {
+		t.Error("page wrong:", h.responder.Page)
+	}
+	errList := h.responder.Data[authboss.DataValidation].(map[string][]string)
+	if e := errList[""][0]; e != "password too short" {
+		t.Error("validation error wrong:", e)
+	}
+	if user.Password != hash {
+		t.Error("password should not be changed")
+	}
+}
+
+func TestPostHandled(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	user := h.putUser(t, "old password")
+	hash := user.Password
+
+	h.ab.Events.Before(authboss.EventPasswordChange, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		return true, nil
+	})
+
+	h.bodyReader.Return = mocks.Values{CurrentPassword: "old password", Password: "new password"}
+
+	r := mocks.Request("POST")
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	h.loadClientState(w, &r)
+
+	if err := h.password.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if user.Password != hash {
+		t.Error("password should not be changed")
+	}
+	if len(h.redirector.Options.RedirectPath) != 0 {
+		t.Error("it should not redirect")
+	}
+}
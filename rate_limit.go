@@ -0,0 +1,34 @@
+package authboss
+
+import (
+	"context"
+	"time"
+)
+
+// RateLimit is a token bucket that holds Limit tokens and is refilled
+// at a rate of Limit tokens per Window. A zero Limit means no limit.
+type RateLimit struct {
+	Limit  int
+	Window time.Duration
+}
+
+// RateLimitPolicy is the limits the ratelimit module puts on a route
+type RateLimitPolicy struct {
+	// IP limits the requests from a single ip address
+	IP RateLimit
+	// Global limits the requests from everyone together
+	Global RateLimit
+}
+
+// RateLimitStorer keeps the token buckets for the ratelimit module, the
+// ratelimit package has an in-memory implementation that's used when
+// none is set.
+type RateLimitStorer interface {
+	// Take removes a token from the key's bucket, creating a full bucket
+	// if there is none. If the bucket is empty no token is removed and the
+	// time until there is one is returned.
+	Take(ctx context.Context, key string, limit RateLimit) (wait time.Duration, err error)
+	// Wait returns the time until the key's bucket has a token without
+	// removing one, it's zero when there is one now.
+	Wait(ctx context.Context, key string, limit RateLimit) (wait time.Duration, err error)
+}
@@ -0,0 +1,114 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// sweepEvery is how many takes happen between removing full buckets
+const sweepEvery = 1024
+
+// MemoryStorer is an in-memory authboss.RateLimitStorer, it's only useful
+// when there is a single instance of the app since nothing is shared.
+type MemoryStorer struct {
+	mut     sync.Mutex
+	buckets map[string]*bucket
+	takes   int
+
+	now func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  authboss.RateLimit
+}
+
+// NewMemoryStorer constructor
+func NewMemoryStorer() *MemoryStorer {
+	return &MemoryStorer{buckets: make(map[string]*bucket), now: time.Now}
+}
+
+// Take a token from the key's bucket
+func (m *MemoryStorer) Take(ctx context.Context, key string, limit authboss.RateLimit) (time.Duration, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	now := m.now()
+
+	m.takes++
+	if m.takes%sweepEvery == 0 {
+		m.sweep(now)
+	}
+
+	b := m.bucket(key, limit, now)
+	if b.tokens < 1 {
+		return b.wait(), nil
+	}
+
+	b.tokens--
+	return 0, nil
+}
+
+// Wait returns the time until the key's bucket has a token
+func (m *MemoryStorer) Wait(ctx context.Context, key string, limit authboss.RateLimit) (time.Duration, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	b, ok := m.buckets[key]
+	if !ok {
+		return 0, nil
+	}
+
+	b.refill(limit, m.now())
+	if b.tokens >= 1 {
+		return 0, nil
+	}
+
+	return b.wait(), nil
+}
+
+func (m *MemoryStorer) bucket(key string, limit authboss.RateLimit, now time.Time) *bucket {
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Limit), last: now, limit: limit}
+		m.buckets[key] = b
+		return b
+	}
+
+	b.refill(limit, now)
+	return b
+}
+
+// sweep removes the buckets that have refilled since they'd be created
+// full again anyway
+func (m *MemoryStorer) sweep(now time.Time) {
+	for key, b := range m.buckets {
+		b.refill(b.limit, now)
+		if b.tokens >= float64(b.limit.Limit) {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+func (b *bucket) refill(limit authboss.RateLimit, now time.Time) {
+	b.limit = limit
+
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(float64(limit.Limit), b.tokens+elapsed.Seconds()*b.rate())
+		b.last = now
+	}
+}
+
+// rate in tokens per second
+func (b *bucket) rate() float64 {
+	return float64(b.limit.Limit) / b.limit.Window.Seconds()
+}
+
+func (b *bucket) wait() time.Duration {
+	return time.Duration(math.Ceil((1 - b.tokens) / b.rate() * float64(time.Second)))
+}
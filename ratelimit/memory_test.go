@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+func TestMemoryStorer(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	storer := NewMemoryStorer()
+	storer.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	limit := authboss.RateLimit{Limit: 2, Window: time.Minute}
+
+	if wait, err := storer.Wait(ctx, "key", limit); err != nil || wait != 0 {
+		t.Fatal("an unknown key should not wait:", wait, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if wait, err := storer.Take(ctx, "key", limit); err != nil || wait != 0 {
+			t.Fatal(i, "it should take a token:", wait, err)
+		}
+	}
+
+	wait, err := storer.Take(ctx, "key", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait != 30*time.Second {
+		t.Error("wait wrong:", wait)
+	}
+	if wait, _ := storer.Wait(ctx, "key", limit); wait != 30*time.Second {
+		t.Error("wait wrong:", wait)
+	}
+	if wait, _ := storer.Take(ctx, "other", limit); wait != 0 {
+		t.Error("keys should have their own buckets")
+	}
+
+	now = now.Add(30 * time.Second)
+	if wait, _ := storer.Wait(ctx, "key", limit); wait != 0 {
+		t.Error("a token should have been added:", wait)
+	}
+	if wait, _ := storer.Take(ctx, "key", limit); wait != 0 {
+		t.Error("it should take the new token:", wait)
+	}
+	if wait, _ := storer.Take(ctx, "key", limit); wait != 30*time.Second {
+		t.Error("it should be empty again:", wait)
+	}
+}
+
+func TestMemoryStorerSweep(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	storer := NewMemoryStorer()
+	storer.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	limit := authboss.RateLimit{Limit: 1, Window: time.Minute}
+
+	_, _ = storer.Take(ctx, "old", limit)
+	now = now.Add(time.Hour)
+	_, _ = storer.Take(ctx, "new", limit)
+
+	for i := 2; i < sweepEvery; i++ {
+		_, _ = storer.Take(ctx, "new", limit)
+	}
+
+	if _, ok := storer.buckets["old"]; ok {
+		t.Error("the refilled bucket should be removed")
+	}
+	if _, ok := storer.buckets["new"]; !ok {
+		t.Error("the empty bucket should be kept")
+	}
+}
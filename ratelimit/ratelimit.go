@@ -0,0 +1,226 @@
+// Package ratelimit limits the requests made to authboss's routes by ip
+// address and globally, as well as the failed logins from an ip address
+// and the recover and magic link e-mails sent to a user.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// Pages
+const (
+	PageRateLimited = "rate_limited"
+)
+
+// RateLimit module
+type RateLimit struct {
+	*authboss.Authboss
+}
+
+func init() {
+	authboss.RegisterModule("ratelimit", &RateLimit{})
+}
+
+// Init the module
+func (r *RateLimit) Init(ab *authboss.Authboss) error {
+	r.Authboss = ab
+
+	if r.Config.Storage.RateLimit == nil {
+		storer := NewMemoryStorer()
+		storer.now = ab.Now
+		r.Config.Storage.RateLimit = storer
+	}
+
+	if err := r.Core.ViewRenderer.Load(PageRateLimited); err != nil {
+		return err
+	}
+
+	// Wrap the router itself rather than each route so that routes of
+	// modules that were loaded before this one are limited too
+	if _, ok := r.Config.Core.Router.(limitedRouter); !ok {
+		router := r.Config.Core.Router
+		r.Config.Core.Router = limitedRouter{Router: router, handler: Middleware(ab)(router)}
+	}
+
+	r.Events.After(authboss.EventAuthFail, r.AfterAuthFail)
+	r.Events.Before(authboss.EventRecoverStart, r.BeforeRecoverStart)
+	r.Events.Before(authboss.EventMagicLinkStart, r.BeforeMagicLinkStart)
+
+	return nil
+}
+
+// AfterAuthFail takes a token from the ip address's auth failure bucket
+func (r *RateLimit) AfterAuthFail(w http.ResponseWriter, req *http.Request, handled bool) (bool, error) {
+	limit := r.Config.Modules.RateLimitAuthFailures
+	if limit.Limit == 0 {
+		return false, nil
+	}
+
+	ip := authboss.RemoteIP(req)
+	wait, err := r.Config.Storage.RateLimit.Take(req.Context(), authFailKey(ip), limit)
+	if err != nil {
+		return false, err
+	} else if wait > 0 {
+		r.RequestLogger(req).Infof("ip address %s has too many failed logins", ip)
+	}
+
+	return false, nil
+}
+
+// BeforeRecoverStart stops the recover e-mail from being sent when the user
+// has been sent too many. This acts as though it was sent so that it can't
+// be used to tell which users exist.
+func (r *RateLimit) BeforeRecoverStart(w http.ResponseWriter, req *http.Request, handled bool) (bool, error) {
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: r.Config.Paths.RecoverOK,
+		Success:      r.Localizef(req.Context(), authboss.TxtRecoverInitiateSuccessFlash),
+	}
+	return r.limitEmails(w, req, "recover", r.Config.Modules.RateLimitRecoverEmails, ro)
+}
+
+// BeforeMagicLinkStart stops the magic link e-mail from being sent when
+// the user has been sent too many, in the same way as BeforeRecoverStart.
+func (r *RateLimit) BeforeMagicLinkStart(w http.ResponseWriter, req *http.Request, handled bool) (bool, error) {
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: r.Config.Paths.MagicLinkOK,
+		Success:      r.Localizef(req.Context(), authboss.TxtMagicLinkSent),
+	}
+	return r.limitEmails(w, req, "magiclink", r.Config.Modules.RateLimitMagicLinkEmails, ro)
+}
+
+// limitEmails takes a token from the user's bucket for kind of e-mail and
+// redirects with ro, as though the e-mail was sent, when it's empty.
+func (r *RateLimit) limitEmails(w http.ResponseWriter, req *http.Request, kind string, limit authboss.RateLimit, ro authboss.RedirectOptions) (bool, error) {
+	if limit.Limit == 0 {
+		return false, nil
+	}
+
+	user, err := r.CurrentUser(req)
+	if err != nil {
+		return false, err
+	}
+
+	wait, err := r.Config.Storage.RateLimit.Take(req.Context(), kind+":"+user.GetPID(), limit)
+	if err != nil {
+		return false, err
+	} else if wait == 0 {
+		return false, nil
+	}
+
+	r.RequestLogger(req).Infof("user %s has been sent too many %s e-mails", user.GetPID(), kind)
+
+	return true, r.Core.Redirector.Redirect(w, req, ro)
+}
+
+// limitedRouter runs every request to the router through Middleware
+type limitedRouter struct {
+	authboss.Router
+
+	handler http.Handler
+}
+
+func (l limitedRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.handler.ServeHTTP(w, r)
+}
+
+type contextKey string
+
+// ctxKeyLimited marks a request that Middleware has already limited
+const ctxKeyLimited contextKey = "ratelimit_limited"
+
+// Middleware limits POSTs to the routes in Config.Modules.RateLimits, a
+// 429 is rendered with the rate_limited page when a limit is exceeded.
+// The module wraps Config.Core.Router with it when it's loaded so the
+// router must be mounted after Init, requests that were already limited
+// are passed through so wrapping the router again is harmless. The ip
+// address is taken from RemoteAddr so if the app is behind a proxy that
+// needs to be set from the proxy headers beforehand.
+func Middleware(ab *authboss.Authboss) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost || r.Context().Value(ctxKeyLimited) != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), ctxKeyLimited, true))
+
+			route := routePath(ab, r)
+			policy, ok := ab.Config.Modules.RateLimits[route]
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			logger := ab.RequestLogger(r)
+
+			wait, err := limit(r.Context(), ab, route, authboss.RemoteIP(r), policy)
+			if err != nil {
+				logger.Errorf("failed to check rate limit for %s: %+v", route, err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			} else if wait == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			logger.Infof("rate limited %s from %s", route, authboss.RemoteIP(r))
+
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			data := authboss.HTMLData{authboss.DataErr: ab.Localizef(r.Context(), authboss.TxtRateLimited)}
+			if err := ab.Config.Core.Responder.Respond(w, r, http.StatusTooManyRequests, PageRateLimited, data); err != nil {
+				logger.Errorf("failed to respond in ratelimit.Middleware: %+v", err)
+			}
+		})
+	}
+}
+
+// limit checks the ip address's auth failures then takes a token from the
+// ip address's and the global buckets for the route. It returns how long
+// to wait if any of them are empty.
+func limit(ctx context.Context, ab *authboss.Authboss, route, ip string, policy authboss.RateLimitPolicy) (time.Duration, error) {
+	storer := ab.Config.Storage.RateLimit
+
+	if failures := ab.Config.Modules.RateLimitAuthFailures; failures.Limit != 0 {
+		if wait, err := storer.Wait(ctx, authFailKey(ip), failures); err != nil || wait > 0 {
+			return wait, err
+		}
+	}
+
+	if policy.IP.Limit != 0 {
+		if wait, err := storer.Take(ctx, "ip:"+route+":"+ip, policy.IP); err != nil || wait > 0 {
+			return wait, err
+		}
+	}
+
+	if policy.Global.Limit != 0 {
+		if wait, err := storer.Take(ctx, "global:"+route, policy.Global); err != nil || wait > 0 {
+			return wait, err
+		}
+	}
+
+	return 0, nil
+}
+
+// routePath is the path as it's registered on the router, the mount path
+// is removed in case the middleware is used outside of http.StripPrefix
+func routePath(ab *authboss.Authboss, r *http.Request) string {
+	mount := ab.Config.Paths.Mount
+	if len(mount) != 0 && strings.HasPrefix(r.URL.Path, mount+"/") {
+		return strings.TrimPrefix(r.URL.Path, mount)
+	}
+
+	return r.URL.Path
+}
+
+func authFailKey(ip string) string {
+	return "authfail:" + ip
+}
@@ -0,0 +1,270 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+
+	renderer := &mocks.Renderer{}
+	router := &mocks.Router{}
+	ab.Config.Core.ViewRenderer = renderer
+	ab.Config.Core.Router = router
+
+	r := &RateLimit{}
+	if err := r.Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := renderer.HasLoadedViews(PageRateLimited); err != nil {
+		t.Error(err)
+	}
+	if _, ok := ab.Config.Storage.RateLimit.(*MemoryStorer); !ok {
+		t.Error("it should default to the memory storer")
+	}
+
+	// A second Init must not wrap the router twice
+	if err := r.Init(ab); err != nil {
+		t.Fatal(err)
+	}
+	if limited, ok := ab.Config.Core.Router.(limitedRouter); !ok || limited.Router != router {
+		t.Errorf("the router should be wrapped once: %#v", ab.Config.Core.Router)
+	}
+}
+
+func TestDefaultPolicies(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+
+	if len(ab.Config.Modules.RateLimits) == 0 {
+		t.Fatal("there should be default policies")
+	}
+	for route, policy := range ab.Config.Modules.RateLimits {
+		if policy.IP.Limit == 0 {
+			t.Errorf("%s) should be limited by ip address", route)
+		}
+		if policy.Global.Limit != 0 {
+			t.Errorf("%s) should not have a global limit by default", route)
+		}
+	}
+}
+
+type testHarness struct {
+	ratelimit *RateLimit
+	ab        *authboss.Authboss
+
+	redirector *mocks.Redirector
+	responder  *mocks.Responder
+	storer     *mocks.ServerStorer
+}
+
+func testSetup() *testHarness {
+	harness := &testHarness{}
+
+	harness.ab = authboss.New()
+	harness.redirector = &mocks.Redirector{}
+	harness.responder = &mocks.Responder{}
+	harness.storer = mocks.NewServerStorer()
+
+	harness.ab.Paths.RecoverOK = "/recover/ok"
+	harness.ab.Paths.MagicLinkOK = "/magiclink/ok"
+	harness.ab.Modules.RateLimits = map[string]authboss.RateLimitPolicy{
+		"/login": {
+			IP:     authboss.RateLimit{Limit: 2, Window: time.Minute},
+			Global: authboss.RateLimit{Limit: 3, Window: time.Minute},
+		},
+	}
+	harness.ab.Modules.RateLimitAuthFailures = authboss.RateLimit{Limit: 1, Window: time.Hour}
+	harness.ab.Modules.RateLimitRecoverEmails = authboss.RateLimit{Limit: 1, Window: time.Hour}
+	harness.ab.Modules.RateLimitMagicLinkEmails = authboss.RateLimit{Limit: 1, Window: time.Hour}
+
+	harness.ab.Config.Core.Logger = mocks.Logger{}
+	harness.ab.Config.Core.Redirector = harness.redirector
+	harness.ab.Config.Core.Responder = harness.responder
+	harness.ab.Config.Storage.RateLimit = NewMemoryStorer()
+	harness.ab.Config.Storage.Server = harness.storer
+
+	harness.ratelimit = &RateLimit{harness.ab}
+
+	return harness
+}
+
+func (h *testHarness) serve(method, path, ip string) int {
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(method, path, nil)
+	r.RemoteAddr = ip + ":1234"
+
+	h.responder.Status = 0
+	Middleware(h.ab)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})).ServeHTTP(rec, r)
+
+	if h.responder.Status != 0 {
+		if len(rec.Header().Get("Retry-After")) == 0 {
+			panic("retry-after should be set")
+		}
+		return h.responder.Status
+	}
+	return rec.Code
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	tests := []struct {
+		Method string
+		Path   string
+		IP     string
+		Code   int
+	}{
+		{"POST", "/login", "1.1.1.1", http.StatusOK},
+		{"POST", "/auth/login", "1.1.1.1", http.StatusOK},
+		// Over the ip limit
+		{"POST", "/login", "1.1.1.1", http.StatusTooManyRequests},
+		// Only POSTs and routes with policies are limited
+		{"GET", "/login", "1.1.1.1", http.StatusOK},
+		{"POST", "/register", "1.1.1.1", http.StatusOK},
+		{"POST", "/login", "2.2.2.2", http.StatusOK},
+		// Over the global limit
+		{"POST", "/login", "3.3.3.3", http.StatusTooManyRequests},
+	}
+
+	for i, test := range tests {
+		if code := h.serve(test.Method, test.Path, test.IP); code != test.Code {
+			t.Errorf("%d) code wrong: %d", i, code)
+		}
+	}
+
+	if h.responder.Page != PageRateLimited {
+		t.Error("page wrong:", h.responder.Page)
+	}
+	if h.responder.Data[authboss.DataErr] != authboss.TxtRateLimited.Default {
+		t.Error("error wrong:", h.responder.Data[authboss.DataErr])
+	}
+}
+
+func TestMiddlewareTwice(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	var called int
+	handler := Middleware(h.ab)(Middleware(h.ab)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called++
+	})))
+
+	// The ip limit is 2, each request must only be counted once
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest("POST", "/login", nil)
+		r.RemoteAddr = "1.1.1.1:1234"
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	if called != 2 {
+		t.Error("both requests should be allowed:", called)
+	}
+}
+
+func TestAfterAuthFail(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest("POST", "/login", nil)
+		r.RemoteAddr = "1.1.1.1:1234"
+
+		handled, err := h.ratelimit.AfterAuthFail(httptest.NewRecorder(), r, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if handled {
+			t.Error("it should not handle the request")
+		}
+	}
+
+	if code := h.serve("POST", "/login", "1.1.1.1"); code != http.StatusTooManyRequests {
+		t.Error("the ip address should be limited after failing to log in:", code)
+	}
+	if code := h.serve("POST", "/login", "2.2.2.2"); code != http.StatusOK {
+		t.Error("other ip addresses should not be limited:", code)
+	}
+}
+
+func TestBeforeRecoverStart(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	user := &mocks.User{Email: "test@test.com"}
+
+	for i, handled := range []bool{false, true} {
+		h.redirector.Options = authboss.RedirectOptions{}
+
+		r := mocks.Request("POST")
+		r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+
+		got, err := h.ratelimit.BeforeRecoverStart(httptest.NewRecorder(), r, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != handled {
+			t.Errorf("%d) handled wrong: %t", i, got)
+		}
+	}
+
+	opts := h.redirector.Options
+	if opts.RedirectPath != "/recover/ok" {
+		t.Error("redirect path wrong:", opts.RedirectPath)
+	}
+	if opts.Success != authboss.TxtRecoverInitiateSuccessFlash.Default {
+		t.Error("it should look like the e-mail was sent:", opts.Success)
+	}
+}
+
+func TestBeforeMagicLinkStart(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	user := &mocks.User{Email: "test@test.com"}
+
+	r := mocks.Request("POST")
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+
+	// Recover e-mails are counted separately
+	if _, err := h.ratelimit.BeforeRecoverStart(httptest.NewRecorder(), r, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, handled := range []bool{false, true} {
+		h.redirector.Options = authboss.RedirectOptions{}
+
+		got, err := h.ratelimit.BeforeMagicLinkStart(httptest.NewRecorder(), r, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != handled {
+			t.Errorf("%d) handled wrong: %t", i, got)
+		}
+	}
+
+	opts := h.redirector.Options
+	if opts.RedirectPath != "/magiclink/ok" {
+		t.Error("redirect path wrong:", opts.RedirectPath)
+	}
+	if opts.Success != authboss.TxtMagicLinkSent.Default {
+		t.Error("it should look like the e-mail was sent:", opts.Success)
+	}
+}
@@ -13,7 +13,6 @@ import (
 	"net/http"
 	"net/url"
 	"path"
-	"time"
 
 	"github.com/volatiletech/authboss/v3"
 )
@@ -116,7 +115,7 @@ func (r *Recover) StartPost(w http.ResponseWriter, req *http.Request) error {
 
 	ru.PutRecoverSelector(selector)
 	ru.PutRecoverVerifier(verifier)
-	ru.PutRecoverExpiry(time.Now().UTC().Add(r.Config.Modules.RecoverTokenDuration))
+	ru.PutRecoverExpiry(r.Now().Add(r.Config.Modules.RecoverTokenDuration))
 
 	if err := r.Storage.Server.Save(req.Context(), ru); err != nil {
 		return err
@@ -241,7 +240,7 @@ func (r *Recover) EndPost(w http.ResponseWriter, req *http.Request) error {
 		return err
 	}
 
-	if time.Now().UTC().After(user.GetRecoverExpiry()) {
+	if r.Now().After(user.GetRecoverExpiry()) {
 		logger.Infof("invalid recover token submitted, already expired: %+v", err)
 		return r.invalidToken(PageRecoverEnd, w, req)
 	}
@@ -272,9 +271,9 @@ func (r *Recover) EndPost(w http.ResponseWriter, req *http.Request) error {
 	}
 
 	user.PutPassword(pass)
-	user.PutRecoverSelector("")             // Don't allow another recovery
-	user.PutRecoverVerifier("")             // Don't allow another recovery
-	user.PutRecoverExpiry(time.Now().UTC()) // Put current time for those DBs that can't handle 0 time
+	user.PutRecoverSelector("")    // Don't allow another recovery
+	user.PutRecoverVerifier("")    // Don't allow another recovery
+	user.PutRecoverExpiry(r.Now()) // Put current time for those DBs that can't handle 0 time
 
 	if err := storer.Save(req.Context(), user); err != nil {
 		return err
@@ -415,6 +415,37 @@ func TestEndPostExpiredToken(t *testing.T) {
 	invalidCheck(t, h, w)
 }
 
+func TestEndPostExpiredTokenClock(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	clock := mocks.NewClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	h.ab.Config.Core.Clock = clock
+
+	h.bodyReader.Return = &mocks.Values{
+		Token: testToken,
+	}
+	// The token would be valid for a long time yet if the system time
+	// were used
+	h.storer.Users["test@test.com"] = &mocks.User{
+		Email:              "test@test.com",
+		Password:           "to-overwrite",
+		RecoverSelector:    testSelector,
+		RecoverVerifier:    testVerifier,
+		RecoverTokenExpiry: clock.Now().Add(h.ab.Config.Modules.RecoverTokenDuration),
+	}
+	clock.Advance(h.ab.Config.Modules.RecoverTokenDuration + time.Second)
+
+	r := mocks.Request("GET")
+	w := httptest.NewRecorder()
+
+	if err := h.recover.EndPost(w, r); err != nil {
+		t.Error(err)
+	}
+
+	invalidCheck(t, h, w)
+}
+
 func TestEndPostUserNotExist(t *testing.T) {
 	t.Parallel()
 
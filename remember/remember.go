@@ -87,6 +87,7 @@ func Middleware(ab *authboss.Authboss) func(http.Handler) http.Handler {
 // - Can't decode the base64
 // - Invalid token format
 // - Can't find token in DB
+// - The session it was used from has been revoked (the token is deleted)
 //
 // In order to authenticate it adds to the request context as well as to the
 // cookie and session states.
@@ -126,6 +127,12 @@ func Authenticate(ab *authboss.Authboss, w http.ResponseWriter, req **http.Reque
 		return err
 	}
 
+	if revoked, _ := (*req).Context().Value(authboss.CTXKeySessionRevoked).(bool); revoked {
+		logger.Infof("remember me cookie was used by a revoked session for user %s, deleting cookie", pid)
+		authboss.DelCookie(w, authboss.CookieRemember)
+		return nil
+	}
+
 	hash, token, err := GenerateToken(pid)
 	if err != nil {
 		return err
@@ -141,7 +148,8 @@ func Authenticate(ab *authboss.Authboss, w http.ResponseWriter, req **http.Reque
 	authboss.DelCookie(w, authboss.CookieRemember)
 	authboss.PutCookie(w, authboss.CookieRemember, token)
 
-	return nil
+	_, err = ab.Events.FireAfter(authboss.EventRememberAuth, w, *req)
+	return err
 }
 
 // AfterPasswordReset is called after the password has been reset, since
@@ -176,12 +176,22 @@ func TestAuthenticateSuccess(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	var eventPID string
+	h.ab.Events.After(authboss.EventRememberAuth, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		eventPID = r.Context().Value(authboss.CTXKeyPID).(string)
+		return false, nil
+	})
+
 	if err = Authenticate(h.ab, w, &r); err != nil {
 		t.Fatal(err)
 	}
 
 	w.WriteHeader(http.StatusOK)
 
+	if eventPID != user.Email {
+		t.Error("the remember auth event should have been fired with the pid:", eventPID)
+	}
+
 	if cookie := h.cookies.ClientValues[authboss.CookieRemember]; cookie == token {
 		t.Error("the cookie should have been replaced with a new token")
 	}
@@ -0,0 +1,311 @@
+// Package sessions keeps a record of each login so that users can see
+// where they're logged in and log out sessions remotely.
+package sessions
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+
+	"github.com/friendsofgo/errors"
+	"github.com/volatiletech/authboss/v3"
+)
+
+const (
+	nSessionIDSize = 32
+)
+
+// Pages
+const (
+	PageSessions       = "sessions"
+	PageSessionsRevoke = "sessions_revoke"
+)
+
+// Form value constants
+const (
+	FormValueSessionID = "session_id"
+)
+
+// Data constants
+const (
+	// DataSessions is the list of the user's SessionRecords
+	DataSessions = "sessions"
+	// DataCurrentSession is the ID of the SessionRecord for the
+	// session that is making the request.
+	DataCurrentSession = "current_session"
+)
+
+func init() {
+	authboss.RegisterModule("sessions", &Sessions{})
+}
+
+// Sessions module
+type Sessions struct {
+	*authboss.Authboss
+}
+
+// SessionValuer returns the id of the session to revoke
+type SessionValuer interface {
+	authboss.Validator
+
+	GetSessionID() string
+}
+
+// MustHaveSessionValues upgrades a validatable set of values
+// to ones specific to revoking a session.
+func MustHaveSessionValues(v authboss.Validator) SessionValuer {
+	if u, ok := v.(SessionValuer); ok {
+		return u
+	}
+
+	panic(fmt.Sprintf("bodyreader returned a type that could not be upgraded to SessionValuer: %T", v))
+}
+
+// Init module
+func (s *Sessions) Init(ab *authboss.Authboss) error {
+	s.Authboss = ab
+
+	// Ensure the storer is capable before anything is registered
+	_ = authboss.EnsureCanTrackSessions(s.Config.Storage.Server)
+
+	if err := s.Core.ViewRenderer.Load(PageSessions); err != nil {
+		return err
+	}
+
+	var unauthedResponse authboss.MWRespondOnFailure
+	if s.Config.Modules.ResponseOnUnauthed != 0 {
+		unauthedResponse = s.Config.Modules.ResponseOnUnauthed
+	} else if s.Config.Modules.RoutesRedirectOnUnauthed {
+		unauthedResponse = authboss.RespondRedirect
+	}
+	middleware := authboss.MountedMiddleware2(s.Authboss, true, authboss.RequireFullAuth, unauthedResponse)
+
+	s.Core.Router.Get("/sessions", middleware(s.Core.ErrorHandler.Wrap(s.Get)))
+	s.Core.Router.Post("/sessions/revoke", middleware(s.Core.ErrorHandler.Wrap(s.PostRevoke)))
+	s.Core.Router.Post("/sessions/revoke/all", middleware(s.Core.ErrorHandler.Wrap(s.PostRevokeAll)))
+
+	s.Events.After(authboss.EventAuth, s.RecordSession)
+	s.Events.After(authboss.EventOAuth2, s.RecordSession)
+	s.Events.After(authboss.EventRememberAuth, s.RecordSession)
+	s.Events.After(authboss.EventRegister, s.RecordRegisterSession)
+	s.Events.Before(authboss.EventLogout, s.EndSession)
+
+	return nil
+}
+
+// Get lists the sessions of the current user
+func (s *Sessions) Get(w http.ResponseWriter, r *http.Request) error {
+	pid := s.CurrentUserIDP(r)
+	storer := authboss.EnsureCanTrackSessions(s.Config.Storage.Server)
+
+	records, err := storer.ListSessions(r.Context(), pid)
+	if err != nil {
+		return err
+	}
+
+	current, _ := authboss.GetSession(r, authboss.SessionID)
+	data := authboss.HTMLData{
+		DataSessions:       records,
+		DataCurrentSession: current,
+	}
+	return s.Core.Responder.Respond(w, r, http.StatusOK, PageSessions, data)
+}
+
+// PostRevoke deletes one of the current user's sessions, which logs it out
+// on its next request. Revoking the current session logs the user out.
+//
+// A revoked session that sends a remember me cookie with its next request
+// has the token deleted instead of being logged back in. The token isn't
+// tied to the session record though, so if the cookie was copied somewhere
+// else it can still be used, PostRevokeAll deletes every token.
+func (s *Sessions) PostRevoke(w http.ResponseWriter, r *http.Request) error {
+	logger := s.RequestLogger(r)
+
+	validatable, err := s.Core.BodyReader.Read(PageSessionsRevoke, r)
+	if err != nil {
+		return err
+	}
+	id := MustHaveSessionValues(validatable).GetSessionID()
+
+	pid := s.CurrentUserIDP(r)
+	storer := authboss.EnsureCanTrackSessions(s.Config.Storage.Server)
+
+	record, err := storer.LoadSession(r.Context(), id)
+	if err == authboss.ErrSessionNotFound || (err == nil && record.PID != pid) {
+		logger.Infof("user %s tried to revoke a session that does not exist or is not theirs", pid)
+		ro := authboss.RedirectOptions{
+			Code:         http.StatusTemporaryRedirect,
+			RedirectPath: path.Join(s.Paths.Mount, "/sessions"),
+			Failure:      s.Localizef(r.Context(), authboss.TxtSessionNotFound),
+		}
+		return s.Core.Redirector.Redirect(w, r, ro)
+	} else if err != nil {
+		return err
+	}
+
+	if err = storer.DeleteSession(r.Context(), id); err != nil {
+		return err
+	}
+
+	logger.Infof("user %s revoked session %s", pid, id)
+
+	if current, _ := authboss.GetSession(r, authboss.SessionID); current == id {
+		authboss.DelAllSession(w, s.Config.Storage.SessionStateWhitelistKeys)
+		authboss.DelKnownSession(w)
+		authboss.DelKnownCookie(w)
+
+		ro := authboss.RedirectOptions{
+			Code:         http.StatusTemporaryRedirect,
+			RedirectPath: s.Paths.LogoutOK,
+			Success:      s.Localizef(r.Context(), authboss.TxtLoggedOut),
+		}
+		return s.Core.Redirector.Redirect(w, r, ro)
+	}
+
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: path.Join(s.Paths.Mount, "/sessions"),
+		Success:      s.Localizef(r.Context(), authboss.TxtSessionRevoked),
+	}
+	return s.Core.Redirector.Redirect(w, r, ro)
+}
+
+// PostRevokeAll deletes all of the current user's sessions except the one
+// making the request. Remember me tokens and bearer refresh tokens are
+// deleted as well so that the revoked sessions cannot log themselves back in.
+func (s *Sessions) PostRevokeAll(w http.ResponseWriter, r *http.Request) error {
+	logger := s.RequestLogger(r)
+
+	pid := s.CurrentUserIDP(r)
+	storer := authboss.EnsureCanTrackSessions(s.Config.Storage.Server)
+
+	records, err := storer.ListSessions(r.Context(), pid)
+	if err != nil {
+		return err
+	}
+
+	current, _ := authboss.GetSession(r, authboss.SessionID)
+	for _, record := range records {
+		if record.ID == current {
+			continue
+		}
+		if err = storer.DeleteSession(r.Context(), record.ID); err != nil {
+			return err
+		}
+	}
+
+	if rmStorer, ok := s.Config.Storage.Server.(authboss.RememberingServerStorer); ok {
+		if err = rmStorer.DelRememberTokens(r.Context(), pid); err != nil {
+			return err
+		}
+		authboss.DelCookie(w, authboss.CookieRemember)
+	}
+
+	if btStorer, ok := s.Config.Storage.Server.(authboss.BearerTokenServerStorer); ok {
+		if err = btStorer.DelRefreshTokens(r.Context(), pid); err != nil {
+			return err
+		}
+	}
+
+	logger.Infof("user %s revoked all other sessions", pid)
+
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: path.Join(s.Paths.Mount, "/sessions"),
+		Success:      s.Localizef(r.Context(), authboss.TxtOtherSessionsRevoked),
+	}
+	return s.Core.Redirector.Redirect(w, r, ro)
+}
+
+// RecordSession creates a session record for the user that just logged in
+// and stores its id in the session.
+func (s *Sessions) RecordSession(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+	var pid string
+	if user, ok := r.Context().Value(authboss.CTXKeyUser).(authboss.User); ok && user != nil {
+		pid = user.GetPID()
+	} else {
+		pid, _ = s.CurrentUserID(r)
+	}
+	if len(pid) == 0 {
+		return false, nil
+	}
+
+	storer := authboss.EnsureCanTrackSessions(s.Config.Storage.Server)
+
+	// A previous login in the same browser is replaced by this one
+	if old, ok := authboss.GetSession(r, authboss.SessionID); ok && len(old) != 0 {
+		if err := storer.DeleteSession(r.Context(), old); err != nil {
+			return false, err
+		}
+	}
+
+	id, err := generateSessionID()
+	if err != nil {
+		return false, err
+	}
+
+	now := s.Now()
+	record := authboss.SessionRecord{
+		ID:        id,
+		PID:       pid,
+		UserAgent: r.UserAgent(),
+		IP:        authboss.RemoteIP(r),
+		CreatedAt: now,
+		LastSeen:  now,
+	}
+	if err = storer.CreateSession(r.Context(), record); err != nil {
+		return false, err
+	}
+
+	authboss.PutSession(w, authboss.SessionID, id)
+
+	return false, nil
+}
+
+// RecordRegisterSession creates a session record for a user that was just
+// registered, the register module logs them in unless the request was
+// handled. Confirm handles it for unconfirmed users but it may run after
+// this, so that case is checked here as well.
+func (s *Sessions) RecordRegisterSession(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+	if handled {
+		return false, nil
+	}
+
+	if s.IsLoaded("confirm") {
+		user, ok := r.Context().Value(authboss.CTXKeyUser).(authboss.ConfirmableUser)
+		if ok && !user.GetConfirmed() {
+			return false, nil
+		}
+	}
+
+	return s.RecordSession(w, r, handled)
+}
+
+// EndSession deletes the session record of a user that is logging out
+func (s *Sessions) EndSession(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+	id, ok := authboss.GetSession(r, authboss.SessionID)
+	if !ok || len(id) == 0 {
+		return false, nil
+	}
+
+	storer := authboss.EnsureCanTrackSessions(s.Config.Storage.Server)
+	if err := storer.DeleteSession(r.Context(), id); err != nil {
+		return false, err
+	}
+
+	authboss.DelSession(w, authboss.SessionID)
+
+	return false, nil
+}
+
+func generateSessionID() (string, error) {
+	id := make([]byte, nSessionIDSize)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return "", errors.Wrap(err, "failed to generate session id")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(id), nil
+}
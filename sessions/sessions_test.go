@@ -0,0 +1,441 @@
+package sessions
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/mocks"
+	"github.com/volatiletech/authboss/v3/remember"
+)
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+
+	router := &mocks.Router{}
+	renderer := &mocks.Renderer{}
+	errHandler := &mocks.ErrorHandler{}
+	ab.Config.Core.Router = router
+	ab.Config.Core.ViewRenderer = renderer
+	ab.Config.Core.ErrorHandler = errHandler
+	ab.Config.Storage.Server = mocks.NewServerStorer()
+
+	s := &Sessions{}
+	if err := s.Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := renderer.HasLoadedViews(PageSessions); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasGets("/sessions"); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasPosts("/sessions/revoke", "/sessions/revoke/all"); err != nil {
+		t.Error(err)
+	}
+}
+
+type testHarness struct {
+	sessions *Sessions
+	ab       *authboss.Authboss
+
+	bodyReader *mocks.BodyReader
+	responder  *mocks.Responder
+	redirector *mocks.Redirector
+	session    *mocks.ClientStateRW
+	cookies    *mocks.ClientStateRW
+	storer     *mocks.ServerStorer
+}
+
+func testSetup() *testHarness {
+	harness := &testHarness{}
+
+	harness.ab = authboss.New()
+	harness.bodyReader = &mocks.BodyReader{}
+	harness.responder = &mocks.Responder{}
+	harness.redirector = &mocks.Redirector{}
+	harness.session = mocks.NewClientRW()
+	harness.cookies = mocks.NewClientRW()
+	harness.storer = mocks.NewServerStorer()
+
+	harness.ab.Paths.Mount = "/auth"
+	harness.ab.Paths.LogoutOK = "/logout/ok"
+
+	harness.ab.Config.Core.BodyReader = harness.bodyReader
+	harness.ab.Config.Core.Logger = mocks.Logger{}
+	harness.ab.Config.Core.Responder = harness.responder
+	harness.ab.Config.Core.Redirector = harness.redirector
+	harness.ab.Config.Storage.SessionState = harness.session
+	harness.ab.Config.Storage.CookieState = harness.cookies
+	harness.ab.Config.Storage.Server = harness.storer
+
+	harness.sessions = &Sessions{harness.ab}
+
+	return harness
+}
+
+func (h *testHarness) loadClientState(w http.ResponseWriter, r **http.Request) {
+	req, err := h.ab.LoadClientState(w, *r)
+	if err != nil {
+		panic(err)
+	}
+
+	*r = req
+}
+
+func (h *testHarness) addSession(id, pid string) {
+	now := time.Now().UTC()
+	h.storer.Sessions[id] = authboss.SessionRecord{
+		ID: id, PID: pid, CreatedAt: now, LastSeen: now,
+	}
+}
+
+func TestRecordSession(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.addSession("old", "test@test.com")
+	h.session.ClientValues[authboss.SessionID] = "old"
+	h.session.ClientValues[authboss.SessionKey] = "test@test.com"
+
+	r := mocks.Request("POST")
+	r.RemoteAddr = "127.0.0.1:5555"
+	r.Header.Set("User-Agent", "test-agent")
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	h.loadClientState(w, &r)
+
+	user := &mocks.User{Email: "test@test.com"}
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+
+	if handled, err := h.sessions.RecordSession(w, r, false); err != nil {
+		t.Fatal(err)
+	} else if handled {
+		t.Error("it should not handle the event")
+	}
+
+	// Flush client state
+	w.WriteHeader(http.StatusOK)
+
+	id := h.session.ClientValues[authboss.SessionID]
+	if len(id) == 0 || id == "old" {
+		t.Fatal("a new session id should be stored:", id)
+	}
+	if _, ok := h.storer.Sessions["old"]; ok {
+		t.Error("the previous session record should be deleted")
+	}
+
+	record, ok := h.storer.Sessions[id]
+	if !ok {
+		t.Fatal("session record should be created")
+	}
+	if record.PID != "test@test.com" {
+		t.Error("pid wrong:", record.PID)
+	}
+	if record.IP != "127.0.0.1" {
+		t.Error("ip wrong:", record.IP)
+	}
+	if record.UserAgent != "test-agent" {
+		t.Error("user agent wrong:", record.UserAgent)
+	}
+	if record.CreatedAt.IsZero() || record.LastSeen.IsZero() {
+		t.Error("times should be set")
+	}
+}
+
+func TestRecordSessionRemember(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	r := mocks.Request("GET")
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	h.loadClientState(w, &r)
+
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyPID, "test@test.com"))
+
+	if _, err := h.sessions.RecordSession(w, r, false); err != nil {
+		t.Fatal(err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	record, ok := h.storer.Sessions[h.session.ClientValues[authboss.SessionID]]
+	if !ok {
+		t.Fatal("session record should be created")
+	}
+	if record.PID != "test@test.com" {
+		t.Error("pid wrong:", record.PID)
+	}
+}
+
+func TestEndSession(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.addSession("id", "test@test.com")
+	h.session.ClientValues[authboss.SessionID] = "id"
+	h.session.ClientValues[authboss.SessionKey] = "test@test.com"
+
+	r := mocks.Request("POST")
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	h.loadClientState(w, &r)
+
+	if _, err := h.sessions.EndSession(w, r, false); err != nil {
+		t.Fatal(err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if _, ok := h.storer.Sessions["id"]; ok {
+		t.Error("session record should be deleted")
+	}
+	if _, ok := h.session.ClientValues[authboss.SessionID]; ok {
+		t.Error("session id should be deleted")
+	}
+}
+
+func TestGet(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.addSession("one", "test@test.com")
+	h.addSession("two", "test@test.com")
+	h.addSession("other", "other@test.com")
+	h.session.ClientValues[authboss.SessionID] = "one"
+	h.session.ClientValues[authboss.SessionKey] = "test@test.com"
+
+	r := mocks.Request("GET")
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	h.loadClientState(w, &r)
+
+	if err := h.sessions.Get(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.responder.Page != PageSessions {
+		t.Error("page wrong:", h.responder.Page)
+	}
+	if current := h.responder.Data[DataCurrentSession]; current != "one" {
+		t.Error("current session wrong:", current)
+	}
+	records := h.responder.Data[DataSessions].([]authboss.SessionRecord)
+	if len(records) != 2 {
+		t.Fatal("expected the user's two sessions:", records)
+	}
+	for _, record := range records {
+		if record.PID != "test@test.com" {
+			t.Error("listed another user's session:", record)
+		}
+	}
+}
+
+func TestPostRevoke(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.addSession("one", "test@test.com")
+	h.addSession("two", "test@test.com")
+	h.session.ClientValues[authboss.SessionID] = "one"
+	h.session.ClientValues[authboss.SessionKey] = "test@test.com"
+
+	h.bodyReader.Return = mocks.Values{SessionID: "two"}
+
+	r := mocks.Request("POST")
+	resp := httptest.NewRecorder()
+	w := h.ab.NewResponse(resp)
+	h.loadClientState(w, &r)
+
+	if err := h.sessions.PostRevoke(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Code != http.StatusTemporaryRedirect {
+		t.Error("code wrong:", resp.Code)
+	}
+	opts := h.redirector.Options
+	if opts.RedirectPath != "/auth/sessions" {
+		t.Error("redirect path wrong:", opts.RedirectPath)
+	}
+	if len(opts.Success) == 0 {
+		t.Error("should have a success message")
+	}
+
+	if _, ok := h.storer.Sessions["two"]; ok {
+		t.Error("session should be revoked")
+	}
+	if _, ok := h.storer.Sessions["one"]; !ok {
+		t.Error("current session should remain")
+	}
+	if h.session.ClientValues[authboss.SessionKey] != "test@test.com" {
+		t.Error("user should still be logged in")
+	}
+}
+
+func TestPostRevokeRemember(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.storer.Users["test@test.com"] = &mocks.User{Email: "test@test.com"}
+	h.addSession("one", "test@test.com")
+	h.addSession("two", "test@test.com")
+
+	hash, token, err := remember.GenerateToken("test@test.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.storer.RMTokens["test@test.com"] = []string{hash}
+
+	// Revoke session two from session one
+	h.session.ClientValues[authboss.SessionID] = "one"
+	h.session.ClientValues[authboss.SessionKey] = "test@test.com"
+	h.bodyReader.Return = mocks.Values{SessionID: "two"}
+
+	r := mocks.Request("POST")
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	h.loadClientState(w, &r)
+	if err := h.sessions.PostRevoke(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	// Session two makes a request with its remember cookie
+	h.session.ClientValues[authboss.SessionID] = "two"
+	h.session.ClientValues[authboss.SessionKey] = "test@test.com"
+	h.cookies.ClientValues[authboss.CookieRemember] = token
+
+	r = mocks.Request("GET")
+	w = h.ab.NewResponse(httptest.NewRecorder())
+	h.loadClientState(w, &r)
+	if err := remember.Authenticate(h.ab, w, &r); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if pid, _ := h.ab.CurrentUserID(r); len(pid) != 0 {
+		t.Error("the revoked session should not be logged back in:", pid)
+	}
+	if _, ok := h.session.ClientValues[authboss.SessionKey]; ok {
+		t.Error("the session should have been cleared")
+	}
+	if _, ok := h.cookies.ClientValues[authboss.CookieRemember]; ok {
+		t.Error("the remember cookie should have been deleted")
+	}
+	if len(h.storer.RMTokens["test@test.com"]) != 0 {
+		t.Error("the remember token should have been deleted:", h.storer.RMTokens)
+	}
+	if len(h.storer.Sessions) != 1 {
+		t.Error("no session should have been recorded:", h.storer.Sessions)
+	}
+}
+
+func TestPostRevokeCurrent(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.addSession("one", "test@test.com")
+	h.session.ClientValues[authboss.SessionID] = "one"
+	h.session.ClientValues[authboss.SessionKey] = "test@test.com"
+	h.cookies.ClientValues[authboss.CookieRemember] = "token"
+
+	h.bodyReader.Return = mocks.Values{SessionID: "one"}
+
+	r := mocks.Request("POST")
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	h.loadClientState(w, &r)
+
+	if err := h.sessions.PostRevoke(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.redirector.Options.RedirectPath != "/logout/ok" {
+		t.Error("redirect path wrong:", h.redirector.Options.RedirectPath)
+	}
+	if _, ok := h.storer.Sessions["one"]; ok {
+		t.Error("session should be revoked")
+	}
+	if _, ok := h.session.ClientValues[authboss.SessionKey]; ok {
+		t.Error("user should be logged out")
+	}
+	if _, ok := h.cookies.ClientValues[authboss.CookieRemember]; ok {
+		t.Error("remember cookie should be deleted")
+	}
+	if len(h.storer.RefreshTokens["test@test.com"]) != 0 {
+		t.Error("refresh tokens should be deleted")
+	}
+}
+
+func TestPostRevokeNotOwned(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.addSession("one", "test@test.com")
+	h.addSession("other", "other@test.com")
+	h.session.ClientValues[authboss.SessionID] = "one"
+	h.session.ClientValues[authboss.SessionKey] = "test@test.com"
+
+	for _, id := range []string{"other", "missing"} {
+		h.bodyReader.Return = mocks.Values{SessionID: id}
+
+		r := mocks.Request("POST")
+		w := h.ab.NewResponse(httptest.NewRecorder())
+		h.loadClientState(w, &r)
+
+		if err := h.sessions.PostRevoke(w, r); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(h.redirector.Options.Failure) == 0 {
+			t.Error("should have a failure message")
+		}
+	}
+
+	if _, ok := h.storer.Sessions["other"]; !ok {
+		t.Error("another user's session should not be revoked")
+	}
+}
+
+func TestPostRevokeAll(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.addSession("one", "test@test.com")
+	h.addSession("two", "test@test.com")
+	h.addSession("three", "test@test.com")
+	h.addSession("other", "other@test.com")
+	h.storer.RMTokens["test@test.com"] = []string{"token"}
+	h.storer.RefreshTokens["test@test.com"] = map[string]time.Time{"token": time.Now().Add(time.Hour)}
+	h.session.ClientValues[authboss.SessionID] = "one"
+	h.session.ClientValues[authboss.SessionKey] = "test@test.com"
+	h.cookies.ClientValues[authboss.CookieRemember] = "token"
+
+	r := mocks.Request("POST")
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	h.loadClientState(w, &r)
+
+	if err := h.sessions.PostRevokeAll(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if h.redirector.Options.RedirectPath != "/auth/sessions" {
+		t.Error("redirect path wrong:", h.redirector.Options.RedirectPath)
+	}
+	if len(h.storer.Sessions) != 2 {
+		t.Error("only the current and other user's sessions should remain:", h.storer.Sessions)
+	}
+	if _, ok := h.storer.Sessions["one"]; !ok {
+		t.Error("current session should remain")
+	}
+	if len(h.storer.RMTokens["test@test.com"]) != 0 {
+		t.Error("remember tokens should be deleted")
+	}
+	if _, ok := h.cookies.ClientValues[authboss.CookieRemember]; ok {
+		t.Error("remember cookie should be deleted")
+	}
+}
@@ -9,6 +9,7 @@ package authboss
 
 import (
 	"context"
+	"time"
 
 	"github.com/friendsofgo/errors"
 )
@@ -22,6 +23,12 @@ var (
 	// ErrTokenNotFound should be returned from UseToken when the
 	// record is not found.
 	ErrTokenNotFound = errors.New("token not found")
+	// ErrSessionNotFound should be returned from LoadSession when the
+	// session record is not found.
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrAPIKeyNotFound should be returned from LoadAPIKey and
+	// LoadAPIKeyByHash when the api key is not found.
+	ErrAPIKeyNotFound = errors.New("api key not found")
 )
 
 // ServerStorer represents the data store that's capable of loading users
@@ -88,6 +95,67 @@ type OAuth2ServerStorer interface {
 	SaveOAuth2(ctx context.Context, user OAuth2User) error
 }
 
+// OAuth2Identity is an account with an oauth2 provider that has been
+// linked to a user.
+type OAuth2Identity struct {
+	Provider string
+	UID      string
+	// PID of the user the identity is linked to
+	PID   string
+	Email string
+
+	AccessToken  string
+	RefreshToken string
+	Expiry       time.Time
+
+	CreatedAt time.Time
+}
+
+// OAuth2LinkingServerStorer allows oauth2 identities to be linked to
+// existing users. When the ServerStorer implements this the oauth2 module
+// logs in the user an identity is linked to instead of creating a user
+// for the identity.
+type OAuth2LinkingServerStorer interface {
+	ServerStorer
+
+	// LoadByOAuth2Identity finds the user the provider and uid have been
+	// linked to, it should return ErrUserNotFound if there is none.
+	LoadByOAuth2Identity(ctx context.Context, provider, uid string) (User, error)
+	// LinkOAuth2Identity links an identity to the user with identity.PID,
+	// if the identity is already linked to that user it should be updated.
+	LinkOAuth2Identity(ctx context.Context, identity OAuth2Identity) error
+	// UnlinkOAuth2Identity removes the link between an identity and a user.
+	// It should not return an error if the link does not exist.
+	UnlinkOAuth2Identity(ctx context.Context, pid, provider, uid string) error
+	// ListOAuth2Identities returns all identities linked to the given pid
+	ListOAuth2Identities(ctx context.Context, pid string) ([]OAuth2Identity, error)
+}
+
+// DeletingServerStorer allows users to be deleted
+type DeletingServerStorer interface {
+	ServerStorer
+
+	// Delete the user with the given pid. Anything else belonging to the
+	// user (remember tokens, session records etc.) should be removed as well.
+	// It should return ErrUserNotFound if the user does not exist.
+	Delete(ctx context.Context, pid string) error
+}
+
+// PIDChangingServerStorer allows a user to be moved to a new pid, this is
+// needed to change the e-mail address of a user when it is also their pid
+type PIDChangingServerStorer interface {
+	ServerStorer
+
+	// ChangePID moves the user stored under oldPID to user.GetPID() and
+	// saves the rest of the user in the same step, so a failure can't leave
+	// the user half moved. Session records, api keys and oauth2 identities
+	// should be moved as well. Remember and refresh tokens contain the old
+	// pid and can never match again so they should be deleted. It should
+	// return ErrUserNotFound if the user does not exist and ErrUserFound if
+	// the new pid is taken.
+	ChangePID(ctx context.Context, oldPID string, user User) error
+}
+
 // ConfirmingServerStorer can find a user by a confirm token
 type ConfirmingServerStorer interface {
 	ServerStorer
@@ -97,6 +165,24 @@ type ConfirmingServerStorer interface {
 	LoadByConfirmSelector(ctx context.Context, selector string) (ConfirmableUser, error)
 }
 
+// EmailChangingServerStorer can find a user by an e-mail change token
+type EmailChangingServerStorer interface {
+	ServerStorer
+
+	// LoadByEmailChangeSelector finds a user by his e-mail change selector
+	// field and should return ErrUserNotFound if that user cannot be found.
+	LoadByEmailChangeSelector(ctx context.Context, selector string) (EmailChangeableUser, error)
+}
+
+// MagicLinkServerStorer can find a user by a magic link token
+type MagicLinkServerStorer interface {
+	ServerStorer
+
+	// LoadByMagicLinkSelector finds a user by his magic link selector
+	// field and should return ErrUserNotFound if that user cannot be found.
+	LoadByMagicLinkSelector(ctx context.Context, selector string) (MagicLinkableUser, error)
+}
+
 // RecoveringServerStorer allows users to be recovered by a token
 type RecoveringServerStorer interface {
 	ServerStorer
@@ -106,6 +192,15 @@ type RecoveringServerStorer interface {
 	LoadByRecoverSelector(ctx context.Context, selector string) (RecoverableUser, error)
 }
 
+// UnlockingServerStorer allows locked users to unlock themselves by a token
+type UnlockingServerStorer interface {
+	ServerStorer
+
+	// LoadByUnlockSelector finds a user by their unlock selector field
+	// and should return ErrUserNotFound if that user cannot be found.
+	LoadByUnlockSelector(ctx context.Context, selector string) (LockableUserWithUnlockToken, error)
+}
+
 // RememberingServerStorer allows users to be remembered across sessions
 type RememberingServerStorer interface {
 	ServerStorer
@@ -119,6 +214,100 @@ type RememberingServerStorer interface {
 	UseRememberToken(ctx context.Context, pid, token string) error
 }
 
+// BearerTokenServerStorer stores refresh tokens and revoked access tokens
+// for the bearer module. Like remember tokens only hashes of refresh tokens
+// are given to the storer.
+type BearerTokenServerStorer interface {
+	ServerStorer
+
+	// AddRefreshToken to a user, it may be removed after it expires
+	AddRefreshToken(ctx context.Context, pid, token string, expires time.Time) error
+	// UseRefreshToken finds the pid-token pair and deletes it.
+	// If the token could not be found or has expired return ErrTokenNotFound
+	UseRefreshToken(ctx context.Context, pid, token string) error
+	// DelRefreshTokens removes all refresh tokens for the given pid
+	DelRefreshTokens(ctx context.Context, pid string) error
+
+	// RevokeAccessToken records that the access token with the given id may
+	// no longer be used, the record can be removed after it expires.
+	RevokeAccessToken(ctx context.Context, id string, expires time.Time) error
+	// IsAccessTokenRevoked checks if the access token id has been revoked
+	IsAccessTokenRevoked(ctx context.Context, id string) (bool, error)
+}
+
+// SessionRecord is a record of a single logged in session for a user
+type SessionRecord struct {
+	// ID is a random identifier that is also stored in the
+	// user's session under SessionID
+	ID  string
+	PID string
+
+	UserAgent string
+	IP        string
+
+	CreatedAt time.Time
+	LastSeen  time.Time
+}
+
+// SessionServerStorer keeps records of logged in sessions so that
+// they can be listed and revoked.
+type SessionServerStorer interface {
+	ServerStorer
+
+	// CreateSession stores a new session record
+	CreateSession(ctx context.Context, record SessionRecord) error
+	// LoadSession finds a session record by its id and should return
+	// ErrSessionNotFound if it cannot be found.
+	LoadSession(ctx context.Context, id string) (SessionRecord, error)
+	// ListSessions returns all session records for the given pid
+	ListSessions(ctx context.Context, pid string) ([]SessionRecord, error)
+	// TouchSession updates the LastSeen field of a session record
+	TouchSession(ctx context.Context, id string, lastSeen time.Time) error
+	// DeleteSession removes a session record, this revokes the session.
+	// It should not return an error if the record does not exist.
+	DeleteSession(ctx context.Context, id string) error
+}
+
+// APIKey is a long lived credential that belongs to a user. Only a hash
+// of the key is stored, the key itself is shown to the user once when it's
+// created.
+type APIKey struct {
+	// ID is a random identifier used to list and revoke the key, it is
+	// not a secret.
+	ID   string
+	PID  string
+	Name string
+	// Hash is the SHA-512 hash of the key, it's what the key is
+	// looked up by when it's used.
+	Hash   string
+	Scopes []string
+
+	CreatedAt time.Time
+	LastUsed  time.Time
+}
+
+// APIKeyServerStorer stores the api keys that users create so that they
+// can be used to authenticate requests.
+type APIKeyServerStorer interface {
+	ServerStorer
+
+	// CreateAPIKey stores a new api key
+	CreateAPIKey(ctx context.Context, key APIKey) error
+	// LoadAPIKey finds an api key by its id and should return
+	// ErrAPIKeyNotFound if it cannot be found.
+	LoadAPIKey(ctx context.Context, id string) (APIKey, error)
+	// LoadAPIKeyByHash finds an api key by its hash and should return
+	// ErrAPIKeyNotFound if it cannot be found.
+	LoadAPIKeyByHash(ctx context.Context, hash string) (APIKey, error)
+	// ListAPIKeys returns all api keys for the given pid
+	ListAPIKeys(ctx context.Context, pid string) ([]APIKey, error)
+	// TouchAPIKey updates the LastUsed field of an api key
+	TouchAPIKey(ctx context.Context, id string, lastUsed time.Time) error
+	// DeleteAPIKey removes an api key, this revokes it.
+	// It should not return an error if the key does not exist.
+	DeleteAPIKey(ctx context.Context, id string) error
+}
+
 // EnsureCanCreate makes sure the server storer supports create operations
 func EnsureCanCreate(storer ServerStorer) CreatingServerStorer {
 	s, ok := storer.(CreatingServerStorer)
@@ -129,6 +318,16 @@ func EnsureCanCreate(storer ServerStorer) CreatingServerStorer {
 	return s
 }
 
+// EnsureCanDelete makes sure the server storer supports delete operations
+func EnsureCanDelete(storer ServerStorer) DeletingServerStorer {
+	s, ok := storer.(DeletingServerStorer)
+	if !ok {
+		panic("could not upgrade ServerStorer to DeletingServerStorer, check your struct")
+	}
+
+	return s
+}
+
 // EnsureCanConfirm makes sure the server storer supports
 // confirm-lookup operations
 func EnsureCanConfirm(storer ServerStorer) ConfirmingServerStorer {
@@ -140,6 +339,28 @@ func EnsureCanConfirm(storer ServerStorer) ConfirmingServerStorer {
 	return s
 }
 
+// EnsureCanChangeEmail makes sure the server storer supports
+// e-mail change lookup operations
+func EnsureCanChangeEmail(storer ServerStorer) EmailChangingServerStorer {
+	s, ok := storer.(EmailChangingServerStorer)
+	if !ok {
+		panic("could not upgrade ServerStorer to EmailChangingServerStorer, check your struct")
+	}
+
+	return s
+}
+
+// EnsureCanMagicLink makes sure the server storer supports
+// magic link lookup operations
+func EnsureCanMagicLink(storer ServerStorer) MagicLinkServerStorer {
+	s, ok := storer.(MagicLinkServerStorer)
+	if !ok {
+		panic("could not upgrade ServerStorer to MagicLinkServerStorer, check your struct")
+	}
+
+	return s
+}
+
 // EnsureCanRecover makes sure the server storer supports
 // confirm-lookup operations
 func EnsureCanRecover(storer ServerStorer) RecoveringServerStorer {
@@ -151,6 +372,17 @@ func EnsureCanRecover(storer ServerStorer) RecoveringServerStorer {
 	return s
 }
 
+// EnsureCanUnlock makes sure the server storer supports
+// unlock-by-token operations
+func EnsureCanUnlock(storer ServerStorer) UnlockingServerStorer {
+	s, ok := storer.(UnlockingServerStorer)
+	if !ok {
+		panic("could not upgrade ServerStorer to UnlockingServerStorer, check your struct")
+	}
+
+	return s
+}
+
 // EnsureCanRemember makes sure the server storer supports remember operations
 func EnsureCanRemember(storer ServerStorer) RememberingServerStorer {
 	s, ok := storer.(RememberingServerStorer)
@@ -161,6 +393,17 @@ func EnsureCanRemember(storer ServerStorer) RememberingServerStorer {
 	return s
 }
 
+// EnsureCanBearerToken makes sure the server storer supports
+// refresh token and access token revocation operations
+func EnsureCanBearerToken(storer ServerStorer) BearerTokenServerStorer {
+	s, ok := storer.(BearerTokenServerStorer)
+	if !ok {
+		panic("could not upgrade ServerStorer to BearerTokenServerStorer, check your struct")
+	}
+
+	return s
+}
+
 // EnsureCanOAuth2 makes sure the server storer supports
 // oauth2 creation and lookup
 func EnsureCanOAuth2(storer ServerStorer) OAuth2ServerStorer {
@@ -171,3 +414,35 @@ func EnsureCanOAuth2(storer ServerStorer) OAuth2ServerStorer {
 
 	return s
 }
+
+// EnsureCanLinkOAuth2 makes sure the server storer supports
+// linking oauth2 identities to users
+func EnsureCanLinkOAuth2(storer ServerStorer) OAuth2LinkingServerStorer {
+	s, ok := storer.(OAuth2LinkingServerStorer)
+	if !ok {
+		panic("could not upgrade ServerStorer to OAuth2LinkingServerStorer, check your struct")
+	}
+
+	return s
+}
+
+// EnsureCanTrackSessions makes sure the server storer supports
+// session record operations
+func EnsureCanTrackSessions(storer ServerStorer) SessionServerStorer {
+	s, ok := storer.(SessionServerStorer)
+	if !ok {
+		panic("could not upgrade ServerStorer to SessionServerStorer, check your struct")
+	}
+
+	return s
+}
+
+// EnsureCanAPIKey makes sure the server storer supports api key operations
+func EnsureCanAPIKey(storer ServerStorer) APIKeyServerStorer {
+	s, ok := storer.(APIKeyServerStorer)
+	if !ok {
+		panic("could not upgrade ServerStorer to APIKeyServerStorer, check your struct")
+	}
+
+	return s
+}
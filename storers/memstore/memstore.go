@@ -0,0 +1,773 @@
+// Package memstore is a ServerStorer that keeps everything in memory. It
+// implements every storer interface in authboss and is safe for concurrent
+// use, which makes it a real backend for integration tests and local
+// development servers. It can optionally keep a json snapshot on disk so
+// that data survives restarts.
+package memstore
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/friendsofgo/errors"
+	"github.com/volatiletech/authboss/v3"
+)
+
+var (
+	_ authboss.CreatingServerStorer      = &Store{}
+	_ authboss.OAuth2ServerStorer        = &Store{}
+	_ authboss.OAuth2LinkingServerStorer = &Store{}
+	_ authboss.DeletingServerStorer      = &Store{}
+	_ authboss.ConfirmingServerStorer    = &Store{}
+	_ authboss.EmailChangingServerStorer = &Store{}
+	_ authboss.MagicLinkServerStorer     = &Store{}
+	_ authboss.RecoveringServerStorer    = &Store{}
+	_ authboss.UnlockingServerStorer     = &Store{}
+	_ authboss.RememberingServerStorer   = &Store{}
+	_ authboss.BearerTokenServerStorer   = &Store{}
+	_ authboss.SessionServerStorer       = &Store{}
+	_ authboss.APIKeyServerStorer        = &Store{}
+	_ authboss.ReadingAuditStorer        = &Store{}
+)
+
+// Store keeps users and everything belonging to them in memory. Users are
+// copied in and out of the store so the values returned can be modified
+// freely until they're saved.
+//
+// The zero value is not usable, use New or Open.
+type Store struct {
+	mut  sync.RWMutex
+	data data
+
+	// path is where the snapshot is written after each change, if it's
+	// empty nothing is written
+	path string
+
+	// Clock is used to check whether refresh tokens and revocations have
+	// expired, it should be the same as Config.Core.Clock. When it's nil
+	// the system time is used.
+	Clock authboss.Clock
+}
+
+// data is everything in the store, it's also the format of the snapshot
+type data struct {
+	Users            map[string]User                    `json:"users"`
+	RememberTokens   map[string][]string                `json:"remember_tokens"`
+	RefreshTokens    map[string]map[string]time.Time    `json:"refresh_tokens"`
+	RevokedTokens    map[string]time.Time               `json:"revoked_tokens"`
+	Sessions         map[string]authboss.SessionRecord  `json:"sessions"`
+	APIKeys          map[string]authboss.APIKey         `json:"api_keys"`
+	OAuth2Identities map[string]authboss.OAuth2Identity `json:"oauth2_identities"`
+	AuditRecords     []authboss.AuditRecord             `json:"audit_records"`
+}
+
+// New creates an empty store that is only kept in memory
+func New() *Store {
+	s := &Store{}
+	s.data.init()
+	return s
+}
+
+// Open creates a store that writes a json snapshot to path after every
+// change. If the file already exists the store starts with its contents.
+func Open(path string) (*Store, error) {
+	s := New()
+	s.path = path
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to open snapshot")
+	}
+	defer f.Close()
+
+	if err := s.Restore(f); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Snapshot writes everything in the store to w as json
+func (s *Store) Snapshot(w io.Writer) error {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	return errors.Wrap(json.NewEncoder(w).Encode(s.data), "failed to write snapshot")
+}
+
+// Restore replaces everything in the store with a snapshot read from r
+func (s *Store) Restore(r io.Reader) error {
+	var d data
+	if err := json.NewDecoder(r).Decode(&d); err != nil {
+		return errors.Wrap(err, "failed to read snapshot")
+	}
+	d.init()
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.data = d
+	return nil
+}
+
+// New creates a blank user, it is not yet persisted
+func (s *Store) New(ctx context.Context) authboss.User {
+	return &User{}
+}
+
+// Create the user, it returns ErrUserFound if the pid is taken
+func (s *Store) Create(ctx context.Context, user authboss.User) error {
+	u, err := toUser(user)
+	if err != nil {
+		return err
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if _, ok := s.data.Users[u.PID]; ok {
+		return authboss.ErrUserFound
+	}
+
+	s.data.Users[u.PID] = *u
+	return s.persist()
+}
+
+// Save the user, it returns ErrUserNotFound if they don't exist
+func (s *Store) Save(ctx context.Context, user authboss.User) error {
+	u, err := toUser(user)
+	if err != nil {
+		return err
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if _, ok := s.data.Users[u.PID]; !ok {
+		return authboss.ErrUserNotFound
+	}
+
+	s.data.Users[u.PID] = *u
+	return s.persist()
+}
+
+// Load the user with the given pid, oauth2 users are stored under their
+// oauth2 pid so they need no special handling.
+func (s *Store) Load(ctx context.Context, pid string) (authboss.User, error) {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	u, ok := s.data.Users[pid]
+	if !ok {
+		return nil, authboss.ErrUserNotFound
+	}
+
+	return &u, nil
+}
+
+// Delete the user and their remember tokens, refresh tokens, sessions,
+// api keys and oauth2 identities
+func (s *Store) Delete(ctx context.Context, pid string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if _, ok := s.data.Users[pid]; !ok {
+		return authboss.ErrUserNotFound
+	}
+
+	delete(s.data.Users, pid)
+	delete(s.data.RememberTokens, pid)
+	delete(s.data.RefreshTokens, pid)
+	for id, record := range s.data.Sessions {
+		if record.PID == pid {
+			delete(s.data.Sessions, id)
+		}
+	}
+	for id, key := range s.data.APIKeys {
+		if key.PID == pid {
+			delete(s.data.APIKeys, id)
+		}
+	}
+	for id, identity := range s.data.OAuth2Identities {
+		if identity.PID == pid {
+			delete(s.data.OAuth2Identities, id)
+		}
+	}
+
+	return s.persist()
+}
+
+// ChangePID moves the user to their new pid and saves them along with
+// their sessions, api keys and oauth2 identities. Remember and refresh
+// tokens are deleted since they contain the old pid.
+func (s *Store) ChangePID(ctx context.Context, oldPID string, user authboss.User) error {
+	u, err := toUser(user)
+	if err != nil {
+		return err
+	}
+	newPID := u.PID
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if _, ok := s.data.Users[oldPID]; !ok {
+		return authboss.ErrUserNotFound
+	}
+	if _, ok := s.data.Users[newPID]; ok {
+		return authboss.ErrUserFound
+	}
+
+	delete(s.data.Users, oldPID)
+	s.data.Users[newPID] = *u
+
+	delete(s.data.RememberTokens, oldPID)
+	delete(s.data.RefreshTokens, oldPID)
+	for id, record := range s.data.Sessions {
+		if record.PID == oldPID {
+			record.PID = newPID
+			s.data.Sessions[id] = record
+		}
+	}
+	for id, key := range s.data.APIKeys {
+		if key.PID == oldPID {
+			key.PID = newPID
+			s.data.APIKeys[id] = key
+		}
+	}
+	for id, identity := range s.data.OAuth2Identities {
+		if identity.PID == oldPID {
+			identity.PID = newPID
+			s.data.OAuth2Identities[id] = identity
+		}
+	}
+
+	return s.persist()
+}
+
+// LoadByConfirmSelector finds the user with the confirm selector
+func (s *Store) LoadByConfirmSelector(ctx context.Context, selector string) (authboss.ConfirmableUser, error) {
+	u, err := s.loadBy(selector, func(u *User) string { return u.ConfirmSelector })
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// LoadByRecoverSelector finds the user with the recover selector
+func (s *Store) LoadByRecoverSelector(ctx context.Context, selector string) (authboss.RecoverableUser, error) {
+	u, err := s.loadBy(selector, func(u *User) string { return u.RecoverSelector })
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// LoadByEmailChangeSelector finds the user with the e-mail change selector
+func (s *Store) LoadByEmailChangeSelector(ctx context.Context, selector string) (authboss.EmailChangeableUser, error) {
+	u, err := s.loadBy(selector, func(u *User) string { return u.EmailChangeSelector })
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// LoadByMagicLinkSelector finds the user with the magic link selector
+func (s *Store) LoadByMagicLinkSelector(ctx context.Context, selector string) (authboss.MagicLinkableUser, error) {
+	u, err := s.loadBy(selector, func(u *User) string { return u.MagicLinkSelector })
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// LoadByUnlockSelector finds the user with the unlock selector
+func (s *Store) LoadByUnlockSelector(ctx context.Context, selector string) (authboss.LockableUserWithUnlockToken, error) {
+	u, err := s.loadBy(selector, func(u *User) string { return u.UnlockSelector })
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// NewFromOAuth2 loads the user with the provider and uid in details, or
+// creates a new one, and updates their e-mail address
+func (s *Store) NewFromOAuth2(ctx context.Context, provider string, details map[string]string) (authboss.OAuth2User, error) {
+	uid := details[authboss.OAuth2UID]
+	email := details[authboss.OAuth2Email]
+
+	var u *User
+	user, err := s.Load(ctx, authboss.MakeOAuth2PID(provider, uid))
+	if err == authboss.ErrUserNotFound {
+		u = &User{OAuth2UID: uid, OAuth2Provider: provider}
+		u.PID = oauth2PID(u)
+	} else if err != nil {
+		return nil, err
+	} else {
+		u = user.(*User)
+	}
+
+	u.Email = email
+	return u, nil
+}
+
+// SaveOAuth2 creates the user if they don't exist or saves them if they do
+func (s *Store) SaveOAuth2(ctx context.Context, user authboss.OAuth2User) error {
+	u, err := toUser(user)
+	if err != nil {
+		return err
+	}
+
+	if len(u.PID) == 0 {
+		u.PID = oauth2PID(u)
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.data.Users[u.PID] = *u
+	return s.persist()
+}
+
+// LoadByOAuth2Identity finds the user the identity is linked to
+func (s *Store) LoadByOAuth2Identity(ctx context.Context, provider, uid string) (authboss.User, error) {
+	s.mut.RLock()
+	identity, ok := s.data.OAuth2Identities[authboss.MakeOAuth2PID(provider, uid)]
+	s.mut.RUnlock()
+
+	if !ok {
+		return nil, authboss.ErrUserNotFound
+	}
+
+	return s.Load(ctx, identity.PID)
+}
+
+// LinkOAuth2Identity to a user, replacing the link if there was one
+func (s *Store) LinkOAuth2Identity(ctx context.Context, identity authboss.OAuth2Identity) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.data.OAuth2Identities[authboss.MakeOAuth2PID(identity.Provider, identity.UID)] = identity
+	return s.persist()
+}
+
+// UnlinkOAuth2Identity from a user
+func (s *Store) UnlinkOAuth2Identity(ctx context.Context, pid, provider, uid string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	key := authboss.MakeOAuth2PID(provider, uid)
+	identity, ok := s.data.OAuth2Identities[key]
+	if !ok || identity.PID != pid {
+		return nil
+	}
+
+	delete(s.data.OAuth2Identities, key)
+	return s.persist()
+}
+
+// ListOAuth2Identities linked to a user, the oldest first
+func (s *Store) ListOAuth2Identities(ctx context.Context, pid string) ([]authboss.OAuth2Identity, error) {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	var identities []authboss.OAuth2Identity
+	for _, identity := range s.data.OAuth2Identities {
+		if identity.PID == pid {
+			identities = append(identities, identity)
+		}
+	}
+
+	sort.Slice(identities, func(i, j int) bool {
+		return identities[i].CreatedAt.Before(identities[j].CreatedAt)
+	})
+	return identities, nil
+}
+
+// AddRememberToken to a user
+func (s *Store) AddRememberToken(ctx context.Context, pid, token string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.data.RememberTokens[pid] = append(s.data.RememberTokens[pid], token)
+	return s.persist()
+}
+
+// DelRememberTokens removes all of a user's remember tokens
+func (s *Store) DelRememberTokens(ctx context.Context, pid string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	delete(s.data.RememberTokens, pid)
+	return s.persist()
+}
+
+// UseRememberToken finds the pid-token pair and deletes it, it returns
+// ErrTokenNotFound if it does not exist
+func (s *Store) UseRememberToken(ctx context.Context, pid, token string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	tokens := s.data.RememberTokens[pid]
+	for i, tok := range tokens {
+		if tok != token {
+			continue
+		}
+
+		tokens = append(tokens[:i:i], tokens[i+1:]...)
+		if len(tokens) == 0 {
+			delete(s.data.RememberTokens, pid)
+		} else {
+			s.data.RememberTokens[pid] = tokens
+		}
+		return s.persist()
+	}
+
+	return authboss.ErrTokenNotFound
+}
+
+// AddRefreshToken to a user
+func (s *Store) AddRefreshToken(ctx context.Context, pid, token string, expires time.Time) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.data.RefreshTokens[pid] == nil {
+		s.data.RefreshTokens[pid] = make(map[string]time.Time)
+	}
+	s.data.RefreshTokens[pid][token] = expires
+	return s.persist()
+}
+
+// UseRefreshToken finds the pid-token pair and deletes it, it returns
+// ErrTokenNotFound if it does not exist or has expired
+func (s *Store) UseRefreshToken(ctx context.Context, pid, token string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	expires, ok := s.data.RefreshTokens[pid][token]
+	if !ok {
+		return authboss.ErrTokenNotFound
+	}
+
+	delete(s.data.RefreshTokens[pid], token)
+	if len(s.data.RefreshTokens[pid]) == 0 {
+		delete(s.data.RefreshTokens, pid)
+	}
+	if err := s.persist(); err != nil {
+		return err
+	}
+
+	if s.now().After(expires) {
+		return authboss.ErrTokenNotFound
+	}
+	return nil
+}
+
+// DelRefreshTokens removes all of a user's refresh tokens
+func (s *Store) DelRefreshTokens(ctx context.Context, pid string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	delete(s.data.RefreshTokens, pid)
+	return s.persist()
+}
+
+// RevokeAccessToken by id, revocations that have expired are removed
+// at the same time
+func (s *Store) RevokeAccessToken(ctx context.Context, id string, expires time.Time) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	now := s.now()
+	for revoked, exp := range s.data.RevokedTokens {
+		if now.After(exp) {
+			delete(s.data.RevokedTokens, revoked)
+		}
+	}
+
+	s.data.RevokedTokens[id] = expires
+	return s.persist()
+}
+
+// IsAccessTokenRevoked by id
+func (s *Store) IsAccessTokenRevoked(ctx context.Context, id string) (bool, error) {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	_, ok := s.data.RevokedTokens[id]
+	return ok, nil
+}
+
+// CreateSession record
+func (s *Store) CreateSession(ctx context.Context, record authboss.SessionRecord) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.data.Sessions[record.ID] = record
+	return s.persist()
+}
+
+// LoadSession record
+func (s *Store) LoadSession(ctx context.Context, id string) (authboss.SessionRecord, error) {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	record, ok := s.data.Sessions[id]
+	if !ok {
+		return authboss.SessionRecord{}, authboss.ErrSessionNotFound
+	}
+
+	return record, nil
+}
+
+// ListSessions for a user, the oldest first
+func (s *Store) ListSessions(ctx context.Context, pid string) ([]authboss.SessionRecord, error) {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	var records []authboss.SessionRecord
+	for _, record := range s.data.Sessions {
+		if record.PID == pid {
+			records = append(records, record)
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].CreatedAt.Before(records[j].CreatedAt)
+	})
+	return records, nil
+}
+
+// TouchSession updates the last seen time
+func (s *Store) TouchSession(ctx context.Context, id string, lastSeen time.Time) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	record, ok := s.data.Sessions[id]
+	if !ok {
+		return authboss.ErrSessionNotFound
+	}
+
+	record.LastSeen = lastSeen
+	s.data.Sessions[id] = record
+	return s.persist()
+}
+
+// DeleteSession record
+func (s *Store) DeleteSession(ctx context.Context, id string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	delete(s.data.Sessions, id)
+	return s.persist()
+}
+
+// CreateAPIKey for a user
+func (s *Store) CreateAPIKey(ctx context.Context, key authboss.APIKey) error {
+	key.Scopes = append([]string(nil), key.Scopes...)
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.data.APIKeys[key.ID] = key
+	return s.persist()
+}
+
+// LoadAPIKey by id
+func (s *Store) LoadAPIKey(ctx context.Context, id string) (authboss.APIKey, error) {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	key, ok := s.data.APIKeys[id]
+	if !ok {
+		return authboss.APIKey{}, authboss.ErrAPIKeyNotFound
+	}
+
+	return copyAPIKey(key), nil
+}
+
+// LoadAPIKeyByHash of the key
+func (s *Store) LoadAPIKeyByHash(ctx context.Context, hash string) (authboss.APIKey, error) {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	for _, key := range s.data.APIKeys {
+		if key.Hash == hash {
+			return copyAPIKey(key), nil
+		}
+	}
+
+	return authboss.APIKey{}, authboss.ErrAPIKeyNotFound
+}
+
+// ListAPIKeys for a user, the oldest first
+func (s *Store) ListAPIKeys(ctx context.Context, pid string) ([]authboss.APIKey, error) {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	var keys []authboss.APIKey
+	for _, key := range s.data.APIKeys {
+		if key.PID == pid {
+			keys = append(keys, copyAPIKey(key))
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+// TouchAPIKey updates the last used time
+func (s *Store) TouchAPIKey(ctx context.Context, id string, lastUsed time.Time) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	key, ok := s.data.APIKeys[id]
+	if !ok {
+		return authboss.ErrAPIKeyNotFound
+	}
+
+	key.LastUsed = lastUsed
+	s.data.APIKeys[id] = key
+	return s.persist()
+}
+
+// DeleteAPIKey by id
+func (s *Store) DeleteAPIKey(ctx context.Context, id string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	delete(s.data.APIKeys, id)
+	return s.persist()
+}
+
+// PutAuditRecord stores a new record, records are never removed so this
+// is best left to tests and development
+func (s *Store) PutAuditRecord(ctx context.Context, record authboss.AuditRecord) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.data.AuditRecords = append(s.data.AuditRecords, record)
+	return s.persist()
+}
+
+// RecentAuditRecords returns up to limit of the user's records, the
+// newest first
+func (s *Store) RecentAuditRecords(ctx context.Context, pid string, limit int) ([]authboss.AuditRecord, error) {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	var records []authboss.AuditRecord
+	for i := len(s.data.AuditRecords) - 1; i >= 0 && len(records) < limit; i-- {
+		if record := s.data.AuditRecords[i]; record.PID == pid {
+			records = append(records, record)
+		}
+	}
+
+	return records, nil
+}
+
+// loadBy finds the user whose selector (returned by field) matches.
+// Every user without a token has an empty selector so it never matches.
+func (s *Store) loadBy(selector string, field func(*User) string) (*User, error) {
+	if len(selector) == 0 {
+		return nil, authboss.ErrUserNotFound
+	}
+
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	for _, u := range s.data.Users {
+		if field(&u) == selector {
+			return &u, nil
+		}
+	}
+
+	return nil, authboss.ErrUserNotFound
+}
+
+// persist writes the snapshot if the store was opened with a path, the
+// write lock must be held. The snapshot is written to a temporary file
+// first so a crash can't leave a partially written one behind.
+func (s *Store) persist() error {
+	if len(s.path) == 0 {
+		return nil
+	}
+
+	b, err := json.Marshal(s.data)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode snapshot")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return errors.Wrap(err, "failed to create snapshot")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		return errors.Wrap(err, "failed to write snapshot")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "failed to write snapshot")
+	}
+
+	return errors.Wrap(os.Rename(tmp.Name(), s.path), "failed to replace snapshot")
+}
+
+func (s *Store) now() time.Time {
+	if s.Clock == nil {
+		return time.Now()
+	}
+
+	return s.Clock.Now()
+}
+
+// init makes the maps that were missing from a snapshot
+func (d *data) init() {
+	if d.Users == nil {
+		d.Users = make(map[string]User)
+	}
+	if d.RememberTokens == nil {
+		d.RememberTokens = make(map[string][]string)
+	}
+	if d.RefreshTokens == nil {
+		d.RefreshTokens = make(map[string]map[string]time.Time)
+	}
+	if d.RevokedTokens == nil {
+		d.RevokedTokens = make(map[string]time.Time)
+	}
+	if d.Sessions == nil {
+		d.Sessions = make(map[string]authboss.SessionRecord)
+	}
+	if d.APIKeys == nil {
+		d.APIKeys = make(map[string]authboss.APIKey)
+	}
+	if d.OAuth2Identities == nil {
+		d.OAuth2Identities = make(map[string]authboss.OAuth2Identity)
+	}
+}
+
+// copyAPIKey so that the scopes of a stored key can't be modified
+func copyAPIKey(key authboss.APIKey) authboss.APIKey {
+	key.Scopes = append([]string(nil), key.Scopes...)
+	return key
+}
+
+func toUser(user authboss.User) (*User, error) {
+	u, ok := user.(*User)
+	if !ok {
+		return nil, errors.Errorf("memstore can only store *memstore.User, given type: %T", user)
+	}
+
+	return u, nil
+}
@@ -0,0 +1,497 @@
+package memstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/storers/storertest"
+)
+
+var (
+	_ authboss.AuthableUser                = &User{}
+	_ authboss.ConfirmableUser             = &User{}
+	_ authboss.EmailChangeableUser         = &User{}
+	_ authboss.MagicLinkableUser           = &User{}
+	_ authboss.LockableUserWithLockCount   = &User{}
+	_ authboss.LockableUserWithUnlockToken = &User{}
+	_ authboss.RecoverableUser             = &User{}
+	_ authboss.OAuth2User                  = &User{}
+)
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	storertest.RunConformance(t, func(t *testing.T) authboss.ServerStorer {
+		return New()
+	})
+}
+
+func TestCreateLoadSave(t *testing.T) {
+	t.Parallel()
+
+	s := New()
+	ctx := context.Background()
+
+	if _, err := s.Load(ctx, "test@test.com"); err != authboss.ErrUserNotFound {
+		t.Error("err wrong:", err)
+	}
+
+	user := s.New(ctx).(*User)
+	user.PutPID("test@test.com")
+	user.PutPassword("hash")
+
+	if err := s.Create(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Create(ctx, user); err != authboss.ErrUserFound {
+		t.Error("err wrong:", err)
+	}
+
+	// The store keeps a copy
+	user.PutPassword("changed")
+
+	loaded, err := s.Load(ctx, "test@test.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := loaded.(*User)
+	if got.Password != "hash" {
+		t.Error("the stored user should not change until it's saved:", got.Password)
+	}
+	if got.GetEmail() != "test@test.com" {
+		t.Error("e-mail should fall back to the pid:", got.GetEmail())
+	}
+
+	if err := s.Save(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err = s.Load(ctx, "test@test.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.(*User); got.Password != "changed" {
+		t.Error("password wrong:", got.Password)
+	}
+
+	if err := s.Save(ctx, &User{PID: "other@test.com"}); err != authboss.ErrUserNotFound {
+		t.Error("err wrong:", err)
+	}
+}
+
+func TestLoadBySelector(t *testing.T) {
+	t.Parallel()
+
+	s := New()
+	ctx := context.Background()
+
+	users := []*User{
+		{PID: "confirm@test.com", ConfirmSelector: "confirm"},
+		{PID: "recover@test.com", RecoverSelector: "recover"},
+		{PID: "email@test.com", EmailChangeSelector: "email"},
+		{PID: "magic@test.com", MagicLinkSelector: "magic"},
+		{PID: "unlock@test.com", UnlockSelector: "unlock"},
+	}
+	for _, u := range users {
+		if err := s.Create(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	loaders := map[string]func(string) (authboss.User, error){
+		"confirm": func(sel string) (authboss.User, error) { return s.LoadByConfirmSelector(ctx, sel) },
+		"recover": func(sel string) (authboss.User, error) { return s.LoadByRecoverSelector(ctx, sel) },
+		"email":   func(sel string) (authboss.User, error) { return s.LoadByEmailChangeSelector(ctx, sel) },
+		"magic":   func(sel string) (authboss.User, error) { return s.LoadByMagicLinkSelector(ctx, sel) },
+		"unlock":  func(sel string) (authboss.User, error) { return s.LoadByUnlockSelector(ctx, sel) },
+	}
+
+	for selector, load := range loaders {
+		if u, err := load(selector); err != nil {
+			t.Error(selector, err)
+		} else if u.GetPID() != selector+"@test.com" {
+			t.Error(selector, "wrong user:", u.GetPID())
+		}
+
+		// Every user without a token has an empty selector
+		if u, err := load(""); err != authboss.ErrUserNotFound || u != nil {
+			t.Error(selector, "an empty selector should never be found:", err)
+		}
+		if _, err := load("nope"); err != authboss.ErrUserNotFound {
+			t.Error(selector, "err wrong:", err)
+		}
+	}
+}
+
+func TestOAuth2(t *testing.T) {
+	t.Parallel()
+
+	s := New()
+	ctx := context.Background()
+
+	details := map[string]string{"uid": "123", "email": "test@test.com"}
+	user, err := s.NewFromOAuth2(ctx, "google", details)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.PutOAuth2AccessToken("access")
+	if err := s.SaveOAuth2(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	details["email"] = "new@test.com"
+	user, err = s.NewFromOAuth2(ctx, "google", details)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.GetOAuth2AccessToken() != "access" {
+		t.Error("it should have loaded the existing user")
+	}
+	if err := s.SaveOAuth2(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := s.Load(ctx, authboss.MakeOAuth2PID("google", "123"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.(*User); got.Email != "new@test.com" {
+		t.Error("e-mail should be updated:", got.Email)
+	}
+}
+
+func TestOAuth2Identities(t *testing.T) {
+	t.Parallel()
+
+	s := New()
+	ctx := context.Background()
+
+	if err := s.Create(ctx, &User{PID: "test@test.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	identity := authboss.OAuth2Identity{Provider: "google", UID: "123", PID: "test@test.com"}
+	if err := s.LinkOAuth2Identity(ctx, identity); err != nil {
+		t.Fatal(err)
+	}
+
+	if u, err := s.LoadByOAuth2Identity(ctx, "google", "123"); err != nil {
+		t.Error(err)
+	} else if u.GetPID() != "test@test.com" {
+		t.Error("wrong user:", u.GetPID())
+	}
+
+	// Unlinking must be done by the user it's linked to
+	if err := s.UnlinkOAuth2Identity(ctx, "other@test.com", "google", "123"); err != nil {
+		t.Fatal(err)
+	}
+	if identities, err := s.ListOAuth2Identities(ctx, "test@test.com"); err != nil {
+		t.Fatal(err)
+	} else if len(identities) != 1 {
+		t.Error("identity should still be linked:", identities)
+	}
+
+	if err := s.UnlinkOAuth2Identity(ctx, "test@test.com", "google", "123"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.LoadByOAuth2Identity(ctx, "google", "123"); err != authboss.ErrUserNotFound {
+		t.Error("err wrong:", err)
+	}
+}
+
+func TestRememberTokens(t *testing.T) {
+	t.Parallel()
+
+	s := New()
+	ctx := context.Background()
+
+	for _, token := range []string{"one", "two", "three"} {
+		if err := s.AddRememberToken(ctx, "test@test.com", token); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.UseRememberToken(ctx, "test@test.com", "two"); err != nil {
+		t.Error(err)
+	}
+	if err := s.UseRememberToken(ctx, "test@test.com", "two"); err != authboss.ErrTokenNotFound {
+		t.Error("a used token should be deleted:", err)
+	}
+	for _, token := range []string{"one", "three"} {
+		if err := s.UseRememberToken(ctx, "test@test.com", token); err != nil {
+			t.Error("other tokens should be kept:", token, err)
+		}
+	}
+
+	if err := s.AddRememberToken(ctx, "test@test.com", "four"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DelRememberTokens(ctx, "test@test.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UseRememberToken(ctx, "test@test.com", "four"); err != authboss.ErrTokenNotFound {
+		t.Error("tokens should be deleted:", err)
+	}
+}
+
+func TestRefreshTokens(t *testing.T) {
+	t.Parallel()
+
+	s := New()
+	ctx := context.Background()
+
+	if err := s.AddRefreshToken(ctx, "test@test.com", "valid", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddRefreshToken(ctx, "test@test.com", "expired", time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.UseRefreshToken(ctx, "test@test.com", "valid"); err != nil {
+		t.Error(err)
+	}
+	if err := s.UseRefreshToken(ctx, "test@test.com", "valid"); err != authboss.ErrTokenNotFound {
+		t.Error("a used token should be deleted:", err)
+	}
+	if err := s.UseRefreshToken(ctx, "test@test.com", "expired"); err != authboss.ErrTokenNotFound {
+		t.Error("an expired token should not be usable:", err)
+	}
+
+	if err := s.RevokeAccessToken(ctx, "id", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if revoked, err := s.IsAccessTokenRevoked(ctx, "id"); err != nil || !revoked {
+		t.Error("token should be revoked:", err)
+	}
+	if revoked, err := s.IsAccessTokenRevoked(ctx, "other"); err != nil || revoked {
+		t.Error("token should not be revoked:", err)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	t.Parallel()
+
+	s := New()
+	ctx := context.Background()
+	pid := "test@test.com"
+
+	if err := s.Delete(ctx, pid); err != authboss.ErrUserNotFound {
+		t.Error("err wrong:", err)
+	}
+
+	if err := s.Create(ctx, &User{PID: pid}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddRememberToken(ctx, pid, "token"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateSession(ctx, authboss.SessionRecord{ID: "session", PID: pid}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateAPIKey(ctx, authboss.APIKey{ID: "key", PID: pid, Hash: "hash"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateSession(ctx, authboss.SessionRecord{ID: "other", PID: "other@test.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Delete(ctx, pid); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Load(ctx, pid); err != authboss.ErrUserNotFound {
+		t.Error("user should be deleted:", err)
+	}
+	if err := s.UseRememberToken(ctx, pid, "token"); err != authboss.ErrTokenNotFound {
+		t.Error("remember tokens should be deleted:", err)
+	}
+	if _, err := s.LoadSession(ctx, "session"); err != authboss.ErrSessionNotFound {
+		t.Error("sessions should be deleted:", err)
+	}
+	if _, err := s.LoadAPIKeyByHash(ctx, "hash"); err != authboss.ErrAPIKeyNotFound {
+		t.Error("api keys should be deleted:", err)
+	}
+	if _, err := s.LoadSession(ctx, "other"); err != nil {
+		t.Error("other users' sessions should be kept:", err)
+	}
+}
+
+func TestSessionsAndAPIKeys(t *testing.T) {
+	t.Parallel()
+
+	s := New()
+	ctx := context.Background()
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		id := fmt.Sprint(i)
+		created := now.Add(time.Duration(i) * time.Minute)
+		if err := s.CreateSession(ctx, authboss.SessionRecord{ID: id, PID: "test", CreatedAt: created}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.CreateAPIKey(ctx, authboss.APIKey{ID: id, PID: "test", Hash: "hash" + id, Scopes: []string{"read"}, CreatedAt: created}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := s.ListSessions(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[0].ID != "0" || records[2].ID != "2" {
+		t.Error("sessions wrong:", records)
+	}
+
+	if err := s.TouchSession(ctx, "1", now); err != nil {
+		t.Fatal(err)
+	}
+	if record, err := s.LoadSession(ctx, "1"); err != nil || !record.LastSeen.Equal(now) {
+		t.Error("last seen wrong:", record.LastSeen, err)
+	}
+	if err := s.TouchSession(ctx, "nope", now); err != authboss.ErrSessionNotFound {
+		t.Error("err wrong:", err)
+	}
+
+	key, err := s.LoadAPIKeyByHash(ctx, "hash1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.Scopes[0] = "write"
+	if key, err := s.LoadAPIKey(ctx, "1"); err != nil || key.Scopes[0] != "read" {
+		t.Error("stored scopes should not change:", key.Scopes, err)
+	}
+
+	if err := s.DeleteAPIKey(ctx, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := s.ListAPIKeys(ctx, "test"); err != nil || len(keys) != 2 {
+		t.Error("keys wrong:", keys, err)
+	}
+}
+
+func TestAuditRecords(t *testing.T) {
+	t.Parallel()
+
+	s := New()
+	ctx := context.Background()
+
+	for _, event := range []string{"Register", "Auth", "Logout"} {
+		if err := s.PutAuditRecord(ctx, authboss.AuditRecord{PID: "test", Event: event}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.PutAuditRecord(ctx, authboss.AuditRecord{PID: "other", Event: "Auth"}); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := s.RecentAuditRecords(ctx, "test", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Event != "Logout" || records[1].Event != "Auth" {
+		t.Error("records wrong:", records)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "authboss.json")
+	ctx := context.Background()
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Create(ctx, &User{PID: "test@test.com", Password: "hash"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddRememberToken(ctx, "test@test.com", "token"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new store opened from the same file has the same data
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := s.Load(ctx, "test@test.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.(*User); got.Password != "hash" {
+		t.Error("password wrong:", got.Password)
+	}
+	if err := s.UseRememberToken(ctx, "test@test.com", "token"); err != nil {
+		t.Error(err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := s.Snapshot(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := New()
+	if err := restored.Restore(buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restored.Load(ctx, "test@test.com"); err != nil {
+		t.Error(err)
+	}
+	if err := restored.UseRememberToken(ctx, "test@test.com", "token"); err != authboss.ErrTokenNotFound {
+		t.Error("used token should not be in the snapshot:", err)
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	t.Parallel()
+
+	s := New()
+	ctx := context.Background()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			pid := fmt.Sprintf("%d@test.com", i)
+			if err := s.Create(ctx, &User{PID: pid}); err != nil {
+				t.Error(err)
+				return
+			}
+			for j := 0; j < 10; j++ {
+				user, err := s.Load(ctx, pid)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				u := user.(*User)
+				u.AttemptCount++
+				if err := s.Save(ctx, u); err != nil {
+					t.Error(err)
+				}
+				if err := s.AddRememberToken(ctx, pid, fmt.Sprint(j)); err != nil {
+					t.Error(err)
+				}
+				_, _ = s.LoadByRecoverSelector(ctx, "selector")
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < 10; i++ {
+		user, err := s.Load(ctx, fmt.Sprintf("%d@test.com", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count := user.(*User).AttemptCount; count != 10 {
+			t.Error("attempt count wrong:", count)
+		}
+	}
+}
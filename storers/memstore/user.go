@@ -0,0 +1,273 @@
+package memstore
+
+import (
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// User is stored by Store, it implements the user interfaces of every
+// module in authboss.
+type User struct {
+	// PID is the e-mail address or username the user registered with,
+	// oauth2 users have the pid from authboss.MakeOAuth2PID.
+	PID      string `json:"pid"`
+	Email    string `json:"email,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	Confirmed       bool   `json:"confirmed,omitempty"`
+	ConfirmSelector string `json:"confirm_selector,omitempty"`
+	ConfirmVerifier string `json:"confirm_verifier,omitempty"`
+
+	RecoverSelector string    `json:"recover_selector,omitempty"`
+	RecoverVerifier string    `json:"recover_verifier,omitempty"`
+	RecoverExpiry   time.Time `json:"recover_expiry"`
+
+	PendingEmail        string    `json:"pending_email,omitempty"`
+	EmailChangeSelector string    `json:"email_change_selector,omitempty"`
+	EmailChangeVerifier string    `json:"email_change_verifier,omitempty"`
+	EmailChangeExpiry   time.Time `json:"email_change_expiry"`
+
+	MagicLinkSelector string    `json:"magic_link_selector,omitempty"`
+	MagicLinkVerifier string    `json:"magic_link_verifier,omitempty"`
+	MagicLinkExpiry   time.Time `json:"magic_link_expiry"`
+
+	AttemptCount   int       `json:"attempt_count,omitempty"`
+	LastAttempt    time.Time `json:"last_attempt"`
+	Locked         time.Time `json:"locked"`
+	LockCount      int       `json:"lock_count,omitempty"`
+	UnlockSelector string    `json:"unlock_selector,omitempty"`
+	UnlockVerifier string    `json:"unlock_verifier,omitempty"`
+	UnlockExpiry   time.Time `json:"unlock_expiry"`
+
+	OAuth2UID          string    `json:"oauth2_uid,omitempty"`
+	OAuth2Provider     string    `json:"oauth2_provider,omitempty"`
+	OAuth2AccessToken  string    `json:"oauth2_access_token,omitempty"`
+	OAuth2RefreshToken string    `json:"oauth2_refresh_token,omitempty"`
+	OAuth2Expiry       time.Time `json:"oauth2_expiry"`
+
+	OTPs                string `json:"otps,omitempty"`
+	TOTPSecretKey       string `json:"totp_secret_key,omitempty"`
+	TOTPLastCode        string `json:"totp_last_code,omitempty"`
+	SMSPhoneNumber      string `json:"sms_phone_number,omitempty"`
+	WebAuthnCredentials string `json:"webauthn_credentials,omitempty"`
+	RecoveryCodes       string `json:"recovery_codes,omitempty"`
+}
+
+// GetPID from user
+func (u User) GetPID() string { return u.PID }
+
+// GetEmail from user, when no e-mail is stored the pid is used so that
+// apps that use e-mail addresses as pids don't have to store it twice.
+func (u User) GetEmail() string {
+	if len(u.Email) == 0 {
+		return u.PID
+	}
+	return u.Email
+}
+
+// GetPassword from user
+func (u User) GetPassword() string { return u.Password }
+
+// GetConfirmed from user
+func (u User) GetConfirmed() bool { return u.Confirmed }
+
+// GetConfirmSelector from user
+func (u User) GetConfirmSelector() string { return u.ConfirmSelector }
+
+// GetConfirmVerifier from user
+func (u User) GetConfirmVerifier() string { return u.ConfirmVerifier }
+
+// GetRecoverSelector from user
+func (u User) GetRecoverSelector() string { return u.RecoverSelector }
+
+// GetRecoverVerifier from user
+func (u User) GetRecoverVerifier() string { return u.RecoverVerifier }
+
+// GetRecoverExpiry from user
+func (u User) GetRecoverExpiry() time.Time { return u.RecoverExpiry }
+
+// GetPendingEmail from user
+func (u User) GetPendingEmail() string { return u.PendingEmail }
+
+// GetEmailChangeSelector from user
+func (u User) GetEmailChangeSelector() string { return u.EmailChangeSelector }
+
+// GetEmailChangeVerifier from user
+func (u User) GetEmailChangeVerifier() string { return u.EmailChangeVerifier }
+
+// GetEmailChangeExpiry from user
+func (u User) GetEmailChangeExpiry() time.Time { return u.EmailChangeExpiry }
+
+// GetMagicLinkSelector from user
+func (u User) GetMagicLinkSelector() string { return u.MagicLinkSelector }
+
+// GetMagicLinkVerifier from user
+func (u User) GetMagicLinkVerifier() string { return u.MagicLinkVerifier }
+
+// GetMagicLinkExpiry from user
+func (u User) GetMagicLinkExpiry() time.Time { return u.MagicLinkExpiry }
+
+// GetAttemptCount from user
+func (u User) GetAttemptCount() int { return u.AttemptCount }
+
+// GetLastAttempt from user
+func (u User) GetLastAttempt() time.Time { return u.LastAttempt }
+
+// GetLocked from user
+func (u User) GetLocked() time.Time { return u.Locked }
+
+// GetLockCount from user
+func (u User) GetLockCount() int { return u.LockCount }
+
+// GetUnlockSelector from user
+func (u User) GetUnlockSelector() string { return u.UnlockSelector }
+
+// GetUnlockVerifier from user
+func (u User) GetUnlockVerifier() string { return u.UnlockVerifier }
+
+// GetUnlockExpiry from user
+func (u User) GetUnlockExpiry() time.Time { return u.UnlockExpiry }
+
+// IsOAuth2User returns true if the user is an oauth2 user
+func (u User) IsOAuth2User() bool { return len(u.OAuth2Provider) != 0 }
+
+// GetOAuth2UID from user
+func (u User) GetOAuth2UID() string { return u.OAuth2UID }
+
+// GetOAuth2Provider from user
+func (u User) GetOAuth2Provider() string { return u.OAuth2Provider }
+
+// GetOAuth2AccessToken from user
+func (u User) GetOAuth2AccessToken() string { return u.OAuth2AccessToken }
+
+// GetOAuth2RefreshToken from user
+func (u User) GetOAuth2RefreshToken() string { return u.OAuth2RefreshToken }
+
+// GetOAuth2Expiry from user
+func (u User) GetOAuth2Expiry() time.Time { return u.OAuth2Expiry }
+
+// GetOTPs from user
+func (u User) GetOTPs() string { return u.OTPs }
+
+// GetTOTPSecretKey from user
+func (u User) GetTOTPSecretKey() string { return u.TOTPSecretKey }
+
+// GetTOTPLastCode from user
+func (u User) GetTOTPLastCode() string { return u.TOTPLastCode }
+
+// GetSMSPhoneNumber from user
+func (u User) GetSMSPhoneNumber() string { return u.SMSPhoneNumber }
+
+// GetWebAuthnCredentials from user
+func (u User) GetWebAuthnCredentials() string { return u.WebAuthnCredentials }
+
+// GetRecoveryCodes from user
+func (u User) GetRecoveryCodes() string { return u.RecoveryCodes }
+
+// PutPID into user
+func (u *User) PutPID(pid string) { u.PID = pid }
+
+// PutEmail into user
+func (u *User) PutEmail(email string) { u.Email = email }
+
+// PutPassword into user
+func (u *User) PutPassword(password string) { u.Password = password }
+
+// PutConfirmed into user
+func (u *User) PutConfirmed(confirmed bool) { u.Confirmed = confirmed }
+
+// PutConfirmSelector into user
+func (u *User) PutConfirmSelector(selector string) { u.ConfirmSelector = selector }
+
+// PutConfirmVerifier into user
+func (u *User) PutConfirmVerifier(verifier string) { u.ConfirmVerifier = verifier }
+
+// PutRecoverSelector into user
+func (u *User) PutRecoverSelector(selector string) { u.RecoverSelector = selector }
+
+// PutRecoverVerifier into user
+func (u *User) PutRecoverVerifier(verifier string) { u.RecoverVerifier = verifier }
+
+// PutRecoverExpiry into user
+func (u *User) PutRecoverExpiry(expiry time.Time) { u.RecoverExpiry = expiry }
+
+// PutPendingEmail into user
+func (u *User) PutPendingEmail(email string) { u.PendingEmail = email }
+
+// PutEmailChangeSelector into user
+func (u *User) PutEmailChangeSelector(selector string) { u.EmailChangeSelector = selector }
+
+// PutEmailChangeVerifier into user
+func (u *User) PutEmailChangeVerifier(verifier string) { u.EmailChangeVerifier = verifier }
+
+// PutEmailChangeExpiry into user
+func (u *User) PutEmailChangeExpiry(expiry time.Time) { u.EmailChangeExpiry = expiry }
+
+// PutMagicLinkSelector into user
+func (u *User) PutMagicLinkSelector(selector string) { u.MagicLinkSelector = selector }
+
+// PutMagicLinkVerifier into user
+func (u *User) PutMagicLinkVerifier(verifier string) { u.MagicLinkVerifier = verifier }
+
+// PutMagicLinkExpiry into user
+func (u *User) PutMagicLinkExpiry(expiry time.Time) { u.MagicLinkExpiry = expiry }
+
+// PutAttemptCount into user
+func (u *User) PutAttemptCount(attempts int) { u.AttemptCount = attempts }
+
+// PutLastAttempt into user
+func (u *User) PutLastAttempt(last time.Time) { u.LastAttempt = last }
+
+// PutLocked into user
+func (u *User) PutLocked(locked time.Time) { u.Locked = locked }
+
+// PutLockCount into user
+func (u *User) PutLockCount(count int) { u.LockCount = count }
+
+// PutUnlockSelector into user
+func (u *User) PutUnlockSelector(selector string) { u.UnlockSelector = selector }
+
+// PutUnlockVerifier into user
+func (u *User) PutUnlockVerifier(verifier string) { u.UnlockVerifier = verifier }
+
+// PutUnlockExpiry into user
+func (u *User) PutUnlockExpiry(expiry time.Time) { u.UnlockExpiry = expiry }
+
+// PutOAuth2UID into user
+func (u *User) PutOAuth2UID(uid string) { u.OAuth2UID = uid }
+
+// PutOAuth2Provider into user
+func (u *User) PutOAuth2Provider(provider string) { u.OAuth2Provider = provider }
+
+// PutOAuth2AccessToken into user
+func (u *User) PutOAuth2AccessToken(token string) { u.OAuth2AccessToken = token }
+
+// PutOAuth2RefreshToken into user
+func (u *User) PutOAuth2RefreshToken(refreshToken string) { u.OAuth2RefreshToken = refreshToken }
+
+// PutOAuth2Expiry into user
+func (u *User) PutOAuth2Expiry(expiry time.Time) { u.OAuth2Expiry = expiry }
+
+// PutOTPs into user
+func (u *User) PutOTPs(otps string) { u.OTPs = otps }
+
+// PutTOTPSecretKey into user
+func (u *User) PutTOTPSecretKey(key string) { u.TOTPSecretKey = key }
+
+// PutTOTPLastCode into user
+func (u *User) PutTOTPLastCode(code string) { u.TOTPLastCode = code }
+
+// PutSMSPhoneNumber into user
+func (u *User) PutSMSPhoneNumber(number string) { u.SMSPhoneNumber = number }
+
+// PutWebAuthnCredentials into user
+func (u *User) PutWebAuthnCredentials(credentials string) { u.WebAuthnCredentials = credentials }
+
+// PutRecoveryCodes into user
+func (u *User) PutRecoveryCodes(codes string) { u.RecoveryCodes = codes }
+
+// oauth2PID is the pid oauth2 users are stored under
+func oauth2PID(u *User) string {
+	return authboss.MakeOAuth2PID(u.OAuth2Provider, u.OAuth2UID)
+}
@@ -0,0 +1,49 @@
+package sqlstore
+
+import "strconv"
+
+// Dialect holds what differs between databases for the queries and
+// schema that Store uses.
+type Dialect struct {
+	// Placeholder returns the bind parameter for the n'th (from 1)
+	// argument of a query.
+	Placeholder func(n int) string
+
+	// Column types used in the schema
+	StringType string
+	TextType   string
+	IntType    string
+	BoolType   string
+	TimeType   string
+}
+
+// Dialects for the databases Store has been used with, others can be
+// supported by creating a Dialect.
+var (
+	SQLite = Dialect{
+		Placeholder: questionPlaceholder,
+		StringType:  "VARCHAR(255)",
+		TextType:    "TEXT",
+		IntType:     "INTEGER",
+		BoolType:    "BOOLEAN",
+		TimeType:    "TIMESTAMP",
+	}
+	Postgres = Dialect{
+		Placeholder: func(n int) string { return "$" + strconv.Itoa(n) },
+		StringType:  "VARCHAR(255)",
+		TextType:    "TEXT",
+		IntType:     "INTEGER",
+		BoolType:    "BOOLEAN",
+		TimeType:    "TIMESTAMP",
+	}
+	MySQL = Dialect{
+		Placeholder: questionPlaceholder,
+		StringType:  "VARCHAR(255)",
+		TextType:    "TEXT",
+		IntType:     "INTEGER",
+		BoolType:    "BOOLEAN",
+		TimeType:    "DATETIME(6)",
+	}
+)
+
+func questionPlaceholder(int) string { return "?" }
@@ -0,0 +1,3 @@
+// Package sqlitetest runs the sqlstore tests against SQLite. It's a module
+// of its own so the SQLite driver isn't a dependency of authboss.
+package sqlitetest
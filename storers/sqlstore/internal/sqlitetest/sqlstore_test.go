@@ -0,0 +1,279 @@
+package sqlitetest
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/storers/sqlstore"
+	"github.com/volatiletech/authboss/v3/storers/storertest"
+	_ "modernc.org/sqlite"
+)
+
+func testStore(t *testing.T) *sqlstore.Store {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	s := sqlstore.New(db, sqlstore.SQLite)
+	if err := s.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	storertest.RunConformance(t, func(t *testing.T) authboss.ServerStorer {
+		return testStore(t)
+	})
+}
+
+func TestMigrate(t *testing.T) {
+	t.Parallel()
+
+	s := testStore(t)
+
+	// Running it again should do nothing
+	if err := s.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var version int
+	if err := s.DB.QueryRow("SELECT MAX(version) FROM authboss_migrations").Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != len(s.Migrations()) {
+		t.Error("version wrong:", version)
+	}
+}
+
+func TestCreateLoadSave(t *testing.T) {
+	t.Parallel()
+
+	s := testStore(t)
+	ctx := context.Background()
+
+	if _, err := s.Load(ctx, "test@test.com"); err != authboss.ErrUserNotFound {
+		t.Error("err wrong:", err)
+	}
+
+	locked := time.Date(2030, 1, 2, 3, 4, 5, 6000, time.UTC)
+
+	user := s.New(ctx).(*sqlstore.User)
+	user.PutPID("test@test.com")
+	user.PutPassword("hash")
+	user.PutLocked(locked)
+	user.PutAttemptCount(2)
+	user.PutConfirmed(true)
+
+	if err := s.Create(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Create(ctx, user); err != authboss.ErrUserFound {
+		t.Error("err wrong:", err)
+	}
+
+	loaded, err := s.Load(ctx, "test@test.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := loaded.(*sqlstore.User)
+	if got.Password != "hash" || got.AttemptCount != 2 || !got.Confirmed {
+		t.Errorf("user wrong: %#v", got)
+	}
+	if !got.Locked.Equal(locked) {
+		t.Error("locked wrong:", got.Locked)
+	}
+	if !got.LastAttempt.IsZero() {
+		t.Error("zero times should stay zero:", got.LastAttempt)
+	}
+	if got.GetEmail() != "test@test.com" {
+		t.Error("e-mail should fall back to the pid:", got.GetEmail())
+	}
+
+	got.PutPassword("newhash")
+	got.PutTOTPSecretKey("secret")
+	if err := s.Save(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	// Saving without changes is not the same as the user not existing
+	if err := s.Save(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err = s.Load(ctx, "test@test.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.(*sqlstore.User); got.Password != "newhash" || got.TOTPSecretKey != "secret" {
+		t.Errorf("user wrong: %#v", got)
+	}
+
+	if err := s.Save(ctx, &sqlstore.User{PID: "other@test.com"}); err != authboss.ErrUserNotFound {
+		t.Error("err wrong:", err)
+	}
+}
+
+func TestLoadBySelector(t *testing.T) {
+	t.Parallel()
+
+	s := testStore(t)
+	ctx := context.Background()
+
+	users := []*sqlstore.User{
+		{PID: "confirm@test.com", ConfirmSelector: "confirm"},
+		{PID: "recover@test.com", RecoverSelector: "recover"},
+	}
+	for _, u := range users {
+		if err := s.Create(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if u, err := s.LoadByConfirmSelector(ctx, "confirm"); err != nil {
+		t.Error(err)
+	} else if u.GetPID() != "confirm@test.com" {
+		t.Error("wrong user:", u.GetPID())
+	}
+
+	if u, err := s.LoadByRecoverSelector(ctx, "recover"); err != nil {
+		t.Error(err)
+	} else if u.GetPID() != "recover@test.com" {
+		t.Error("wrong user:", u.GetPID())
+	}
+
+	// Every user without a token has an empty selector
+	if u, err := s.LoadByConfirmSelector(ctx, ""); err != authboss.ErrUserNotFound || u != nil {
+		t.Error("an empty selector should never be found:", err)
+	}
+	if _, err := s.LoadByRecoverSelector(ctx, "nope"); err != authboss.ErrUserNotFound {
+		t.Error("err wrong:", err)
+	}
+}
+
+func TestOAuth2(t *testing.T) {
+	t.Parallel()
+
+	s := testStore(t)
+	ctx := context.Background()
+
+	details := map[string]string{"uid": "123", "email": "test@test.com"}
+	user, err := s.NewFromOAuth2(ctx, "google", details)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.PutOAuth2AccessToken("access")
+
+	if err := s.SaveOAuth2(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	pid := authboss.MakeOAuth2PID("google", "123")
+	loaded, err := s.Load(ctx, pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.(*sqlstore.User); got.OAuth2AccessToken != "access" || got.Email != "test@test.com" {
+		t.Errorf("user wrong: %#v", got)
+	}
+
+	// The details are updated for an existing user
+	details["email"] = "new@test.com"
+	user, err = s.NewFromOAuth2(ctx, "google", details)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.GetOAuth2AccessToken() != "access" {
+		t.Error("it should have loaded the existing user")
+	}
+	if err := s.SaveOAuth2(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err = s.Load(ctx, pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.(*sqlstore.User); got.Email != "new@test.com" {
+		t.Error("e-mail should be updated:", got.Email)
+	}
+}
+
+func TestRememberTokens(t *testing.T) {
+	t.Parallel()
+
+	s := testStore(t)
+	ctx := context.Background()
+
+	for _, token := range []string{"one", "two"} {
+		if err := s.AddRememberToken(ctx, "test@test.com", token); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.AddRememberToken(ctx, "other@test.com", "three"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.UseRememberToken(ctx, "test@test.com", "one"); err != nil {
+		t.Error(err)
+	}
+	if err := s.UseRememberToken(ctx, "test@test.com", "one"); err != authboss.ErrTokenNotFound {
+		t.Error("a used token should be deleted:", err)
+	}
+	if err := s.UseRememberToken(ctx, "test@test.com", "three"); err != authboss.ErrTokenNotFound {
+		t.Error("tokens belong to one user:", err)
+	}
+
+	if err := s.DelRememberTokens(ctx, "test@test.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UseRememberToken(ctx, "test@test.com", "two"); err != authboss.ErrTokenNotFound {
+		t.Error("tokens should be deleted:", err)
+	}
+	if err := s.UseRememberToken(ctx, "other@test.com", "three"); err != nil {
+		t.Error("other users' tokens should be kept:", err)
+	}
+}
+
+func TestColumns(t *testing.T) {
+	t.Parallel()
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	s := sqlstore.New(db, sqlstore.SQLite)
+	s.Table = "accounts"
+	s.Columns.PID = "username"
+	s.Columns.Password = "password_hash"
+
+	ctx := context.Background()
+	if err := s.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Create(ctx, &sqlstore.User{PID: "test", Password: "hash"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var hash string
+	if err := db.QueryRow("SELECT password_hash FROM accounts WHERE username = 'test'").Scan(&hash); err != nil {
+		t.Fatal(err)
+	}
+	if hash != "hash" {
+		t.Error("hash wrong:", hash)
+	}
+}
@@ -0,0 +1,500 @@
+// Package sqlstore is a ServerStorer built on database/sql. It can be used
+// as is with its User, schema and migrations, or as a reference for
+// writing a storer for an existing database.
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/friendsofgo/errors"
+	"github.com/volatiletech/authboss/v3"
+)
+
+// Columns are the names of the columns that the fields of User are stored
+// in, they can be changed to fit an existing table.
+type Columns struct {
+	PID      string
+	Email    string
+	Password string
+
+	Confirmed       string
+	ConfirmSelector string
+	ConfirmVerifier string
+
+	RecoverSelector string
+	RecoverVerifier string
+	RecoverExpiry   string
+
+	AttemptCount string
+	LastAttempt  string
+	Locked       string
+
+	OAuth2UID          string
+	OAuth2Provider     string
+	OAuth2AccessToken  string
+	OAuth2RefreshToken string
+	OAuth2Expiry       string
+
+	OTPs           string
+	TOTPSecretKey  string
+	TOTPLastCode   string
+	SMSPhoneNumber string
+	RecoveryCodes  string
+
+	// RememberPID and RememberToken are the columns of the remember
+	// tokens table
+	RememberPID   string
+	RememberToken string
+}
+
+// DefaultColumns are the columns used by the default schema
+func DefaultColumns() Columns {
+	return Columns{
+		PID:      "pid",
+		Email:    "email",
+		Password: "password",
+
+		Confirmed:       "confirmed",
+		ConfirmSelector: "confirm_selector",
+		ConfirmVerifier: "confirm_verifier",
+
+		RecoverSelector: "recover_selector",
+		RecoverVerifier: "recover_verifier",
+		RecoverExpiry:   "recover_expiry",
+
+		AttemptCount: "attempt_count",
+		LastAttempt:  "last_attempt",
+		Locked:       "locked",
+
+		OAuth2UID:          "oauth2_uid",
+		OAuth2Provider:     "oauth2_provider",
+		OAuth2AccessToken:  "oauth2_access_token",
+		OAuth2RefreshToken: "oauth2_refresh_token",
+		OAuth2Expiry:       "oauth2_expiry",
+
+		OTPs:           "otps",
+		TOTPSecretKey:  "totp_secret_key",
+		TOTPLastCode:   "totp_last_code",
+		SMSPhoneNumber: "sms_phone_number",
+		RecoveryCodes:  "recovery_codes",
+
+		RememberPID:   "pid",
+		RememberToken: "token",
+	}
+}
+
+// Store is an authboss.ServerStorer that keeps Users in a database. It
+// implements CreatingServerStorer, ConfirmingServerStorer,
+// RecoveringServerStorer, RememberingServerStorer and OAuth2ServerStorer.
+//
+// OAuth2 users are stored with the pid from authboss.MakeOAuth2PID so Load
+// needs no special case for them.
+type Store struct {
+	DB      *sql.DB
+	Dialect Dialect
+	Columns Columns
+
+	// Table holds the users
+	Table string
+	// RememberTable holds the remember tokens
+	RememberTable string
+	// MigrationsTable records which migrations Migrate has run
+	MigrationsTable string
+}
+
+// New creates a Store with the default tables and columns
+func New(db *sql.DB, dialect Dialect) *Store {
+	return &Store{
+		DB:              db,
+		Dialect:         dialect,
+		Columns:         DefaultColumns(),
+		Table:           "users",
+		RememberTable:   "remember_tokens",
+		MigrationsTable: "authboss_migrations",
+	}
+}
+
+// Migrations are the statements that create the default schema, in order.
+// Apps that have their own migration tool can copy them into it instead of
+// calling Migrate. New statements are only ever appended.
+func (s *Store) Migrations() []string {
+	c, d := s.Columns, s.Dialect
+
+	str := func(col string) string { return col + " " + d.StringType + " NOT NULL" }
+	text := func(col string) string { return col + " " + d.TextType + " NOT NULL" }
+	tm := func(col string) string { return col + " " + d.TimeType + " NULL" }
+
+	users := []string{
+		str(c.PID),
+		str(c.Email),
+		text(c.Password),
+		c.Confirmed + " " + d.BoolType + " NOT NULL",
+		str(c.ConfirmSelector),
+		str(c.ConfirmVerifier),
+		str(c.RecoverSelector),
+		str(c.RecoverVerifier),
+		tm(c.RecoverExpiry),
+		c.AttemptCount + " " + d.IntType + " NOT NULL",
+		tm(c.LastAttempt),
+		tm(c.Locked),
+		str(c.OAuth2UID),
+		str(c.OAuth2Provider),
+		text(c.OAuth2AccessToken),
+		text(c.OAuth2RefreshToken),
+		tm(c.OAuth2Expiry),
+		text(c.OTPs),
+		str(c.TOTPSecretKey),
+		str(c.TOTPLastCode),
+		str(c.SMSPhoneNumber),
+		text(c.RecoveryCodes),
+		"PRIMARY KEY (" + c.PID + ")",
+	}
+
+	index := func(col string) string {
+		return fmt.Sprintf("CREATE INDEX %s_%s_idx ON %s (%s)", s.Table, col, s.Table, col)
+	}
+
+	return []string{
+		fmt.Sprintf("CREATE TABLE %s (\n\t%s\n)", s.Table, strings.Join(users, ",\n\t")),
+		fmt.Sprintf("CREATE TABLE %s (\n\t%s,\n\t%s,\n\tPRIMARY KEY (%s, %s)\n)",
+			s.RememberTable, str(c.RememberPID), str(c.RememberToken), c.RememberPID, c.RememberToken),
+		index(c.ConfirmSelector),
+		index(c.RecoverSelector),
+	}
+}
+
+// Migrate runs the Migrations that haven't been run yet
+func (s *Store) Migrate(ctx context.Context) error {
+	create := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version %s NOT NULL, PRIMARY KEY (version))",
+		s.MigrationsTable, s.Dialect.IntType)
+	if _, err := s.DB.ExecContext(ctx, create); err != nil {
+		return errors.Wrap(err, "failed to create migrations table")
+	}
+
+	var version int
+	query := fmt.Sprintf("SELECT COALESCE(MAX(version), 0) FROM %s", s.MigrationsTable)
+	if err := s.DB.QueryRowContext(ctx, query).Scan(&version); err != nil {
+		return errors.Wrap(err, "failed to read migration version")
+	}
+
+	migrations := s.Migrations()
+	insert := fmt.Sprintf("INSERT INTO %s (version) VALUES (%s)", s.MigrationsTable, s.Dialect.Placeholder(1))
+	for i := version; i < len(migrations); i++ {
+		tx, err := s.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return errors.Wrap(err, "failed to begin migration")
+		}
+
+		if _, err = tx.ExecContext(ctx, migrations[i]); err == nil {
+			_, err = tx.ExecContext(ctx, insert, i+1)
+		}
+		if err != nil {
+			_ = tx.Rollback()
+			return errors.Wrapf(err, "failed to run migration %d", i+1)
+		}
+
+		if err = tx.Commit(); err != nil {
+			return errors.Wrapf(err, "failed to commit migration %d", i+1)
+		}
+	}
+
+	return nil
+}
+
+// New creates a blank user, it is not yet persisted in the database
+func (s *Store) New(ctx context.Context) authboss.User {
+	return &User{}
+}
+
+// Load the user by their pid
+func (s *Store) Load(ctx context.Context, key string) (authboss.User, error) {
+	u, err := s.loadBy(ctx, s.Columns.PID, key)
+	if err != nil {
+		// Don't return a nil *User in a non-nil interface
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// Save the user, it returns ErrUserNotFound if they don't exist
+func (s *Store) Save(ctx context.Context, user authboss.User) error {
+	u, err := toUser(user)
+	if err != nil {
+		return err
+	}
+
+	cols, fields := s.userColumns(), userFields(u)
+
+	sets := make([]string, 0, len(cols)-1)
+	for i, col := range cols[1:] {
+		sets = append(sets, col+" = "+s.Dialect.Placeholder(i+1))
+	}
+
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s = %s",
+		s.Table, strings.Join(sets, ", "), s.Columns.PID, s.Dialect.Placeholder(len(cols)))
+	args := append(fields[1:], u.PID)
+
+	result, err := s.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return errors.Wrap(err, "failed to save user")
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "failed to save user")
+	} else if affected != 0 {
+		return nil
+	}
+
+	// Some databases (mysql) don't count rows that didn't change
+	if exists, err := s.exists(ctx, u.PID); err != nil {
+		return err
+	} else if !exists {
+		return authboss.ErrUserNotFound
+	}
+
+	return nil
+}
+
+// Create the user, it returns ErrUserFound if they already exist
+func (s *Store) Create(ctx context.Context, user authboss.User) error {
+	u, err := toUser(user)
+	if err != nil {
+		return err
+	}
+
+	if exists, err := s.exists(ctx, u.PID); err != nil {
+		return err
+	} else if exists {
+		return authboss.ErrUserFound
+	}
+
+	if err = s.insert(ctx, u); err != nil {
+		// Someone else may have created the user since the check
+		if exists, existsErr := s.exists(ctx, u.PID); existsErr == nil && exists {
+			return authboss.ErrUserFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+// LoadByConfirmSelector finds a user by their confirm selector
+func (s *Store) LoadByConfirmSelector(ctx context.Context, selector string) (authboss.ConfirmableUser, error) {
+	u, err := s.loadBy(ctx, s.Columns.ConfirmSelector, selector)
+	if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// LoadByRecoverSelector finds a user by their recover selector
+func (s *Store) LoadByRecoverSelector(ctx context.Context, selector string) (authboss.RecoverableUser, error) {
+	u, err := s.loadBy(ctx, s.Columns.RecoverSelector, selector)
+	if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// NewFromOAuth2 loads the user for the provider and uid in details,
+// updating their e-mail, or creates a new one if they don't exist.
+func (s *Store) NewFromOAuth2(ctx context.Context, provider string, details map[string]string) (authboss.OAuth2User, error) {
+	uid := details[authboss.OAuth2UID]
+	email := details[authboss.OAuth2Email]
+
+	u, err := s.loadBy(ctx, s.Columns.PID, authboss.MakeOAuth2PID(provider, uid))
+	if err == authboss.ErrUserNotFound {
+		u = &User{OAuth2UID: uid, OAuth2Provider: provider}
+		u.PID = oauth2PID(u)
+	} else if err != nil {
+		return nil, err
+	}
+
+	u.Email = email
+	return u, nil
+}
+
+// SaveOAuth2 creates the user if they don't exist or saves them if they do
+func (s *Store) SaveOAuth2(ctx context.Context, user authboss.OAuth2User) error {
+	u, err := toUser(user)
+	if err != nil {
+		return err
+	}
+
+	if len(u.PID) == 0 {
+		u.PID = oauth2PID(u)
+	}
+
+	err = s.Save(ctx, u)
+	if err == authboss.ErrUserNotFound {
+		return s.Create(ctx, u)
+	}
+
+	return err
+}
+
+// AddRememberToken to a user
+func (s *Store) AddRememberToken(ctx context.Context, pid, token string) error {
+	query := fmt.Sprintf("INSERT INTO %s (%s, %s) VALUES (%s, %s)",
+		s.RememberTable, s.Columns.RememberPID, s.Columns.RememberToken,
+		s.Dialect.Placeholder(1), s.Dialect.Placeholder(2))
+
+	_, err := s.DB.ExecContext(ctx, query, pid, token)
+	return errors.Wrap(err, "failed to add remember token")
+}
+
+// DelRememberTokens removes all tokens for the given pid
+func (s *Store) DelRememberTokens(ctx context.Context, pid string) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s = %s",
+		s.RememberTable, s.Columns.RememberPID, s.Dialect.Placeholder(1))
+
+	_, err := s.DB.ExecContext(ctx, query, pid)
+	return errors.Wrap(err, "failed to delete remember tokens")
+}
+
+// UseRememberToken deletes the pid-token pair, it returns ErrTokenNotFound
+// if it doesn't exist.
+func (s *Store) UseRememberToken(ctx context.Context, pid, token string) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s = %s AND %s = %s",
+		s.RememberTable, s.Columns.RememberPID, s.Dialect.Placeholder(1),
+		s.Columns.RememberToken, s.Dialect.Placeholder(2))
+
+	result, err := s.DB.ExecContext(ctx, query, pid, token)
+	if err != nil {
+		return errors.Wrap(err, "failed to use remember token")
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "failed to use remember token")
+	} else if affected == 0 {
+		return authboss.ErrTokenNotFound
+	}
+
+	return nil
+}
+
+func (s *Store) loadBy(ctx context.Context, col, value string) (*User, error) {
+	// Users without a token have an empty selector, it must never match
+	if len(value) == 0 {
+		return nil, authboss.ErrUserNotFound
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s = %s",
+		strings.Join(s.userColumns(), ", "), s.Table, col, s.Dialect.Placeholder(1))
+
+	u := &User{}
+	err := s.DB.QueryRowContext(ctx, query, value).Scan(userFields(u)...)
+	if err == sql.ErrNoRows {
+		return nil, authboss.ErrUserNotFound
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to load user")
+	}
+
+	return u, nil
+}
+
+func (s *Store) insert(ctx context.Context, u *User) error {
+	cols := s.userColumns()
+
+	placeholders := make([]string, len(cols))
+	for i := range cols {
+		placeholders[i] = s.Dialect.Placeholder(i + 1)
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		s.Table, strings.Join(cols, ", "), strings.Join(placeholders, ", "))
+
+	_, err := s.DB.ExecContext(ctx, query, userFields(u)...)
+	return errors.Wrap(err, "failed to create user")
+}
+
+func (s *Store) exists(ctx context.Context, pid string) (bool, error) {
+	query := fmt.Sprintf("SELECT 1 FROM %s WHERE %s = %s", s.Table, s.Columns.PID, s.Dialect.Placeholder(1))
+
+	var one int
+	err := s.DB.QueryRowContext(ctx, query, pid).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Wrap(err, "failed to check if user exists")
+	}
+
+	return true, nil
+}
+
+// userColumns are the users table's columns in the same order as the
+// fields returned by userFields, the pid must be first.
+func (s *Store) userColumns() []string {
+	c := s.Columns
+	return []string{
+		c.PID, c.Email, c.Password,
+		c.Confirmed, c.ConfirmSelector, c.ConfirmVerifier,
+		c.RecoverSelector, c.RecoverVerifier, c.RecoverExpiry,
+		c.AttemptCount, c.LastAttempt, c.Locked,
+		c.OAuth2UID, c.OAuth2Provider, c.OAuth2AccessToken, c.OAuth2RefreshToken, c.OAuth2Expiry,
+		c.OTPs, c.TOTPSecretKey, c.TOTPLastCode, c.SMSPhoneNumber, c.RecoveryCodes,
+	}
+}
+
+// userFields are used both to scan into and as query arguments
+func userFields(u *User) []interface{} {
+	return []interface{}{
+		&u.PID, &u.Email, &u.Password,
+		&u.Confirmed, &u.ConfirmSelector, &u.ConfirmVerifier,
+		&u.RecoverSelector, &u.RecoverVerifier, nullTime{&u.RecoverExpiry},
+		&u.AttemptCount, nullTime{&u.LastAttempt}, nullTime{&u.Locked},
+		&u.OAuth2UID, &u.OAuth2Provider, &u.OAuth2AccessToken, &u.OAuth2RefreshToken, nullTime{&u.OAuth2Expiry},
+		&u.OTPs, &u.TOTPSecretKey, &u.TOTPLastCode, &u.SMSPhoneNumber, &u.RecoveryCodes,
+	}
+}
+
+func toUser(user authboss.User) (*User, error) {
+	u, ok := user.(*User)
+	if !ok {
+		return nil, errors.Errorf("sqlstore can only store *sqlstore.User, given type: %T", user)
+	}
+
+	return u, nil
+}
+
+// nullTime stores the zero time as NULL since some databases can't store
+// times that far in the past, and times are always read back as UTC.
+type nullTime struct {
+	t *time.Time
+}
+
+// Scan implements sql.Scanner
+func (n nullTime) Scan(value interface{}) error {
+	var nt sql.NullTime
+	if err := nt.Scan(value); err != nil {
+		return err
+	}
+
+	if nt.Valid {
+		*n.t = nt.Time.UTC()
+	} else {
+		*n.t = time.Time{}
+	}
+
+	return nil
+}
+
+// Value implements driver.Valuer
+func (n nullTime) Value() (driver.Value, error) {
+	if n.t.IsZero() {
+		return nil, nil
+	}
+
+	return n.t.UTC(), nil
+}
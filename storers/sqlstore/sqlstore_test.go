@@ -0,0 +1,22 @@
+package sqlstore
+
+import (
+	"github.com/volatiletech/authboss/v3"
+)
+
+// The tests that need a database are in internal/sqlitetest, a module of
+// its own so the SQLite driver isn't a dependency of authboss.
+
+var (
+	_ authboss.CreatingServerStorer    = &Store{}
+	_ authboss.ConfirmingServerStorer  = &Store{}
+	_ authboss.RecoveringServerStorer  = &Store{}
+	_ authboss.RememberingServerStorer = &Store{}
+	_ authboss.OAuth2ServerStorer      = &Store{}
+
+	_ authboss.AuthableUser    = &User{}
+	_ authboss.ConfirmableUser = &User{}
+	_ authboss.LockableUser    = &User{}
+	_ authboss.RecoverableUser = &User{}
+	_ authboss.OAuth2User      = &User{}
+)
@@ -0,0 +1,187 @@
+package sqlstore
+
+import (
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// User is stored by Store, it implements the user interfaces for the auth,
+// confirm, lock, recover, oauth2, otp, totp2fa and sms2fa modules.
+type User struct {
+	// PID is the e-mail address or username the user registered with,
+	// oauth2 users have the pid from authboss.MakeOAuth2PID.
+	PID      string
+	Email    string
+	Password string
+
+	Confirmed       bool
+	ConfirmSelector string
+	ConfirmVerifier string
+
+	RecoverSelector string
+	RecoverVerifier string
+	RecoverExpiry   time.Time
+
+	AttemptCount int
+	LastAttempt  time.Time
+	Locked       time.Time
+
+	OAuth2UID          string
+	OAuth2Provider     string
+	OAuth2AccessToken  string
+	OAuth2RefreshToken string
+	OAuth2Expiry       time.Time
+
+	OTPs           string
+	TOTPSecretKey  string
+	TOTPLastCode   string
+	SMSPhoneNumber string
+	RecoveryCodes  string
+}
+
+// GetPID from user
+func (u User) GetPID() string { return u.PID }
+
+// GetEmail from user, when no e-mail is stored the pid is used so that
+// apps that use e-mail addresses as pids don't have to store it twice.
+func (u User) GetEmail() string {
+	if len(u.Email) == 0 {
+		return u.PID
+	}
+	return u.Email
+}
+
+// GetPassword from user
+func (u User) GetPassword() string { return u.Password }
+
+// GetConfirmed from user
+func (u User) GetConfirmed() bool { return u.Confirmed }
+
+// GetConfirmSelector from user
+func (u User) GetConfirmSelector() string { return u.ConfirmSelector }
+
+// GetConfirmVerifier from user
+func (u User) GetConfirmVerifier() string { return u.ConfirmVerifier }
+
+// GetRecoverSelector from user
+func (u User) GetRecoverSelector() string { return u.RecoverSelector }
+
+// GetRecoverVerifier from user
+func (u User) GetRecoverVerifier() string { return u.RecoverVerifier }
+
+// GetRecoverExpiry from user
+func (u User) GetRecoverExpiry() time.Time { return u.RecoverExpiry }
+
+// GetAttemptCount from user
+func (u User) GetAttemptCount() int { return u.AttemptCount }
+
+// GetLastAttempt from user
+func (u User) GetLastAttempt() time.Time { return u.LastAttempt }
+
+// GetLocked from user
+func (u User) GetLocked() time.Time { return u.Locked }
+
+// IsOAuth2User returns true if the user is an oauth2 user
+func (u User) IsOAuth2User() bool { return len(u.OAuth2Provider) != 0 }
+
+// GetOAuth2UID from user
+func (u User) GetOAuth2UID() string { return u.OAuth2UID }
+
+// GetOAuth2Provider from user
+func (u User) GetOAuth2Provider() string { return u.OAuth2Provider }
+
+// GetOAuth2AccessToken from user
+func (u User) GetOAuth2AccessToken() string { return u.OAuth2AccessToken }
+
+// GetOAuth2RefreshToken from user
+func (u User) GetOAuth2RefreshToken() string { return u.OAuth2RefreshToken }
+
+// GetOAuth2Expiry from user
+func (u User) GetOAuth2Expiry() time.Time { return u.OAuth2Expiry }
+
+// GetOTPs from user
+func (u User) GetOTPs() string { return u.OTPs }
+
+// GetTOTPSecretKey from user
+func (u User) GetTOTPSecretKey() string { return u.TOTPSecretKey }
+
+// GetTOTPLastCode from user
+func (u User) GetTOTPLastCode() string { return u.TOTPLastCode }
+
+// GetSMSPhoneNumber from user
+func (u User) GetSMSPhoneNumber() string { return u.SMSPhoneNumber }
+
+// GetRecoveryCodes from user
+func (u User) GetRecoveryCodes() string { return u.RecoveryCodes }
+
+// PutPID into user
+func (u *User) PutPID(pid string) { u.PID = pid }
+
+// PutEmail into user
+func (u *User) PutEmail(email string) { u.Email = email }
+
+// PutPassword into user
+func (u *User) PutPassword(password string) { u.Password = password }
+
+// PutConfirmed into user
+func (u *User) PutConfirmed(confirmed bool) { u.Confirmed = confirmed }
+
+// PutConfirmSelector into user
+func (u *User) PutConfirmSelector(selector string) { u.ConfirmSelector = selector }
+
+// PutConfirmVerifier into user
+func (u *User) PutConfirmVerifier(verifier string) { u.ConfirmVerifier = verifier }
+
+// PutRecoverSelector into user
+func (u *User) PutRecoverSelector(selector string) { u.RecoverSelector = selector }
+
+// PutRecoverVerifier into user
+func (u *User) PutRecoverVerifier(verifier string) { u.RecoverVerifier = verifier }
+
+// PutRecoverExpiry into user
+func (u *User) PutRecoverExpiry(expiry time.Time) { u.RecoverExpiry = expiry }
+
+// PutAttemptCount into user
+func (u *User) PutAttemptCount(attempts int) { u.AttemptCount = attempts }
+
+// PutLastAttempt into user
+func (u *User) PutLastAttempt(last time.Time) { u.LastAttempt = last }
+
+// PutLocked into user
+func (u *User) PutLocked(locked time.Time) { u.Locked = locked }
+
+// PutOAuth2UID into user
+func (u *User) PutOAuth2UID(uid string) { u.OAuth2UID = uid }
+
+// PutOAuth2Provider into user
+func (u *User) PutOAuth2Provider(provider string) { u.OAuth2Provider = provider }
+
+// PutOAuth2AccessToken into user
+func (u *User) PutOAuth2AccessToken(token string) { u.OAuth2AccessToken = token }
+
+// PutOAuth2RefreshToken into user
+func (u *User) PutOAuth2RefreshToken(refreshToken string) { u.OAuth2RefreshToken = refreshToken }
+
+// PutOAuth2Expiry into user
+func (u *User) PutOAuth2Expiry(expiry time.Time) { u.OAuth2Expiry = expiry }
+
+// PutOTPs into user
+func (u *User) PutOTPs(otps string) { u.OTPs = otps }
+
+// PutTOTPSecretKey into user
+func (u *User) PutTOTPSecretKey(key string) { u.TOTPSecretKey = key }
+
+// PutTOTPLastCode into user
+func (u *User) PutTOTPLastCode(code string) { u.TOTPLastCode = code }
+
+// PutSMSPhoneNumber into user
+func (u *User) PutSMSPhoneNumber(number string) { u.SMSPhoneNumber = number }
+
+// PutRecoveryCodes into user
+func (u *User) PutRecoveryCodes(codes string) { u.RecoveryCodes = codes }
+
+// oauth2PID is the pid oauth2 users are stored under
+func oauth2PID(u *User) string {
+	return authboss.MakeOAuth2PID(u.OAuth2Provider, u.OAuth2UID)
+}
@@ -0,0 +1,916 @@
+// Package storertest is a conformance test suite for ServerStorer
+// implementations. It checks the parts of the storer contracts that are
+// easy to get wrong, like which errors are returned and that tokens are
+// deleted when they're used, for every optional storer interface the
+// implementation satisfies.
+//
+//	func TestConformance(t *testing.T) {
+//		storertest.RunConformance(t, func(t *testing.T) authboss.ServerStorer {
+//			return newEmptyStore(t)
+//		})
+//	}
+package storertest
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// Factory creates a new, empty storer. It's called once for each test
+// so that they can't affect each other, any cleanup should be registered
+// with t.Cleanup.
+type Factory func(t *testing.T) authboss.ServerStorer
+
+// conformanceTest is run when the storer satisfies the interface, which
+// is checked by implements
+type conformanceTest struct {
+	name       string
+	implements func(authboss.ServerStorer) bool
+	test       func(t *testing.T, s authboss.ServerStorer)
+}
+
+var conformanceTests = []conformanceTest{
+	{"ServerStorer", isCreating, testServerStorer},
+	{"CreatingServerStorer", isCreating, testCreating},
+	{"ConfirmingServerStorer", isConfirming, testConfirming},
+	{"RecoveringServerStorer", isRecovering, testRecovering},
+	{"EmailChangingServerStorer", isEmailChanging, testEmailChanging},
+	{"MagicLinkServerStorer", isMagicLink, testMagicLink},
+	{"UnlockingServerStorer", isUnlocking, testUnlocking},
+	{"RememberingServerStorer", isRemembering, testRemembering},
+	{"BearerTokenServerStorer", isBearerToken, testBearerToken},
+	{"SessionServerStorer", isSession, testSession},
+	{"APIKeyServerStorer", isAPIKey, testAPIKey},
+	{"OAuth2ServerStorer", isOAuth2, testOAuth2},
+	{"OAuth2LinkingServerStorer", isOAuth2Linking, testOAuth2Linking},
+	{"DeletingServerStorer", isDeleting, testDeleting},
+	{"PIDChangingServerStorer", isPIDChanging, testPIDChanging},
+	{"ReadingAuditStorer", isReadingAudit, testReadingAudit},
+}
+
+// RunConformance runs a subtest for each storer interface, the ones the
+// storer does not implement are skipped. Users are created with
+// CreatingServerStorer so without it only the token, session and
+// api key tests can be run.
+//
+// Errors are compared with == since that's how the modules check them,
+// a storer that wraps ErrUserNotFound or ErrTokenNotFound will fail.
+func RunConformance(t *testing.T, factory Factory) {
+	t.Helper()
+
+	for _, c := range conformanceTests {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			s := factory(t)
+			if !c.implements(s) {
+				t.Skipf("%T does not implement %s", s, c.name)
+			}
+
+			c.test(t, s)
+		})
+	}
+}
+
+func isCreating(s authboss.ServerStorer) bool {
+	_, ok := s.(authboss.CreatingServerStorer)
+	return ok
+}
+
+func isConfirming(s authboss.ServerStorer) bool {
+	_, ok := s.(authboss.ConfirmingServerStorer)
+	return ok && isCreating(s)
+}
+
+func isRecovering(s authboss.ServerStorer) bool {
+	_, ok := s.(authboss.RecoveringServerStorer)
+	return ok && isCreating(s)
+}
+
+func isEmailChanging(s authboss.ServerStorer) bool {
+	_, ok := s.(authboss.EmailChangingServerStorer)
+	return ok && isCreating(s)
+}
+
+func isMagicLink(s authboss.ServerStorer) bool {
+	_, ok := s.(authboss.MagicLinkServerStorer)
+	return ok && isCreating(s)
+}
+
+func isUnlocking(s authboss.ServerStorer) bool {
+	_, ok := s.(authboss.UnlockingServerStorer)
+	return ok && isCreating(s)
+}
+
+func isRemembering(s authboss.ServerStorer) bool {
+	_, ok := s.(authboss.RememberingServerStorer)
+	return ok
+}
+
+func isBearerToken(s authboss.ServerStorer) bool {
+	_, ok := s.(authboss.BearerTokenServerStorer)
+	return ok
+}
+
+func isSession(s authboss.ServerStorer) bool {
+	_, ok := s.(authboss.SessionServerStorer)
+	return ok
+}
+
+func isAPIKey(s authboss.ServerStorer) bool {
+	_, ok := s.(authboss.APIKeyServerStorer)
+	return ok
+}
+
+func isOAuth2(s authboss.ServerStorer) bool {
+	_, ok := s.(authboss.OAuth2ServerStorer)
+	return ok
+}
+
+func isOAuth2Linking(s authboss.ServerStorer) bool {
+	_, ok := s.(authboss.OAuth2LinkingServerStorer)
+	return ok && isCreating(s)
+}
+
+func isDeleting(s authboss.ServerStorer) bool {
+	_, ok := s.(authboss.DeletingServerStorer)
+	return ok && isCreating(s)
+}
+
+func isPIDChanging(s authboss.ServerStorer) bool {
+	_, ok := s.(authboss.PIDChangingServerStorer)
+	return ok && isCreating(s)
+}
+
+func isReadingAudit(s authboss.ServerStorer) bool {
+	_, ok := s.(authboss.ReadingAuditStorer)
+	return ok
+}
+
+func testServerStorer(t *testing.T, s authboss.ServerStorer) {
+	ctx := context.Background()
+
+	if _, err := s.Load(ctx, "missing@test.com"); err != authboss.ErrUserNotFound {
+		t.Errorf("Load of a missing user must return authboss.ErrUserNotFound, got: %v", err)
+	}
+
+	missing := s.(authboss.CreatingServerStorer).New(ctx)
+	missing.PutPID("missing@test.com")
+	if err := s.Save(ctx, missing); err != authboss.ErrUserNotFound {
+		t.Errorf("Save must not create users and must return authboss.ErrUserNotFound, got: %v", err)
+	}
+	if _, err := s.Load(ctx, "missing@test.com"); err != authboss.ErrUserNotFound {
+		t.Error("Save must not create users")
+	}
+
+	createUser(t, s, "test@test.com", func(u authboss.User) {
+		if a, ok := u.(authboss.AuthableUser); ok {
+			a.PutPassword("hash")
+		}
+	})
+
+	user := loadUser(t, s, "test@test.com")
+	a, ok := user.(authboss.AuthableUser)
+	if !ok {
+		return
+	}
+
+	if a.GetPassword() != "hash" {
+		t.Errorf("Load returned the wrong password: %q", a.GetPassword())
+	}
+
+	a.PutPassword("newhash")
+	if err := s.Save(ctx, a); err != nil {
+		t.Fatal("Save failed:", err)
+	}
+	// Saving a user without changes must not look like they don't exist
+	if err := s.Save(ctx, a); err != nil {
+		t.Error("Save without changes failed:", err)
+	}
+
+	if got := loadUser(t, s, "test@test.com").(authboss.AuthableUser).GetPassword(); got != "newhash" {
+		t.Errorf("Save did not persist the password, got: %q", got)
+	}
+}
+
+func testCreating(t *testing.T, s authboss.ServerStorer) {
+	ctx := context.Background()
+	storer := s.(authboss.CreatingServerStorer)
+
+	if storer.New(ctx) == nil {
+		t.Fatal("New must return a user")
+	}
+
+	createUser(t, s, "test@test.com", nil)
+	createUser(t, s, "other@test.com", nil)
+
+	user := storer.New(ctx)
+	user.PutPID("test@test.com")
+	if err := storer.Create(ctx, user); err != authboss.ErrUserFound {
+		t.Errorf("Create of an existing user must return authboss.ErrUserFound, got: %v", err)
+	}
+
+	for _, pid := range []string{"test@test.com", "other@test.com"} {
+		if got := loadUser(t, s, pid).GetPID(); got != pid {
+			t.Errorf("Load(%q) returned the user %q", pid, got)
+		}
+	}
+}
+
+func testConfirming(t *testing.T, s authboss.ServerStorer) {
+	storer := s.(authboss.ConfirmingServerStorer)
+	testSelector(t, s, "ConfirmableUser",
+		func(u authboss.User, selector string) bool {
+			c, ok := u.(authboss.ConfirmableUser)
+			if ok {
+				c.PutConfirmSelector(selector)
+				c.PutConfirmVerifier("verifier")
+			}
+			return ok
+		},
+		func(ctx context.Context, selector string) (authboss.User, error) {
+			return storer.LoadByConfirmSelector(ctx, selector)
+		},
+	)
+}
+
+func testRecovering(t *testing.T, s authboss.ServerStorer) {
+	storer := s.(authboss.RecoveringServerStorer)
+	expiry := time.Now().UTC().Truncate(time.Second).Add(time.Hour)
+
+	testSelector(t, s, "RecoverableUser",
+		func(u authboss.User, selector string) bool {
+			r, ok := u.(authboss.RecoverableUser)
+			if ok {
+				r.PutRecoverSelector(selector)
+				r.PutRecoverVerifier("verifier")
+				r.PutRecoverExpiry(expiry)
+			}
+			return ok
+		},
+		func(ctx context.Context, selector string) (authboss.User, error) {
+			return storer.LoadByRecoverSelector(ctx, selector)
+		},
+	)
+
+	user, err := storer.LoadByRecoverSelector(context.Background(), "test@test.com-selector")
+	if err != nil {
+		return
+	}
+	if user.GetRecoverVerifier() != "verifier" {
+		t.Errorf("LoadByRecoverSelector returned the wrong verifier: %q", user.GetRecoverVerifier())
+	}
+	if !user.GetRecoverExpiry().Equal(expiry) {
+		t.Errorf("LoadByRecoverSelector returned the wrong expiry: %v", user.GetRecoverExpiry())
+	}
+}
+
+func testEmailChanging(t *testing.T, s authboss.ServerStorer) {
+	storer := s.(authboss.EmailChangingServerStorer)
+	testSelector(t, s, "EmailChangeableUser",
+		func(u authboss.User, selector string) bool {
+			e, ok := u.(authboss.EmailChangeableUser)
+			if ok {
+				e.PutEmailChangeSelector(selector)
+				e.PutEmailChangeVerifier("verifier")
+			}
+			return ok
+		},
+		func(ctx context.Context, selector string) (authboss.User, error) {
+			return storer.LoadByEmailChangeSelector(ctx, selector)
+		},
+	)
+}
+
+func testMagicLink(t *testing.T, s authboss.ServerStorer) {
+	storer := s.(authboss.MagicLinkServerStorer)
+	testSelector(t, s, "MagicLinkableUser",
+		func(u authboss.User, selector string) bool {
+			m, ok := u.(authboss.MagicLinkableUser)
+			if ok {
+				m.PutMagicLinkSelector(selector)
+				m.PutMagicLinkVerifier("verifier")
+			}
+			return ok
+		},
+		func(ctx context.Context, selector string) (authboss.User, error) {
+			return storer.LoadByMagicLinkSelector(ctx, selector)
+		},
+	)
+}
+
+func testUnlocking(t *testing.T, s authboss.ServerStorer) {
+	storer := s.(authboss.UnlockingServerStorer)
+	testSelector(t, s, "LockableUserWithUnlockToken",
+		func(u authboss.User, selector string) bool {
+			l, ok := u.(authboss.LockableUserWithUnlockToken)
+			if ok {
+				l.PutUnlockSelector(selector)
+				l.PutUnlockVerifier("verifier")
+			}
+			return ok
+		},
+		func(ctx context.Context, selector string) (authboss.User, error) {
+			return storer.LoadByUnlockSelector(ctx, selector)
+		},
+	)
+}
+
+// testSelector checks a LoadByXSelector method. The users are created
+// without a token and given one with Save like the modules do, their
+// selector is their pid followed by "-selector". put returns false if the
+// user does not implement the interface needed to store the token.
+func testSelector(t *testing.T, s authboss.ServerStorer, userInterface string,
+	put func(u authboss.User, selector string) bool,
+	load func(ctx context.Context, selector string) (authboss.User, error)) {
+
+	ctx := context.Background()
+	pids := []string{"test@test.com", "other@test.com", "none@test.com"}
+
+	for _, pid := range pids {
+		createUser(t, s, pid, nil)
+	}
+
+	if _, err := load(ctx, "test@test.com-selector"); err != authboss.ErrUserNotFound {
+		t.Errorf("loading by a missing selector must return authboss.ErrUserNotFound, got: %v", err)
+	}
+
+	for _, pid := range pids[:2] {
+		user := loadUser(t, s, pid)
+		if !put(user, pid+"-selector") {
+			t.Fatalf("%T from New must implement %s", user, userInterface)
+		}
+		if err := s.Save(ctx, user); err != nil {
+			t.Fatal("Save failed:", err)
+		}
+	}
+
+	for _, pid := range pids[:2] {
+		user, err := load(ctx, pid+"-selector")
+		if err != nil {
+			t.Errorf("loading by selector %q failed: %v", pid+"-selector", err)
+		} else if user.GetPID() != pid {
+			t.Errorf("loading by selector %q returned the user %q", pid+"-selector", user.GetPID())
+		}
+	}
+
+	// Users without a token have an empty selector, it must never match
+	// or a blank token could be used to take over any of them
+	if _, err := load(ctx, ""); err != authboss.ErrUserNotFound {
+		t.Errorf("loading by an empty selector must return authboss.ErrUserNotFound, got: %v", err)
+	}
+
+	// Modules clear the selector once the token is used
+	user := loadUser(t, s, "other@test.com")
+	put(user, "")
+	if err := s.Save(ctx, user); err != nil {
+		t.Fatal("Save failed:", err)
+	}
+	if _, err := load(ctx, "other@test.com-selector"); err != authboss.ErrUserNotFound {
+		t.Errorf("a cleared selector must no longer be found, got: %v", err)
+	}
+}
+
+func testRemembering(t *testing.T, s authboss.ServerStorer) {
+	ctx := context.Background()
+	storer := s.(authboss.RememberingServerStorer)
+
+	if err := storer.UseRememberToken(ctx, "test@test.com", "missing"); err != authboss.ErrTokenNotFound {
+		t.Errorf("UseRememberToken of a missing token must return authboss.ErrTokenNotFound, got: %v", err)
+	}
+
+	for _, token := range []string{"one", "two", "three"} {
+		if err := storer.AddRememberToken(ctx, "test@test.com", token); err != nil {
+			t.Fatal("AddRememberToken failed:", err)
+		}
+	}
+	if err := storer.AddRememberToken(ctx, "other@test.com", "four"); err != nil {
+		t.Fatal("AddRememberToken failed:", err)
+	}
+
+	if err := storer.UseRememberToken(ctx, "test@test.com", "one"); err != nil {
+		t.Error("UseRememberToken failed:", err)
+	}
+	if err := storer.UseRememberToken(ctx, "test@test.com", "one"); err != authboss.ErrTokenNotFound {
+		t.Errorf("UseRememberToken must delete the token, using it twice returned: %v", err)
+	}
+	if err := storer.UseRememberToken(ctx, "test@test.com", "four"); err != authboss.ErrTokenNotFound {
+		t.Errorf("UseRememberToken must only find the pid's own tokens, got: %v", err)
+	}
+	if err := storer.UseRememberToken(ctx, "test@test.com", "two"); err != nil {
+		t.Error("UseRememberToken must only delete the token used:", err)
+	}
+
+	if err := storer.DelRememberTokens(ctx, "test@test.com"); err != nil {
+		t.Fatal("DelRememberTokens failed:", err)
+	}
+	if err := storer.UseRememberToken(ctx, "test@test.com", "three"); err != authboss.ErrTokenNotFound {
+		t.Errorf("DelRememberTokens must delete all of the pid's tokens, got: %v", err)
+	}
+	if err := storer.UseRememberToken(ctx, "other@test.com", "four"); err != nil {
+		t.Error("DelRememberTokens must only delete the pid's tokens:", err)
+	}
+}
+
+func testBearerToken(t *testing.T, s authboss.ServerStorer) {
+	ctx := context.Background()
+	storer := s.(authboss.BearerTokenServerStorer)
+	now := time.Now()
+
+	if err := storer.UseRefreshToken(ctx, "test@test.com", "missing"); err != authboss.ErrTokenNotFound {
+		t.Errorf("UseRefreshToken of a missing token must return authboss.ErrTokenNotFound, got: %v", err)
+	}
+
+	tokens := map[string]time.Time{
+		"one":     now.Add(time.Hour),
+		"two":     now.Add(time.Hour),
+		"expired": now.Add(-time.Hour),
+	}
+	for token, expires := range tokens {
+		if err := storer.AddRefreshToken(ctx, "test@test.com", token, expires); err != nil {
+			t.Fatal("AddRefreshToken failed:", err)
+		}
+	}
+
+	if err := storer.UseRefreshToken(ctx, "test@test.com", "one"); err != nil {
+		t.Error("UseRefreshToken failed:", err)
+	}
+	if err := storer.UseRefreshToken(ctx, "test@test.com", "one"); err != authboss.ErrTokenNotFound {
+		t.Errorf("UseRefreshToken must delete the token, using it twice returned: %v", err)
+	}
+	if err := storer.UseRefreshToken(ctx, "test@test.com", "expired"); err != authboss.ErrTokenNotFound {
+		t.Errorf("UseRefreshToken of an expired token must return authboss.ErrTokenNotFound, got: %v", err)
+	}
+	if err := storer.UseRefreshToken(ctx, "other@test.com", "two"); err != authboss.ErrTokenNotFound {
+		t.Errorf("UseRefreshToken must only find the pid's own tokens, got: %v", err)
+	}
+
+	if err := storer.DelRefreshTokens(ctx, "test@test.com"); err != nil {
+		t.Fatal("DelRefreshTokens failed:", err)
+	}
+	if err := storer.UseRefreshToken(ctx, "test@test.com", "two"); err != authboss.ErrTokenNotFound {
+		t.Errorf("DelRefreshTokens must delete all of the pid's tokens, got: %v", err)
+	}
+
+	if revoked, err := storer.IsAccessTokenRevoked(ctx, "id"); err != nil || revoked {
+		t.Errorf("IsAccessTokenRevoked of a token that was never revoked must be false, got: %t %v", revoked, err)
+	}
+	if err := storer.RevokeAccessToken(ctx, "id", now.Add(time.Hour)); err != nil {
+		t.Fatal("RevokeAccessToken failed:", err)
+	}
+	if revoked, err := storer.IsAccessTokenRevoked(ctx, "id"); err != nil || !revoked {
+		t.Errorf("IsAccessTokenRevoked of a revoked token must be true, got: %t %v", revoked, err)
+	}
+}
+
+func testSession(t *testing.T, s authboss.ServerStorer) {
+	ctx := context.Background()
+	storer := s.(authboss.SessionServerStorer)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	if _, err := storer.LoadSession(ctx, "missing"); err != authboss.ErrSessionNotFound {
+		t.Errorf("LoadSession of a missing session must return authboss.ErrSessionNotFound, got: %v", err)
+	}
+
+	records := []authboss.SessionRecord{
+		{ID: "one", PID: "test@test.com", UserAgent: "agent", IP: "127.0.0.1", CreatedAt: now, LastSeen: now},
+		{ID: "two", PID: "test@test.com", CreatedAt: now, LastSeen: now},
+		{ID: "three", PID: "other@test.com", CreatedAt: now, LastSeen: now},
+	}
+	for _, record := range records {
+		if err := storer.CreateSession(ctx, record); err != nil {
+			t.Fatal("CreateSession failed:", err)
+		}
+	}
+
+	record, err := storer.LoadSession(ctx, "one")
+	if err != nil {
+		t.Fatal("LoadSession failed:", err)
+	}
+	if record.PID != "test@test.com" || record.UserAgent != "agent" || record.IP != "127.0.0.1" || !record.CreatedAt.Equal(now) {
+		t.Errorf("LoadSession returned the wrong record: %#v", record)
+	}
+
+	if list, err := storer.ListSessions(ctx, "test@test.com"); err != nil {
+		t.Error("ListSessions failed:", err)
+	} else if ids := sessionIDs(list); ids != "one,two" && ids != "two,one" {
+		t.Errorf("ListSessions must return only the pid's sessions, got: %s", ids)
+	}
+
+	lastSeen := now.Add(time.Minute)
+	if err := storer.TouchSession(ctx, "one", lastSeen); err != nil {
+		t.Fatal("TouchSession failed:", err)
+	}
+	if record, err := storer.LoadSession(ctx, "one"); err != nil {
+		t.Error("LoadSession failed:", err)
+	} else if !record.LastSeen.Equal(lastSeen) {
+		t.Errorf("TouchSession did not update LastSeen, got: %v", record.LastSeen)
+	}
+
+	if err := storer.DeleteSession(ctx, "one"); err != nil {
+		t.Fatal("DeleteSession failed:", err)
+	}
+	if _, err := storer.LoadSession(ctx, "one"); err != authboss.ErrSessionNotFound {
+		t.Errorf("DeleteSession must delete the session, got: %v", err)
+	}
+	if err := storer.DeleteSession(ctx, "one"); err != nil {
+		t.Error("DeleteSession of a missing session must not return an error:", err)
+	}
+	if _, err := storer.LoadSession(ctx, "two"); err != nil {
+		t.Error("DeleteSession must only delete the given session:", err)
+	}
+}
+
+func testAPIKey(t *testing.T, s authboss.ServerStorer) {
+	ctx := context.Background()
+	storer := s.(authboss.APIKeyServerStorer)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	if _, err := storer.LoadAPIKey(ctx, "missing"); err != authboss.ErrAPIKeyNotFound {
+		t.Errorf("LoadAPIKey of a missing key must return authboss.ErrAPIKeyNotFound, got: %v", err)
+	}
+	if _, err := storer.LoadAPIKeyByHash(ctx, "missing"); err != authboss.ErrAPIKeyNotFound {
+		t.Errorf("LoadAPIKeyByHash of a missing key must return authboss.ErrAPIKeyNotFound, got: %v", err)
+	}
+
+	keys := []authboss.APIKey{
+		{ID: "one", PID: "test@test.com", Name: "ci", Hash: "hash1", Scopes: []string{"read", "write"}, CreatedAt: now},
+		{ID: "two", PID: "test@test.com", Hash: "hash2", CreatedAt: now},
+		{ID: "three", PID: "other@test.com", Hash: "hash3", CreatedAt: now},
+	}
+	for _, key := range keys {
+		if err := storer.CreateAPIKey(ctx, key); err != nil {
+			t.Fatal("CreateAPIKey failed:", err)
+		}
+	}
+
+	key, err := storer.LoadAPIKey(ctx, "one")
+	if err != nil {
+		t.Fatal("LoadAPIKey failed:", err)
+	}
+	if key.PID != "test@test.com" || key.Name != "ci" || key.Hash != "hash1" || !key.CreatedAt.Equal(now) {
+		t.Errorf("LoadAPIKey returned the wrong key: %#v", key)
+	}
+	if len(key.Scopes) != 2 || key.Scopes[0] != "read" || key.Scopes[1] != "write" {
+		t.Errorf("LoadAPIKey returned the wrong scopes: %v", key.Scopes)
+	}
+
+	if key, err := storer.LoadAPIKeyByHash(ctx, "hash2"); err != nil {
+		t.Error("LoadAPIKeyByHash failed:", err)
+	} else if key.ID != "two" {
+		t.Errorf("LoadAPIKeyByHash returned the wrong key: %q", key.ID)
+	}
+
+	if list, err := storer.ListAPIKeys(ctx, "test@test.com"); err != nil {
+		t.Error("ListAPIKeys failed:", err)
+	} else if len(list) != 2 {
+		t.Errorf("ListAPIKeys must return only the pid's keys, got %d", len(list))
+	}
+
+	lastUsed := now.Add(time.Minute)
+	if err := storer.TouchAPIKey(ctx, "one", lastUsed); err != nil {
+		t.Fatal("TouchAPIKey failed:", err)
+	}
+	if key, err := storer.LoadAPIKey(ctx, "one"); err != nil {
+		t.Error("LoadAPIKey failed:", err)
+	} else if !key.LastUsed.Equal(lastUsed) {
+		t.Errorf("TouchAPIKey did not update LastUsed, got: %v", key.LastUsed)
+	}
+
+	if err := storer.DeleteAPIKey(ctx, "one"); err != nil {
+		t.Fatal("DeleteAPIKey failed:", err)
+	}
+	if _, err := storer.LoadAPIKeyByHash(ctx, "hash1"); err != authboss.ErrAPIKeyNotFound {
+		t.Errorf("DeleteAPIKey must delete the key, got: %v", err)
+	}
+	if err := storer.DeleteAPIKey(ctx, "one"); err != nil {
+		t.Error("DeleteAPIKey of a missing key must not return an error:", err)
+	}
+}
+
+func testOAuth2(t *testing.T, s authboss.ServerStorer) {
+	ctx := context.Background()
+	storer := s.(authboss.OAuth2ServerStorer)
+	pid := authboss.MakeOAuth2PID("google", "123")
+
+	details := map[string]string{authboss.OAuth2UID: "123", authboss.OAuth2Email: "test@test.com"}
+
+	user, err := storer.NewFromOAuth2(ctx, "google", details)
+	if err != nil {
+		t.Fatal("NewFromOAuth2 failed:", err)
+	}
+	if user.GetOAuth2Provider() != "google" || user.GetOAuth2UID() != "123" {
+		t.Errorf("NewFromOAuth2 must set the provider and uid, got: %q %q", user.GetOAuth2Provider(), user.GetOAuth2UID())
+	}
+
+	user.PutOAuth2AccessToken("access")
+	if err := storer.SaveOAuth2(ctx, user); err != nil {
+		t.Fatal("SaveOAuth2 must create the user:", err)
+	}
+
+	loaded, err := s.Load(ctx, pid)
+	if err != nil {
+		t.Fatalf("Load must find oauth2 users by their oauth2 pid %q: %v", pid, err)
+	}
+	if o, ok := loaded.(authboss.OAuth2User); !ok {
+		t.Fatalf("Load of an oauth2 pid must return an OAuth2User, got: %T", loaded)
+	} else if o.GetOAuth2AccessToken() != "access" {
+		t.Errorf("Load returned the wrong access token: %q", o.GetOAuth2AccessToken())
+	}
+
+	user, err = storer.NewFromOAuth2(ctx, "google", details)
+	if err != nil {
+		t.Fatal("NewFromOAuth2 failed:", err)
+	}
+	if user.GetOAuth2AccessToken() != "access" {
+		t.Error("NewFromOAuth2 must return the existing user")
+	}
+
+	user.PutOAuth2AccessToken("refreshed")
+	if err := storer.SaveOAuth2(ctx, user); err != nil {
+		t.Fatal("SaveOAuth2 must save an existing user:", err)
+	}
+	if o := loadUser(t, s, pid).(authboss.OAuth2User); o.GetOAuth2AccessToken() != "refreshed" {
+		t.Errorf("SaveOAuth2 did not persist the access token, got: %q", o.GetOAuth2AccessToken())
+	}
+}
+
+func testOAuth2Linking(t *testing.T, s authboss.ServerStorer) {
+	ctx := context.Background()
+	storer := s.(authboss.OAuth2LinkingServerStorer)
+
+	createUser(t, s, "test@test.com", nil)
+
+	if _, err := storer.LoadByOAuth2Identity(ctx, "google", "123"); err != authboss.ErrUserNotFound {
+		t.Errorf("LoadByOAuth2Identity of an unlinked identity must return authboss.ErrUserNotFound, got: %v", err)
+	}
+
+	identity := authboss.OAuth2Identity{Provider: "google", UID: "123", PID: "test@test.com", AccessToken: "access"}
+	if err := storer.LinkOAuth2Identity(ctx, identity); err != nil {
+		t.Fatal("LinkOAuth2Identity failed:", err)
+	}
+
+	if user, err := storer.LoadByOAuth2Identity(ctx, "google", "123"); err != nil {
+		t.Error("LoadByOAuth2Identity failed:", err)
+	} else if user.GetPID() != "test@test.com" {
+		t.Errorf("LoadByOAuth2Identity returned the user %q", user.GetPID())
+	}
+
+	// Linking again updates the identity
+	identity.AccessToken = "refreshed"
+	if err := storer.LinkOAuth2Identity(ctx, identity); err != nil {
+		t.Fatal("LinkOAuth2Identity of a linked identity failed:", err)
+	}
+	identities, err := storer.ListOAuth2Identities(ctx, "test@test.com")
+	if err != nil {
+		t.Fatal("ListOAuth2Identities failed:", err)
+	}
+	if len(identities) != 1 {
+		t.Fatalf("linking an identity twice must update it, got %d identities", len(identities))
+	}
+	if identities[0].AccessToken != "refreshed" {
+		t.Errorf("LinkOAuth2Identity did not update the identity, got: %q", identities[0].AccessToken)
+	}
+
+	if err := storer.UnlinkOAuth2Identity(ctx, "test@test.com", "google", "123"); err != nil {
+		t.Fatal("UnlinkOAuth2Identity failed:", err)
+	}
+	if _, err := storer.LoadByOAuth2Identity(ctx, "google", "123"); err != authboss.ErrUserNotFound {
+		t.Errorf("UnlinkOAuth2Identity must remove the link, got: %v", err)
+	}
+	if err := storer.UnlinkOAuth2Identity(ctx, "test@test.com", "google", "123"); err != nil {
+		t.Error("UnlinkOAuth2Identity of a missing link must not return an error:", err)
+	}
+}
+
+func testDeleting(t *testing.T, s authboss.ServerStorer) {
+	ctx := context.Background()
+	storer := s.(authboss.DeletingServerStorer)
+	pid := "test@test.com"
+
+	if err := storer.Delete(ctx, pid); err != authboss.ErrUserNotFound {
+		t.Errorf("Delete of a missing user must return authboss.ErrUserNotFound, got: %v", err)
+	}
+
+	createUser(t, s, pid, nil)
+	createUser(t, s, "other@test.com", nil)
+
+	remembering, isRemembering := s.(authboss.RememberingServerStorer)
+	if isRemembering {
+		if err := remembering.AddRememberToken(ctx, pid, "token"); err != nil {
+			t.Fatal("AddRememberToken failed:", err)
+		}
+	}
+	sessions, isSession := s.(authboss.SessionServerStorer)
+	if isSession {
+		if err := sessions.CreateSession(ctx, authboss.SessionRecord{ID: "session", PID: pid}); err != nil {
+			t.Fatal("CreateSession failed:", err)
+		}
+	}
+	apiKeys, isAPIKey := s.(authboss.APIKeyServerStorer)
+	if isAPIKey {
+		if err := apiKeys.CreateAPIKey(ctx, authboss.APIKey{ID: "key", PID: pid, Hash: "hash"}); err != nil {
+			t.Fatal("CreateAPIKey failed:", err)
+		}
+	}
+
+	if err := storer.Delete(ctx, pid); err != nil {
+		t.Fatal("Delete failed:", err)
+	}
+
+	if _, err := s.Load(ctx, pid); err != authboss.ErrUserNotFound {
+		t.Errorf("Delete must delete the user, Load returned: %v", err)
+	}
+	if _, err := s.Load(ctx, "other@test.com"); err != nil {
+		t.Error("Delete must only delete the given user:", err)
+	}
+	if isRemembering {
+		if err := remembering.UseRememberToken(ctx, pid, "token"); err != authboss.ErrTokenNotFound {
+			t.Errorf("Delete must delete the user's remember tokens, got: %v", err)
+		}
+	}
+	if isSession {
+		if _, err := sessions.LoadSession(ctx, "session"); err != authboss.ErrSessionNotFound {
+			t.Errorf("Delete must delete the user's sessions, got: %v", err)
+		}
+	}
+	if isAPIKey {
+		if _, err := apiKeys.LoadAPIKey(ctx, "key"); err != authboss.ErrAPIKeyNotFound {
+			t.Errorf("Delete must delete the user's api keys, got: %v", err)
+		}
+	}
+}
+
+func testPIDChanging(t *testing.T, s authboss.ServerStorer) {
+	ctx := context.Background()
+	storer := s.(authboss.PIDChangingServerStorer)
+	oldPID, newPID := "test@test.com", "new@test.com"
+
+	missing := s.(authboss.CreatingServerStorer).New(ctx)
+	missing.PutPID(newPID)
+	if err := storer.ChangePID(ctx, oldPID, missing); err != authboss.ErrUserNotFound {
+		t.Errorf("ChangePID of a missing user must return authboss.ErrUserNotFound, got: %v", err)
+	}
+
+	createUser(t, s, oldPID, nil)
+	createUser(t, s, "other@test.com", nil)
+
+	taken := loadUser(t, s, oldPID)
+	taken.PutPID("other@test.com")
+	if err := storer.ChangePID(ctx, oldPID, taken); err != authboss.ErrUserFound {
+		t.Errorf("ChangePID to a taken pid must return authboss.ErrUserFound, got: %v", err)
+	}
+
+	remembering, isRemembering := s.(authboss.RememberingServerStorer)
+	if isRemembering {
+		if err := remembering.AddRememberToken(ctx, oldPID, "token"); err != nil {
+			t.Fatal("AddRememberToken failed:", err)
+		}
+	}
+	bearer, isBearer := s.(authboss.BearerTokenServerStorer)
+	if isBearer {
+		if err := bearer.AddRefreshToken(ctx, oldPID, "token", time.Now().Add(time.Hour)); err != nil {
+			t.Fatal("AddRefreshToken failed:", err)
+		}
+	}
+	sessions, isSession := s.(authboss.SessionServerStorer)
+	if isSession {
+		if err := sessions.CreateSession(ctx, authboss.SessionRecord{ID: "session", PID: oldPID}); err != nil {
+			t.Fatal("CreateSession failed:", err)
+		}
+	}
+	apiKeys, isAPIKey := s.(authboss.APIKeyServerStorer)
+	if isAPIKey {
+		if err := apiKeys.CreateAPIKey(ctx, authboss.APIKey{ID: "key", PID: oldPID, Hash: "hash"}); err != nil {
+			t.Fatal("CreateAPIKey failed:", err)
+		}
+	}
+
+	user := loadUser(t, s, oldPID)
+	user.PutPID(newPID)
+	authable, isAuthable := user.(authboss.AuthableUser)
+	if isAuthable {
+		authable.PutPassword("changed")
+	}
+
+	if err := storer.ChangePID(ctx, oldPID, user); err != nil {
+		t.Fatal("ChangePID failed:", err)
+	}
+
+	if _, err := s.Load(ctx, oldPID); err != authboss.ErrUserNotFound {
+		t.Errorf("ChangePID must move the user, Load of the old pid returned: %v", err)
+	}
+	moved := loadUser(t, s, newPID)
+	if pid := moved.GetPID(); pid != newPID {
+		t.Errorf("ChangePID must set the user's pid, got: %q", pid)
+	}
+	if isAuthable {
+		if password := moved.(authboss.AuthableUser).GetPassword(); password != "changed" {
+			t.Errorf("ChangePID must save the rest of the user, got password: %q", password)
+		}
+	}
+	if isRemembering {
+		if err := remembering.UseRememberToken(ctx, newPID, "token"); err != authboss.ErrTokenNotFound {
+			t.Errorf("ChangePID must delete the user's remember tokens, got: %v", err)
+		}
+		if err := remembering.UseRememberToken(ctx, oldPID, "token"); err != authboss.ErrTokenNotFound {
+			t.Errorf("ChangePID must delete the user's remember tokens, got: %v", err)
+		}
+	}
+	if isBearer {
+		if err := bearer.UseRefreshToken(ctx, newPID, "token"); err != authboss.ErrTokenNotFound {
+			t.Errorf("ChangePID must delete the user's refresh tokens, got: %v", err)
+		}
+		if err := bearer.UseRefreshToken(ctx, oldPID, "token"); err != authboss.ErrTokenNotFound {
+			t.Errorf("ChangePID must delete the user's refresh tokens, got: %v", err)
+		}
+	}
+	if isSession {
+		if record, err := sessions.LoadSession(ctx, "session"); err != nil {
+			t.Error("LoadSession failed:", err)
+		} else if record.PID != newPID {
+			t.Errorf("ChangePID must move the user's sessions, got pid: %q", record.PID)
+		}
+	}
+	if isAPIKey {
+		if key, err := apiKeys.LoadAPIKey(ctx, "key"); err != nil {
+			t.Error("LoadAPIKey failed:", err)
+		} else if key.PID != newPID {
+			t.Errorf("ChangePID must move the user's api keys, got pid: %q", key.PID)
+		}
+	}
+}
+
+func testReadingAudit(t *testing.T, s authboss.ServerStorer) {
+	ctx := context.Background()
+	storer := s.(authboss.ReadingAuditStorer)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	events := []string{"Register", "Auth", "AuthFail", "Logout"}
+	for i, event := range events {
+		record := authboss.AuditRecord{PID: "test@test.com", Event: event, Time: now.Add(time.Duration(i) * time.Second)}
+		if err := storer.PutAuditRecord(ctx, record); err != nil {
+			t.Fatal("PutAuditRecord failed:", err)
+		}
+	}
+	if err := storer.PutAuditRecord(ctx, authboss.AuditRecord{PID: "other@test.com", Event: "Auth", Time: now}); err != nil {
+		t.Fatal("PutAuditRecord failed:", err)
+	}
+
+	records, err := storer.RecentAuditRecords(ctx, "test@test.com", 3)
+	if err != nil {
+		t.Fatal("RecentAuditRecords failed:", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("RecentAuditRecords must return up to limit records, got %d", len(records))
+	}
+	for i, want := range []string{"Logout", "AuthFail", "Auth"} {
+		if records[i].Event != want || records[i].PID != "test@test.com" {
+			t.Errorf("RecentAuditRecords must return the pid's newest records first, record %d was: %#v", i, records[i])
+		}
+	}
+}
+
+// createUser creates a user with New and Create, modify can set fields
+// before it's created
+func createUser(t *testing.T, s authboss.ServerStorer, pid string, modify func(authboss.User)) {
+	t.Helper()
+
+	ctx := context.Background()
+	storer := s.(authboss.CreatingServerStorer)
+
+	user := storer.New(ctx)
+	user.PutPID(pid)
+	if modify != nil {
+		modify(user)
+	}
+
+	if err := storer.Create(ctx, user); err != nil {
+		t.Fatalf("Create(%q) failed: %v", pid, err)
+	}
+}
+
+func loadUser(t *testing.T, s authboss.ServerStorer, pid string) authboss.User {
+	t.Helper()
+
+	user, err := s.Load(context.Background(), pid)
+	if err != nil {
+		t.Fatalf("Load(%q) failed: %v", pid, err)
+	}
+	if user == nil {
+		t.Fatalf("Load(%q) returned a nil user", pid)
+	}
+
+	return user
+}
+
+func sessionIDs(records []authboss.SessionRecord) string {
+	ids := make([]string, len(records))
+	for i, record := range records {
+		ids[i] = record.ID
+	}
+	return strings.Join(ids, ",")
+}
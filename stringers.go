@@ -22,11 +22,18 @@ func _() {
 	_ = x[EventLogout-11]
 	_ = x[EventTwoFactorAdded-12]
 	_ = x[EventTwoFactorRemoved-13]
+	_ = x[EventRememberAuth-14]
+	_ = x[EventPasswordChange-15]
+	_ = x[EventEmailChange-16]
+	_ = x[EventAccountDelete-17]
+	_ = x[EventUnlock-18]
+	_ = x[EventBearerAuth-19]
+	_ = x[EventMagicLinkStart-20]
 }
 
-const _Event_name = "EventRegisterEventAuthEventAuthHijackEventOAuth2EventAuthFailEventOAuth2FailEventRecoverStartEventRecoverEndEventGetUserEventGetUserSessionEventPasswordResetEventLogoutEventTwoFactorAddedEventTwoFactorRemoved"
+const _Event_name = "EventRegisterEventAuthEventAuthHijackEventOAuth2EventAuthFailEventOAuth2FailEventRecoverStartEventRecoverEndEventGetUserEventGetUserSessionEventPasswordResetEventLogoutEventTwoFactorAddedEventTwoFactorRemovedEventRememberAuthEventPasswordChangeEventEmailChangeEventAccountDeleteEventUnlockEventBearerAuthEventMagicLinkStart"
 
-var _Event_index = [...]uint8{0, 13, 22, 37, 48, 61, 76, 93, 108, 120, 139, 157, 168, 187, 208}
+var _Event_index = [...]uint16{0, 13, 22, 37, 48, 61, 76, 93, 108, 120, 139, 157, 168, 187, 208, 225, 244, 260, 278, 289, 304, 323}
 
 func (i Event) String() string {
 	if i < 0 || i >= Event(len(_Event_index)-1) {
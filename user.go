@@ -45,6 +45,36 @@ type ConfirmableUser interface {
 	PutConfirmVerifier(verifier string)
 }
 
+// EmailChangeableUser can change their e-mail address after confirming
+// that they own the new one
+type EmailChangeableUser interface {
+	ConfirmableUser
+
+	GetPendingEmail() (email string)
+	GetEmailChangeSelector() (selector string)
+	GetEmailChangeVerifier() (verifier string)
+	GetEmailChangeExpiry() (expiry time.Time)
+
+	PutPendingEmail(email string)
+	PutEmailChangeSelector(selector string)
+	PutEmailChangeVerifier(verifier string)
+	PutEmailChangeExpiry(expiry time.Time)
+}
+
+// MagicLinkableUser can log in with a link sent to their e-mail address
+type MagicLinkableUser interface {
+	User
+
+	GetEmail() (email string)
+	GetMagicLinkSelector() (selector string)
+	GetMagicLinkVerifier() (verifier string)
+	GetMagicLinkExpiry() (expiry time.Time)
+
+	PutMagicLinkSelector(selector string)
+	PutMagicLinkVerifier(verifier string)
+	PutMagicLinkExpiry(expiry time.Time)
+}
+
 // LockableUser is a user that can be locked
 type LockableUser interface {
 	User
@@ -58,6 +88,30 @@ type LockableUser interface {
 	PutLocked(locked time.Time)
 }
 
+// LockableUserWithLockCount counts how many times in a row the user has
+// been locked so that the LockPolicy can lock them for longer each time
+type LockableUserWithLockCount interface {
+	LockableUser
+
+	GetLockCount() (count int)
+	PutLockCount(count int)
+}
+
+// LockableUserWithUnlockToken can be sent a link to unlock themselves
+// when they're locked
+type LockableUserWithUnlockToken interface {
+	LockableUser
+
+	GetEmail() (email string)
+	GetUnlockSelector() (selector string)
+	GetUnlockVerifier() (verifier string)
+	GetUnlockExpiry() (expiry time.Time)
+
+	PutUnlockSelector(selector string)
+	PutUnlockVerifier(verifier string)
+	PutUnlockExpiry(expiry time.Time)
+}
+
 // RecoverableUser is a user that can be recovered via e-mail
 type RecoverableUser interface {
 	AuthableUser
@@ -132,6 +186,22 @@ func MustBeConfirmable(u User) ConfirmableUser {
 	panic(fmt.Sprintf("could not upgrade user to a confirmable user, type: %T", u))
 }
 
+// MustBeEmailChangeable forces an upgrade to an EmailChangeableUser or panic.
+func MustBeEmailChangeable(u User) EmailChangeableUser {
+	if eu, ok := u.(EmailChangeableUser); ok {
+		return eu
+	}
+	panic(fmt.Sprintf("could not upgrade user to an email changeable user, given type: %T", u))
+}
+
+// MustBeMagicLinkable forces an upgrade to a MagicLinkableUser or panic.
+func MustBeMagicLinkable(u User) MagicLinkableUser {
+	if mu, ok := u.(MagicLinkableUser); ok {
+		return mu
+	}
+	panic(fmt.Sprintf("could not upgrade user to a magic linkable user, given type: %T", u))
+}
+
 // MustBeLockable forces an upgrade to a LockableUser or panic.
 func MustBeLockable(u User) LockableUser {
 	if lu, ok := u.(LockableUser); ok {
@@ -163,6 +233,14 @@ func MustBeOAuthable(u User) OAuth2User {
 	panic(fmt.Sprintf("could not upgrade user to an oauthable user, given type: %T", u))
 }
 
+// Keys in the details map that an OAuth2Provider's FindUserDetails returns
+// and that OAuth2ServerStorer.NewFromOAuth2 is given
+const (
+	OAuth2UID   = "uid"
+	OAuth2Email = "email"
+	OAuth2Name  = "name"
+)
+
 // MakeOAuth2PID is used to create a pid for users that don't have
 // an e-mail address or username in the normal system. This allows
 // all the modules to continue to working as intended without having
@@ -64,6 +64,58 @@ type RecoverEndValuer interface {
 	GetToken() string
 }
 
+// EmailChangeValuer provides the new e-mail address a logged in
+// user wants to change to, and their current password which may be
+// empty when the user has none.
+type EmailChangeValuer interface {
+	Validator
+
+	GetCurrentPassword() string
+	GetEmail() string
+}
+
+// MagicLinkStartValuer provides the pid of the user that wants a magic
+// link sent to them.
+type MagicLinkStartValuer interface {
+	Validator
+
+	GetPID() string
+}
+
+// MagicLinkValuer provides the token from a magic link
+type MagicLinkValuer interface {
+	Validator
+
+	GetToken() string
+}
+
+// PasswordChangeValuer is used to get the current password and the
+// new password from a logged in user that is changing their password.
+type PasswordChangeValuer interface {
+	Validator
+
+	GetCurrentPassword() string
+	GetPassword() string
+}
+
+// DeleteAccountValuer is used to get the values a logged in user must
+// provide again to delete their account. Either may be empty when the
+// user has no password or no two factor authentication.
+type DeleteAccountValuer interface {
+	Validator
+
+	GetCurrentPassword() string
+	GetCode() string
+}
+
+// RefreshTokenValuer provides the refresh token an API client sends to
+// get new bearer tokens or to revoke them.
+type RefreshTokenValuer interface {
+	Validator
+
+	GetRefreshToken() string
+}
+
 // RememberValuer allows auth/oauth2 to pass along the remember
 // bool from the user to the remember module unobtrusively.
 type RememberValuer interface {
@@ -133,6 +185,66 @@ func MustHaveRecoverMiddleValues(v Validator) RecoverMiddleValuer {
 	panic(fmt.Sprintf("bodyreader returned a type that could not be upgraded to RecoverMiddleValuer: %T", v))
 }
 
+// MustHaveEmailChangeValues upgrades a validatable set of values
+// to ones specific to a user that is changing their e-mail.
+func MustHaveEmailChangeValues(v Validator) EmailChangeValuer {
+	if u, ok := v.(EmailChangeValuer); ok {
+		return u
+	}
+
+	panic(fmt.Sprintf("bodyreader returned a type that could not be upgraded to EmailChangeValuer: %T", v))
+}
+
+// MustHaveMagicLinkStartValues upgrades a validatable set of values
+// to ones specific to a user requesting a magic link.
+func MustHaveMagicLinkStartValues(v Validator) MagicLinkStartValuer {
+	if u, ok := v.(MagicLinkStartValuer); ok {
+		return u
+	}
+
+	panic(fmt.Sprintf("bodyreader returned a type that could not be upgraded to MagicLinkStartValuer: %T", v))
+}
+
+// MustHaveMagicLinkValues upgrades a validatable set of values
+// to ones specific to a user logging in with a magic link.
+func MustHaveMagicLinkValues(v Validator) MagicLinkValuer {
+	if u, ok := v.(MagicLinkValuer); ok {
+		return u
+	}
+
+	panic(fmt.Sprintf("bodyreader returned a type that could not be upgraded to MagicLinkValuer: %T", v))
+}
+
+// MustHavePasswordChangeValues upgrades a validatable set of values
+// to ones specific to a user that is changing their password.
+func MustHavePasswordChangeValues(v Validator) PasswordChangeValuer {
+	if u, ok := v.(PasswordChangeValuer); ok {
+		return u
+	}
+
+	panic(fmt.Sprintf("bodyreader returned a type that could not be upgraded to PasswordChangeValuer: %T", v))
+}
+
+// MustHaveDeleteAccountValues upgrades a validatable set of values
+// to ones specific to a user that is deleting their account.
+func MustHaveDeleteAccountValues(v Validator) DeleteAccountValuer {
+	if u, ok := v.(DeleteAccountValuer); ok {
+		return u
+	}
+
+	panic(fmt.Sprintf("bodyreader returned a type that could not be upgraded to DeleteAccountValuer: %T", v))
+}
+
+// MustHaveRefreshTokenValues upgrades a validatable set of values
+// to ones specific to a client using a refresh token.
+func MustHaveRefreshTokenValues(v Validator) RefreshTokenValuer {
+	if u, ok := v.(RefreshTokenValuer); ok {
+		return u
+	}
+
+	panic(fmt.Sprintf("bodyreader returned a type that could not be upgraded to RefreshTokenValuer: %T", v))
+}
+
 // MustHaveRecoverEndValues upgrades a validatable set of values
 // to ones specific to a user that needs to be recovered.
 func MustHaveRecoverEndValues(v Validator) RecoverEndValuer {